@@ -5,56 +5,228 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/LucianoBarrera/api-gateway/internal/cache"
 	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/configstore"
+	"github.com/LucianoBarrera/api-gateway/internal/handoff"
 	"github.com/LucianoBarrera/api-gateway/internal/server"
+	"github.com/LucianoBarrera/api-gateway/internal/upstream"
 	"github.com/LucianoBarrera/api-gateway/internal/usecase"
 )
 
-func gracefulShutdown(apiServer *http.Server, done chan bool) {
+// Names of the listeners handed over on upgrades and looked up in
+// LISTEN_FDNAMES under systemd socket activation
+const (
+	apiListenerName   = "api"
+	adminListenerName = "admin"
+)
+
+// handoffSettle is how long connections the old process accepted before
+// an upgrade get to send their request before it drains; the new process
+// already takes every new connection
+const handoffSettle = time.Second
+
+func gracefulShutdown(apiServer, adminServer *http.Server, gateway *server.Gateway, upgrader *handoff.Upgrader, done chan bool) {
 	// Create context that listens for the interrupt signal from the OS.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	// Listen for the interrupt signal.
-	<-ctx.Done()
+	// SIGUSR2 hands the listeners over to a new process started from the
+	// gateway's executable, which may have been replaced by a new version.
+	// This process drains once the new one is ready.
+	upgrades := make(chan os.Signal, 1)
+	signal.Notify(upgrades, syscall.SIGUSR2)
+	defer signal.Stop(upgrades)
+
+	// The shutdown settings are read from the config in use, so they can
+	// be changed through the admin API
+	var shutdownConfig config.ShutdownConfig
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("shutting down gracefully, press Ctrl+C again to force")
+			stop() // Allow Ctrl+C to force shutdown
+			shutdownConfig = gateway.Config().Server.Shutdown
+			break wait
+		case <-upgrades:
+			log.Println("Handing the listeners over to a new process")
+			pid, err := upgrader.Upgrade()
+			if err != nil {
+				log.Printf("Upgrade failed, still serving: %v", err)
+				continue
+			}
+			log.Printf("Process %d is serving; draining", pid)
+			shutdownConfig = gateway.Config().Server.Shutdown
+			shutdownConfig.PreStopDelay = config.Duration{Duration: handoffSettle}
+			break wait
+		}
+	}
 
-	log.Println("shutting down gracefully, press Ctrl+C again to force")
-	stop() // Allow Ctrl+C to force shutdown
+	server.Drain(apiServer, gateway.Controls(), shutdownConfig)
 
-	// The context is used to inform the server it has 5 seconds to finish
-	// the request it is currently handling
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := apiServer.Shutdown(ctx); err != nil {
-		log.Printf("Server forced to shutdown with error: %v", err)
+	// The admin API stays up while the client listener drains, so the
+	// drain can be watched
+	if adminServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownConfig.Timeout())
+		defer cancel()
+		if err := adminServer.Shutdown(ctx); err != nil {
+			log.Printf("Admin server forced to shutdown with error: %v", err)
+		}
 	}
 
+	// Logs are written unbuffered and metrics are scraped, so there is
+	// nothing left to flush
 	log.Println("Server exiting")
 
 	// Notify the main goroutine that the shutdown is complete
 	done <- true
 }
 
+// buildForwarder creates the reverse proxy plus the specialised forwarders
+// (REST-to-gRPC transcoding, mirroring, request coalescing, response cache,
+// header rules, traffic splitting, aggregates, request decompression,
+// fault injection) for the
+// services that need them
+func buildForwarder(appConfig config.AppConfig, responseCache *cache.Store, targets *upstream.Registry) (usecase.RequestForwarder, error) {
+	forwarders := map[string]usecase.RequestForwarder{}
+	for serviceName, serviceConfig := range appConfig.Services {
+		if serviceConfig.Transcoding == nil {
+			continue
+		}
+		transcoder, err := usecase.NewTranscodingService(appConfig, serviceName, targets)
+		if err != nil {
+			return nil, err
+		}
+		forwarders[serviceName] = transcoder
+	}
+
+	// Only requests that actually reach an upstream are mirrored
+	proxy, err := usecase.NewMirroringService(appConfig, usecase.NewApiGatewayService(appConfig, targets))
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache misses are coalesced before they reach the upstreams
+	cached := usecase.NewCachingService(appConfig, responseCache,
+		usecase.NewCoalescingService(appConfig,
+			usecase.NewForwarderDispatcher(proxy, forwarders)))
+
+	// Bodies are transformed above the cache, so cached responses are
+	// already in the shape clients get
+	bodyTransformer, err := usecase.NewBodyTransformer(appConfig, cached)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header rules see the picked version, and the cache sees the headers
+	// that are actually sent upstream
+	transformed, err := usecase.NewHeaderTransformer(appConfig, bodyTransformer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions are picked first, from the client's original headers
+	serviceForwarder, err := usecase.NewTrafficSplitter(appConfig, transformed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Aggregates call the other services through the regular forwarders
+	aggregates := map[string]usecase.RequestForwarder{}
+	for aggregateName := range appConfig.Aggregates {
+		aggregate, err := usecase.NewAggregateService(appConfig, aggregateName, serviceForwarder)
+		if err != nil {
+			return nil, err
+		}
+		aggregates[aggregateName] = aggregate
+	}
+
+	// Client requests are checked against the services' OpenAPI documents;
+	// the calls aggregates build themselves are not. Compressed bodies are
+	// decoded first so the validator sees them as the upstream will.
+	validated, err := usecase.NewOpenAPIValidator(appConfig, serviceForwarder)
+	if err != nil {
+		return nil, err
+	}
+	decompressed := usecase.NewRequestDecompressor(appConfig, validated)
+
+	// Faults are only injected into client requests, never into the calls
+	// aggregates make on their behalf
+	return usecase.NewFaultInjector(appConfig, usecase.NewForwarderDispatcher(decompressed, aggregates))
+}
+
 func main() {
 
 	appConfig := config.LoadAppConfig()
 
-	// Create the API gateway service
-	apiGatewayService := usecase.NewApiGatewayService(appConfig)
+	// Changes made through the admin API are stored as revisions; the
+	// latest one is used instead of the config files
+	revisions, err := configstore.Open(appConfig.Admin.StoreDir, appConfig.Admin.Revisions())
+	if err != nil {
+		log.Fatalf("Fatal error opening config store: %v", err)
+	}
+
+	responseCache := cache.NewStore(appConfig.Cache.Limit())
+	controls := server.NewControls(upstream.NewRegistry())
 
-	server := server.NewServer(appConfig, apiGatewayService)
+	// Create the API gateway service. It is rebuilt whenever the config
+	// changes; the cache and the runtime controls are kept.
+	gateway, err := server.NewGateway(appConfig, func(appConfig config.AppConfig) (usecase.RequestForwarder, error) {
+		return buildForwarder(appConfig, responseCache, controls.Upstreams())
+	}, responseCache, controls, revisions)
+	if err != nil {
+		log.Fatalf("Fatal error building request forwarders: %v", err)
+	}
+	appConfig = gateway.Config()
+
+	// Listeners are inherited from the process being upgraded, or from
+	// systemd socket activation, when there are any
+	upgrader, err := handoff.New(apiListenerName, adminListenerName)
+	if err != nil {
+		log.Fatalf("Fatal error receiving listeners: %v", err)
+	}
+
+	apiServer := server.NewServer(gateway)
+	apiListener, err := upgrader.Listen(apiListenerName, apiServer.Addr)
+	if err != nil {
+		log.Fatalf("Fatal error listening on %s: %v", apiServer.Addr, err)
+	}
+	adminServer := server.NewAdminServer(gateway)
+	if adminServer != nil {
+		adminListener, err := upgrader.Listen(adminListenerName, adminServer.Addr)
+		if err != nil {
+			log.Fatalf("Fatal error listening on %s: %v", adminServer.Addr, err)
+		}
+		go func() {
+			log.Printf("Admin API listening on %s", adminListener.Addr())
+			if err := adminServer.Serve(adminListener); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Admin server error: %v", err)
+			}
+		}()
+	}
 
 	// Create a done channel to signal when the shutdown is complete
 	done := make(chan bool, 1)
 
 	// Run graceful shutdown in a separate goroutine
-	go gracefulShutdown(server, done)
+	go gracefulShutdown(apiServer, adminServer, gateway, upgrader, done)
 
-	err := server.ListenAndServe()
+	// Connections queue up on the listeners until they are served, so the
+	// process can report ready before serving
+	if err := upgrader.Ready(); err != nil {
+		log.Printf("Failed to report ready to the previous process: %v", err)
+	}
+	if appConfig.Server.TLSCertFile != "" {
+		err = apiServer.ServeTLS(apiListener, appConfig.Server.TLSCertFile, appConfig.Server.TLSKeyFile)
+	} else {
+		err = apiServer.Serve(apiListener)
+	}
 	if err != nil && err != http.ErrServerClosed {
 		panic(fmt.Sprintf("http server error: %s", err))
 	}
@@ -2,4 +2,27 @@ module github.com/LucianoBarrera/api-gateway
 
 go 1.24.5
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/getkin/kin-openapi v0.133.0
+	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/net v0.37.0
+	google.golang.org/genproto/googleapis/api v0.0.0-20250603155806-513f23925822
+	google.golang.org/protobuf v1.36.6
+)
+
+require (
+	github.com/go-openapi/jsonpointer v0.21.0 // indirect
+	github.com/go-openapi/swag v0.23.0 // indirect
+	github.com/gorilla/mux v1.8.0 // indirect
+	github.com/josharian/intern v1.0.0 // indirect
+	github.com/mailru/easyjson v0.7.7 // indirect
+	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826 // indirect
+	github.com/oasdiff/yaml v0.0.0-20250309154309-f31be36b4037 // indirect
+	github.com/oasdiff/yaml3 v0.0.0-20250309153720-d2182401db90 // indirect
+	github.com/perimeterx/marshmallow v1.1.5 // indirect
+	github.com/woodsbury/decimal128 v1.3.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
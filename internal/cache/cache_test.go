@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestEntry(bodySize int) *Entry {
+	return &Entry{StatusCode: http.StatusOK, Header: http.Header{}, Body: []byte(strings.Repeat("x", bodySize))}
+}
+
+func TestStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewStore(3 * 1024)
+
+	store.Set("a", newTestEntry(700))
+	store.Set("b", newTestEntry(700))
+	store.Set("c", newTestEntry(700))
+
+	// Touch "a" so "b" becomes the least recently used entry
+	if _, ok := store.Get("a"); !ok {
+		t.Fatal("expected entry a to be cached")
+	}
+	store.Set("d", newTestEntry(700))
+
+	if _, ok := store.Get("b"); ok {
+		t.Error("expected entry b to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := store.Get(key); !ok {
+			t.Errorf("expected entry %s to be cached", key)
+		}
+	}
+
+	stats := store.Stats()
+	if stats.Evictions != 1 || stats.Entries != 3 || stats.UsedBytes > stats.MaxBytes {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if store.Set("huge", newTestEntry(4*1024)) {
+		t.Error("expected an entry larger than the store to be rejected")
+	}
+}
+
+func TestStoreDeletePrefix(t *testing.T) {
+	store := NewStore(1 << 20)
+	store.Set("GET users/users", newTestEntry(10))
+	store.Set("GET users/users/1", newTestEntry(10))
+	store.Set("GET auth/auth/status", newTestEntry(10))
+
+	if removed := store.DeletePrefix("GET users/"); removed != 2 {
+		t.Errorf("expected 2 entries removed, got %d", removed)
+	}
+	if _, ok := store.Get("GET auth/auth/status"); !ok {
+		t.Error("expected entries of other services to be kept")
+	}
+	if !store.Delete("GET auth/auth/status") || store.Delete("GET auth/auth/status") {
+		t.Error("expected Delete to report whether the key was present")
+	}
+}
+
+func TestNewEntry(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		requestHeader http.Header
+		statusCode    int
+		header        http.Header
+		policy        Policy
+		storable      bool
+		lifetime      time.Duration
+	}{
+		{
+			name:     "max-age",
+			header:   http.Header{"Cache-Control": {"public, max-age=60"}},
+			storable: true,
+			lifetime: time.Minute,
+		},
+		{
+			name:     "s-maxage wins over max-age",
+			header:   http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}},
+			storable: true,
+			lifetime: 10 * time.Second,
+		},
+		{
+			name:     "Age header reduces remaining freshness",
+			header:   http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}},
+			storable: true,
+			lifetime: 40 * time.Second,
+		},
+		{
+			name: "Expires relative to Date",
+			header: http.Header{
+				"Date":    {now.Format(http.TimeFormat)},
+				"Expires": {now.Add(5 * time.Minute).Format(http.TimeFormat)},
+			},
+			storable: true,
+			lifetime: 5 * time.Minute,
+		},
+		{
+			name:     "default TTL without explicit freshness",
+			header:   http.Header{},
+			policy:   Policy{DefaultTTL: 30 * time.Second},
+			storable: true,
+			lifetime: 30 * time.Second,
+		},
+		{
+			name:     "Last-Modified heuristic",
+			header:   http.Header{"Last-Modified": {now.Add(-10 * time.Hour).Format(http.TimeFormat)}},
+			storable: true,
+			lifetime: time.Hour,
+		},
+		{
+			name:     "stale-while-revalidate without validators",
+			header:   http.Header{"Cache-Control": {"max-age=0, stale-while-revalidate=30"}},
+			storable: true,
+		},
+		{
+			name:   "expired without validators or stale window",
+			header: http.Header{"Cache-Control": {"max-age=0"}},
+		},
+		{
+			name:   "no-store",
+			header: http.Header{"Cache-Control": {"no-store"}},
+		},
+		{
+			name:   "private",
+			header: http.Header{"Cache-Control": {"private, max-age=60"}},
+		},
+		{
+			name:   "Set-Cookie",
+			header: http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"session=1"}},
+		},
+		{
+			name:   "Vary: *",
+			header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}},
+		},
+		{
+			name:          "authenticated request without public",
+			requestHeader: http.Header{"Authorization": {"Bearer token"}},
+			header:        http.Header{"Cache-Control": {"max-age=60"}},
+		},
+		{
+			name:          "authenticated request with public",
+			requestHeader: http.Header{"Authorization": {"Bearer token"}},
+			header:        http.Header{"Cache-Control": {"public, max-age=60"}},
+			storable:      true,
+			lifetime:      time.Minute,
+		},
+		{
+			name:       "status not cacheable by default",
+			statusCode: http.StatusInternalServerError,
+			header:     http.Header{},
+			policy:     Policy{DefaultTTL: time.Minute},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+			for name, values := range tt.requestHeader {
+				req.Header[name] = values
+			}
+			statusCode := tt.statusCode
+			if statusCode == 0 {
+				statusCode = http.StatusOK
+			}
+
+			entry, ok := NewEntry(req, statusCode, tt.header, []byte("{}"), now, tt.policy)
+			if ok != tt.storable {
+				t.Fatalf("expected storable=%v, got %v", tt.storable, ok)
+			}
+			if !ok {
+				return
+			}
+			if remaining := entry.FreshUntil.Sub(now); remaining != tt.lifetime {
+				t.Errorf("expected %v of freshness, got %v", tt.lifetime, remaining)
+			}
+		})
+	}
+}
+
+func TestEntryConditionalsAndVary(t *testing.T) {
+	now := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	req.Header.Set("Accept-Language", "en")
+
+	entry, ok := NewEntry(req, http.StatusOK, http.Header{
+		"Cache-Control": {"max-age=60, stale-while-revalidate=30"},
+		"Etag":          {`W/"v1"`},
+		"Vary":          {"Accept-Language"},
+	}, []byte("{}"), now, Policy{})
+	if !ok {
+		t.Fatal("expected the response to be storable")
+	}
+
+	if !entry.WithinStaleWhileRevalidate(now.Add(80 * time.Second)) {
+		t.Error("expected stale-while-revalidate from the response to apply")
+	}
+
+	other := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	other.Header.Set("Accept-Language", "es")
+	if entry.MatchesVary(other) {
+		t.Error("expected a different Accept-Language to select another variant")
+	}
+
+	conditional := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	conditional.Header.Set("If-None-Match", `"v0", "v1"`)
+	if !entry.NotModifiedFor(conditional) {
+		t.Error("expected a weakly matching If-None-Match to be answered with 304")
+	}
+}
@@ -0,0 +1,285 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heuristicLastModifiedFraction is the share of a response's age since
+// Last-Modified that RFC 9111 section 4.2.2 suggests as a freshness lifetime
+const heuristicLastModifiedFraction = 10
+
+// maxHeuristicLifetime caps the Last-Modified heuristic
+const maxHeuristicLifetime = 24 * time.Hour
+
+// heuristicallyCacheable are the statuses RFC 9110 section 15.1 allows to be
+// cached without explicit freshness information
+var heuristicallyCacheable = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// Directives are parsed Cache-Control directives keyed by lower-case name
+type Directives map[string]string
+
+// ParseCacheControl parses every Cache-Control header value
+func ParseCacheControl(header http.Header) Directives {
+	directives := Directives{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// Has reports whether the directive is present
+func (d Directives) Has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// Seconds returns a delta-seconds directive such as max-age as a duration
+func (d Directives) Seconds(name string) (time.Duration, bool) {
+	raw, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// Policy holds the configured defaults applied when the upstream does not
+// say otherwise
+type Policy struct {
+	// DefaultTTL is used for responses without explicit freshness
+	DefaultTTL time.Duration
+	// StaleWhileRevalidate and StaleIfError apply when the response does
+	// not carry the corresponding Cache-Control extensions
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+}
+
+// IsRequestCacheable reports whether a request may be answered from the
+// cache. Only GET and HEAD are cached; HEAD is served from GET entries.
+func IsRequestCacheable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Header.Get("Upgrade") != "" {
+		return false
+	}
+	return !ParseCacheControl(req.Header).Has("no-store")
+}
+
+// RequiresRevalidation reports whether the client asked for a validated
+// response (Cache-Control: no-cache or max-age=0)
+func RequiresRevalidation(req *http.Request) bool {
+	directives := ParseCacheControl(req.Header)
+	if directives.Has("no-cache") || req.Header.Get("Pragma") == "no-cache" {
+		return true
+	}
+	maxAge, ok := directives.Seconds("max-age")
+	return ok && maxAge == 0
+}
+
+// NewEntry decides whether a response to req may be stored and, if so,
+// builds the entry with its freshness information. now is when the
+// response was received.
+func NewEntry(req *http.Request, statusCode int, header http.Header, body []byte, now time.Time, policy Policy) (*Entry, bool) {
+	directives := ParseCacheControl(header)
+	if directives.Has("no-store") || directives.Has("private") {
+		return nil, false
+	}
+
+	// Responses setting cookies are specific to one client
+	if header.Get("Set-Cookie") != "" {
+		return nil, false
+	}
+
+	// Partial and conditional responses cannot stand in for the full resource
+	if statusCode == http.StatusPartialContent || statusCode == http.StatusNotModified {
+		return nil, false
+	}
+
+	// A shared cache must not reuse responses to authenticated requests
+	// unless the origin explicitly allows it
+	if req.Header.Get("Authorization") != "" &&
+		!directives.Has("public") && !directives.Has("s-maxage") && !directives.Has("must-revalidate") {
+		return nil, false
+	}
+
+	varyValues := map[string]string{}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				varyValues[name] = req.Header.Get(name)
+			}
+		}
+	}
+
+	lifetime, explicit := freshnessLifetime(header, directives, now)
+	if !explicit {
+		if !heuristicallyCacheable[statusCode] {
+			return nil, false
+		}
+		if policy.DefaultTTL > 0 {
+			lifetime = policy.DefaultTTL
+		} else {
+			lifetime = heuristicLifetime(header, now)
+		}
+	}
+
+	entry := &Entry{
+		StatusCode:           statusCode,
+		Header:               header.Clone(),
+		Body:                 body,
+		StoredAt:             now,
+		NoCache:              directives.Has("no-cache"),
+		StaleWhileRevalidate: policy.StaleWhileRevalidate,
+		StaleIfError:         policy.StaleIfError,
+		VaryValues:           varyValues,
+	}
+
+	// Account for time the response already spent in upstream caches
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+		entry.StoredAt = now.Add(-time.Duration(age) * time.Second)
+	}
+	entry.FreshUntil = entry.StoredAt.Add(lifetime)
+
+	if swr, ok := directives.Seconds("stale-while-revalidate"); ok {
+		entry.StaleWhileRevalidate = swr
+	}
+	if sie, ok := directives.Seconds("stale-if-error"); ok {
+		entry.StaleIfError = sie
+	}
+	if directives.Has("must-revalidate") || directives.Has("proxy-revalidate") {
+		entry.StaleWhileRevalidate, entry.StaleIfError = 0, 0
+	}
+
+	// Nothing to gain from storing a response that can neither be reused,
+	// even stale, nor revalidated
+	etag, lastModified := entry.Validators()
+	if lifetime <= 0 && etag == "" && lastModified == "" &&
+		entry.StaleWhileRevalidate == 0 && entry.StaleIfError == 0 {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// freshnessLifetime follows RFC 9111 section 4.2.1 for a shared cache
+func freshnessLifetime(header http.Header, directives Directives, now time.Time) (time.Duration, bool) {
+	if sMaxAge, ok := directives.Seconds("s-maxage"); ok {
+		return sMaxAge, true
+	}
+	if maxAge, ok := directives.Seconds("max-age"); ok {
+		return maxAge, true
+	}
+	if expiresHeader := header.Get("Expires"); expiresHeader != "" {
+		expires, err := http.ParseTime(expiresHeader)
+		if err != nil {
+			// Invalid Expires values mean "already expired"
+			return 0, true
+		}
+		date := now
+		if parsed, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = parsed
+		}
+		if lifetime := expires.Sub(date); lifetime > 0 {
+			return lifetime, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func heuristicLifetime(header http.Header, now time.Time) time.Duration {
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil || !lastModified.Before(now) {
+		return 0
+	}
+	return min(now.Sub(lastModified)/heuristicLastModifiedFraction, maxHeuristicLifetime)
+}
+
+// MatchesVary reports whether req selects the same variant as the entry
+func (e *Entry) MatchesVary(req *http.Request) bool {
+	for name, value := range e.VaryValues {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// NotModifiedFor reports whether the client's conditional headers match the
+// entry, in which case it can be answered with 304
+func (e *Entry) NotModifiedFor(req *http.Request) bool {
+	if e.StatusCode != http.StatusOK {
+		return false
+	}
+
+	etag, lastModified := e.Validators()
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etag != "" && etagMatches(ifNoneMatch, etag)
+	}
+
+	if ifModifiedSince := req.Header.Get("If-Modified-Since"); ifModifiedSince != "" && lastModified != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		modified, err := http.ParseTime(lastModified)
+		return err == nil && !modified.After(since)
+	}
+	return false
+}
+
+// etagMatches applies the weak comparison used by If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh applies the headers of a 304 revalidation response to the entry,
+// returning an updated copy with renewed freshness
+func (e *Entry) Refresh(req *http.Request, notModified http.Header, now time.Time, policy Policy) (*Entry, bool) {
+	header := e.Header.Clone()
+	for name, values := range notModified {
+		if name == "Content-Length" {
+			continue
+		}
+		header[name] = values
+	}
+	return NewEntry(req, e.StatusCode, header, e.Body, now, policy)
+}
@@ -0,0 +1,214 @@
+// Package cache implements the gateway's in-memory HTTP response cache: a
+// memory-bounded LRU store plus the RFC 9111 rules that decide what may be
+// stored and for how long.
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a stored upstream response
+type Entry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	// StoredAt is when the response was received, used to compute Age
+	StoredAt time.Time
+	// FreshUntil is when the response stops being fresh
+	FreshUntil time.Time
+	// StaleWhileRevalidate and StaleIfError extend how long a stale
+	// response may still be served (RFC 5861)
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	// NoCache requires revalidation before every use
+	NoCache bool
+	// VaryValues holds the request header values named by the response's
+	// Vary header, which a request must match to use this entry
+	VaryValues map[string]string
+}
+
+// size approximates the memory held by an entry
+func (e *Entry) size() int64 {
+	size := int64(len(e.Body)) + 256
+	for name, values := range e.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	for name, value := range e.VaryValues {
+		size += int64(len(name) + len(value))
+	}
+	return size
+}
+
+// Age returns the entry's current age in seconds for the Age header
+func (e *Entry) Age(now time.Time) int64 {
+	age := int64(now.Sub(e.StoredAt) / time.Second)
+	if age < 0 {
+		return 0
+	}
+	return age
+}
+
+// IsFresh reports whether the entry may be served without revalidation
+func (e *Entry) IsFresh(now time.Time) bool {
+	return !e.NoCache && now.Before(e.FreshUntil)
+}
+
+// WithinStaleWhileRevalidate reports whether a stale entry may be served
+// while it is revalidated in the background
+func (e *Entry) WithinStaleWhileRevalidate(now time.Time) bool {
+	return !e.NoCache && e.StaleWhileRevalidate > 0 && now.Before(e.FreshUntil.Add(e.StaleWhileRevalidate))
+}
+
+// WithinStaleIfError reports whether a stale entry may be served because
+// the upstream failed
+func (e *Entry) WithinStaleIfError(now time.Time) bool {
+	return e.StaleIfError > 0 && now.Before(e.FreshUntil.Add(e.StaleIfError))
+}
+
+// Validators returns the entry's ETag and Last-Modified values
+func (e *Entry) Validators() (etag, lastModified string) {
+	return e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+}
+
+type item struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+// Store is a concurrency-safe LRU of entries bounded by total size in bytes
+type Store struct {
+	mu       sync.Mutex
+	maxBytes int64
+	used     int64
+	order    *list.List
+	items    map[string]*list.Element
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// Stats summarises the store for introspection
+type Stats struct {
+	Entries   int   `json:"entries"`
+	UsedBytes int64 `json:"used_bytes"`
+	MaxBytes  int64 `json:"max_bytes"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
+// NewStore creates a store holding at most maxBytes of responses
+func NewStore(maxBytes int64) *Store {
+	return &Store{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// Get returns the entry for key and marks it as recently used
+func (s *Store) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.items[key]
+	if !ok {
+		s.misses++
+		return nil, false
+	}
+	s.hits++
+	s.order.MoveToFront(element)
+	return element.Value.(*item).entry, true
+}
+
+// Set stores entry under key, evicting the least recently used entries to
+// stay within the size bound. Entries larger than the whole store are dropped.
+func (s *Store) Set(key string, entry *Entry) bool {
+	size := int64(len(key)) + entry.size()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if size > s.maxBytes {
+		s.removeLocked(key)
+		return false
+	}
+
+	if element, ok := s.items[key]; ok {
+		existing := element.Value.(*item)
+		s.used += size - existing.size
+		existing.entry, existing.size = entry, size
+		s.order.MoveToFront(element)
+	} else {
+		s.items[key] = s.order.PushFront(&item{key: key, entry: entry, size: size})
+		s.used += size
+	}
+
+	for s.used > s.maxBytes {
+		oldest := s.order.Back()
+		if oldest == nil {
+			break
+		}
+		s.removeLocked(oldest.Value.(*item).key)
+		s.evictions++
+	}
+	return true
+}
+
+// Delete removes a single key and reports whether it was present
+func (s *Store) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removeLocked(key)
+}
+
+// DeletePrefix removes every key starting with prefix and returns how many
+// entries were removed
+func (s *Store) DeletePrefix(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for key := range s.items {
+		if strings.HasPrefix(key, prefix) {
+			s.removeLocked(key)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (s *Store) removeLocked(key string) bool {
+	element, ok := s.items[key]
+	if !ok {
+		return false
+	}
+	s.used -= element.Value.(*item).size
+	s.order.Remove(element)
+	delete(s.items, key)
+	return true
+}
+
+// Stats returns a snapshot of the store's counters
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Stats{
+		Entries:   len(s.items),
+		UsedBytes: s.used,
+		MaxBytes:  s.maxBytes,
+		Hits:      s.hits,
+		Misses:    s.misses,
+		Evictions: s.evictions,
+	}
+}
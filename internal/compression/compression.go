@@ -0,0 +1,165 @@
+// Package compression negotiates content codings and provides pooled
+// encoders and decoders for gzip, brotli and zstd.
+package compression
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings supported by the gateway
+const (
+	Gzip   = "gzip"
+	Brotli = "br"
+	Zstd   = "zstd"
+)
+
+// ErrUnsupported is returned for content codings the gateway cannot handle
+var ErrUnsupported = errors.New("unsupported content coding")
+
+// brotliLevel trades ratio for speed; higher levels are too slow for
+// responses compressed on the fly
+const brotliLevel = 4
+
+// Negotiate picks the coding for a response from the client's
+// Accept-Encoding header and the offered codings, which are in order of
+// preference. The client's q-values win over the preference order. It
+// returns "" when the response should be sent as is.
+func Negotiate(acceptEncoding string, offered []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	weights := map[string]float64{}
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(entry, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		if coding == "x-gzip" {
+			coding = Gzip
+		}
+		weights[coding] = qValue(params)
+	}
+
+	best, bestWeight := "", 0.0
+	for _, coding := range offered {
+		weight, ok := weights[coding]
+		if !ok {
+			weight = weights["*"]
+		}
+		if weight > bestWeight {
+			best, bestWeight = coding, weight
+		}
+	}
+	return best
+}
+
+// qValue reads the q parameter of an Accept-Encoding entry, which defaults
+// to 1
+func qValue(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(name, "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 {
+			return 0
+		}
+		return min(q, 1)
+	}
+	return 1
+}
+
+// Writer compresses what is written to it. Close must be called to finish
+// the stream; the writer may not be used afterwards.
+type Writer interface {
+	io.WriteCloser
+	// Flush writes any buffered data so the receiver can decode it
+	Flush() error
+}
+
+var (
+	gzipWriters   sync.Pool
+	brotliWriters sync.Pool
+	zstdWriters   sync.Pool
+)
+
+// pooledWriter returns its encoder to the pool once the stream is closed
+type pooledWriter struct {
+	encoder interface {
+		io.WriteCloser
+		Flush() error
+	}
+	pool *sync.Pool
+}
+
+func (w *pooledWriter) Write(p []byte) (int, error) { return w.encoder.Write(p) }
+
+func (w *pooledWriter) Flush() error { return w.encoder.Flush() }
+
+func (w *pooledWriter) Close() error {
+	err := w.encoder.Close()
+	w.pool.Put(w.encoder)
+	return err
+}
+
+// NewWriter returns a writer that encodes to dst with the given coding
+func NewWriter(coding string, dst io.Writer) (Writer, error) {
+	switch coding {
+	case Gzip:
+		if encoder, ok := gzipWriters.Get().(*gzip.Writer); ok {
+			encoder.Reset(dst)
+			return &pooledWriter{encoder: encoder, pool: &gzipWriters}, nil
+		}
+		return &pooledWriter{encoder: gzip.NewWriter(dst), pool: &gzipWriters}, nil
+	case Brotli:
+		if encoder, ok := brotliWriters.Get().(*brotli.Writer); ok {
+			encoder.Reset(dst)
+			return &pooledWriter{encoder: encoder, pool: &brotliWriters}, nil
+		}
+		return &pooledWriter{encoder: brotli.NewWriterLevel(dst, brotliLevel), pool: &brotliWriters}, nil
+	case Zstd:
+		if encoder, ok := zstdWriters.Get().(*zstd.Encoder); ok {
+			encoder.Reset(dst)
+			return &pooledWriter{encoder: encoder, pool: &zstdWriters}, nil
+		}
+		encoder, err := zstd.NewWriter(dst, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &pooledWriter{encoder: encoder, pool: &zstdWriters}, nil
+	}
+	return nil, fmt.Errorf("%w '%s'", ErrUnsupported, coding)
+}
+
+// zstdMaxWindow bounds the memory a zstd request body may make the decoder
+// allocate
+const zstdMaxWindow = 8 << 20
+
+// NewReader returns a reader that decodes src with the given coding
+func NewReader(coding string, src io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(coding) {
+	case Gzip, "x-gzip":
+		return gzip.NewReader(src)
+	case Brotli:
+		return io.NopCloser(brotli.NewReader(src)), nil
+	case Zstd:
+		decoder, err := zstd.NewReader(src, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%w '%s'", ErrUnsupported, coding)
+}
@@ -0,0 +1,79 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	offered := []string{Brotli, Zstd, Gzip}
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"gzip", Gzip},
+		{"gzip, deflate, br", Brotli},
+		{"gzip, br;q=0.5", Gzip},
+		{"zstd, gzip", Zstd},
+		{"x-gzip", Gzip},
+		{"*", Brotli},
+		{"*;q=0.5, br;q=0", Zstd},
+		{"br;q=0, gzip;q=0", ""},
+		{"identity", ""},
+		{"deflate", ""},
+		{"GZIP;Q=0.8, ZSTD;q=0.9", Zstd},
+	}
+	for _, tt := range tests {
+		if got := Negotiate(tt.acceptEncoding, offered); got != tt.expected {
+			t.Errorf("Negotiate(%q): expected %q, got %q", tt.acceptEncoding, tt.expected, got)
+		}
+	}
+
+	if got := Negotiate("br, gzip", []string{Gzip}); got != Gzip {
+		t.Errorf("expected only offered codings to be picked, got %q", got)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	payload := strings.Repeat(`{"id":1,"name":"Ada"},`, 200)
+	for _, coding := range []string{Gzip, Brotli, Zstd} {
+		// Twice, so the second round uses a pooled encoder
+		for range 2 {
+			var encoded bytes.Buffer
+			w, err := NewWriter(coding, &encoded)
+			if err != nil {
+				t.Fatalf("%s: failed to create writer: %v", coding, err)
+			}
+			io.WriteString(w, payload)
+			if err := w.Close(); err != nil {
+				t.Fatalf("%s: failed to close writer: %v", coding, err)
+			}
+			if encoded.Len() >= len(payload) {
+				t.Errorf("%s: expected the payload to shrink, got %d bytes", coding, encoded.Len())
+			}
+
+			r, err := NewReader(coding, &encoded)
+			if err != nil {
+				t.Fatalf("%s: failed to create reader: %v", coding, err)
+			}
+			decoded, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || string(decoded) != payload {
+				t.Errorf("%s: round trip failed: %v", coding, err)
+			}
+		}
+	}
+}
+
+func TestUnsupportedCoding(t *testing.T) {
+	if _, err := NewWriter("deflate", io.Discard); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported from NewWriter, got %v", err)
+	}
+	if _, err := NewReader("gzip, br", strings.NewReader("")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported from NewReader, got %v", err)
+	}
+}
@@ -2,27 +2,1435 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"mime"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/ipfilter"
 )
 
 type AppConfig struct {
 	AllowedApiKey string            `json:"allowed_api_key"`
 	KnownServices map[string]string `json:"known_services"`
+	WebSocket     WebSocketConfig   `json:"websocket"`
+	Streaming     StreamingConfig   `json:"streaming"`
+	Server        ServerConfig      `json:"server"`
+	// Compression encodes responses to clients that accept it
+	Compression CompressionConfig `json:"compression"`
+	// IPFilter allows or blocks clients by address for every request
+	IPFilter *IPFilterConfig `json:"ip_filter,omitempty"`
+	// CORS is the policy for browser requests to services without their
+	// own, and to the gateway's other endpoints. Without any policy the
+	// gateway adds no CORS headers.
+	CORS *CORSConfig `json:"cors,omitempty"`
+	// Services holds optional per-service settings, keyed by the same names
+	// as KnownServices
+	Services map[string]ServiceConfig `json:"services"`
+	// Aggregates are composite routes served at /api/<aggregate>/ that fan
+	// out to several services and merge their responses
+	Aggregates map[string]AggregateConfig `json:"aggregates"`
+	Cache      CacheConfig                `json:"cache"`
+	// FaultInjection switches the per-route fault rules on or off
+	FaultInjection FaultInjectionConfig `json:"fault_injection"`
+	// Consumers are API clients with their own keys, identified by ID in
+	// logs and routing decisions. allowed_api_key keeps working as an
+	// anonymous key.
+	Consumers []ConsumerConfig `json:"consumers"`
+	// Admin is the operators' API, served on its own listener
+	Admin AdminConfig `json:"admin"`
+	// Maintenance takes every service out of service; services can set
+	// their own
+	Maintenance *MaintenanceConfig `json:"maintenance,omitempty"`
+	// Readiness controls what GET /readiness checks
+	Readiness ReadinessConfig `json:"readiness"`
 }
 
-func LoadAppConfig() AppConfig {
-	cfg := AppConfig{}
+// DefaultAdminAddress keeps the admin API reachable from this host only
+const DefaultAdminAddress = "127.0.0.1:9901"
+
+// AdminConfig controls the admin API listener. It is read once at startup;
+// reloading the config does not move or re-key it.
+type AdminConfig struct {
+	Enabled bool `json:"enabled"`
+	// Address is the host:port to listen on. Defaults to DefaultAdminAddress.
+	Address string `json:"address"`
+	// Token must be sent as "Authorization: Bearer <token>". It is separate
+	// from the API keys clients use.
+	Token string `json:"token"`
+	// StoreDir is where config revisions made through the admin API are
+	// persisted. Without it they are kept in memory and lost on restart.
+	StoreDir string `json:"store_dir"`
+	// MaxRevisions bounds how many revisions are kept. Defaults to
+	// DefaultMaxRevisions.
+	MaxRevisions int `json:"max_revisions"`
+}
+
+// DefaultMaxRevisions is used when the admin config does not set max_revisions
+const DefaultMaxRevisions = 50
+
+// Revisions returns the effective MaxRevisions
+func (a AdminConfig) Revisions() int {
+	if a.MaxRevisions > 0 {
+		return a.MaxRevisions
+	}
+	return DefaultMaxRevisions
+}
+
+// ListenAddress returns the effective Address
+func (a AdminConfig) ListenAddress() string {
+	if a.Address != "" {
+		return a.Address
+	}
+	return DefaultAdminAddress
+}
+
+// Validate checks the admin listener settings
+func (a AdminConfig) Validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if a.Token == "" {
+		return fmt.Errorf("admin: a token is required when the admin API is enabled")
+	}
+	if _, _, err := net.SplitHostPort(a.ListenAddress()); err != nil {
+		return fmt.Errorf("admin: invalid address '%s': %w", a.Address, err)
+	}
+	if a.MaxRevisions < 0 {
+		return fmt.Errorf("admin: max_revisions must not be negative")
+	}
+	return nil
+}
+
+// RedactedValue replaces secrets in Redacted configs
+const RedactedValue = "[redacted]"
+
+// sensitiveHeaderPattern matches header names whose configured values are
+// treated as secrets
+var sensitiveHeaderPattern = regexp.MustCompile(`(?i)auth|cookie|key|secret|token|password`)
+
+// Redacted returns a copy of the config that is safe to show operators:
+// API keys, the admin token and the values of credential-like headers set
+// by header rules are replaced.
+func (c AppConfig) Redacted() (AppConfig, error) {
+	redacted, err := c.Clone()
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	redact := func(value *string) {
+		if *value != "" {
+			*value = RedactedValue
+		}
+	}
+	redact(&redacted.AllowedApiKey)
+	redact(&redacted.Admin.Token)
+	for i := range redacted.Consumers {
+		redact(&redacted.Consumers[i].APIKey)
+	}
+	for _, serviceConfig := range redacted.Services {
+		redactHeaderRules(serviceConfig.Headers)
+		for _, route := range serviceConfig.Routes {
+			redactHeaderRules(route.Headers)
+		}
+	}
+	return redacted, nil
+}
+
+// Clone returns a deep copy of the config, so the copy can be changed
+// without touching the original
+func (c AppConfig) Clone() (AppConfig, error) {
+	// Every field round-trips through the config file format
+	data, err := json.Marshal(c)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	var clone AppConfig
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return AppConfig{}, err
+	}
+	return clone, nil
+}
+
+func redactHeaderRules(rules *HeaderRules) {
+	if rules == nil {
+		return
+	}
+	for _, operations := range []HeaderOperations{rules.Request, rules.Response} {
+		for _, values := range []map[string]string{operations.Set, operations.Add} {
+			for name, value := range values {
+				if value != "" && sensitiveHeaderPattern.MatchString(name) {
+					values[name] = RedactedValue
+				}
+			}
+		}
+	}
+}
+
+// ConsumerConfig identifies an API client
+type ConsumerConfig struct {
+	ID     string `json:"id"`
+	APIKey string `json:"api_key"`
+}
+
+// ValidateConsumers checks that every consumer has an ID and an API key,
+// and that neither is shared
+func (c AppConfig) ValidateConsumers() error {
+	ids, keys := map[string]bool{}, map[string]bool{c.AllowedApiKey: true}
+	for _, consumer := range c.Consumers {
+		if consumer.ID == "" || consumer.APIKey == "" {
+			return fmt.Errorf("consumers: every consumer needs an id and an api_key")
+		}
+		if ids[consumer.ID] {
+			return fmt.Errorf("consumers: duplicate id '%s'", consumer.ID)
+		}
+		if keys[consumer.APIKey] {
+			return fmt.Errorf("consumers: consumer '%s' reuses an API key", consumer.ID)
+		}
+		ids[consumer.ID], keys[consumer.APIKey] = true, true
+	}
+	return nil
+}
+
+// ConsumerForKey returns the consumer that owns an API key
+func (c AppConfig) ConsumerForKey(apiKey string) (ConsumerConfig, bool) {
+	for _, consumer := range c.Consumers {
+		if consumer.APIKey != "" && consumer.APIKey == apiKey {
+			return consumer, true
+		}
+	}
+	return ConsumerConfig{}, false
+}
+
+// DefaultCacheMaxBytes bounds the response cache when max_bytes is not set
+const DefaultCacheMaxBytes = 64 << 20
+
+// CacheConfig holds settings shared by every cached service
+type CacheConfig struct {
+	// MaxBytes bounds the memory held by cached responses; least recently
+	// used entries are evicted first. Defaults to DefaultCacheMaxBytes.
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// Limit returns the configured size bound or the default
+func (c CacheConfig) Limit() int64 {
+	if c.MaxBytes > 0 {
+		return c.MaxBytes
+	}
+	return DefaultCacheMaxBytes
+}
+
+// ServiceCacheConfig enables response caching for a service
+type ServiceCacheConfig struct {
+	// VaryHeaders are request headers that always take part in the cache
+	// key, in addition to any Vary header sent by the upstream
+	VaryHeaders []string `json:"vary_headers"`
+	// DefaultTTL applies to cacheable responses without explicit freshness
+	DefaultTTL Duration `json:"default_ttl"`
+	// StaleWhileRevalidate and StaleIfError apply when the upstream does not
+	// send the corresponding Cache-Control extensions
+	StaleWhileRevalidate Duration `json:"stale_while_revalidate"`
+	StaleIfError         Duration `json:"stale_if_error"`
+	// MaxEntryBytes skips caching of larger responses. Defaults to 1 MiB.
+	MaxEntryBytes int64 `json:"max_entry_bytes"`
+}
+
+// AggregateConfig declares the upstream calls behind a composite route
+type AggregateConfig struct {
+	Calls []AggregateCall `json:"calls"`
+}
+
+// AggregateCall is one upstream request of an aggregate. Calls run in
+// parallel unless they depend on the output of another call.
+type AggregateCall struct {
+	// Key is where the call's JSON response is placed in the merged document
+	Key string `json:"key"`
+	// Service is the known service the call is sent to
+	Service string `json:"service"`
+	// Method defaults to GET
+	Method string `json:"method"`
+	// Path is relative to the service and may reference fields of earlier
+	// responses as ${key.field}, e.g. "/users/${session.user_id}"
+	Path string `json:"path"`
+	// DependsOn lists calls that must finish first, in addition to the
+	// ones referenced in Path
+	DependsOn []string `json:"depends_on"`
+	// Required calls fail the whole aggregate; optional ones are reported
+	// as null with their error listed under "_errors"
+	Required bool `json:"required"`
+	// Timeout bounds this call. Zero leaves it to the client request.
+	Timeout Duration `json:"timeout"`
+}
+
+// ServerConfig controls the client-facing listener
+type ServerConfig struct {
+	// H2C enables HTTP/2 over cleartext TCP (prior knowledge) next to
+	// HTTP/1.1, which gRPC clients on the internal network rely on
+	H2C bool `json:"h2c"`
+	// TLSCertFile and TLSKeyFile enable TLS on the listener; HTTP/2 is then
+	// negotiated with ALPN
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	// Limits protect the listener from oversized requests and slow clients
+	Limits RequestLimitsConfig `json:"limits"`
+	// TrustedProxies are the CIDRs of load balancers and proxies in front
+	// of the gateway. Only their ClientIPHeader is believed when resolving
+	// the client's address.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// ClientIPHeader lists the addresses a request came through, appended
+	// to by each proxy. Defaults to DefaultClientIPHeader.
+	ClientIPHeader string `json:"client_ip_header"`
+	// Shutdown controls how the listener drains when the gateway stops
+	Shutdown ShutdownConfig `json:"shutdown"`
+}
+
+// DefaultDrainTimeout bounds the wait for requests in flight at shutdown
+const DefaultDrainTimeout = 5 * time.Second
+
+// ShutdownConfig controls the shutdown sequence: readiness fails, the
+// pre-stop delay passes, the listener closes and requests in flight get up
+// to the drain timeout to finish
+type ShutdownConfig struct {
+	// PreStopDelay keeps accepting connections after readiness starts
+	// failing, so load balancers notice before connections are refused
+	PreStopDelay Duration `json:"pre_stop_delay"`
+	// DrainTimeout is how long requests in flight get to finish before
+	// they are cut off. Defaults to DefaultDrainTimeout.
+	DrainTimeout Duration `json:"drain_timeout"`
+}
+
+// Timeout returns the effective DrainTimeout
+func (s ShutdownConfig) Timeout() time.Duration {
+	if s.DrainTimeout.Duration > 0 {
+		return s.DrainTimeout.Duration
+	}
+	return DefaultDrainTimeout
+}
+
+// Validate rejects negative durations
+func (s ShutdownConfig) Validate() error {
+	if s.PreStopDelay.Duration < 0 || s.DrainTimeout.Duration < 0 {
+		return fmt.Errorf("server: shutdown durations must not be negative")
+	}
+	return nil
+}
+
+// DefaultClientIPHeader is read when client_ip_header is not set
+const DefaultClientIPHeader = "X-Forwarded-For"
+
+// ClientHeader returns the effective ClientIPHeader
+func (s ServerConfig) ClientHeader() string {
+	if s.ClientIPHeader != "" {
+		return s.ClientIPHeader
+	}
+	return DefaultClientIPHeader
+}
+
+// TrustedProxySet parses TrustedProxies
+func (s ServerConfig) TrustedProxySet() (*ipfilter.Set, error) {
+	return ipfilter.Parse(s.TrustedProxies)
+}
+
+// Defaults for the request limits
+const (
+	DefaultReadHeaderTimeout     = 5 * time.Second
+	DefaultMaxHeaderBytes        = 64 << 10
+	DefaultMaxURLLength          = 8 << 10
+	DefaultMaxQueryParams        = 100
+	DefaultMaxBodyBytes          = 10 << 20
+	DefaultMinBodyBytesPerSecond = 1024
+	DefaultMinBodyRateGrace      = 5 * time.Second
+)
+
+// RequestLimitsConfig bounds the size of client requests and how slowly
+// they may be sent. Zero values use the defaults above.
+type RequestLimitsConfig struct {
+	// ReadHeaderTimeout bounds the time to read the request line and headers
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	// MaxHeaderBytes caps the request line and headers
+	MaxHeaderBytes int `json:"max_header_bytes"`
+	// MaxURLLength caps the request target, path and query included
+	MaxURLLength int `json:"max_url_length"`
+	// MaxQueryParams caps the number of query parameters
+	MaxQueryParams int `json:"max_query_params"`
+	// MaxBodyBytes caps request bodies. Routes may set their own limit.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// MinBodyBytesPerSecond is the lowest average rate at which a body may
+	// be sent once MinBodyRateGrace has passed. Negative disables the check.
+	MinBodyBytesPerSecond int64 `json:"min_body_bytes_per_second"`
+	// MinBodyRateGrace is the time a client has before the rate applies
+	MinBodyRateGrace Duration `json:"min_body_rate_grace"`
+}
+
+// HeaderTimeout returns the effective ReadHeaderTimeout
+func (l RequestLimitsConfig) HeaderTimeout() time.Duration {
+	if l.ReadHeaderTimeout.Duration > 0 {
+		return l.ReadHeaderTimeout.Duration
+	}
+	return DefaultReadHeaderTimeout
+}
+
+// HeaderBytes returns the effective MaxHeaderBytes
+func (l RequestLimitsConfig) HeaderBytes() int {
+	if l.MaxHeaderBytes > 0 {
+		return l.MaxHeaderBytes
+	}
+	return DefaultMaxHeaderBytes
+}
+
+// URLLength returns the effective MaxURLLength
+func (l RequestLimitsConfig) URLLength() int {
+	if l.MaxURLLength > 0 {
+		return l.MaxURLLength
+	}
+	return DefaultMaxURLLength
+}
+
+// QueryParams returns the effective MaxQueryParams
+func (l RequestLimitsConfig) QueryParams() int {
+	if l.MaxQueryParams > 0 {
+		return l.MaxQueryParams
+	}
+	return DefaultMaxQueryParams
+}
+
+// BodyBytes returns the effective MaxBodyBytes
+func (l RequestLimitsConfig) BodyBytes() int64 {
+	if l.MaxBodyBytes > 0 {
+		return l.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// MinBodyRate returns the effective MinBodyBytesPerSecond and grace period.
+// A zero rate means the check is disabled.
+func (l RequestLimitsConfig) MinBodyRate() (int64, time.Duration) {
+	rate, grace := l.MinBodyBytesPerSecond, l.MinBodyRateGrace.Duration
+	if rate < 0 {
+		return 0, 0
+	}
+	if rate == 0 {
+		rate = DefaultMinBodyBytesPerSecond
+	}
+	if grace <= 0 {
+		grace = DefaultMinBodyRateGrace
+	}
+	return rate, grace
+}
+
+// DefaultCompressionMinSizeBytes is used when compression does not set
+// min_size_bytes
+const DefaultCompressionMinSizeBytes = 1024
+
+// CompressionEncodings are the content codings the gateway can produce and
+// decode
+var CompressionEncodings = []string{"br", "zstd", "gzip"}
+
+// DefaultCompressionContentTypes are compressed when the compression config
+// does not list its own content types
+var DefaultCompressionContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/javascript",
+	"application/xml",
+	"application/*+xml",
+	"image/svg+xml",
+}
+
+// CompressionConfig controls compression of responses to clients
+type CompressionConfig struct {
+	Enabled bool `json:"enabled"`
+	// Encodings are offered in order of preference; a client's q-values
+	// take precedence. Defaults to CompressionEncodings.
+	Encodings []string `json:"encodings"`
+	// MinSizeBytes is the smallest response body worth compressing.
+	// Defaults to DefaultCompressionMinSizeBytes.
+	MinSizeBytes int `json:"min_size_bytes"`
+	// ContentTypes are the media types that are compressed; a "*" matches
+	// any run of characters, e.g. "text/*" or "application/*+json".
+	// Defaults to DefaultCompressionContentTypes.
+	ContentTypes []string `json:"content_types"`
+}
+
+// Validate rejects encodings the gateway cannot produce
+func (c CompressionConfig) Validate() error {
+	for _, encoding := range c.Encodings {
+		if !slices.Contains(CompressionEncodings, encoding) {
+			return fmt.Errorf("compression: unsupported encoding '%s', expected one of %s", encoding, strings.Join(CompressionEncodings, ", "))
+		}
+	}
+	return nil
+}
+
+// Offered returns the effective Encodings
+func (c CompressionConfig) Offered() []string {
+	if len(c.Encodings) > 0 {
+		return c.Encodings
+	}
+	return CompressionEncodings
+}
+
+// MinSize returns the effective MinSizeBytes
+func (c CompressionConfig) MinSize() int {
+	if c.MinSizeBytes > 0 {
+		return c.MinSizeBytes
+	}
+	return DefaultCompressionMinSizeBytes
+}
+
+// IsCompressibleContentType reports whether responses with the given
+// Content-Type header may be compressed
+func (c CompressionConfig) IsCompressibleContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	contentTypes := c.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultCompressionContentTypes
+	}
+	for _, pattern := range contentTypes {
+		pattern = strings.ToLower(pattern)
+		prefix, suffix, wildcard := strings.Cut(pattern, "*")
+		if !wildcard {
+			if mediaType == pattern {
+				return true
+			}
+			continue
+		}
+		if len(mediaType) >= len(prefix)+len(suffix) && strings.HasPrefix(mediaType, prefix) && strings.HasSuffix(mediaType, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Defaults for CORS policies that do not list their own methods or headers
+var (
+	DefaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	DefaultCORSHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type", "Authorization", "X-Request-ID", "X-API-Key"}
+)
+
+// CORSRegexPrefix marks an allowed origin that is a regular expression
+const CORSRegexPrefix = "regex:"
+
+// CORSConfig is a policy for cross-origin browser requests
+type CORSConfig struct {
+	// AllowedOrigins are exact origins ("https://app.example.com"), origins
+	// with a wildcard subdomain ("https://*.example.com"), regular
+	// expressions prefixed with "regex:", or "*" for any origin
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedMethods defaults to DefaultCORSMethods
+	AllowedMethods []string `json:"allowed_methods"`
+	// AllowedHeaders are the request headers a browser may send; "*"
+	// allows any. Defaults to DefaultCORSHeaders.
+	AllowedHeaders []string `json:"allowed_headers"`
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string `json:"exposed_headers"`
+	// AllowCredentials lets browsers send cookies and read the response
+	AllowCredentials bool `json:"allow_credentials"`
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge Duration `json:"max_age"`
+}
+
+// Validate checks the origin patterns. Credentials cannot be combined with
+// wildcards, which browsers refuse.
+func (c CORSConfig) Validate() error {
+	if len(c.AllowedOrigins) == 0 {
+		return errors.New("allowed_origins is required")
+	}
+	for _, origin := range c.AllowedOrigins {
+		switch {
+		case origin == "*":
+			if c.AllowCredentials {
+				return errors.New("allowed origin '*' cannot be combined with allow_credentials")
+			}
+		case strings.HasPrefix(origin, CORSRegexPrefix):
+			if _, err := regexp.Compile(CORSOriginPattern(origin)); err != nil {
+				return fmt.Errorf("allowed origin '%s': %w", origin, err)
+			}
+		case strings.Count(origin, "*") > 1 || strings.Contains(origin, "*") && !strings.Contains(origin, "://*."):
+			return fmt.Errorf("allowed origin '%s': a wildcard may only stand for subdomains, as in https://*.example.com", origin)
+		}
+	}
+	if c.AllowCredentials && slices.Contains(c.AllowedHeaders, "*") {
+		return errors.New("allowed header '*' cannot be combined with allow_credentials")
+	}
+	return nil
+}
+
+// CORSOriginPattern returns the regular expression of a regex: origin,
+// anchored so that it must match the whole origin. Otherwise
+// https://.*\.example\.com would also match https://x.example.com.attacker.net.
+func CORSOriginPattern(origin string) string {
+	return `^(?:` + strings.TrimPrefix(origin, CORSRegexPrefix) + `)$`
+}
+
+// Methods returns the effective AllowedMethods
+func (c CORSConfig) Methods() []string {
+	if len(c.AllowedMethods) > 0 {
+		return c.AllowedMethods
+	}
+	return DefaultCORSMethods
+}
+
+// Headers returns the effective AllowedHeaders
+func (c CORSConfig) Headers() []string {
+	if len(c.AllowedHeaders) > 0 {
+		return c.AllowedHeaders
+	}
+	return DefaultCORSHeaders
+}
+
+// ValidateCORS checks the gateway's CORS policy and those of its services
+func (c AppConfig) ValidateCORS() error {
+	if c.CORS != nil {
+		if err := c.CORS.Validate(); err != nil {
+			return fmt.Errorf("cors: %w", err)
+		}
+	}
+	for serviceName, serviceConfig := range c.Services {
+		if serviceConfig.CORS == nil {
+			continue
+		}
+		if err := serviceConfig.CORS.Validate(); err != nil {
+			return fmt.Errorf("service '%s': cors: %w", serviceName, err)
+		}
+	}
+	return nil
+}
+
+// CORSPolicy returns the CORS policy of a service, falling back to the
+// gateway's own. It returns nil when neither is configured.
+func (c AppConfig) CORSPolicy(serviceName string) *CORSConfig {
+	if policy := c.Service(serviceName).CORS; policy != nil {
+		return policy
+	}
+	return c.CORS
+}
+
+// IPFilterConfig allows or blocks clients by address. Entries are IPv4 or
+// IPv6 CIDRs or single addresses.
+type IPFilterConfig struct {
+	// Allow restricts access to clients in these ranges
+	Allow []string `json:"allow"`
+	// AllowFile adds entries to Allow from a file, one per line
+	AllowFile string `json:"allow_file"`
+	// Deny blocks clients in these ranges, even when they are allowed
+	Deny []string `json:"deny"`
+	// DenyFile adds entries to Deny from a file, one per line
+	DenyFile string `json:"deny_file"`
+}
+
+// Lists parses the allow and deny lists, reading the files they name. The
+// allow list is nil when neither Allow nor AllowFile is set, so everyone
+// not denied is let through.
+func (c IPFilterConfig) Lists() (allow, deny *ipfilter.Set, err error) {
+	if len(c.Allow) > 0 || c.AllowFile != "" {
+		if allow, err = parseIPList(c.Allow, c.AllowFile); err != nil {
+			return nil, nil, fmt.Errorf("allow: %w", err)
+		}
+	}
+	if deny, err = parseIPList(c.Deny, c.DenyFile); err != nil {
+		return nil, nil, fmt.Errorf("deny: %w", err)
+	}
+	return allow, deny, nil
+}
+
+func parseIPList(entries []string, file string) (*ipfilter.Set, error) {
+	if file != "" {
+		fromFile, err := ipfilter.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(slices.Clone(entries), fromFile...)
+	}
+	return ipfilter.Parse(entries)
+}
+
+// Defaults for the readiness probes of critical services
+const (
+	DefaultProbeTimeout  = time.Second
+	DefaultProbeInterval = 5 * time.Second
+)
+
+// ReadinessConfig controls when the gateway reports itself ready to take
+// traffic
+type ReadinessConfig struct {
+	// CriticalServices need a target that is active, passively healthy and
+	// accepting connections for the gateway to be ready
+	CriticalServices []string `json:"critical_services"`
+	// WarmUp keeps the gateway not ready for a while after it starts
+	WarmUp Duration `json:"warm_up"`
+	// ProbeTimeout bounds the connection attempts to critical services'
+	// targets. Defaults to DefaultProbeTimeout.
+	ProbeTimeout Duration `json:"probe_timeout"`
+	// ProbeInterval is how long a probe result is reused. Defaults to
+	// DefaultProbeInterval.
+	ProbeInterval Duration `json:"probe_interval"`
+}
+
+// Timeout returns the effective ProbeTimeout
+func (r ReadinessConfig) Timeout() time.Duration {
+	if r.ProbeTimeout.Duration > 0 {
+		return r.ProbeTimeout.Duration
+	}
+	return DefaultProbeTimeout
+}
 
-	err := readConfig(GetEnvironment(), &cfg)
+// Interval returns the effective ProbeInterval
+func (r ReadinessConfig) Interval() time.Duration {
+	if r.ProbeInterval.Duration > 0 {
+		return r.ProbeInterval.Duration
+	}
+	return DefaultProbeInterval
+}
+
+// ValidateReadiness checks that critical services are known services
+func (c AppConfig) ValidateReadiness() error {
+	for _, serviceName := range c.Readiness.CriticalServices {
+		if _, known := c.KnownServices[serviceName]; !known {
+			return fmt.Errorf("readiness: critical service '%s' is not a known service", serviceName)
+		}
+	}
+	if c.Readiness.WarmUp.Duration < 0 || c.Readiness.ProbeTimeout.Duration < 0 || c.Readiness.ProbeInterval.Duration < 0 {
+		return fmt.Errorf("readiness: durations must not be negative")
+	}
+	return nil
+}
+
+// DefaultMaintenanceRetryAfter is sent in Retry-After when the end of a
+// maintenance is not known and the config does not set retry_after
+const DefaultMaintenanceRetryAfter = 5 * time.Minute
+
+// MaintenanceConfig answers requests with 503 instead of forwarding them,
+// from now on or during scheduled windows
+type MaintenanceConfig struct {
+	// Enabled starts the maintenance now, until the config changes
+	Enabled bool `json:"enabled"`
+	// Windows schedule maintenance in advance
+	Windows []MaintenanceWindow `json:"windows"`
+	// RetryAfter is sent when the end of the maintenance is not known.
+	// Defaults to DefaultMaintenanceRetryAfter.
+	RetryAfter Duration `json:"retry_after"`
+	// ContentType with Body, or a BodyFile read when the config is
+	// applied, replaces the problem details response, e.g. with an HTML
+	// page. gRPC calls always get a grpc-status.
+	ContentType string `json:"content_type"`
+	Body        string `json:"body"`
+	BodyFile    string `json:"body_file"`
+	// AllowConsumers and AllowIPs are still forwarded, so the backend can
+	// be checked before it is reopened
+	AllowConsumers []string `json:"allow_consumers"`
+	AllowIPs       []string `json:"allow_ips"`
+}
+
+// MaintenanceWindow is a scheduled maintenance, in RFC 3339 times
+type MaintenanceWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ActiveAt reports whether the maintenance is on at now and, if a window
+// says so, when it ends. An enabled maintenance has no known end.
+func (m MaintenanceConfig) ActiveAt(now time.Time) (bool, time.Time) {
+	if m.Enabled {
+		return true, time.Time{}
+	}
+	for _, window := range m.Windows {
+		if !now.Before(window.Start) && now.Before(window.End) {
+			return true, window.End
+		}
+	}
+	return false, time.Time{}
+}
+
+// RetryAfterDuration returns the effective RetryAfter
+func (m MaintenanceConfig) RetryAfterDuration() time.Duration {
+	if m.RetryAfter.Duration > 0 {
+		return m.RetryAfter.Duration
+	}
+	return DefaultMaintenanceRetryAfter
+}
+
+// ResponseBody returns the configured response body, if any
+func (m MaintenanceConfig) ResponseBody() ([]byte, error) {
+	if m.BodyFile != "" {
+		return os.ReadFile(m.BodyFile)
+	}
+	if m.Body != "" {
+		return []byte(m.Body), nil
+	}
+	return nil, nil
+}
+
+// Validate checks the windows, response and allowlist
+func (m MaintenanceConfig) Validate() error {
+	for _, window := range m.Windows {
+		if window.Start.IsZero() || !window.End.After(window.Start) {
+			return fmt.Errorf("windows need a start and an end after it")
+		}
+	}
+	if m.RetryAfter.Duration < 0 {
+		return fmt.Errorf("retry_after must not be negative")
+	}
+	if m.Body != "" && m.BodyFile != "" {
+		return fmt.Errorf("set body or body_file, not both")
+	}
+	if (m.Body != "" || m.BodyFile != "") && m.ContentType == "" {
+		return fmt.Errorf("a custom body needs a content_type")
+	}
+	if _, err := m.ResponseBody(); err != nil {
+		return fmt.Errorf("body_file: %w", err)
+	}
+	if _, err := ipfilter.Parse(m.AllowIPs); err != nil {
+		return fmt.Errorf("allow_ips: %w", err)
+	}
+	return nil
+}
+
+// ValidateMaintenance checks the gateway's and every service's maintenance
+func (c AppConfig) ValidateMaintenance() error {
+	if c.Maintenance != nil {
+		if err := c.Maintenance.Validate(); err != nil {
+			return fmt.Errorf("maintenance: %w", err)
+		}
+	}
+	for serviceName, serviceConfig := range c.Services {
+		if serviceConfig.Maintenance == nil {
+			continue
+		}
+		if err := serviceConfig.Maintenance.Validate(); err != nil {
+			return fmt.Errorf("service '%s': maintenance: %w", serviceName, err)
+		}
+	}
+	return nil
+}
+
+// ValidateIPFilters checks the trusted proxies and every allow and deny list
+func (c AppConfig) ValidateIPFilters() error {
+	if _, err := c.Server.TrustedProxySet(); err != nil {
+		return fmt.Errorf("server: trusted_proxies: %w", err)
+	}
+	if c.IPFilter != nil {
+		if _, _, err := c.IPFilter.Lists(); err != nil {
+			return fmt.Errorf("ip_filter: %w", err)
+		}
+	}
+	for serviceName, serviceConfig := range c.Services {
+		if serviceConfig.IPFilter == nil {
+			continue
+		}
+		if _, _, err := serviceConfig.IPFilter.Lists(); err != nil {
+			return fmt.Errorf("service '%s': ip_filter: %w", serviceName, err)
+		}
+	}
+	return nil
+}
+
+// ParseUpstreamURL parses the base URL of an upstream. It must be an
+// absolute http or https URL with a host, and carry no credentials, query
+// or fragment, since none of them would be sent.
+func ParseUpstreamURL(raw string) (*url.URL, error) {
+	upstream, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case upstream.Scheme != "http" && upstream.Scheme != "https":
+		return nil, fmt.Errorf("upstream URL '%s' must use http or https", raw)
+	case upstream.Hostname() == "":
+		return nil, fmt.Errorf("upstream URL '%s' has no host", raw)
+	case upstream.User != nil:
+		return nil, fmt.Errorf("upstream URL '%s' must not contain credentials", raw)
+	case upstream.RawQuery != "" || upstream.ForceQuery || upstream.Fragment != "":
+		return nil, fmt.Errorf("upstream URL '%s' must not have a query or fragment", raw)
+	}
+	return upstream, nil
+}
+
+// parseProxiedUpstream parses the URL of an upstream that requests are
+// proxied to. Proxied requests keep their own path below /api/<service>,
+// so a base path would be silently dropped and is refused instead.
+func parseProxiedUpstream(raw string) (*url.URL, error) {
+	upstream, err := ParseUpstreamURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	if upstream.Path != "" && upstream.Path != "/" {
+		return nil, fmt.Errorf("upstream URL '%s' must not have a path", raw)
+	}
+	return upstream, nil
+}
+
+// ValidateUpstreams checks the URL of every service, version and mirror
+func (c AppConfig) ValidateUpstreams() error {
+	for serviceName, raw := range c.KnownServices {
+		if _, err := parseProxiedUpstream(raw); err != nil {
+			return fmt.Errorf("known_services: service '%s': %w", serviceName, err)
+		}
+	}
+	for serviceName, serviceConfig := range c.Services {
+		for _, version := range serviceConfig.Versions {
+			for _, raw := range version.Upstreams {
+				if _, err := parseProxiedUpstream(raw); err != nil {
+					return fmt.Errorf("service '%s': version '%s': %w", serviceName, version.Name, err)
+				}
+			}
+		}
+		if serviceConfig.Mirror != nil {
+			if _, err := ParseUpstreamURL(serviceConfig.Mirror.URL); err != nil {
+				return fmt.Errorf("service '%s': mirror: %w", serviceName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// UpstreamTargets returns every URL requests are proxied to, with the
+// services that use it: "users" for a known_services URL and
+// "users/canary" for a version's upstream. Mirrors are not included.
+func (c AppConfig) UpstreamTargets() map[string][]string {
+	targets := map[string][]string{}
+	for serviceName, raw := range c.KnownServices {
+		if len(c.Service(serviceName).Versions) == 0 {
+			targets[raw] = append(targets[raw], serviceName)
+		}
+	}
+	for serviceName, serviceConfig := range c.Services {
+		if _, known := c.KnownServices[serviceName]; !known {
+			continue
+		}
+		for _, version := range serviceConfig.Versions {
+			for _, raw := range version.Upstreams {
+				targets[raw] = append(targets[raw], serviceName+"/"+version.Name)
+			}
+		}
+	}
+	for _, users := range targets {
+		slices.Sort(users)
+	}
+	return targets
+}
+
+// ProtocolGRPC marks a service whose upstreams speak gRPC over HTTP/2
+const ProtocolGRPC = "grpc"
+
+// ServiceConfig holds settings for a single known service
+type ServiceConfig struct {
+	// Protocol is the upstream protocol: "http" (default) or "grpc"
+	Protocol string `json:"protocol"`
+	// GRPCServices lists the fully-qualified gRPC services (e.g.
+	// "users.v1.UserService") or packages (e.g. "users.v1") that are routed
+	// to this service when called as /package.Service/Method
+	GRPCServices []string `json:"grpc_services"`
+	// Transcoding exposes the service's gRPC methods as JSON/HTTP routes
+	Transcoding *TranscodingConfig `json:"transcoding,omitempty"`
+	// Cache enables the shared response cache for the service
+	Cache *ServiceCacheConfig `json:"cache,omitempty"`
+	// Routes apply settings to a subset of the service's paths
+	Routes []RouteConfig `json:"routes"`
+	// Versions are named deployments of the service with their own
+	// upstreams. When set, requests are split between them and the
+	// known_services URL is no longer used for proxying.
+	Versions []VersionConfig `json:"versions"`
+	// TrafficSplit controls how requests are assigned to Versions
+	TrafficSplit TrafficSplitConfig `json:"traffic_split"`
+	// Mirror sends a copy of live traffic to a shadow upstream
+	Mirror *MirrorConfig `json:"mirror,omitempty"`
+	// Headers transform the headers of upstream requests and client
+	// responses. Route rules are applied after these.
+	Headers *HeaderRules `json:"headers,omitempty"`
+	// ForwardAPIKey passes the client's x-api-key on to the upstream, which
+	// otherwise never sees it
+	ForwardAPIKey bool `json:"forward_api_key"`
+	// OpenAPI validates the service's requests against its OpenAPI 3 document
+	OpenAPI *OpenAPIConfig `json:"openapi,omitempty"`
+	// IPFilter allows or blocks clients of the service by address, in
+	// addition to the gateway's own lists
+	IPFilter *IPFilterConfig `json:"ip_filter,omitempty"`
+	// CORS replaces the gateway's CORS policy for the service
+	CORS *CORSConfig `json:"cors,omitempty"`
+	// DecompressRequests decodes gzip, br and zstd request bodies before
+	// they are forwarded, for upstreams that only accept plain bodies
+	DecompressRequests bool `json:"decompress_requests"`
+	// Maintenance takes the service out of service, replacing the
+	// gateway's maintenance response and allowlist
+	Maintenance *MaintenanceConfig `json:"maintenance,omitempty"`
+}
+
+// OpenAPIConfig points at a service's OpenAPI 3 document. Its paths are
+// matched below /api/<service>, whatever servers the document declares.
+type OpenAPIConfig struct {
+	// Spec is the path to the document, in YAML or JSON
+	Spec string `json:"spec"`
+	// Strict rejects requests for operations the document does not declare.
+	// Otherwise they are forwarded unvalidated.
+	Strict bool `json:"strict"`
+	// LogResponseViolations validates responses too, logging violations
+	// without changing the response. Meant for dev.
+	LogResponseViolations bool `json:"log_response_violations"`
+}
+
+// HeaderRules transform headers in both directions
+type HeaderRules struct {
+	// Request applies to the request sent upstream
+	Request HeaderOperations `json:"request"`
+	// Response applies to the response sent to the client
+	Response HeaderOperations `json:"response"`
+}
+
+// HeaderOperations are applied in the order remove, rename, set, add.
+// Values of set and add may reference ${request_id}, ${client_ip},
+// ${principal_id}, ${service}, ${route} and ${version}.
+type HeaderOperations struct {
+	Remove []string          `json:"remove"`
+	Rename map[string]string `json:"rename"`
+	Set    map[string]string `json:"set"`
+	Add    map[string]string `json:"add"`
+}
+
+// Defaults for mirrored traffic
+const (
+	DefaultMirrorMaxBodyBytes  = 1 << 20
+	DefaultMirrorMaxConcurrent = 50
+)
+
+// MirrorConfig copies a share of a service's requests to a shadow upstream
+// whose responses are only logged, never returned to the client
+type MirrorConfig struct {
+	// URL is the base URL of the shadow upstream
+	URL string `json:"url"`
+	// Percentage of requests to mirror, from 0 to 100
+	Percentage float64 `json:"percentage"`
+	// MaxBodyBytes bounds the request body buffered for the mirror and the
+	// response bodies kept for comparison. Requests with larger bodies are
+	// not mirrored. Defaults to DefaultMirrorMaxBodyBytes.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// MaxConcurrent bounds the mirrored requests in flight; requests beyond
+	// it are not mirrored. Defaults to DefaultMirrorMaxConcurrent.
+	MaxConcurrent int `json:"max_concurrent"`
+	// Timeout bounds each mirrored request. Defaults to 10s.
+	Timeout Duration `json:"timeout"`
+	// CompareBodies logs the differences between the primary and the
+	// mirror response bodies
+	CompareBodies bool `json:"compare_bodies"`
+}
+
+// VersionConfig is one named deployment of a service
+type VersionConfig struct {
+	Name string `json:"name"`
+	// Upstreams are base URLs used in round-robin order
+	Upstreams []string `json:"upstreams"`
+	// Weight is the version's share of requests that no override or sticky
+	// cookie assigns, relative to the other versions' weights
+	Weight int `json:"weight"`
+}
+
+// TrafficSplitConfig assigns requests to service versions
+type TrafficSplitConfig struct {
+	// Overrides are checked in order before the weighted split
+	Overrides []VersionOverride `json:"overrides"`
+	// StickyCookie, when set, is issued with the version picked by the
+	// weighted split so the client keeps getting the same version
+	StickyCookie string `json:"sticky_cookie"`
+	// StickyTTL is the lifetime of the sticky cookie. Defaults to 24h.
+	StickyTTL Duration `json:"sticky_ttl"`
+}
+
+// VersionOverride routes matching requests to a fixed version. Exactly one
+// of Header, Cookie or Consumer selects what is compared with Value.
+type VersionOverride struct {
+	// Header matches a request header, e.g. "X-Canary" with value "true"
+	Header string `json:"header"`
+	// Cookie matches a request cookie by name
+	Cookie string `json:"cookie"`
+	// Consumers matches the IDs of authenticated consumers
+	Consumers []string `json:"consumers"`
+	// Value is compared case-insensitively with the header or cookie
+	Value   string `json:"value"`
+	Version string `json:"version"`
+}
+
+// Version returns the named version of the service
+func (s ServiceConfig) Version(name string) (VersionConfig, bool) {
+	for _, version := range s.Versions {
+		if version.Name == name {
+			return version, true
+		}
+	}
+	return VersionConfig{}, false
+}
+
+// RouteConfig selects requests to a service by method and path
+type RouteConfig struct {
+	// Name identifies the route in logs and metrics. Defaults to PathPrefix.
+	Name string `json:"name"`
+	// PathPrefix matches paths relative to /api/<service> on path segment
+	// boundaries, e.g. "/users" matches "/users" and "/users/1" but not
+	// "/usersX". The longest matching prefix wins; an empty prefix matches
+	// everything.
+	PathPrefix string `json:"path_prefix"`
+	// Methods restricts the route to these HTTP methods. Empty matches all.
+	Methods []string `json:"methods"`
+	// Coalesce collapses identical concurrent requests into one upstream call
+	Coalesce *CoalesceConfig `json:"coalesce,omitempty"`
+	// Faults are injected into matching requests when fault injection is enabled
+	Faults []FaultRule `json:"faults"`
+	// Headers transform headers of the route's requests and responses,
+	// after the service's rules
+	Headers *HeaderRules `json:"headers,omitempty"`
+	// Transform rewrites the JSON bodies of the route's requests and responses
+	Transform *BodyTransforms `json:"transform,omitempty"`
+	// MaxBodyBytes replaces server.limits.max_body_bytes for the route
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+}
+
+// DefaultTransformMaxBodyBytes caps JSON bodies that have to be buffered to
+// be transformed
+const DefaultTransformMaxBodyBytes = 1 << 20
+
+// BodyTransforms rewrite JSON bodies in both directions
+type BodyTransforms struct {
+	// Request applies to the body sent upstream
+	Request *BodyTransform `json:"request,omitempty"`
+	// Response applies to the body sent to the client
+	Response *BodyTransform `json:"response,omitempty"`
+	// MaxBodyBytes caps bodies that cannot be transformed as a stream.
+	// Defaults to DefaultTransformMaxBodyBytes.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+}
+
+// BodyLimit returns the effective MaxBodyBytes
+func (t BodyTransforms) BodyLimit() int64 {
+	if t.MaxBodyBytes > 0 {
+		return t.MaxBodyBytes
+	}
+	return DefaultTransformMaxBodyBytes
+}
+
+// BodyTransform operations are applied in the order unwrap, allow, remove,
+// rename, set, wrap. Paths use a JSONPath subset: "$", ".field", "[0]" and
+// "[*]", e.g. "$.data.items[*].id".
+type BodyTransform struct {
+	// Unwrap replaces the body with the value at this path, e.g. "$.data"
+	Unwrap string `json:"unwrap"`
+	// Allow keeps only these paths and drops every other field
+	Allow []string `json:"allow"`
+	// Remove deletes these paths
+	Remove []string `json:"remove"`
+	// Rename gives the field at each path a new name, e.g.
+	// {"$.items[*].user_name": "name"}
+	Rename map[string]string `json:"rename"`
+	// Set writes a JSON value at each path, creating missing objects
+	Set map[string]json.RawMessage `json:"set"`
+	// Wrap nests the body under this field name, e.g. "data"
+	Wrap string `json:"wrap"`
+}
+
+// productionEnvironment is the APP_ENV value of production deployments
+const productionEnvironment = "prod"
+
+// FaultInjectionConfig guards the fault rules used for chaos testing
+type FaultInjectionConfig struct {
+	// Enabled turns the routes' fault rules on
+	Enabled bool `json:"enabled"`
+	// AllowInProduction lets the gateway start in prod with fault rules
+	// configured. Without it, startup is refused.
+	AllowInProduction bool `json:"allow_in_production"`
+}
+
+// Delay distributions for injected faults
+const (
+	DelayFixed   = "fixed"
+	DelayUniform = "uniform"
+	DelayNormal  = "normal"
+)
+
+// FaultRule describes faults injected into a share of a route's requests.
+// The delay is applied first, then the request is aborted, reset or
+// forwarded with a throttled response.
+type FaultRule struct {
+	// Percentage of matching requests affected, from 0 to 100. Defaults to
+	// 100 for rules triggered by Header.
+	Percentage float64 `json:"percentage"`
+	// Header limits the rule to requests carrying this header, optionally
+	// with HeaderValue
+	Header      string `json:"header"`
+	HeaderValue string `json:"header_value"`
+
+	Delay *DelayFault `json:"delay,omitempty"`
+	// AbortStatus answers the request with this status instead of forwarding it
+	AbortStatus int `json:"abort_status"`
+	// ResetConnection drops the client connection without a response
+	ResetConnection bool `json:"reset_connection"`
+	// BandwidthBytesPerSecond throttles the response body sent to the client
+	BandwidthBytesPerSecond int64 `json:"bandwidth_bytes_per_second"`
+}
+
+// DelayFault delays requests before they are forwarded
+type DelayFault struct {
+	// Distribution is "fixed" (default), "uniform" (Duration ± Jitter) or
+	// "normal" (mean Duration, standard deviation Jitter)
+	Distribution string   `json:"distribution"`
+	Duration     Duration `json:"duration"`
+	Jitter       Duration `json:"jitter"`
+}
+
+// HasFaults reports whether any route of any service declares fault rules
+func (c AppConfig) HasFaults() bool {
+	for _, service := range c.Services {
+		for _, route := range service.Routes {
+			if len(route.Faults) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateFaultInjection refuses fault injection in production unless it
+// was explicitly allowed
+func (c AppConfig) ValidateFaultInjection(env string) error {
+	if env != productionEnvironment || c.FaultInjection.AllowInProduction {
+		return nil
+	}
+	if c.FaultInjection.Enabled || c.HasFaults() {
+		return fmt.Errorf("fault injection is configured in %s; set fault_injection.allow_in_production to start anyway", env)
+	}
+	return nil
+}
+
+// DefaultCoalesceMaxWaiters is used when a coalesced route does not set max_waiters
+const DefaultCoalesceMaxWaiters = 1000
+
+// CoalesceConfig controls single-flight collapsing of identical GET requests
+type CoalesceConfig struct {
+	// VaryHeaders are request headers that must match for two requests to
+	// share a response, in addition to method, path and query
+	VaryHeaders []string `json:"vary_headers"`
+	// MaxWaiters bounds how many requests may wait on one upstream call;
+	// further requests go upstream on their own. Defaults to
+	// DefaultCoalesceMaxWaiters.
+	MaxWaiters int `json:"max_waiters"`
+	// MaxResponseBytes bounds the response held in memory for the waiters.
+	// Larger responses are not shared. Defaults to 1 MiB.
+	MaxResponseBytes int64 `json:"max_response_bytes"`
+}
+
+// DisplayName returns the route's name for logs and metrics
+func (r RouteConfig) DisplayName() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.PathPrefix
+}
+
+// BodyLimit returns the request body limit of a request to a service, where
+// path is relative to /api/<service>
+func (c AppConfig) BodyLimit(serviceName, method, path string) int64 {
+	if route, ok := c.Service(serviceName).Route(method, path); ok && route.MaxBodyBytes > 0 {
+		return route.MaxBodyBytes
+	}
+	return c.Server.Limits.BodyBytes()
+}
+
+// Route finds the service route matching a request, where path is relative
+// to /api/<service>. The longest matching path prefix wins.
+func (s ServiceConfig) Route(method, path string) (RouteConfig, bool) {
+	var match RouteConfig
+	found := false
+	for _, route := range s.Routes {
+		if !hasPathPrefix(path, route.PathPrefix) {
+			continue
+		}
+		if len(route.Methods) > 0 && !slices.ContainsFunc(route.Methods, func(m string) bool { return strings.EqualFold(m, method) }) {
+			continue
+		}
+		if !found || len(route.PathPrefix) > len(match.PathPrefix) {
+			match, found = route, true
+		}
+	}
+	return match, found
+}
+
+// hasPathPrefix reports whether prefix matches path on a segment boundary
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// TranscodingConfig maps HTTP routes onto gRPC methods of a service
+type TranscodingConfig struct {
+	// DescriptorSet is the path to a compiled FileDescriptorSet (protoc
+	// --include_imports --descriptor_set_out). Methods carrying
+	// google.api.http annotations become routes automatically.
+	DescriptorSet string `json:"descriptor_set"`
+	// Mappings add or override routes for methods without annotations.
+	// They are matched before the annotated routes.
+	Mappings []TranscodingMapping `json:"mappings"`
+}
+
+// TranscodingMapping is an explicit HTTP rule for one gRPC method, using the
+// same semantics as a google.api.HttpRule
+type TranscodingMapping struct {
+	// Method is the HTTP method, e.g. "GET"
+	Method string `json:"method"`
+	// Path is a path template relative to /api/<service>, e.g. "/v1/users/{id}"
+	Path string `json:"path"`
+	// GRPCMethod is the fully-qualified method, e.g. "users.v1.UserService/GetUser"
+	GRPCMethod string `json:"grpc_method"`
+	// Body is "*" to map the whole JSON body onto the request message, a
+	// field name to map it onto that field, or empty for no body
+	Body string `json:"body"`
+	// ResponseBody optionally selects a single response field to return
+	ResponseBody string `json:"response_body"`
+}
+
+// IsGRPC reports whether the service's upstreams speak gRPC
+func (s ServiceConfig) IsGRPC() bool {
+	return strings.EqualFold(s.Protocol, ProtocolGRPC)
+}
+
+// HasService reports whether name can be used in /api/<name>/, either as a
+// known service or as an aggregate
+func (c AppConfig) HasService(name string) bool {
+	if _, known := c.KnownServices[name]; known {
+		return true
+	}
+	_, aggregate := c.Aggregates[name]
+	return aggregate
+}
+
+// Service returns the settings for the named service, or the zero value when
+// none are configured
+func (c AppConfig) Service(name string) ServiceConfig {
+	return c.Services[name]
+}
+
+// ServiceForGRPC finds the known service that serves the given
+// fully-qualified gRPC service name. The most specific pattern wins.
+func (c AppConfig) ServiceForGRPC(grpcService string) (string, bool) {
+	match, matchLen := "", 0
+	for name, svc := range c.Services {
+		if _, known := c.KnownServices[name]; !known {
+			continue
+		}
+		for _, pattern := range svc.GRPCServices {
+			if (grpcService == pattern || strings.HasPrefix(grpcService, pattern+".")) && len(pattern) > matchLen {
+				match, matchLen = name, len(pattern)
+			}
+		}
+	}
+	return match, match != ""
+}
+
+// WebSocketConfig controls connections that were upgraded through the gateway
+// (WebSockets or any other protocol negotiated with the Upgrade header)
+type WebSocketConfig struct {
+	// IdleTimeout closes an upgraded connection when no data has flowed in
+	// either direction for this long. Zero disables the idle check.
+	IdleTimeout Duration `json:"idle_timeout"`
+	// MaxLifetime closes an upgraded connection once it has been open for
+	// this long, regardless of activity. Zero means no limit.
+	MaxLifetime Duration `json:"max_lifetime"`
+}
+
+// DefaultStreamingContentTypes are flushed to the client as they arrive when
+// the streaming config does not list its own content types
+var DefaultStreamingContentTypes = []string{"text/event-stream", "application/x-ndjson", "application/stream+json"}
+
+// StreamingConfig controls how long-lived and streamed upstream responses are relayed
+type StreamingConfig struct {
+	// FlushInterval is how often buffered response data is flushed to the
+	// client for non-streaming responses. Zero leaves it to the proxy defaults.
+	FlushInterval Duration `json:"flush_interval"`
+	// ContentTypes are flushed after every write and exempt from the server's
+	// WriteTimeout. Defaults to DefaultStreamingContentTypes.
+	ContentTypes []string `json:"content_types"`
+	// MaxDuration bounds how long a streaming response may stay open once it
+	// is exempt from WriteTimeout. Zero means no limit.
+	MaxDuration Duration `json:"max_duration"`
+}
+
+// IsStreamingContentType reports whether responses with the given
+// Content-Type header should be streamed without buffering
+func (c StreamingConfig) IsStreamingContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	contentTypes := c.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultStreamingContentTypes
+	}
+	for _, ct := range contentTypes {
+		if strings.EqualFold(ct, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadAppConfig reads the config of the current environment, exiting when
+// it cannot be read or is invalid
+func LoadAppConfig() AppConfig {
+	cfg, err := ReadAppConfig()
 	if err != nil {
 		log.Fatalf("Fatal error loading config: %v", err)
 	}
 	return cfg
 }
 
+// ReadAppConfig reads and validates the config of the current environment
+func ReadAppConfig() (AppConfig, error) {
+	cfg := AppConfig{}
+
+	env := GetEnvironment()
+	if err := readConfig(env, &cfg); err != nil {
+		return AppConfig{}, err
+	}
+	if err := cfg.Validate(env); err != nil {
+		return AppConfig{}, err
+	}
+	return cfg, nil
+}
+
+// Validate runs the checks a config must pass before the gateway uses it
+func (c AppConfig) Validate(env string) error {
+	validators := []func() error{
+		c.ValidateUpstreams,
+		func() error { return c.ValidateFaultInjection(env) },
+		c.Compression.Validate,
+		c.ValidateCORS,
+		c.ValidateIPFilters,
+		c.ValidateConsumers,
+		c.ValidateMaintenance,
+		c.ValidateReadiness,
+		c.Server.Shutdown.Validate,
+		c.Admin.Validate,
+	}
+	for _, validate := range validators {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // readConfig reads a file that is located in the path ./config-files/<env>.json and unmarshalls it into the given config struct
 func readConfig(env string, bindTo interface{}) error {
 	configFile, err := readConfigFile(env)
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration wraps time.Duration so it can be written as "30s" or "5m" in config files
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON accepts either a Go duration string or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", v, err)
+		}
+		d.Duration = parsed
+	case float64:
+		d.Duration = time.Duration(v * float64(time.Second))
+	case nil:
+		d.Duration = 0
+	default:
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+
+	return nil
+}
+
+// MarshalJSON writes the duration back in its string form
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
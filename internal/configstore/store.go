@@ -0,0 +1,199 @@
+// Package configstore keeps the revisions of the config made through the
+// admin API, persisted as one JSON file per revision
+package configstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+// ErrRevisionNotFound is returned for revisions that never existed or were
+// pruned
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// revisionFilePattern matches the files revisions are stored in
+var revisionFilePattern = regexp.MustCompile(`^revision-(\d+)\.json$`)
+
+// Revision is one version of the config
+type Revision struct {
+	Number    int       `json:"revision"`
+	CreatedAt time.Time `json:"created_at"`
+	// Change describes what produced the revision, e.g. "PUT /services/users"
+	Change string `json:"change"`
+	// RemoteAddr is the address the change was requested from, if any
+	RemoteAddr string           `json:"remote_addr,omitempty"`
+	Config     config.AppConfig `json:"config"`
+}
+
+// Summary is a revision without its config
+type Summary struct {
+	Number     int       `json:"revision"`
+	CreatedAt  time.Time `json:"created_at"`
+	Change     string    `json:"change"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+}
+
+// Summary returns the revision without its config
+func (r Revision) Summary() Summary {
+	return Summary{Number: r.Number, CreatedAt: r.CreatedAt, Change: r.Change, RemoteAddr: r.RemoteAddr}
+}
+
+// Store holds the latest revisions, oldest first. Revision numbers only
+// grow, also across restarts, so a number always names the same config.
+type Store struct {
+	dir          string
+	maxRevisions int
+
+	mu        sync.RWMutex
+	revisions []Revision
+}
+
+// Open loads the revisions stored in dir, creating it if needed. An empty
+// dir keeps revisions in memory only.
+func Open(dir string, maxRevisions int) (*Store, error) {
+	s := &Store{dir: dir, maxRevisions: maxRevisions}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create config store: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config store: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !revisionFilePattern.MatchString(entry.Name()) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+		var revision Revision
+		if err := json.Unmarshal(data, &revision); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", entry.Name(), err)
+		}
+		s.revisions = append(s.revisions, revision)
+	}
+	sort.Slice(s.revisions, func(i, j int) bool { return s.revisions[i].Number < s.revisions[j].Number })
+	s.prune()
+	return s, nil
+}
+
+// Latest returns the newest revision
+func (s *Store) Latest() (Revision, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.revisions) == 0 {
+		return Revision{}, false
+	}
+	return s.revisions[len(s.revisions)-1], true
+}
+
+// Get returns a revision by number
+func (s *Store) Get(number int) (Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := sort.Search(len(s.revisions), func(i int) bool { return s.revisions[i].Number >= number })
+	if i == len(s.revisions) || s.revisions[i].Number != number {
+		return Revision{}, ErrRevisionNotFound
+	}
+	return s.revisions[i], nil
+}
+
+// List returns the summaries of the stored revisions, newest first
+func (s *Store) List() []Summary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	summaries := make([]Summary, 0, len(s.revisions))
+	for i := len(s.revisions) - 1; i >= 0; i-- {
+		summaries = append(summaries, s.revisions[i].Summary())
+	}
+	return summaries
+}
+
+// Append stores cfg as the next revision. The revision is on disk before
+// Append returns; revisions beyond the limit are pruned, oldest first.
+func (s *Store) Append(cfg config.AppConfig, change, remoteAddr string) (Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revision := Revision{
+		Number:     1,
+		CreatedAt:  time.Now().UTC(),
+		Change:     change,
+		RemoteAddr: remoteAddr,
+		Config:     cfg,
+	}
+	if n := len(s.revisions); n > 0 {
+		revision.Number = s.revisions[n-1].Number + 1
+	}
+	if err := s.write(revision); err != nil {
+		return Revision{}, err
+	}
+	s.revisions = append(s.revisions, revision)
+	s.prune()
+	return revision, nil
+}
+
+// prune drops the oldest revisions beyond the limit
+func (s *Store) prune() {
+	for s.maxRevisions > 0 && len(s.revisions) > s.maxRevisions {
+		if s.dir != "" {
+			// A file left behind is harmless; it is pruned again on the next start
+			os.Remove(s.path(s.revisions[0].Number))
+		}
+		s.revisions = s.revisions[1:]
+	}
+}
+
+func (s *Store) path(number int) string {
+	return filepath.Join(s.dir, "revision-"+strconv.Itoa(number)+".json")
+}
+
+// write persists a revision. It is written to a temporary file that is
+// renamed into place, so a crash never leaves a partial revision.
+func (s *Store) write(revision Revision) error {
+	if s.dir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(revision, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode revision: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".revision-*")
+	if err != nil {
+		return fmt.Errorf("failed to write revision: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write revision: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write revision: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write revision: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(revision.Number)); err != nil {
+		return fmt.Errorf("failed to write revision: %w", err)
+	}
+	if dir, err := os.Open(s.dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package configstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir, 2)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	if _, ok := store.Latest(); ok {
+		t.Fatal("expected a new store to be empty")
+	}
+
+	for _, apiKey := range []string{"first", "second", "third"} {
+		if _, err := store.Append(config.AppConfig{AllowedApiKey: apiKey}, "PUT /consumers/"+apiKey, "127.0.0.1:1234"); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if _, err := store.Get(1); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("expected the oldest revision to be pruned, got %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 2 {
+		t.Errorf("expected 2 revision files, got %v", files)
+	}
+
+	reopened, err := Open(dir, 2)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	latest, ok := reopened.Latest()
+	if !ok || latest.Number != 3 || latest.Config.AllowedApiKey != "third" || latest.Change != "PUT /consumers/third" {
+		t.Errorf("expected the latest revision to survive a restart, got %+v", latest)
+	}
+	if summaries := reopened.List(); len(summaries) != 2 || summaries[0].Number != 3 || summaries[1].Number != 2 {
+		t.Errorf("expected revisions newest first, got %+v", summaries)
+	}
+	if revision, _ := reopened.Append(config.AppConfig{}, "reload from files", ""); revision.Number != 4 {
+		t.Errorf("expected numbering to continue, got %d", revision.Number)
+	}
+}
+
+func TestStoreRejectsCorruptRevisions(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "revision-1.json"), []byte("{"), 0o600)
+	if _, err := Open(dir, 0); err == nil {
+		t.Error("expected a corrupt revision to be reported")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store, err := Open("", 0)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	store.Append(config.AppConfig{AllowedApiKey: "key"}, "initial config from files", "")
+	if revision, err := store.Get(1); err != nil || revision.Config.AllowedApiKey != "key" {
+		t.Errorf("expected the revision to be kept in memory, got %+v, %v", revision, err)
+	}
+}
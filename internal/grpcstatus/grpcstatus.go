@@ -0,0 +1,121 @@
+// Package grpcstatus translates between HTTP statuses and gRPC status codes
+// and writes gateway errors in the form gRPC clients expect.
+package grpcstatus
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Code is a gRPC status code as defined in google.golang.org/grpc/codes
+type Code int
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+// IsGRPCRequest reports whether the request was made by a gRPC client
+func IsGRPCRequest(r *http.Request) bool {
+	return IsGRPCContentType(r.Header.Get("Content-Type"))
+}
+
+// IsGRPCContentType reports whether a Content-Type header denotes gRPC
+// (application/grpc, application/grpc+proto, ...). gRPC-Web is not: it is
+// framed for HTTP/1.1 and cannot be sent to a gRPC upstream as it is.
+func IsGRPCContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.HasPrefix(contentType, "application/grpc") && !strings.HasPrefix(contentType, "application/grpc-web")
+}
+
+// FromHTTPStatus maps a gateway HTTP status to the closest gRPC code
+func FromHTTPStatus(status int) Code {
+	switch status {
+	case http.StatusOK:
+		return OK
+	case http.StatusBadRequest:
+		return InvalidArgument
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return PermissionDenied
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return Unimplemented
+	case http.StatusConflict:
+		return Aborted
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return DeadlineExceeded
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return ResourceExhausted
+	case http.StatusPreconditionFailed:
+		return FailedPrecondition
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return Unavailable
+	case http.StatusInternalServerError:
+		return Internal
+	}
+	return Unknown
+}
+
+// ToHTTPStatus maps a gRPC code to the HTTP status used when exposing it to
+// HTTP clients, following the google.api mapping
+func ToHTTPStatus(code Code) int {
+	switch code {
+	case OK:
+		return http.StatusOK
+	case Canceled:
+		return 499 // Client Closed Request
+	case InvalidArgument, FailedPrecondition, OutOfRange:
+		return http.StatusBadRequest
+	case DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case NotFound:
+		return http.StatusNotFound
+	case AlreadyExists, Aborted:
+		return http.StatusConflict
+	case PermissionDenied:
+		return http.StatusForbidden
+	case ResourceExhausted:
+		return http.StatusTooManyRequests
+	case Unimplemented:
+		return http.StatusNotImplemented
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
+
+// WriteError writes a trailers-only gRPC response carrying the code that
+// corresponds to statusCode. gRPC always uses HTTP 200 and reports failures
+// through the grpc-status and grpc-message headers.
+func WriteError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(FromHTTPStatus(statusCode))))
+	w.Header().Set("Grpc-Message", encodeMessage(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeMessage percent-encodes a grpc-message value as required by the gRPC
+// HTTP/2 protocol spec
+func encodeMessage(message string) string {
+	return strings.ReplaceAll(url.PathEscape(message), "+", "%2B")
+}
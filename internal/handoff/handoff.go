@@ -0,0 +1,363 @@
+// Package handoff passes the gateway's listening sockets on to a new
+// process of the gateway, so the binary can be upgraded without refusing
+// connections, and receives listeners from systemd socket activation
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// listenersEnv names the listeners a parent process passed, in file
+	// descriptor order; the readiness pipe follows them
+	listenersEnv = "GATEWAY_HANDOFF_LISTENERS"
+	// firstFD is the first descriptor passed after stdin, stdout and stderr
+	firstFD = 3
+)
+
+// DefaultReadyTimeout bounds the wait for a new process to be ready
+const DefaultReadyTimeout = 30 * time.Second
+
+// ErrUpgrading is returned for an upgrade requested while another one is
+// in progress or after one succeeded
+var ErrUpgrading = errors.New("the listeners are already being handed over")
+
+// Upgrader hands the listeners of this process over to a new one. The new
+// process inherits the listening sockets, so connections queue up on them
+// rather than being refused while it starts; once it reports ready, this
+// process can drain and exit.
+type Upgrader struct {
+	// ReadyTimeout bounds the wait for the new process. Defaults to
+	// DefaultReadyTimeout.
+	ReadyTimeout time.Duration
+
+	mu        sync.Mutex
+	inherited map[string]net.Listener
+	listeners map[string]*listener // in use, handed over on upgrade
+	names     []string             // of listeners, in the order they were opened
+	parent    *os.File             // readiness pipe to the parent process
+	upgrading bool
+}
+
+// New collects the listeners passed by a parent process or by systemd.
+// names are the listeners the process uses; systemd listeners that are not
+// named after one of them are given names in order, so a socket unit
+// with a single, unnamed socket serves the first.
+func New(names ...string) (*Upgrader, error) {
+	u := &Upgrader{
+		ReadyTimeout: DefaultReadyTimeout,
+		inherited:    map[string]net.Listener{},
+		listeners:    map[string]*listener{},
+	}
+	if passed, ok := os.LookupEnv(listenersEnv); ok {
+		os.Unsetenv(listenersEnv)
+		passedNames := strings.Split(passed, ",")
+		if err := u.inherit(passedNames); err != nil {
+			return nil, err
+		}
+		u.parent = os.NewFile(uintptr(firstFD+len(passedNames)), "handoff-ready")
+		return u, nil
+	}
+
+	systemdNames, ok := systemdListeners(names)
+	if !ok {
+		return u, nil
+	}
+	return u, u.inherit(systemdNames)
+}
+
+// systemdListeners returns the names of the listeners systemd passed, in
+// file descriptor order, following sd_listen_fds(3)
+func systemdListeners(names []string) ([]string, bool) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, false
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, false
+	}
+	fdNames := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(env)
+	}
+
+	named := map[string]bool{}
+	for _, name := range fdNames {
+		named[name] = true
+	}
+	unnamed := make([]string, 0, len(names))
+	for _, name := range names {
+		if !named[name] {
+			unnamed = append(unnamed, name)
+		}
+	}
+	listeners := make([]string, count)
+	for i := range listeners {
+		if i < len(fdNames) && slices.Contains(names, fdNames[i]) {
+			listeners[i] = fdNames[i]
+		} else if len(unnamed) > 0 {
+			listeners[i], unnamed = unnamed[0], unnamed[1:]
+		} else {
+			listeners[i] = "unused-" + strconv.Itoa(i)
+		}
+	}
+	return listeners, true
+}
+
+// inherit turns the descriptors starting at firstFD into listeners
+func (u *Upgrader) inherit(names []string) error {
+	for i, name := range names {
+		file := os.NewFile(uintptr(firstFD+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("inherited listener '%s': %w", name, err)
+		}
+		u.inherited[name] = listener
+	}
+	return nil
+}
+
+// Listen returns the inherited listener with the given name, or starts
+// listening on addr when there is none
+func (u *Upgrader) Listen(name, addr string) (net.Listener, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	socket, ok := u.inherited[name]
+	if ok {
+		delete(u.inherited, name)
+		log.Printf("Serving %s on inherited listener %s", name, socket.Addr())
+	} else {
+		var err error
+		if socket, err = net.Listen("tcp", addr); err != nil {
+			return nil, err
+		}
+	}
+	l := &listener{Listener: socket, handedOver: make(chan struct{}), closed: make(chan struct{})}
+	u.listeners[name] = l
+	u.names = append(u.names, name)
+	return l, nil
+}
+
+// Ready tells the parent process, or systemd, that this process is
+// serving. Inherited listeners that were not used are closed.
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	for name, listener := range u.inherited {
+		log.Printf("Closing unused inherited listener %s (%s)", name, listener.Addr())
+		listener.Close()
+	}
+	u.inherited = map[string]net.Listener{}
+	parent := u.parent
+	u.parent = nil
+	u.mu.Unlock()
+
+	if err := notifySystemd(fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid())); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+	if parent == nil {
+		return nil
+	}
+	defer parent.Close()
+	_, err := parent.Write([]byte{1})
+	return err
+}
+
+// Upgrade starts a new process of the gateway, from the executable the
+// gateway was started as, with the listeners in use. Once the new process
+// is ready, this one stops accepting connections, so they all go to the
+// new process, and Upgrade returns its process ID; the connections already
+// accepted are still served. A new process that exits or does not become
+// ready within ReadyTimeout is killed and this process keeps serving.
+func (u *Upgrader) Upgrade() (int, error) {
+	u.mu.Lock()
+	if u.upgrading {
+		u.mu.Unlock()
+		return 0, ErrUpgrading
+	}
+	u.upgrading = true
+	names := slices.Clone(u.names)
+	listeners := make([]*listener, len(names))
+	for i, name := range names {
+		listeners[i] = u.listeners[name]
+	}
+	u.mu.Unlock()
+
+	pid, err := u.start(names, listeners)
+	if err != nil {
+		u.mu.Lock()
+		u.upgrading = false
+		u.mu.Unlock()
+		return 0, err
+	}
+	for _, l := range listeners {
+		l.handOver()
+	}
+	return pid, nil
+}
+
+// start runs the new process and waits for it to be ready
+func (u *Upgrader) start(names []string, listeners []*listener) (int, error) {
+	files := make([]*os.File, 0, len(listeners)+1)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for i, l := range listeners {
+		file, err := l.file()
+		if err != nil {
+			return 0, fmt.Errorf("listener '%s': %w", names[i], err)
+		}
+		files = append(files, file)
+	}
+
+	executable, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return 0, err
+	}
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+	files = append(files, readyWriter)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), listenersEnv+"="+strings.Join(names, ","))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	err = cmd.Start()
+	// The pipe must only stay open in the new process, so reading it ends
+	// if that process exits
+	readyWriter.Close()
+	if err != nil {
+		return 0, err
+	}
+	// Reaps the new process if it exits while this one is still running
+	go cmd.Wait()
+
+	timeout := u.ReadyTimeout
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+	ready.SetReadDeadline(time.Now().Add(timeout))
+	if _, err := ready.Read(make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return 0, fmt.Errorf("process %d did not become ready within %s", cmd.Process.Pid, timeout)
+		}
+		return 0, fmt.Errorf("process %d exited before becoming ready", cmd.Process.Pid)
+	}
+	return cmd.Process.Pid, nil
+}
+
+// listener is a listening socket that can be handed over. Once it is, it
+// stops accepting connections, leaving them to the new process, but stays
+// open until the server closes it.
+type listener struct {
+	net.Listener
+	handedOver chan struct{}
+	closed     chan struct{}
+	handOnce   sync.Once
+	closeOnce  sync.Once
+}
+
+// Accept implements net.Listener
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case <-l.handedOver:
+		<-l.closed
+		return nil, net.ErrClosed
+	default:
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		select {
+		case <-l.handedOver:
+			// Interrupted by handOver
+			<-l.closed
+			return nil, net.ErrClosed
+		default:
+		}
+	}
+	return conn, err
+}
+
+// Close implements net.Listener
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+// handOver stops accepting connections
+func (l *listener) handOver() {
+	l.handOnce.Do(func() {
+		close(l.handedOver)
+		// Wakes up a pending Accept
+		if deadliner, ok := l.Listener.(interface{ SetDeadline(time.Time) error }); ok {
+			deadliner.SetDeadline(time.Now())
+		}
+	})
+}
+
+// file duplicates the socket. File is not used: passing its descriptor to
+// the new process switches it to blocking mode, which it shares with the
+// listener's own descriptor, and an Accept blocked in the kernel could not
+// be interrupted when the listener is closed.
+func (l *listener) file() (*os.File, error) {
+	conn, ok := l.Listener.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("cannot hand over a %T", l.Listener)
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var dup int
+	var dupErr error
+	err = raw.Control(func(fd uintptr) {
+		if dup, dupErr = syscall.Dup(int(fd)); dupErr == nil {
+			syscall.CloseOnExec(dup)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dupErr != nil {
+		return nil, os.NewSyscallError("dup", dupErr)
+	}
+	return os.NewFile(uintptr(dup), l.Addr().String()), nil
+}
+
+// notifySystemd sends a state to the service manager, following
+// sd_notify(3). It does nothing when not run by systemd with a notify
+// socket.
+func notifySystemd(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,236 @@
+package handoff
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// helperEnv makes the test binary run helperProcess instead of the tests.
+// Its value says how the process behaves: "serve", "fail-upgrade" for new
+// processes that exit before they are ready, or "systemd".
+const helperEnv = "HANDOFF_TEST_HELPER"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(helperEnv); mode != "" {
+		helperProcess(mode)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// helperProcess is a gateway stand-in: it serves its process ID on the
+// "api" listener, hands it over on SIGUSR2 and exits on SIGTERM
+func helperProcess(mode string) {
+	_, inherited := os.LookupEnv(listenersEnv)
+	if mode == "fail-upgrade" && inherited {
+		os.Exit(1)
+	}
+	if mode == "systemd" {
+		// systemd sets LISTEN_PID between fork and exec
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	}
+
+	upgrader, err := New("api")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	upgrader.ReadyTimeout = 5 * time.Second
+	listener, err := upgrader.Listen("api", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, os.Getpid())
+	})}
+	go srv.Serve(listener)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2, syscall.SIGTERM)
+	upgrader.Ready()
+	if !inherited {
+		// Tells the test the process is ready for signals
+		fmt.Println(listener.Addr())
+	}
+	for sig := range signals {
+		if sig == syscall.SIGUSR2 {
+			if _, err := upgrader.Upgrade(); err != nil {
+				fmt.Fprintln(os.Stderr, "upgrade failed:", err)
+				continue
+			}
+			// Connections accepted before the handover get to send their
+			// request, which Shutdown would otherwise drop
+			time.Sleep(100 * time.Millisecond)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		srv.Shutdown(ctx)
+		cancel()
+		return
+	}
+}
+
+// startHelper runs the test binary as a helper process and returns it with
+// the address it serves on
+func startHelper(t *testing.T, mode string) (*exec.Cmd, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), helperEnv+"="+mode)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start helper: %v", err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+	addr, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read the helper's address: %v", err)
+	}
+	return cmd, strings.TrimSpace(addr)
+}
+
+// servedBy returns the process ID that answered a request to addr
+func servedBy(t *testing.T, addr string) int {
+	t.Helper()
+	pid, err := request(addr)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	return pid
+}
+
+func request(addr string) (int, error) {
+	// A connection per request, so every request meets the listener
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get("http://" + addr)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(body))
+}
+
+func TestUpgrade(t *testing.T) {
+	parent, addr := startHelper(t, "serve")
+	parentPID := servedBy(t, addr)
+	if parentPID != parent.Process.Pid {
+		t.Fatalf("expected the helper to serve, got process %d", parentPID)
+	}
+
+	// Requests keep coming while the listener is handed over
+	var sent, failed atomic.Int64
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			sent.Add(1)
+			if _, err := request(addr); err != nil {
+				failed.Add(1)
+				t.Logf("request failed: %v", err)
+			}
+		}
+	}()
+
+	parent.Process.Signal(syscall.SIGUSR2)
+	if err := parent.Wait(); err != nil {
+		t.Fatalf("expected the old process to exit cleanly, got %v", err)
+	}
+	close(stop)
+	<-stopped
+
+	childPID := servedBy(t, addr)
+	if childPID == parentPID {
+		t.Fatal("expected the new process to serve after the old one exited")
+	}
+	t.Cleanup(func() { syscall.Kill(childPID, syscall.SIGKILL) })
+	if failed.Load() > 0 {
+		t.Errorf("expected no refused connections, %d of %d requests failed", failed.Load(), sent.Load())
+	}
+	syscall.Kill(childPID, syscall.SIGTERM)
+}
+
+func TestUpgradeFailureKeepsServing(t *testing.T) {
+	parent, addr := startHelper(t, "fail-upgrade")
+	parent.Process.Signal(syscall.SIGUSR2)
+
+	// The failed new process is noticed and the old one keeps serving
+	time.Sleep(500 * time.Millisecond)
+	if pid := servedBy(t, addr); pid != parent.Process.Pid {
+		t.Errorf("expected the old process to keep serving, got process %d", pid)
+	}
+	parent.Process.Signal(syscall.SIGTERM)
+	if err := parent.Wait(); err != nil {
+		t.Errorf("expected the old process to exit cleanly, got %v", err)
+	}
+}
+
+func TestSystemdSocketActivation(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	notifySocket := filepath.Join(t.TempDir(), "notify")
+	notifications, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: notifySocket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("failed to listen for notifications: %v", err)
+	}
+	defer notifications.Close()
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), helperEnv+"=systemd", "LISTEN_FDS=1", "LISTEN_FDNAMES=gateway.socket", "NOTIFY_SOCKET="+notifySocket)
+	cmd.ExtraFiles = []*os.File{file}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start helper: %v", err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill(); cmd.Wait() })
+
+	notifications.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 256)
+	n, err := notifications.Read(buf)
+	if err != nil {
+		t.Fatalf("expected a readiness notification: %v", err)
+	}
+	if want := fmt.Sprintf("READY=1\nMAINPID=%d", cmd.Process.Pid); string(buf[:n]) != want {
+		t.Errorf("expected %q, got %q", want, buf[:n])
+	}
+
+	// The unnamed socket is used as the first listener
+	if pid := servedBy(t, listener.Addr().String()); pid != cmd.Process.Pid {
+		t.Errorf("expected the helper to serve on the socket it was passed, got process %d", pid)
+	}
+}
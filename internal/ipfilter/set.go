@@ -0,0 +1,192 @@
+// Package ipfilter matches client addresses against large lists of IPv4
+// and IPv6 prefixes.
+package ipfilter
+
+import (
+	"bufio"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Set is a set of IP prefixes held in a path-compressed radix trie, so a
+// lookup costs at most one step per bit of the address however many
+// prefixes there are. IPv4 prefixes are stored as IPv4-mapped IPv6 ones.
+// The zero value is an empty set; a Set must not be modified while it is
+// being read.
+type Set struct {
+	root *node
+	size int
+}
+
+type node struct {
+	key      [16]byte // masked to bits
+	bits     int
+	terminal bool // key/bits is in the set
+	children [2]*node
+}
+
+// Parse builds a set from CIDRs and single addresses
+func Parse(entries []string) (*Set, error) {
+	s := &Set{}
+	for _, entry := range entries {
+		prefix, err := ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		s.Add(prefix)
+	}
+	return s, nil
+}
+
+// ParsePrefix parses a CIDR, or a single address as a full-length prefix
+func ParsePrefix(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR '%s': %w", entry, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address '%s': %w", entry, err)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ReadFile reads a list of CIDRs and addresses, one per line. Blank lines
+// and text after a '#' are ignored.
+func ReadFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if _, err := ParsePrefix(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Len returns the number of prefixes added to the set
+func (s *Set) Len() int {
+	return s.size
+}
+
+// Add adds a prefix to the set
+func (s *Set) Add(prefix netip.Prefix) {
+	key, length := keyOf(prefix.Addr(), prefix.Bits())
+	key = mask(key, length)
+	s.size++
+
+	link := &s.root
+	for {
+		n := *link
+		if n == nil {
+			*link = &node{key: key, bits: length, terminal: true}
+			return
+		}
+
+		common := min(commonBits(n.key, key), n.bits, length)
+		switch {
+		case common == n.bits && n.bits == length:
+			n.terminal = true
+			return
+		case common == n.bits:
+			// n is a shorter prefix of the new one; a terminal n already
+			// covers it
+			if n.terminal {
+				return
+			}
+			link = &n.children[bit(key, n.bits)]
+		case common == length:
+			// The new prefix covers n
+			parent := &node{key: key, bits: length, terminal: true}
+			parent.children[bit(n.key, length)] = n
+			*link = parent
+			return
+		default:
+			parent := &node{key: mask(key, common), bits: common}
+			parent.children[bit(key, common)] = &node{key: key, bits: length, terminal: true}
+			parent.children[bit(n.key, common)] = n
+			*link = parent
+			return
+		}
+	}
+}
+
+// Contains reports whether any prefix in the set contains addr
+func (s *Set) Contains(addr netip.Addr) bool {
+	if s == nil || !addr.IsValid() {
+		return false
+	}
+	key, _ := keyOf(addr, addr.BitLen())
+	for n := s.root; n != nil; {
+		if commonBits(n.key, key) < n.bits {
+			return false
+		}
+		if n.terminal {
+			return true
+		}
+		if n.bits == 128 {
+			return false
+		}
+		n = n.children[bit(key, n.bits)]
+	}
+	return false
+}
+
+// keyOf returns the 128-bit key of an address and the prefix length in
+// that key space
+func keyOf(addr netip.Addr, length int) ([16]byte, int) {
+	if addr.Is4() {
+		length += 96
+	}
+	return addr.As16(), length
+}
+
+// commonBits returns the number of leading bits a and b share
+func commonBits(a, b [16]byte) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return 128
+}
+
+// bit returns bit i of key, counting from the most significant
+func bit(key [16]byte, i int) int {
+	return int(key[i/8]>>(7-i%8)) & 1
+}
+
+// mask clears every bit of key after the first length
+func mask(key [16]byte, length int) [16]byte {
+	for i := range key {
+		switch {
+		case length >= (i+1)*8:
+		case length <= i*8:
+			key[i] = 0
+		default:
+			key[i] &= ^byte(0xff >> (length - i*8))
+		}
+	}
+	return key
+}
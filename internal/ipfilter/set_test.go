@@ -0,0 +1,114 @@
+package ipfilter
+
+import (
+	"math/rand/v2"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetContains(t *testing.T) {
+	set, err := Parse([]string{"10.0.0.0/8", "192.168.1.0/24", "203.0.113.7", "2001:db8::/32", "2001:db8:1::/48", "fd00::1"})
+	if err != nil {
+		t.Fatalf("failed to parse set: %v", err)
+	}
+
+	tests := map[string]bool{
+		"10.1.2.3":          true,
+		"11.0.0.1":          false,
+		"192.168.1.200":     true,
+		"192.168.2.1":       false,
+		"203.0.113.7":       true,
+		"203.0.113.8":       false,
+		"::ffff:10.9.9.9":   true,
+		"2001:db8:ffff::1":  true,
+		"2001:db9::1":       false,
+		"fd00::1":           true,
+		"fd00::2":           false,
+		"::a00:1":           false, // 10.0.0.1 in the low bits, but not IPv4-mapped
+		"2001:db8:1:2::abc": true,
+	}
+	for address, expected := range tests {
+		if got := set.Contains(netip.MustParseAddr(address)); got != expected {
+			t.Errorf("Contains(%s): expected %v, got %v", address, expected, got)
+		}
+	}
+
+	var empty *Set
+	if empty.Contains(netip.MustParseAddr("10.0.0.1")) {
+		t.Error("expected a nil set to contain nothing")
+	}
+}
+
+func TestSetCoveringPrefixes(t *testing.T) {
+	// Broader prefixes added after narrower ones, and the reverse
+	set, _ := Parse([]string{"10.1.1.0/24", "10.1.2.0/24", "10.0.0.0/8", "172.16.0.0/12", "172.16.5.0/24"})
+	for _, address := range []string{"10.200.0.1", "10.1.1.1", "172.20.0.1", "172.16.5.5"} {
+		if !set.Contains(netip.MustParseAddr(address)) {
+			t.Errorf("expected %s to be contained", address)
+		}
+	}
+	if set.Contains(netip.MustParseAddr("172.32.0.1")) {
+		t.Error("expected 172.32.0.1 to be outside 172.16.0.0/12")
+	}
+}
+
+// TestSetMatchesLinearScan compares the trie with netip on random prefixes
+func TestSetMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	randomAddr := func() netip.Addr {
+		if rng.IntN(2) == 0 {
+			return netip.AddrFrom4([4]byte{10, byte(rng.IntN(4)), byte(rng.IntN(256)), byte(rng.IntN(256))})
+		}
+		var b [16]byte
+		b[0], b[1], b[2] = 0x20, 0x01, byte(rng.IntN(4))
+		b[15] = byte(rng.IntN(256))
+		return netip.AddrFrom16(b)
+	}
+
+	set := &Set{}
+	var prefixes []netip.Prefix
+	for range 500 {
+		addr := randomAddr()
+		prefix := netip.PrefixFrom(addr, rng.IntN(addr.BitLen()-8)+8).Masked()
+		prefixes = append(prefixes, prefix)
+		set.Add(prefix)
+	}
+
+	for range 5000 {
+		addr := randomAddr()
+		expected := false
+		for _, prefix := range prefixes {
+			if prefix.Contains(addr) {
+				expected = true
+				break
+			}
+		}
+		if got := set.Contains(addr); got != expected {
+			t.Fatalf("Contains(%s): expected %v, got %v", addr, expected, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, entry := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.256"} {
+		if _, err := Parse([]string{entry}); err == nil {
+			t.Errorf("expected '%s' to be rejected", entry)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.txt")
+	os.WriteFile(path, []byte("# scanners\n198.51.100.0/24\n\n2001:db8::1 # single host\n"), 0o644)
+	entries, err := ReadFile(path)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v: %v", entries, err)
+	}
+
+	os.WriteFile(path, []byte("198.51.100.0/24\nbogus\n"), 0o644)
+	if _, err := ReadFile(path); err == nil {
+		t.Error("expected an invalid line to be rejected")
+	}
+}
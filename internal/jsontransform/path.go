@@ -0,0 +1,251 @@
+package jsontransform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type stepKind int
+
+const (
+	fieldStep stepKind = iota
+	indexStep
+	wildcardStep
+)
+
+// step is one segment of a path: an object field, an array index or every
+// element of an array
+type step struct {
+	kind  stepKind
+	field string
+	index int
+}
+
+// parsePath parses the JSONPath subset used by transforms: "$" followed by
+// ".field", "['field']", "[0]" or "[*]" segments
+func parsePath(path string) ([]step, error) {
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		return nil, fmt.Errorf("path '%s' must start with '$'", path)
+	}
+
+	var steps []step
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if end == 1 {
+				return nil, fmt.Errorf("path '%s' has an empty field name", path)
+			}
+			steps = append(steps, step{kind: fieldStep, field: rest[1:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path '%s' has an unclosed '['", path)
+			}
+			inner := rest[1:end]
+			switch {
+			case inner == "*":
+				steps = append(steps, step{kind: wildcardStep})
+			case len(inner) >= 2 && inner[0] == '\'' && inner[len(inner)-1] == '\'':
+				steps = append(steps, step{kind: fieldStep, field: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("path '%s' has an invalid index '%s'", path, inner)
+				}
+				steps = append(steps, step{kind: indexStep, index: index})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("path '%s' has an unexpected '%c'", path, rest[0])
+		}
+	}
+	return steps, nil
+}
+
+func hasWildcard(steps []step) bool {
+	for _, s := range steps {
+		if s.kind == wildcardStep {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the value at a path without wildcards
+func get(v any, steps []step) (any, bool) {
+	for _, s := range steps {
+		switch s.kind {
+		case fieldStep:
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if v, ok = obj[s.field]; !ok {
+				return nil, false
+			}
+		case indexStep:
+			arr, ok := v.([]any)
+			if !ok || s.index >= len(arr) {
+				return nil, false
+			}
+			v = arr[s.index]
+		}
+	}
+	return v, true
+}
+
+// set writes the result of value() at every location the path matches,
+// creating missing objects along the way. Locations whose parent has the
+// wrong type are left alone.
+func set(v any, steps []step, value func() any) any {
+	if len(steps) == 0 {
+		return value()
+	}
+	s, rest := steps[0], steps[1:]
+	switch s.kind {
+	case fieldStep:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			if v != nil {
+				return v
+			}
+			obj = map[string]any{}
+		}
+		obj[s.field] = set(obj[s.field], rest, value)
+		return obj
+	case indexStep:
+		if arr, ok := v.([]any); ok && s.index < len(arr) {
+			arr[s.index] = set(arr[s.index], rest, value)
+		}
+	case wildcardStep:
+		if arr, ok := v.([]any); ok {
+			for i := range arr {
+				arr[i] = set(arr[i], rest, value)
+			}
+		}
+	}
+	return v
+}
+
+// remove deletes every location the path matches
+func remove(v any, steps []step) any {
+	if len(steps) == 0 {
+		return v
+	}
+	s, rest := steps[0], steps[1:]
+	switch s.kind {
+	case fieldStep:
+		if obj, ok := v.(map[string]any); ok {
+			if len(rest) == 0 {
+				delete(obj, s.field)
+			} else if child, ok := obj[s.field]; ok {
+				obj[s.field] = remove(child, rest)
+			}
+		}
+	case indexStep:
+		if arr, ok := v.([]any); ok && s.index < len(arr) {
+			if len(rest) == 0 {
+				return append(arr[:s.index:s.index], arr[s.index+1:]...)
+			}
+			arr[s.index] = remove(arr[s.index], rest)
+		}
+	case wildcardStep:
+		if arr, ok := v.([]any); ok {
+			if len(rest) == 0 {
+				return []any{}
+			}
+			for i := range arr {
+				arr[i] = remove(arr[i], rest)
+			}
+		}
+	}
+	return v
+}
+
+// rename moves the field the path ends in to a new name within the same
+// object, at every location the path matches
+func rename(v any, steps []step, to string) any {
+	if len(steps) == 1 {
+		if obj, ok := v.(map[string]any); ok {
+			if value, ok := obj[steps[0].field]; ok {
+				delete(obj, steps[0].field)
+				obj[to] = value
+			}
+		}
+		return v
+	}
+	s, rest := steps[0], steps[1:]
+	switch s.kind {
+	case fieldStep:
+		if obj, ok := v.(map[string]any); ok {
+			if child, ok := obj[s.field]; ok {
+				obj[s.field] = rename(child, rest, to)
+			}
+		}
+	case indexStep:
+		if arr, ok := v.([]any); ok && s.index < len(arr) {
+			arr[s.index] = rename(arr[s.index], rest, to)
+		}
+	case wildcardStep:
+		if arr, ok := v.([]any); ok {
+			for i := range arr {
+				arr[i] = rename(arr[i], rest, to)
+			}
+		}
+	}
+	return v
+}
+
+// project keeps only the allowed paths, which may use field and wildcard
+// steps. It reports false when nothing at v is allowed.
+func project(v any, paths [][]step) (any, bool) {
+	for _, p := range paths {
+		if len(p) == 0 {
+			return v, true
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		fields := map[string][][]step{}
+		for _, p := range paths {
+			if p[0].kind == fieldStep {
+				fields[p[0].field] = append(fields[p[0].field], p[1:])
+			}
+		}
+		out := make(map[string]any, len(fields))
+		for name, rest := range fields {
+			if child, ok := val[name]; ok {
+				if projected, ok := project(child, rest); ok {
+					out[name] = projected
+				}
+			}
+		}
+		return out, true
+	case []any:
+		var elements [][]step
+		for _, p := range paths {
+			if p[0].kind == wildcardStep {
+				elements = append(elements, p[1:])
+			}
+		}
+		if len(elements) == 0 {
+			return nil, false
+		}
+		out := make([]any, 0, len(val))
+		for _, element := range val {
+			if projected, ok := project(element, elements); ok {
+				out = append(out, projected)
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
@@ -0,0 +1,303 @@
+// Package jsontransform rewrites JSON documents according to the body
+// transforms configured on routes: unwrapping and wrapping envelopes,
+// projecting allowed fields, and removing, renaming and setting fields
+// addressed by a small JSONPath subset.
+package jsontransform
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+// ErrNotFound is returned when the body has no value at the unwrap path
+var ErrNotFound = errors.New("unwrap path not found in body")
+
+type renameOp struct {
+	path []step
+	to   string
+}
+
+type setOp struct {
+	path  []step
+	value json.RawMessage
+}
+
+// Transform is a compiled config.BodyTransform
+type Transform struct {
+	unwrap  []step
+	allow   [][]step
+	remove  [][]step
+	renames []renameOp
+	sets    []setOp
+	wrap    string
+
+	// elements is the transform applied to each element of a top-level array
+	// when every operation addresses those elements, so the body can be
+	// transformed as a stream
+	elements *Transform
+}
+
+// Compile validates a body transform and prepares it for use
+func Compile(cfg config.BodyTransform) (*Transform, error) {
+	t := &Transform{wrap: cfg.Wrap}
+
+	if cfg.Unwrap != "" {
+		steps, err := parsePath(cfg.Unwrap)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap: %w", err)
+		}
+		if hasWildcard(steps) {
+			return nil, fmt.Errorf("unwrap path '%s' cannot use [*]", cfg.Unwrap)
+		}
+		t.unwrap = steps
+	}
+
+	for _, path := range cfg.Allow {
+		steps, err := parsePath(path)
+		if err != nil {
+			return nil, fmt.Errorf("allow: %w", err)
+		}
+		if slices.ContainsFunc(steps, func(s step) bool { return s.kind == indexStep }) {
+			return nil, fmt.Errorf("allow path '%s' cannot use array indexes", path)
+		}
+		t.allow = append(t.allow, steps)
+	}
+
+	for _, path := range cfg.Remove {
+		steps, err := parsePath(path)
+		if err != nil {
+			return nil, fmt.Errorf("remove: %w", err)
+		}
+		if len(steps) == 0 {
+			return nil, fmt.Errorf("cannot remove the whole body")
+		}
+		t.remove = append(t.remove, steps)
+	}
+
+	// Map order is random; sort so overlapping operations behave the same
+	// on every request
+	for _, path := range sortedKeys(cfg.Rename) {
+		steps, err := parsePath(path)
+		if err != nil {
+			return nil, fmt.Errorf("rename: %w", err)
+		}
+		if len(steps) == 0 || steps[len(steps)-1].kind != fieldStep {
+			return nil, fmt.Errorf("rename path '%s' must end in a field", path)
+		}
+		if cfg.Rename[path] == "" {
+			return nil, fmt.Errorf("rename of '%s' has no new name", path)
+		}
+		t.renames = append(t.renames, renameOp{path: steps, to: cfg.Rename[path]})
+	}
+
+	for _, path := range sortedKeys(cfg.Set) {
+		steps, err := parsePath(path)
+		if err != nil {
+			return nil, fmt.Errorf("set: %w", err)
+		}
+		if !json.Valid(cfg.Set[path]) {
+			return nil, fmt.Errorf("set value of '%s' is not valid JSON", path)
+		}
+		t.sets = append(t.sets, setOp{path: steps, value: cfg.Set[path]})
+	}
+
+	t.elements = t.elementTransform()
+	return t, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// elementTransform returns the per-element equivalent of t, or nil when t
+// touches anything but the elements of a top-level array
+func (t *Transform) elementTransform() *Transform {
+	if t.unwrap != nil || t.wrap != "" {
+		return nil
+	}
+	ops := len(t.allow) + len(t.remove) + len(t.renames) + len(t.sets)
+	if ops == 0 {
+		return nil
+	}
+
+	underElements := func(steps []step) bool { return len(steps) > 0 && steps[0].kind == wildcardStep }
+	elements := &Transform{}
+	for _, steps := range t.allow {
+		if !underElements(steps) {
+			return nil
+		}
+		elements.allow = append(elements.allow, steps[1:])
+	}
+	for _, steps := range t.remove {
+		// Removing whole elements changes the array itself
+		if !underElements(steps) || len(steps) == 1 {
+			return nil
+		}
+		elements.remove = append(elements.remove, steps[1:])
+	}
+	for _, op := range t.renames {
+		if !underElements(op.path) {
+			return nil
+		}
+		elements.renames = append(elements.renames, renameOp{path: op.path[1:], to: op.to})
+	}
+	for _, op := range t.sets {
+		if !underElements(op.path) {
+			return nil
+		}
+		elements.sets = append(elements.sets, setOp{path: op.path[1:], value: op.value})
+	}
+	return elements
+}
+
+// Apply transforms a decoded JSON document. The document may be modified in
+// place.
+func (t *Transform) Apply(v any) (any, error) {
+	if t.unwrap != nil {
+		unwrapped, ok := get(v, t.unwrap)
+		if !ok {
+			return nil, ErrNotFound
+		}
+		v = unwrapped
+	}
+	if len(t.allow) > 0 {
+		v, _ = project(v, t.allow)
+	}
+	for _, steps := range t.remove {
+		v = remove(v, steps)
+	}
+	for _, op := range t.renames {
+		v = rename(v, op.path, op.to)
+	}
+	for _, op := range t.sets {
+		v = set(v, op.path, func() any {
+			// Decode per location so no two locations share a value
+			value, _ := decode(op.value)
+			return value
+		})
+	}
+	if t.wrap != "" {
+		v = map[string]any{t.wrap: v}
+	}
+	return v, nil
+}
+
+// Rewrite transforms a complete JSON document
+func (t *Transform) Rewrite(data []byte) ([]byte, error) {
+	v, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = t.Apply(v); err != nil {
+		return nil, err
+	}
+	return encode(v)
+}
+
+// Streamable reports whether Stream can transform the body without
+// buffering all of it
+func (t *Transform) Streamable() bool {
+	return t.elements != nil
+}
+
+// Stream transforms a top-level JSON array one element at a time, so only a
+// single element is held in memory. Documents that are not arrays are copied
+// unchanged, since a streamable transform only touches array elements. It
+// must only be called when Streamable reports true.
+func (t *Transform) Stream(dst io.Writer, src io.Reader) error {
+	br := bufio.NewReader(src)
+	first, err := peekNonSpace(br)
+	if err != nil {
+		return err
+	}
+	if first != '[' {
+		_, err := io.Copy(dst, br)
+		return err
+	}
+
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(dst, "["); err != nil {
+		return err
+	}
+	for i := 0; dec.More(); i++ {
+		var element any
+		if err := dec.Decode(&element); err != nil {
+			return err
+		}
+		element, err := t.elements.Apply(element)
+		if err != nil {
+			return err
+		}
+		data, err := encode(element)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			data = append([]byte{','}, data...)
+		}
+		if _, err := dst.Write(data); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the top-level array")
+	}
+	_, err = io.WriteString(dst, "]")
+	return err
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, br.UnreadByte()
+		}
+	}
+}
+
+// decode parses JSON keeping numbers as written, so large integers and
+// decimals pass through unchanged
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON document")
+	}
+	return v, nil
+}
+
+func encode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,138 @@
+package jsontransform
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     config.BodyTransform
+		input    string
+		expected string
+	}{
+		{
+			name:     "unwrap and wrap envelopes",
+			spec:     config.BodyTransform{Unwrap: "$.result.payload", Wrap: "data"},
+			input:    `{"status":"OK","result":{"payload":{"id":1}}}`,
+			expected: `{"data":{"id":1}}`,
+		},
+		{
+			name:     "allow projects fields",
+			spec:     config.BodyTransform{Allow: []string{"$.id", "$.profile.name", "$.orders[*].total"}},
+			input:    `{"id":7,"password_hash":"x","profile":{"name":"Ada","ssn":"1"},"orders":[{"total":10,"card":"4111"},{"total":20}]}`,
+			expected: `{"id":7,"orders":[{"total":10},{"total":20}],"profile":{"name":"Ada"}}`,
+		},
+		{
+			name: "remove, rename and set",
+			spec: config.BodyTransform{
+				Remove: []string{"$.internal", "$.items[*].debug", "$.tags[0]"},
+				Rename: map[string]string{"$.usr_nm": "username", "$.items[*].qty": "quantity"},
+				Set:    map[string]json.RawMessage{"$.meta.api_version": json.RawMessage(`"v2"`), "$['odd.key']": json.RawMessage(`true`)},
+			},
+			input:    `{"usr_nm":"ada","internal":{},"tags":["a","b"],"items":[{"qty":1,"debug":"x"}]}`,
+			expected: `{"items":[{"quantity":1}],"meta":{"api_version":"v2"},"odd.key":true,"tags":["b"],"username":"ada"}`,
+		},
+		{
+			name:     "numbers and html pass through unchanged",
+			spec:     config.BodyTransform{Remove: []string{"$.x"}},
+			input:    `{"big":12345678901234567890,"price":0.10,"html":"<b>&</b>","x":1}`,
+			expected: `{"big":12345678901234567890,"html":"<b>&</b>","price":0.10}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transform, err := Compile(tt.spec)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			output, err := transform.Rewrite([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("rewrite failed: %v", err)
+			}
+			if string(output) != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestRewriteUnwrapMissing(t *testing.T) {
+	transform, err := Compile(config.BodyTransform{Unwrap: "$.data"})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if _, err := transform.Rewrite([]byte(`{"error":"boom"}`)); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	transform, err := Compile(config.BodyTransform{
+		Allow:  []string{"$[*].id", "$[*].user_name"},
+		Rename: map[string]string{"$[*].user_name": "name"},
+	})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if !transform.Streamable() {
+		t.Fatal("expected a transform of array elements to be streamable")
+	}
+
+	var out bytes.Buffer
+	err = transform.Stream(&out, strings.NewReader(` [{"id":1,"user_name":"ada","secret":"x"}, {"id":2,"user_name":"grace"}]`))
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	if expected := `[{"id":1,"name":"ada"},{"id":2,"name":"grace"}]`; out.String() != expected {
+		t.Errorf("expected %s, got %s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := transform.Stream(&out, strings.NewReader(`{"error":"not a list"}`)); err != nil || out.String() != `{"error":"not a list"}` {
+		t.Errorf("expected non-arrays to be copied unchanged, got %s (%v)", out.String(), err)
+	}
+
+	if err := transform.Stream(&out, strings.NewReader(`[{"id":1},`)); err == nil {
+		t.Error("expected truncated input to fail")
+	}
+
+	for _, spec := range []config.BodyTransform{
+		{Unwrap: "$.data"},
+		{Remove: []string{"$[*]"}},
+		{Allow: []string{"$[*].id", "$.total"}},
+	} {
+		transform, err := Compile(spec)
+		if err != nil {
+			t.Fatalf("compile failed: %v", err)
+		}
+		if transform.Streamable() {
+			t.Errorf("expected %+v to require buffering", spec)
+		}
+	}
+}
+
+func TestCompileRejectsInvalidTransforms(t *testing.T) {
+	invalid := []config.BodyTransform{
+		{Unwrap: "data"},
+		{Unwrap: "$.items[*]"},
+		{Allow: []string{"$.items[0].id"}},
+		{Remove: []string{"$"}},
+		{Remove: []string{"$.a..b"}},
+		{Remove: []string{"$.a[x]"}},
+		{Rename: map[string]string{"$.items[0]": "first"}},
+		{Rename: map[string]string{"$.a": ""}},
+		{Set: map[string]json.RawMessage{"$.a": json.RawMessage(`{`)}},
+	}
+	for _, spec := range invalid {
+		if _, err := Compile(spec); err == nil {
+			t.Errorf("expected %+v to be rejected", spec)
+		}
+	}
+}
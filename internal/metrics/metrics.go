@@ -0,0 +1,199 @@
+// Package metrics holds the gateway's process-wide counters and gauges and
+// renders them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type metricKind string
+
+const (
+	kindCounter metricKind = "counter"
+	kindGauge   metricKind = "gauge"
+)
+
+// family is a named metric with a fixed set of label names
+type family struct {
+	name       string
+	help       string
+	kind       metricKind
+	labelNames []string
+
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       atomic.Int64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*family{}
+)
+
+func register(name, help string, kind metricKind, labelNames []string) *family {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if existing, ok := registry[name]; ok {
+		return existing
+	}
+
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		series:     map[string]*series{},
+	}
+	registry[name] = f
+	return f
+}
+
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok = f.series[key]; ok {
+		return s
+	}
+	s = &series{labelValues: append([]string(nil), labelValues...)}
+	f.series[key] = s
+	return s
+}
+
+// Counter is a monotonically increasing value, optionally partitioned by labels
+type Counter struct {
+	f *family
+}
+
+// NewCounter registers a counter. Registering the same name twice returns the
+// existing metric so package-level declarations stay safe in tests.
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{f: register(name, help, kindCounter, labelNames)}
+}
+
+// Inc adds one to the series identified by labelValues
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta to the series identified by labelValues
+func (c *Counter) Add(delta int64, labelValues ...string) {
+	c.f.with(labelValues).value.Add(delta)
+}
+
+// Value returns the current value of the series identified by labelValues
+func (c *Counter) Value(labelValues ...string) int64 {
+	return c.f.with(labelValues).value.Load()
+}
+
+// Gauge is a value that can go up and down, optionally partitioned by labels
+type Gauge struct {
+	f *family
+}
+
+// NewGauge registers a gauge. Registering the same name twice returns the
+// existing metric.
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{f: register(name, help, kindGauge, labelNames)}
+}
+
+// Inc adds one to the series identified by labelValues
+func (g *Gauge) Inc(labelValues ...string) {
+	g.f.with(labelValues).value.Add(1)
+}
+
+// Dec subtracts one from the series identified by labelValues
+func (g *Gauge) Dec(labelValues ...string) {
+	g.f.with(labelValues).value.Add(-1)
+}
+
+// Set replaces the value of the series identified by labelValues
+func (g *Gauge) Set(value int64, labelValues ...string) {
+	g.f.with(labelValues).value.Store(value)
+}
+
+// Value returns the current value of the series identified by labelValues
+func (g *Gauge) Value(labelValues ...string) int64 {
+	return g.f.with(labelValues).value.Load()
+}
+
+// Write renders every registered metric in the Prometheus text format
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	families := make([]*family, 0, len(registry))
+	for _, f := range registry {
+		families = append(families, f)
+	}
+	registryMu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	for _, f := range families {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind); err != nil {
+			return err
+		}
+
+		f.mu.RLock()
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if _, err := fmt.Fprintf(w, "%s%s %d\n", f.name, formatLabels(f.labelNames, s.labelValues), s.value.Load()); err != nil {
+				f.mu.RUnlock()
+				return err
+			}
+		}
+		f.mu.RUnlock()
+	}
+
+	return nil
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Handler serves the registered metrics for scraping
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := Write(w); err != nil {
+			http.Error(w, "Failed to write metrics", http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+// Package problem writes the gateway's own error responses as RFC 9457
+// problem details.
+package problem
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/LucianoBarrera/api-gateway/internal/grpcstatus"
+)
+
+// ContentType is the media type of problem details
+const ContentType = "application/problem+json"
+
+// TypeBase prefixes a problem's code to form its type URI
+const TypeBase = "urn:api-gateway:problem:"
+
+// Type is a kind of problem. Its code and title never change between
+// occurrences, so clients can rely on them.
+type Type struct {
+	// Code is a stable, machine-readable identifier
+	Code   string
+	Title  string
+	Status int
+}
+
+// The problems the gateway reports
+var (
+	MissingRequestID           = Type{"missing_request_id", "Missing request ID", http.StatusBadRequest}
+	MissingAPIKey              = Type{"missing_api_key", "Missing API key", http.StatusUnauthorized}
+	InvalidAPIKey              = Type{"invalid_api_key", "Invalid API key", http.StatusUnauthorized}
+	InvalidPath                = Type{"invalid_path", "Invalid path", http.StatusBadRequest}
+	ServiceNotFound            = Type{"service_not_found", "Service not found", http.StatusNotFound}
+	RouteNotFound              = Type{"route_not_found", "Route not found", http.StatusNotFound}
+	MethodNotAllowed           = Type{"method_not_allowed", "Method not allowed", http.StatusMethodNotAllowed}
+	InvalidRequest             = Type{"invalid_request", "Invalid request", http.StatusBadRequest}
+	InvalidBody                = Type{"invalid_body", "Invalid request body", http.StatusBadRequest}
+	ValidationFailed           = Type{"validation_failed", "Request does not match the API description", http.StatusBadRequest}
+	URLTooLong                 = Type{"url_too_long", "Request URL too long", http.StatusRequestURITooLong}
+	TooManyQueryParams         = Type{"too_many_query_params", "Too many query parameters", http.StatusBadRequest}
+	BodyTooLarge               = Type{"body_too_large", "Request body too large", http.StatusRequestEntityTooLarge}
+	BodyTooSlow                = Type{"body_too_slow", "Request body sent too slowly", http.StatusRequestTimeout}
+	UnsupportedContentEncoding = Type{"unsupported_content_encoding", "Unsupported request content encoding", http.StatusUnsupportedMediaType}
+	ClientAddressBlocked       = Type{"client_address_blocked", "Client address not allowed", http.StatusForbidden}
+	CORSRejected               = Type{"cors_rejected", "Cross-origin request not allowed", http.StatusForbidden}
+	CacheDisabled              = Type{"cache_disabled", "Response cache not enabled", http.StatusNotFound}
+	FaultInjected              = Type{"fault_injected", "Injected fault", http.StatusServiceUnavailable}
+	UpstreamUnreachable        = Type{"upstream_unreachable", "Upstream unreachable", http.StatusBadGateway}
+	UpstreamTimeout            = Type{"upstream_timeout", "Upstream timed out", http.StatusGatewayTimeout}
+	UpstreamTLSError           = Type{"upstream_tls_error", "Upstream TLS handshake failed", http.StatusBadGateway}
+	UpstreamError              = Type{"upstream_error", "Upstream request failed", http.StatusBadGateway}
+	UpstreamUnavailable        = Type{"upstream_unavailable", "No upstream available", http.StatusServiceUnavailable}
+	ServiceMaintenance         = Type{"service_maintenance", "Service under maintenance", http.StatusServiceUnavailable}
+	UpstreamGRPCError          = Type{"upstream_grpc_error", "Upstream gRPC call failed", http.StatusBadGateway}
+	AggregateCallFailed        = Type{"aggregate_call_failed", "Required upstream call failed", http.StatusBadGateway}
+	AdminUnauthorized          = Type{"admin_unauthorized", "Invalid admin credentials", http.StatusUnauthorized}
+	UpstreamNotFound           = Type{"upstream_not_found", "Upstream not found", http.StatusNotFound}
+	ConfigRejected             = Type{"config_rejected", "Config rejected", http.StatusUnprocessableEntity}
+	ConsumerNotFound           = Type{"consumer_not_found", "Consumer not found", http.StatusNotFound}
+	RevisionNotFound           = Type{"revision_not_found", "Config revision not found", http.StatusNotFound}
+	RevisionConflict           = Type{"revision_conflict", "Config revision changed", http.StatusPreconditionFailed}
+	ResourceExists             = Type{"resource_exists", "Resource already exists", http.StatusConflict}
+	InternalError              = Type{"internal_error", "Internal server error", http.StatusInternalServerError}
+)
+
+// WithStatus returns the same type answered with another status, for
+// problems whose status is configured, such as injected faults
+func (t Type) WithStatus(status int) Type {
+	t.Status = status
+	return t
+}
+
+// Details is one occurrence of a problem
+type Details struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request that failed
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// Extensions are further members, such as a list of validation errors
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// New describes an occurrence of t
+func New(t Type, detail string) *Details {
+	return &Details{
+		Type:   TypeBase + t.Code,
+		Title:  t.Title,
+		Status: t.Status,
+		Detail: detail,
+		Code:   t.Code,
+	}
+}
+
+// With adds an extension member
+func (d *Details) With(name string, value interface{}) *Details {
+	if d.Extensions == nil {
+		d.Extensions = map[string]interface{}{}
+	}
+	d.Extensions[name] = value
+	return d
+}
+
+// MarshalJSON puts the extension members after the standard ones
+func (d Details) MarshalJSON() ([]byte, error) {
+	type details Details
+	standard, err := json.Marshal(details(d))
+	if err != nil || len(d.Extensions) == 0 {
+		return standard, err
+	}
+	extensions, err := json.Marshal(d.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	merged := bytes.TrimSuffix(standard, []byte("}"))
+	merged = append(merged, ',')
+	return append(merged, extensions[1:]...), nil
+}
+
+// Write sends a problem for request r, filling in its instance and request
+// ID. gRPC clients get the equivalent grpc-status instead of a body.
+func Write(w http.ResponseWriter, r *http.Request, d *Details) {
+	if grpcstatus.IsGRPCRequest(r) {
+		grpcstatus.WriteError(w, d.Status, d.Detail)
+		return
+	}
+
+	if d.Instance == "" {
+		d.Instance = r.URL.Path
+	}
+	if d.RequestID == "" {
+		d.RequestID = r.Header.Get("X-Request-ID")
+	}
+
+	body, err := json.Marshal(d)
+	if err != nil {
+		log.Printf("Failed to marshal problem details: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(d.Status)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Failed to write error response: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/users/users", nil)
+	req.Header.Set("X-Request-ID", "problem-test")
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Length", "42")
+
+	Write(w, req, New(ValidationFailed, "Request does not match").With("errors", []string{"name is required"}))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("expected content type %q, got %q", ContentType, ct)
+	}
+	if w.Header().Get("Content-Length") != "" {
+		t.Error("expected a stale Content-Length to be dropped")
+	}
+
+	expected := `{"type":"urn:api-gateway:problem:validation_failed","title":"Request does not match the API description",` +
+		`"status":400,"detail":"Request does not match","instance":"/api/users/users","code":"validation_failed",` +
+		`"request_id":"problem-test","errors":["name is required"]}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+}
+
+func TestWriteWithStatus(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	w := httptest.NewRecorder()
+
+	Write(w, req, New(FaultInjected.WithStatus(http.StatusTooManyRequests), "Fault injected"))
+
+	var details Details
+	if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+		t.Fatalf("invalid problem details: %v", err)
+	}
+	if w.Code != http.StatusTooManyRequests || details.Status != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 in the response and the body, got %d and %d", w.Code, details.Status)
+	}
+	if details.Code != "fault_injected" || details.RequestID != "" {
+		t.Errorf("unexpected details %+v", details)
+	}
+}
+
+func TestWriteGRPC(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/users.v1.UserService/GetUser", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	w := httptest.NewRecorder()
+
+	Write(w, req, New(UpstreamUnreachable, "The upstream could not be reached"))
+
+	if w.Header().Get("Content-Type") == ContentType || w.Body.Len() != 0 {
+		t.Errorf("expected a gRPC status instead of problem details, got %q %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+	if w.Header().Get("Grpc-Status") == "" {
+		t.Errorf("expected a grpc-status, got headers %v", w.Header())
+	}
+}
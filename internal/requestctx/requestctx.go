@@ -0,0 +1,121 @@
+// Package requestctx carries per-request facts gathered by the middlewares
+// and forwarders (who is calling, which service and version served the
+// request) so they can be reported once the request completes.
+package requestctx
+
+import (
+	"context"
+	"sync"
+)
+
+type contextKey struct{}
+
+// Info describes a request as it travels through the gateway. Fields are
+// filled in as they become known; use the setters, since forwarders may
+// update them from other goroutines.
+type Info struct {
+	mu sync.Mutex
+
+	requestID string
+	clientIP  string
+	consumer  string
+	service   string
+	version   string
+	bodyErr   error
+}
+
+// New attaches a fresh Info for the given request ID to ctx
+func New(ctx context.Context, requestID string) (context.Context, *Info) {
+	info := &Info{requestID: requestID}
+	return context.WithValue(ctx, contextKey{}, info), info
+}
+
+// From returns the request's Info. Requests that did not go through the
+// gateway's middlewares (e.g. in tests) get a detached Info, so callers never
+// need to check for nil.
+func From(ctx context.Context) *Info {
+	if info, ok := ctx.Value(contextKey{}).(*Info); ok {
+		return info
+	}
+	return &Info{}
+}
+
+// RequestID returns the X-Request-ID of the request
+func (i *Info) RequestID() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.requestID
+}
+
+// ClientIP returns the address of the client that sent the request
+func (i *Info) ClientIP() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.clientIP
+}
+
+// SetClientIP records the address of the client that sent the request
+func (i *Info) SetClientIP(clientIP string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.clientIP = clientIP
+}
+
+// Consumer returns the ID of the authenticated consumer, if any
+func (i *Info) Consumer() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.consumer
+}
+
+// SetConsumer records the authenticated consumer
+func (i *Info) SetConsumer(consumer string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.consumer = consumer
+}
+
+// Service returns the service the request was routed to
+func (i *Info) Service() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.service
+}
+
+// SetService records the service the request was routed to
+func (i *Info) SetService(service string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.service = service
+}
+
+// Version returns the service version that served the request
+func (i *Info) Version() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.version
+}
+
+// SetVersion records the service version that served the request
+func (i *Info) SetVersion(version string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.version = version
+}
+
+// BodyError returns why reading the request body was cut short by the
+// server's limits, if it was
+func (i *Info) BodyError() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.bodyErr
+}
+
+// SetBodyError records why reading the request body was cut short. Cutting
+// a read short can cancel the request's context, so whoever sees the
+// resulting error may need this to report the real cause.
+func (i *Info) SetBodyError(err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.bodyErr = err
+}
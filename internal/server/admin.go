@@ -0,0 +1,370 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/upstream"
+)
+
+var adminActions = metrics.NewCounter("gateway_admin_actions_total",
+	"Changes requested through the admin API, by action and result", "action", "result")
+
+// maxAdminRequestBytes bounds the body of an admin request
+const maxAdminRequestBytes = 64 << 10
+
+// adminAPI serves the operators' endpoints on the admin listener
+type adminAPI struct {
+	gateway *Gateway
+}
+
+// NewAdminServer creates the admin listener, or returns nil when the admin
+// API is disabled. Its settings are read from the config the gateway
+// started with.
+func NewAdminServer(gateway *Gateway) *http.Server {
+	cfg := gateway.Config().Admin
+	if !cfg.Enabled {
+		return nil
+	}
+	return &http.Server{
+		Addr:              cfg.ListenAddress(),
+		Handler:           (&adminAPI{gateway: gateway}).routes(cfg.Token),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+}
+
+func (a *adminAPI) routes(token string) http.Handler {
+	mux := http.NewServeMux()
+
+	// Introspection
+	mux.HandleFunc("GET /config", a.configHandler)
+	mux.HandleFunc("GET /routes", a.routesHandler)
+	mux.HandleFunc("GET /upstreams", a.upstreamsHandler)
+	mux.HandleFunc("GET /maintenance", a.maintenanceHandler)
+	mux.HandleFunc("GET /readiness", a.readinessHandler)
+	mux.HandleFunc("GET /cache/stats", a.cacheStatsHandler)
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	// Runtime actions
+	mux.HandleFunc("POST /upstreams/{action}", a.upstreamActionHandler)
+	mux.HandleFunc("PUT /maintenance", a.setMaintenanceHandler)
+	mux.HandleFunc("DELETE /maintenance", a.clearMaintenanceHandler)
+	mux.HandleFunc("PUT /services/{service}/maintenance", a.setMaintenanceHandler)
+	mux.HandleFunc("DELETE /services/{service}/maintenance", a.clearMaintenanceHandler)
+	mux.HandleFunc("POST /reload", a.reloadHandler)
+	mux.HandleFunc("POST /cache/purge", a.cachePurgeHandler)
+
+	// Config management
+	a.configRoutes(mux)
+
+	return adminAuthMiddleware(token, mux)
+}
+
+// adminAuthMiddleware requires the admin token as a bearer token
+func adminAuthMiddleware(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			log.Printf("AUDIT admin access denied - Remote: %s - %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeErrorResponse(w, r, problem.AdminUnauthorized, "A valid admin token is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// configHandler returns the config in use with its secrets redacted
+func (a *adminAPI) configHandler(w http.ResponseWriter, r *http.Request) {
+	redacted, err := a.gateway.Config().Redacted()
+	if err != nil {
+		log.Printf("Failed to redact config: %v", err)
+		writeErrorResponse(w, r, problem.InternalError, "Failed to encode the config")
+		return
+	}
+	w.Header().Set("ETag", etag(a.gateway.Revision()))
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{
+		"revision":   a.gateway.Revision(),
+		"applied_at": a.gateway.AppliedAt(),
+		"config":     redacted,
+	})
+}
+
+// routeTable is what the gateway serves under /api/ and for gRPC calls
+type routeTable struct {
+	Services   []serviceRoute   `json:"services"`
+	Aggregates []aggregateRoute `json:"aggregates"`
+}
+
+type serviceRoute struct {
+	Name         string         `json:"name"`
+	Prefix       string         `json:"prefix"`
+	Protocol     string         `json:"protocol"`
+	GRPCServices []string       `json:"grpc_services,omitempty"`
+	Upstream     string         `json:"upstream,omitempty"`
+	Versions     []versionRoute `json:"versions,omitempty"`
+	Routes       []namedRoute   `json:"routes,omitempty"`
+	Transcoded   bool           `json:"transcoded"`
+	Maintenance  bool           `json:"maintenance"`
+}
+
+type versionRoute struct {
+	Name      string   `json:"name"`
+	Weight    int      `json:"weight"`
+	Upstreams []string `json:"upstreams"`
+}
+
+type namedRoute struct {
+	Name       string   `json:"name"`
+	PathPrefix string   `json:"path_prefix"`
+	Methods    []string `json:"methods,omitempty"`
+}
+
+type aggregateRoute struct {
+	Name        string          `json:"name"`
+	Prefix      string          `json:"prefix"`
+	Calls       []aggregateCall `json:"calls"`
+	Maintenance bool            `json:"maintenance"`
+}
+
+type aggregateCall struct {
+	Key      string `json:"key"`
+	Service  string `json:"service"`
+	Method   string `json:"method,omitempty"`
+	Path     string `json:"path"`
+	Required bool   `json:"required"`
+}
+
+// buildRouteTable lists the routes of a config, ordered by name
+func buildRouteTable(appConfig config.AppConfig, controls *Controls) routeTable {
+	table := routeTable{Services: []serviceRoute{}, Aggregates: []aggregateRoute{}}
+	maintenance := (&Server{appConfig: appConfig, controls: controls}).newMaintenanceGate()
+	now := time.Now()
+	for serviceName, upstreamURL := range appConfig.KnownServices {
+		serviceConfig := appConfig.Service(serviceName)
+		route := serviceRoute{
+			Name:         serviceName,
+			Prefix:       "/api/" + serviceName + "/",
+			Protocol:     "http",
+			GRPCServices: serviceConfig.GRPCServices,
+			Transcoded:   serviceConfig.Transcoding != nil,
+			Maintenance:  maintenance.status(serviceName, now).active,
+		}
+		if serviceConfig.IsGRPC() {
+			route.Protocol = config.ProtocolGRPC
+		}
+		// Versions replace the known_services URL
+		for _, version := range serviceConfig.Versions {
+			route.Versions = append(route.Versions, versionRoute{Name: version.Name, Weight: version.Weight, Upstreams: version.Upstreams})
+		}
+		if len(route.Versions) == 0 {
+			route.Upstream = upstreamURL
+		}
+		for _, namedConfig := range serviceConfig.Routes {
+			route.Routes = append(route.Routes, namedRoute{Name: namedConfig.Name, PathPrefix: namedConfig.PathPrefix, Methods: namedConfig.Methods})
+		}
+		table.Services = append(table.Services, route)
+	}
+	for aggregateName, aggregateConfig := range appConfig.Aggregates {
+		route := aggregateRoute{
+			Name:        aggregateName,
+			Prefix:      "/api/" + aggregateName + "/",
+			Maintenance: maintenance.status(aggregateName, now).active,
+		}
+		for _, call := range aggregateConfig.Calls {
+			route.Calls = append(route.Calls, aggregateCall{Key: call.Key, Service: call.Service, Method: call.Method, Path: call.Path, Required: call.Required})
+		}
+		table.Aggregates = append(table.Aggregates, route)
+	}
+	sort.Slice(table.Services, func(i, j int) bool { return table.Services[i].Name < table.Services[j].Name })
+	sort.Slice(table.Aggregates, func(i, j int) bool { return table.Aggregates[i].Name < table.Aggregates[j].Name })
+	return table
+}
+
+// routesHandler returns the route table
+func (a *adminAPI) routesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, r, http.StatusOK, buildRouteTable(a.gateway.Config(), a.gateway.Controls()))
+}
+
+// upstreamsHandler returns the state and passive health of every upstream
+func (a *adminAPI) upstreamsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{
+		"upstreams": a.gateway.Controls().Upstreams().Statuses(),
+	})
+}
+
+// upstreamActions map the actions of POST /upstreams/{action} to states
+var upstreamActions = map[string]upstream.State{
+	"drain":   upstream.Draining,
+	"disable": upstream.Disabled,
+	"enable":  upstream.Active,
+}
+
+// upstreamActionRequest names the upstream to act on, as listed by
+// GET /upstreams
+type upstreamActionRequest struct {
+	URL string `json:"url"`
+}
+
+// upstreamActionHandler drains, disables or re-enables an upstream
+func (a *adminAPI) upstreamActionHandler(w http.ResponseWriter, r *http.Request) {
+	action := r.PathValue("action")
+	state, ok := upstreamActions[action]
+	if !ok {
+		writeErrorResponse(w, r, problem.RouteNotFound, fmt.Sprintf("Unknown upstream action '%s', expected drain, disable or enable", action))
+		return
+	}
+
+	var request upstreamActionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequestBytes)).Decode(&request); err != nil || request.URL == "" {
+		writeErrorResponse(w, r, problem.InvalidBody, "Expected a JSON body with the 'url' of the upstream")
+		return
+	}
+
+	upstreams := a.gateway.Controls().Upstreams()
+	if err := upstreams.SetState(request.URL, state); errors.Is(err, upstream.ErrUnknownTarget) {
+		adminActions.Inc(action, "rejected")
+		writeErrorResponse(w, r, problem.UpstreamNotFound, fmt.Sprintf("No configured upstream has the URL '%s'", request.URL))
+		return
+	}
+	adminActions.Inc(action, "ok")
+	status, _ := upstreams.Status(request.URL)
+	log.Printf("AUDIT admin upstream %s set to %s (%d requests in flight) - Remote: %s", request.URL, state, status.InFlight, r.RemoteAddr)
+	writeJSONResponse(w, r, http.StatusOK, status)
+}
+
+// maintenanceHandler lists the maintenances started through the admin API.
+// Maintenance set in the config shows in the route table.
+func (a *adminAPI) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	var gateway *runtimeMaintenance
+	services := []runtimeMaintenance{}
+	for _, m := range a.gateway.Controls().Maintenance(time.Now()) {
+		if m.Service == "" {
+			gateway = &m
+			continue
+		}
+		services = append(services, m)
+	}
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{
+		"gateway":  gateway,
+		"services": services,
+	})
+}
+
+// readinessHandler runs the readiness checks and reports them with their
+// details and the state of every critical service target. Connection
+// attempts are not reused from GET /readiness, so targets are probed anew.
+func (a *adminAPI) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	s := &Server{appConfig: a.gateway.Config(), controls: a.gateway.Controls()}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSONResponse(w, r, http.StatusOK, s.newReadinessChecker().check(r.Context(), time.Now()))
+}
+
+// maintenanceRequest optionally limits how long a maintenance lasts, by
+// end time or duration
+type maintenanceRequest struct {
+	Until    time.Time       `json:"until"`
+	Duration config.Duration `json:"duration"`
+}
+
+// maintenanceScope returns the service of a maintenance request, empty for
+// the whole gateway, and the action it is counted under
+func maintenanceScope(r *http.Request) (string, string) {
+	serviceName := r.PathValue("service")
+	if serviceName == "" {
+		return "", "gateway"
+	}
+	return serviceName, "service"
+}
+
+// maintenanceTarget names the service of a maintenance in audit logs
+func maintenanceTarget(serviceName string) string {
+	if serviceName == "" {
+		return "the gateway"
+	}
+	return "service '" + serviceName + "'"
+}
+
+// setMaintenanceHandler puts a service, or the whole gateway, in
+// maintenance mode
+func (a *adminAPI) setMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	serviceName, scope := maintenanceScope(r)
+	action := scope + "_maintenance_on"
+	if serviceName != "" && !a.gateway.Config().HasService(serviceName) {
+		adminActions.Inc(action, "rejected")
+		writeErrorResponse(w, r, problem.ServiceNotFound, fmt.Sprintf("Unknown service '%s'", serviceName))
+		return
+	}
+
+	var request maintenanceRequest
+	if r.ContentLength != 0 && !decodeAdminBody(w, r, &request) {
+		return
+	}
+	until := request.Until
+	if request.Duration.Duration > 0 {
+		until = time.Now().Add(request.Duration.Duration)
+	}
+	if !until.IsZero() && !until.After(time.Now()) {
+		writeErrorResponse(w, r, problem.InvalidBody, "The maintenance must end in the future")
+		return
+	}
+
+	a.gateway.Controls().SetMaintenance(serviceName, until)
+	adminActions.Inc(action, "ok")
+	m, _ := a.gateway.Controls().maintenanceOf(serviceName, time.Now())
+	ends := "until cleared"
+	if !m.Until.IsZero() {
+		ends = "until " + m.Until.Format(time.RFC3339)
+	}
+	log.Printf("AUDIT admin maintenance mode on for %s %s - Remote: %s", maintenanceTarget(serviceName), ends, r.RemoteAddr)
+	writeJSONResponse(w, r, http.StatusOK, m)
+}
+
+// clearMaintenanceHandler takes a service, or the whole gateway, out of
+// the maintenance mode started through the admin API
+func (a *adminAPI) clearMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	serviceName, scope := maintenanceScope(r)
+	action := scope + "_maintenance_off"
+	if serviceName != "" && !a.gateway.Config().HasService(serviceName) {
+		adminActions.Inc(action, "rejected")
+		writeErrorResponse(w, r, problem.ServiceNotFound, fmt.Sprintf("Unknown service '%s'", serviceName))
+		return
+	}
+
+	a.gateway.Controls().ClearMaintenance(serviceName)
+	adminActions.Inc(action, "ok")
+	log.Printf("AUDIT admin maintenance mode off for %s - Remote: %s", maintenanceTarget(serviceName), r.RemoteAddr)
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{
+		"service":     serviceName,
+		"maintenance": false,
+	})
+}
+
+// reloadHandler reads the config files again and applies them as a new
+// revision. A config that cannot be read or is refused leaves the current
+// one in place.
+func (a *adminAPI) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	revision, err := a.gateway.Reload(r.RemoteAddr)
+	if err != nil {
+		a.writeChangeError(w, r, "reload", err)
+		return
+	}
+	adminActions.Inc("reload", "ok")
+	log.Printf("AUDIT admin config reloaded as revision %d - Remote: %s", revision.Number, r.RemoteAddr)
+	w.Header().Set("ETag", etag(revision.Number))
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{
+		"revision":   revision.Summary(),
+		"applied_at": a.gateway.AppliedAt(),
+	})
+}
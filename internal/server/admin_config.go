@@ -0,0 +1,591 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/configstore"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+)
+
+// adminError is a change refused before the new config is validated
+type adminError struct {
+	problemType problem.Type
+	detail      string
+}
+
+func (e *adminError) Error() string {
+	return e.detail
+}
+
+func refuse(problemType problem.Type, format string, args ...interface{}) error {
+	return &adminError{problemType: problemType, detail: fmt.Sprintf(format, args...)}
+}
+
+// serviceResource is a known service with its settings, as managed through
+// the admin API
+type serviceResource struct {
+	Name     string `json:"name"`
+	Upstream string `json:"upstream"`
+	config.ServiceConfig
+}
+
+// routeResource is a named route of a service
+type routeResource struct {
+	Service string `json:"service"`
+	config.RouteConfig
+}
+
+// upstreamTarget names an upstream URL in a request body
+type upstreamTarget struct {
+	URL string `json:"url"`
+}
+
+func (a *adminAPI) configRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /config/export", a.exportHandler)
+	mux.HandleFunc("GET /revisions", a.revisionsHandler)
+	mux.HandleFunc("GET /revisions/{revision}", a.revisionHandler)
+	mux.HandleFunc("POST /revisions/{revision}/rollback", a.rollbackHandler)
+
+	mux.HandleFunc("GET /services", a.listServicesHandler)
+	mux.HandleFunc("GET /services/{service}", a.getServiceHandler)
+	mux.HandleFunc("PUT /services/{service}", a.putServiceHandler)
+	mux.HandleFunc("DELETE /services/{service}", a.deleteServiceHandler)
+	mux.HandleFunc("PUT /services/{service}/upstream", a.putUpstreamHandler)
+	mux.HandleFunc("POST /services/{service}/versions/{version}/upstreams", a.addVersionUpstreamHandler)
+	mux.HandleFunc("DELETE /services/{service}/versions/{version}/upstreams", a.removeVersionUpstreamHandler)
+
+	mux.HandleFunc("GET /services/{service}/routes", a.listRoutesHandler)
+	mux.HandleFunc("PUT /services/{service}/routes/{route}", a.putRouteHandler)
+	mux.HandleFunc("DELETE /services/{service}/routes/{route}", a.deleteRouteHandler)
+
+	mux.HandleFunc("GET /consumers", a.listConsumersHandler)
+	mux.HandleFunc("PUT /consumers/{consumer}", a.putConsumerHandler)
+	mux.HandleFunc("DELETE /consumers/{consumer}", a.deleteConsumerHandler)
+}
+
+// etag formats a revision number as an entity tag
+func etag(revision int) string {
+	return `"` + strconv.Itoa(revision) + `"`
+}
+
+// expectedRevision reads the revision a change is based on from If-Match.
+// It returns 0 when the header is absent.
+func expectedRevision(r *http.Request) (int, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, nil
+	}
+	revision, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(header, "W/"), `"`))
+	if err != nil || revision <= 0 {
+		return 0, fmt.Errorf("If-Match must be a config revision, e.g. \"12\"")
+	}
+	return revision, nil
+}
+
+// decodeAdminBody reads a JSON request body, refusing unknown fields
+func decodeAdminBody(w http.ResponseWriter, r *http.Request, into interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(into); err != nil {
+		writeErrorResponse(w, r, problem.InvalidBody, fmt.Sprintf("Invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
+
+// decodeAdminResource reads a resource from the request body. Resources
+// read from the admin API have their secrets redacted, so a body still
+// carrying a redacted value is refused rather than stored.
+func decodeAdminResource(w http.ResponseWriter, r *http.Request, into interface{}) bool {
+	if !decodeAdminBody(w, r, into) {
+		return false
+	}
+	data, err := json.Marshal(into)
+	if err == nil && strings.Contains(string(data), `"`+config.RedactedValue+`"`) {
+		writeErrorResponse(w, r, problem.InvalidBody, fmt.Sprintf("Replace the %s values with the real ones", config.RedactedValue))
+		return false
+	}
+	return true
+}
+
+// redactedConfig returns the config with its secrets redacted, answering
+// the request itself if that fails
+func redactedConfig(w http.ResponseWriter, r *http.Request, appConfig config.AppConfig) (config.AppConfig, bool) {
+	redacted, err := appConfig.Redacted()
+	if err != nil {
+		log.Printf("Failed to redact config: %v", err)
+		writeErrorResponse(w, r, problem.InternalError, "Failed to encode the config")
+		return config.AppConfig{}, false
+	}
+	return redacted, true
+}
+
+// change applies edit to the current config as a new revision and sets
+// the ETag of the response. Refused changes are answered here.
+func (a *adminAPI) change(w http.ResponseWriter, r *http.Request, action string, edit func(*config.AppConfig) error) (configstore.Revision, bool) {
+	expected, err := expectedRevision(r)
+	if err != nil {
+		writeErrorResponse(w, r, problem.InvalidRequest, err.Error())
+		return configstore.Revision{}, false
+	}
+	revision, err := a.gateway.Update(expected, r.Method+" "+r.URL.Path, r.RemoteAddr, edit)
+	if err != nil {
+		a.writeChangeError(w, r, action, err)
+		return configstore.Revision{}, false
+	}
+	adminActions.Inc(action, "ok")
+	log.Printf("AUDIT admin %s %s applied as config revision %d - Remote: %s", r.Method, r.URL.Path, revision.Number, r.RemoteAddr)
+	w.Header().Set("ETag", etag(revision.Number))
+	return revision, true
+}
+
+// writeChangeError answers a change the gateway refused
+func (a *adminAPI) writeChangeError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	var refused *adminError
+	switch {
+	case errors.As(err, &refused):
+		adminActions.Inc(action, "rejected")
+		writeErrorResponse(w, r, refused.problemType, refused.detail)
+		return
+	case errors.Is(err, ErrRevisionConflict):
+		adminActions.Inc(action, "rejected")
+		w.Header().Set("ETag", etag(a.gateway.Revision()))
+		writeErrorResponse(w, r, problem.RevisionConflict, fmt.Sprintf("The config is at revision %d; fetch it again and retry", a.gateway.Revision()))
+		return
+	case errors.Is(err, configstore.ErrRevisionNotFound):
+		adminActions.Inc(action, "rejected")
+		writeErrorResponse(w, r, problem.RevisionNotFound, fmt.Sprintf("Revision '%s' is not stored", r.PathValue("revision")))
+		return
+	case errors.Is(err, ErrConfigRejected):
+		adminActions.Inc(action, "rejected")
+		log.Printf("AUDIT admin %s %s rejected: %v - Remote: %s", r.Method, r.URL.Path, err, r.RemoteAddr)
+		writeErrorResponse(w, r, problem.ConfigRejected, err.Error())
+		return
+	}
+	adminActions.Inc(action, "error")
+	log.Printf("AUDIT admin %s %s failed: %v - Remote: %s", r.Method, r.URL.Path, err, r.RemoteAddr)
+	writeErrorResponse(w, r, problem.InternalError, "Failed to apply the change")
+}
+
+// exportHandler returns the current config in the config file format,
+// secrets included, so it can replace config-files/<env>.json
+func (a *adminAPI) exportHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.MarshalIndent(a.gateway.Config(), "", "  ")
+	if err != nil {
+		log.Printf("Failed to export config: %v", err)
+		writeErrorResponse(w, r, problem.InternalError, "Failed to encode the config")
+		return
+	}
+	log.Printf("AUDIT admin config revision %d exported - Remote: %s", a.gateway.Revision(), r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, config.GetEnvironment()))
+	w.Header().Set("ETag", etag(a.gateway.Revision()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(data, '\n'))
+}
+
+// revisionsHandler lists the stored revisions, newest first
+func (a *adminAPI) revisionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{
+		"current":   a.gateway.Revision(),
+		"revisions": a.gateway.Revisions().List(),
+	})
+}
+
+// revisionHandler returns a stored revision with its secrets redacted
+func (a *adminAPI) revisionHandler(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.PathValue("revision"))
+	if err != nil {
+		writeErrorResponse(w, r, problem.RevisionNotFound, fmt.Sprintf("Revision '%s' is not stored", r.PathValue("revision")))
+		return
+	}
+	revision, err := a.gateway.Revisions().Get(number)
+	if err != nil {
+		writeErrorResponse(w, r, problem.RevisionNotFound, fmt.Sprintf("Revision %d is not stored", number))
+		return
+	}
+	redacted, ok := redactedConfig(w, r, revision.Config)
+	if !ok {
+		return
+	}
+	revision.Config = redacted
+	w.Header().Set("ETag", etag(revision.Number))
+	writeJSONResponse(w, r, http.StatusOK, revision)
+}
+
+// rollbackHandler applies the config of a stored revision as a new one
+func (a *adminAPI) rollbackHandler(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.PathValue("revision"))
+	if err != nil {
+		writeErrorResponse(w, r, problem.RevisionNotFound, fmt.Sprintf("Revision '%s' is not stored", r.PathValue("revision")))
+		return
+	}
+	expected, err := expectedRevision(r)
+	if err != nil {
+		writeErrorResponse(w, r, problem.InvalidRequest, err.Error())
+		return
+	}
+	revision, err := a.gateway.Rollback(number, expected, r.RemoteAddr)
+	if err != nil {
+		a.writeChangeError(w, r, "rollback", err)
+		return
+	}
+	adminActions.Inc("rollback", "ok")
+	log.Printf("AUDIT admin config rolled back to revision %d as revision %d - Remote: %s", number, revision.Number, r.RemoteAddr)
+	w.Header().Set("ETag", etag(revision.Number))
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{"revision": revision.Summary()})
+}
+
+// serviceFromConfig returns a known service as a resource
+func serviceFromConfig(appConfig config.AppConfig, serviceName string) (serviceResource, bool) {
+	upstreamURL, ok := appConfig.KnownServices[serviceName]
+	if !ok {
+		return serviceResource{}, false
+	}
+	return serviceResource{Name: serviceName, Upstream: upstreamURL, ServiceConfig: appConfig.Service(serviceName)}, true
+}
+
+// listServicesHandler returns every known service, ordered by name
+func (a *adminAPI) listServicesHandler(w http.ResponseWriter, r *http.Request) {
+	appConfig, ok := redactedConfig(w, r, a.gateway.Config())
+	if !ok {
+		return
+	}
+	services := make([]serviceResource, 0, len(appConfig.KnownServices))
+	for serviceName := range appConfig.KnownServices {
+		service, _ := serviceFromConfig(appConfig, serviceName)
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	w.Header().Set("ETag", etag(a.gateway.Revision()))
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{"services": services})
+}
+
+// getServiceHandler returns one known service
+func (a *adminAPI) getServiceHandler(w http.ResponseWriter, r *http.Request) {
+	appConfig, ok := redactedConfig(w, r, a.gateway.Config())
+	if !ok {
+		return
+	}
+	service, ok := serviceFromConfig(appConfig, r.PathValue("service"))
+	if !ok {
+		writeErrorResponse(w, r, problem.ServiceNotFound, fmt.Sprintf("Unknown service '%s'", r.PathValue("service")))
+		return
+	}
+	w.Header().Set("ETag", etag(a.gateway.Revision()))
+	writeJSONResponse(w, r, http.StatusOK, service)
+}
+
+// putServiceHandler creates a service or replaces all of its settings
+func (a *adminAPI) putServiceHandler(w http.ResponseWriter, r *http.Request) {
+	serviceName := r.PathValue("service")
+	var service serviceResource
+	if !decodeAdminResource(w, r, &service) {
+		return
+	}
+	if service.Upstream == "" {
+		writeErrorResponse(w, r, problem.InvalidBody, "A service needs an 'upstream' URL")
+		return
+	}
+	service.Name = serviceName
+
+	created := false
+	revision, ok := a.change(w, r, "put_service", func(appConfig *config.AppConfig) error {
+		if _, aggregate := appConfig.Aggregates[serviceName]; aggregate {
+			return refuse(problem.ResourceExists, "'%s' is an aggregate", serviceName)
+		}
+		_, exists := appConfig.KnownServices[serviceName]
+		created = !exists
+		if appConfig.KnownServices == nil {
+			appConfig.KnownServices = map[string]string{}
+		}
+		if appConfig.Services == nil {
+			appConfig.Services = map[string]config.ServiceConfig{}
+		}
+		appConfig.KnownServices[serviceName] = service.Upstream
+		appConfig.Services[serviceName] = service.ServiceConfig
+		return nil
+	})
+	if !ok {
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	a.writeService(w, r, status, revision)
+}
+
+// deleteServiceHandler removes a service and its settings
+func (a *adminAPI) deleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	serviceName := r.PathValue("service")
+	revision, ok := a.change(w, r, "delete_service", func(appConfig *config.AppConfig) error {
+		if _, ok := appConfig.KnownServices[serviceName]; !ok {
+			return refuse(problem.ServiceNotFound, "Unknown service '%s'", serviceName)
+		}
+		delete(appConfig.KnownServices, serviceName)
+		delete(appConfig.Services, serviceName)
+		return nil
+	})
+	if !ok {
+		return
+	}
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{"revision": revision.Summary()})
+}
+
+// putUpstreamHandler replaces the known_services URL of a service
+func (a *adminAPI) putUpstreamHandler(w http.ResponseWriter, r *http.Request) {
+	serviceName := r.PathValue("service")
+	var target upstreamTarget
+	if !decodeAdminBody(w, r, &target) {
+		return
+	}
+	revision, ok := a.change(w, r, "put_upstream", func(appConfig *config.AppConfig) error {
+		if _, ok := appConfig.KnownServices[serviceName]; !ok {
+			return refuse(problem.ServiceNotFound, "Unknown service '%s'", serviceName)
+		}
+		appConfig.KnownServices[serviceName] = target.URL
+		return nil
+	})
+	if !ok {
+		return
+	}
+	a.writeService(w, r, http.StatusOK, revision)
+}
+
+// editVersion applies edit to a version of a service
+func editVersion(appConfig *config.AppConfig, serviceName, versionName string, edit func(*config.VersionConfig) error) error {
+	if _, ok := appConfig.KnownServices[serviceName]; !ok {
+		return refuse(problem.ServiceNotFound, "Unknown service '%s'", serviceName)
+	}
+	serviceConfig := appConfig.Service(serviceName)
+	for i := range serviceConfig.Versions {
+		if serviceConfig.Versions[i].Name == versionName {
+			if err := edit(&serviceConfig.Versions[i]); err != nil {
+				return err
+			}
+			appConfig.Services[serviceName] = serviceConfig
+			return nil
+		}
+	}
+	return refuse(problem.UpstreamNotFound, "Service '%s' has no version '%s'", serviceName, versionName)
+}
+
+// addVersionUpstreamHandler adds an upstream target to a version
+func (a *adminAPI) addVersionUpstreamHandler(w http.ResponseWriter, r *http.Request) {
+	serviceName, versionName := r.PathValue("service"), r.PathValue("version")
+	var target upstreamTarget
+	if !decodeAdminBody(w, r, &target) {
+		return
+	}
+	revision, ok := a.change(w, r, "add_upstream", func(appConfig *config.AppConfig) error {
+		return editVersion(appConfig, serviceName, versionName, func(version *config.VersionConfig) error {
+			if slices.Contains(version.Upstreams, target.URL) {
+				return refuse(problem.ResourceExists, "'%s' is already an upstream of version '%s'", target.URL, versionName)
+			}
+			version.Upstreams = append(version.Upstreams, target.URL)
+			return nil
+		})
+	})
+	if !ok {
+		return
+	}
+	a.writeService(w, r, http.StatusOK, revision)
+}
+
+// removeVersionUpstreamHandler removes the upstream target given by the
+// url query parameter from a version
+func (a *adminAPI) removeVersionUpstreamHandler(w http.ResponseWriter, r *http.Request) {
+	serviceName, versionName := r.PathValue("service"), r.PathValue("version")
+	targetURL := r.URL.Query().Get("url")
+	revision, ok := a.change(w, r, "remove_upstream", func(appConfig *config.AppConfig) error {
+		return editVersion(appConfig, serviceName, versionName, func(version *config.VersionConfig) error {
+			i := slices.Index(version.Upstreams, targetURL)
+			if i < 0 {
+				return refuse(problem.UpstreamNotFound, "'%s' is not an upstream of version '%s'", targetURL, versionName)
+			}
+			version.Upstreams = slices.Delete(version.Upstreams, i, i+1)
+			return nil
+		})
+	})
+	if !ok {
+		return
+	}
+	a.writeService(w, r, http.StatusOK, revision)
+}
+
+// writeService answers a change to a service with the service as it is
+// in the new revision
+func (a *adminAPI) writeService(w http.ResponseWriter, r *http.Request, status int, revision configstore.Revision) {
+	appConfig, ok := redactedConfig(w, r, revision.Config)
+	if !ok {
+		return
+	}
+	service, _ := serviceFromConfig(appConfig, r.PathValue("service"))
+	writeJSONResponse(w, r, status, map[string]interface{}{
+		"revision": revision.Summary(),
+		"service":  service,
+	})
+}
+
+// listRoutesHandler returns the routes of a service in match order
+func (a *adminAPI) listRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	serviceName := r.PathValue("service")
+	appConfig, ok := redactedConfig(w, r, a.gateway.Config())
+	if !ok {
+		return
+	}
+	if _, ok := appConfig.KnownServices[serviceName]; !ok {
+		writeErrorResponse(w, r, problem.ServiceNotFound, fmt.Sprintf("Unknown service '%s'", serviceName))
+		return
+	}
+	routes := []routeResource{}
+	for _, route := range appConfig.Service(serviceName).Routes {
+		routes = append(routes, routeResource{Service: serviceName, RouteConfig: route})
+	}
+	w.Header().Set("ETag", etag(a.gateway.Revision()))
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{"routes": routes})
+}
+
+// putRouteHandler creates or replaces a named route of a service. New
+// routes are added after the existing ones.
+func (a *adminAPI) putRouteHandler(w http.ResponseWriter, r *http.Request) {
+	serviceName, routeName := r.PathValue("service"), r.PathValue("route")
+	var route config.RouteConfig
+	if !decodeAdminResource(w, r, &route) {
+		return
+	}
+	route.Name = routeName
+
+	created := false
+	revision, ok := a.change(w, r, "put_route", func(appConfig *config.AppConfig) error {
+		if _, ok := appConfig.KnownServices[serviceName]; !ok {
+			return refuse(problem.ServiceNotFound, "Unknown service '%s'", serviceName)
+		}
+		serviceConfig := appConfig.Service(serviceName)
+		i := slices.IndexFunc(serviceConfig.Routes, func(existing config.RouteConfig) bool { return existing.Name == routeName })
+		if i < 0 {
+			created = true
+			serviceConfig.Routes = append(serviceConfig.Routes, route)
+		} else {
+			serviceConfig.Routes[i] = route
+		}
+		if appConfig.Services == nil {
+			appConfig.Services = map[string]config.ServiceConfig{}
+		}
+		appConfig.Services[serviceName] = serviceConfig
+		return nil
+	})
+	if !ok {
+		return
+	}
+	appConfig, ok := redactedConfig(w, r, revision.Config)
+	if !ok {
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	routes := appConfig.Service(serviceName).Routes
+	i := slices.IndexFunc(routes, func(existing config.RouteConfig) bool { return existing.Name == routeName })
+	writeJSONResponse(w, r, status, map[string]interface{}{
+		"revision": revision.Summary(),
+		"route":    routeResource{Service: serviceName, RouteConfig: routes[i]},
+	})
+}
+
+// deleteRouteHandler removes a named route of a service
+func (a *adminAPI) deleteRouteHandler(w http.ResponseWriter, r *http.Request) {
+	serviceName, routeName := r.PathValue("service"), r.PathValue("route")
+	revision, ok := a.change(w, r, "delete_route", func(appConfig *config.AppConfig) error {
+		if _, ok := appConfig.KnownServices[serviceName]; !ok {
+			return refuse(problem.ServiceNotFound, "Unknown service '%s'", serviceName)
+		}
+		serviceConfig := appConfig.Service(serviceName)
+		i := slices.IndexFunc(serviceConfig.Routes, func(existing config.RouteConfig) bool { return existing.Name == routeName })
+		if i < 0 {
+			return refuse(problem.RouteNotFound, "Service '%s' has no route '%s'", serviceName, routeName)
+		}
+		serviceConfig.Routes = slices.Delete(serviceConfig.Routes, i, i+1)
+		appConfig.Services[serviceName] = serviceConfig
+		return nil
+	})
+	if !ok {
+		return
+	}
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{"revision": revision.Summary()})
+}
+
+// listConsumersHandler returns the consumers with their API keys redacted
+func (a *adminAPI) listConsumersHandler(w http.ResponseWriter, r *http.Request) {
+	appConfig, ok := redactedConfig(w, r, a.gateway.Config())
+	if !ok {
+		return
+	}
+	consumers := appConfig.Consumers
+	if consumers == nil {
+		consumers = []config.ConsumerConfig{}
+	}
+	w.Header().Set("ETag", etag(a.gateway.Revision()))
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{"consumers": consumers})
+}
+
+// putConsumerHandler creates a consumer or replaces its API key
+func (a *adminAPI) putConsumerHandler(w http.ResponseWriter, r *http.Request) {
+	consumerID := r.PathValue("consumer")
+	var consumer config.ConsumerConfig
+	if !decodeAdminResource(w, r, &consumer) {
+		return
+	}
+	consumer.ID = consumerID
+
+	created := false
+	revision, ok := a.change(w, r, "put_consumer", func(appConfig *config.AppConfig) error {
+		i := slices.IndexFunc(appConfig.Consumers, func(existing config.ConsumerConfig) bool { return existing.ID == consumerID })
+		if i < 0 {
+			created = true
+			appConfig.Consumers = append(appConfig.Consumers, consumer)
+		} else {
+			appConfig.Consumers[i] = consumer
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSONResponse(w, r, status, map[string]interface{}{
+		"revision": revision.Summary(),
+		"consumer": config.ConsumerConfig{ID: consumerID, APIKey: config.RedactedValue},
+	})
+}
+
+// deleteConsumerHandler removes a consumer, revoking its API key
+func (a *adminAPI) deleteConsumerHandler(w http.ResponseWriter, r *http.Request) {
+	consumerID := r.PathValue("consumer")
+	revision, ok := a.change(w, r, "delete_consumer", func(appConfig *config.AppConfig) error {
+		i := slices.IndexFunc(appConfig.Consumers, func(existing config.ConsumerConfig) bool { return existing.ID == consumerID })
+		if i < 0 {
+			return refuse(problem.ConsumerNotFound, "Unknown consumer '%s'", consumerID)
+		}
+		appConfig.Consumers = slices.Delete(appConfig.Consumers, i, i+1)
+		return nil
+	})
+	if !ok {
+		return
+	}
+	writeJSONResponse(w, r, http.StatusOK, map[string]interface{}{"revision": revision.Summary()})
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+)
+
+// conditionalRequest is adminRequest with an If-Match header
+func conditionalRequest(t *testing.T, admin http.Handler, method, path, body, ifMatch string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	req.Header.Set("If-Match", ifMatch)
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminServices(t *testing.T) {
+	gateway, admin, backendURL := newAdminTestGateway(t)
+
+	w := adminRequest(t, admin, http.MethodPut, "/services/orders", `{
+		"upstream": "`+backendURL+`",
+		"versions": [{"name": "stable", "weight": 1, "upstreams": ["`+backendURL+`"]}]
+	}`)
+	if w.Code != http.StatusCreated || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("expected the service to be created as revision 2, got %d %q: %s", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+	if w := clientRequest(gateway, "/api/orders/orders"); w.Code != http.StatusOK {
+		t.Errorf("expected the new service to be routed without a restart, got %d", w.Code)
+	}
+
+	w = adminRequest(t, admin, http.MethodPost, "/services/orders/versions/stable/upstreams", `{"url":"http://10.0.0.1:8081"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the upstream to be added, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := gateway.Controls().Upstreams().Status("http://10.0.0.1:8081"); !ok {
+		t.Error("expected the added upstream to be tracked")
+	}
+	if w := adminRequest(t, admin, http.MethodPost, "/services/orders/versions/stable/upstreams", `{"url":"http://10.0.0.1:8081"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for an upstream added twice, got %d", w.Code)
+	}
+	if w := adminRequest(t, admin, http.MethodDelete, "/services/orders/versions/stable/upstreams?url=http://10.0.0.1:8081", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the upstream to be removed, got %d", w.Code)
+	}
+	if w := adminRequest(t, admin, http.MethodPost, "/services/orders/versions/canary/upstreams", `{"url":"http://10.0.0.1:8081"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown version, got %d", w.Code)
+	}
+
+	// Changes are validated like the config files
+	revision := gateway.Revision()
+	w = adminRequest(t, admin, http.MethodPut, "/services/orders/upstream", `{"url":"orders:8080"}`)
+	if w.Code != http.StatusUnprocessableEntity || problemCode(t, w) != problem.ConfigRejected.Code {
+		t.Errorf("expected an invalid upstream to be rejected, got %d", w.Code)
+	}
+	if gateway.Revision() != revision || gateway.Config().KnownServices["orders"] != backendURL {
+		t.Error("expected a rejected change to leave the config alone")
+	}
+
+	// Redacted values read from the API cannot be written back
+	redacted := adminRequest(t, admin, http.MethodGet, "/services/users", "").Body.String()
+	if w := adminRequest(t, admin, http.MethodPut, "/services/users", redacted); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a body with redacted values to be refused, got %d", w.Code)
+	}
+
+	if w := adminRequest(t, admin, http.MethodDelete, "/services/orders", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the service to be deleted, got %d", w.Code)
+	}
+	if w := clientRequest(gateway, "/api/orders/orders"); w.Code != http.StatusNotFound {
+		t.Errorf("expected a deleted service to be gone, got %d", w.Code)
+	}
+	if w := adminRequest(t, admin, http.MethodGet, "/services/orders", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted service, got %d", w.Code)
+	}
+}
+
+func TestAdminRoutesAndConsumers(t *testing.T) {
+	gateway, admin, _ := newAdminTestGateway(t)
+
+	if w := adminRequest(t, admin, http.MethodPut, "/services/users/routes/profile", `{"path_prefix":"/profile","methods":["GET"]}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected the route to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := adminRequest(t, admin, http.MethodPut, "/services/users/routes/profile", `{"path_prefix":"/me"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the route to be replaced, got %d", w.Code)
+	}
+	routes := gateway.Config().Service("users").Routes
+	if len(routes) != 2 || routes[1].Name != "profile" || routes[1].PathPrefix != "/me" {
+		t.Errorf("unexpected routes %+v", routes)
+	}
+	if w := adminRequest(t, admin, http.MethodDelete, "/services/users/routes/profile", ""); w.Code != http.StatusOK || len(gateway.Config().Service("users").Routes) != 1 {
+		t.Errorf("expected the route to be deleted, got %d", w.Code)
+	}
+	if w := adminRequest(t, admin, http.MethodDelete, "/services/users/routes/profile", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown route, got %d", w.Code)
+	}
+
+	if w := adminRequest(t, admin, http.MethodPut, "/consumers/partner", `{"api_key":"partner-secret-key"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected the consumer to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	if consumer, ok := gateway.Config().ConsumerForKey("partner-secret-key"); !ok || consumer.ID != "partner" {
+		t.Errorf("expected the new API key to belong to the consumer, got %+v", consumer)
+	}
+	if w := adminRequest(t, admin, http.MethodPut, "/consumers/reseller", `{"api_key":"partner-secret-key"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a shared API key to be rejected, got %d", w.Code)
+	}
+	if body := adminRequest(t, admin, http.MethodGet, "/consumers", "").Body.String(); strings.Contains(body, "secret") {
+		t.Errorf("expected API keys to be redacted: %s", body)
+	}
+	if w := adminRequest(t, admin, http.MethodDelete, "/consumers/partner", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the consumer to be deleted, got %d", w.Code)
+	}
+	if _, ok := gateway.Config().ConsumerForKey("partner-secret-key"); ok {
+		t.Error("expected the API key to be revoked")
+	}
+}
+
+func TestAdminRevisions(t *testing.T) {
+	gateway, admin, backendURL := newAdminTestGateway(t)
+
+	adminRequest(t, admin, http.MethodPut, "/services/auth", `{"upstream":"`+backendURL+`"}`)
+	if w := conditionalRequest(t, admin, http.MethodDelete, "/services/auth", "", `"1"`); w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != `"2"` {
+		t.Errorf("expected a change based on a stale revision to be refused, got %d", w.Code)
+	}
+	if w := conditionalRequest(t, admin, http.MethodDelete, "/services/auth", "", `"2"`); w.Code != http.StatusOK {
+		t.Fatalf("expected a change based on the current revision to apply, got %d", w.Code)
+	}
+
+	var history struct {
+		Current   int `json:"current"`
+		Revisions []struct {
+			Number int    `json:"revision"`
+			Change string `json:"change"`
+		} `json:"revisions"`
+	}
+	json.Unmarshal(adminRequest(t, admin, http.MethodGet, "/revisions", "").Body.Bytes(), &history)
+	if history.Current != 3 || len(history.Revisions) != 3 || history.Revisions[0].Change != "DELETE /services/auth" {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	if w := adminRequest(t, admin, http.MethodPost, "/revisions/2/rollback", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the rollback to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if gateway.Revision() != 4 || !gateway.Config().HasService("auth") {
+		t.Errorf("expected revision 2 to be restored as revision 4, got revision %d", gateway.Revision())
+	}
+	if w := adminRequest(t, admin, http.MethodPost, "/revisions/40/rollback", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown revision, got %d", w.Code)
+	}
+	if body := adminRequest(t, admin, http.MethodGet, "/revisions/1", "").Body.String(); strings.Contains(body, "client-secret-key") {
+		t.Errorf("expected stored revisions to be redacted: %s", body)
+	}
+
+	w := adminRequest(t, admin, http.MethodGet, "/config/export", "")
+	var exported config.AppConfig
+	if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil {
+		t.Fatalf("expected the export to be a config file: %v", err)
+	}
+	if exported.AllowedApiKey != "client-secret-key" || exported.KnownServices["auth"] != backendURL {
+		t.Errorf("expected the export to be the complete current config, got %+v", exported)
+	}
+}
+
+func TestAdminRevisionsPersist(t *testing.T) {
+	storeDir := t.TempDir()
+	_, admin, backendURL := newStoredAdminTestGateway(t, storeDir)
+	adminRequest(t, admin, http.MethodPut, "/services/auth", `{"upstream":"`+backendURL+`"}`)
+
+	restarted, admin, _ := newStoredAdminTestGateway(t, storeDir)
+	if restarted.Revision() != 2 || restarted.Config().KnownServices["auth"] != backendURL {
+		t.Fatalf("expected the stored revision to be used after a restart, got revision %d", restarted.Revision())
+	}
+	if w := adminRequest(t, admin, http.MethodDelete, "/services/auth", ""); w.Header().Get("ETag") != `"3"` {
+		t.Errorf("expected revision numbers to continue after a restart, got %q", w.Header().Get("ETag"))
+	}
+}
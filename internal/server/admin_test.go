@@ -0,0 +1,315 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/cache"
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/configstore"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/upstream"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+const testAdminToken = "admin-test-token"
+
+// newAdminTestGateway starts a gateway for one backend, whose /slow path
+// answers only once the request is cancelled, and returns it with its
+// admin API. Config revisions are kept in memory.
+func newAdminTestGateway(t *testing.T) (*Gateway, http.Handler, string) {
+	return newStoredAdminTestGateway(t, "")
+}
+
+// newStoredAdminTestGateway is newAdminTestGateway with config revisions
+// stored in storeDir
+func newStoredAdminTestGateway(t *testing.T, storeDir string) (*Gateway, http.Handler, string) {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+
+	appConfig := config.AppConfig{
+		AllowedApiKey: "client-secret-key",
+		KnownServices: map[string]string{"users": backend.URL},
+		Consumers:     []config.ConsumerConfig{{ID: "mobile", APIKey: "mobile-secret-key"}},
+		Admin:         config.AdminConfig{Enabled: true, Token: testAdminToken},
+		Services: map[string]config.ServiceConfig{"users": {
+			Headers: &config.HeaderRules{Request: config.HeaderOperations{Set: map[string]string{
+				"Authorization": "Bearer upstream-secret",
+				"X-Gateway":     "api-gateway",
+			}}},
+			Routes: []config.RouteConfig{{Name: "avatars", PathPrefix: "/avatars"}},
+		}},
+	}
+	controls := NewControls(upstream.NewRegistry())
+	revisions, err := configstore.Open(storeDir, 0)
+	if err != nil {
+		t.Fatalf("failed to open config store: %v", err)
+	}
+	gateway, err := NewGateway(appConfig, func(appConfig config.AppConfig) (usecase.RequestForwarder, error) {
+		return usecase.NewApiGatewayService(appConfig, controls.Upstreams()), nil
+	}, cache.NewStore(1<<20), controls, revisions)
+	if err != nil {
+		t.Fatalf("failed to build gateway: %v", err)
+	}
+	admin := NewAdminServer(gateway)
+	if admin == nil {
+		t.Fatal("expected an admin server")
+	}
+	return gateway, admin.Handler, backend.URL
+}
+
+func adminRequest(t *testing.T, admin http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	return w
+}
+
+func clientRequest(gateway http.Handler, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-Request-ID", "admin-test")
+	req.Header.Set("x-api-key", "client-secret-key")
+	w := httptest.NewRecorder()
+	gateway.ServeHTTP(w, req)
+	return w
+}
+
+func problemCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var details problem.Details
+	if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+		t.Fatalf("invalid problem details %q: %v", w.Body.String(), err)
+	}
+	return details.Code
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	_, admin, _ := newAdminTestGateway(t)
+
+	for _, authorization := range []string{"", "Bearer wrong", testAdminToken, "Bearer client-secret-key"} {
+		req := httptest.NewRequest(http.MethodGet, "/routes", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("expected 401 with a challenge for %q, got %d", authorization, w.Code)
+		}
+	}
+
+	if w := adminRequest(t, admin, http.MethodGet, "/routes", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the admin token to be accepted, got %d", w.Code)
+	}
+}
+
+func TestAdminIntrospection(t *testing.T) {
+	_, admin, backendURL := newAdminTestGateway(t)
+
+	w := adminRequest(t, admin, http.MethodGet, "/config", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	for _, secret := range []string{"client-secret-key", "mobile-secret-key", testAdminToken, "upstream-secret"} {
+		if strings.Contains(w.Body.String(), secret) {
+			t.Errorf("expected %q to be redacted: %s", secret, w.Body.String())
+		}
+	}
+	if !strings.Contains(w.Body.String(), `"X-Gateway":"api-gateway"`) {
+		t.Errorf("expected other header values to be shown: %s", w.Body.String())
+	}
+
+	var table routeTable
+	json.Unmarshal(adminRequest(t, admin, http.MethodGet, "/routes", "").Body.Bytes(), &table)
+	if len(table.Services) != 1 || table.Services[0].Upstream != backendURL || len(table.Services[0].Routes) != 1 {
+		t.Errorf("unexpected route table %+v", table)
+	}
+
+	var upstreams struct {
+		Upstreams []upstream.Status `json:"upstreams"`
+	}
+	json.Unmarshal(adminRequest(t, admin, http.MethodGet, "/upstreams", "").Body.Bytes(), &upstreams)
+	if len(upstreams.Upstreams) != 1 || upstreams.Upstreams[0].URL != backendURL || upstreams.Upstreams[0].State != upstream.Active {
+		t.Errorf("unexpected upstreams %+v", upstreams)
+	}
+}
+
+func TestAdminUpstreamActions(t *testing.T) {
+	gateway, admin, backendURL := newAdminTestGateway(t)
+	target := `{"url":"` + backendURL + `"}`
+
+	if w := adminRequest(t, admin, http.MethodPost, "/upstreams/drain", target); w.Code != http.StatusOK {
+		t.Fatalf("expected drain to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	w := clientRequest(gateway, "/api/users/users")
+	if w.Code != http.StatusServiceUnavailable || problemCode(t, w) != problem.UpstreamUnavailable.Code {
+		t.Errorf("expected a drained upstream to get no requests, got %d", w.Code)
+	}
+
+	adminRequest(t, admin, http.MethodPost, "/upstreams/enable", target)
+	if w := clientRequest(gateway, "/api/users/users"); w.Code != http.StatusOK {
+		t.Errorf("expected an enabled upstream to get requests, got %d", w.Code)
+	}
+
+	// Disabling cuts off the requests in flight
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() { inFlight <- clientRequest(gateway, "/api/users/slow") }()
+	deadline := time.Now().Add(2 * time.Second)
+	for status, _ := gateway.Controls().Upstreams().Status(backendURL); status.InFlight == 0; status, _ = gateway.Controls().Upstreams().Status(backendURL) {
+		if time.Now().After(deadline) {
+			t.Fatal("request never reached the upstream")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	adminRequest(t, admin, http.MethodPost, "/upstreams/disable", target)
+	select {
+	case w := <-inFlight:
+		if w.Code != http.StatusServiceUnavailable || problemCode(t, w) != problem.UpstreamUnavailable.Code {
+			t.Errorf("expected the cut-off request to get 503, got %d: %s", w.Code, w.Body.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected disabling to cancel the request in flight")
+	}
+
+	if w := adminRequest(t, admin, http.MethodPost, "/upstreams/drain", `{"url":"http://unknown"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown upstream, got %d", w.Code)
+	}
+	if w := adminRequest(t, admin, http.MethodPost, "/upstreams/restart", target); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown action, got %d", w.Code)
+	}
+}
+
+func TestAdminCache(t *testing.T) {
+	gateway, admin, _ := newAdminTestGateway(t)
+	gateway.responseCache.Set("GET users/users", &cache.Entry{StatusCode: http.StatusOK, Body: []byte("{}")})
+
+	// Clients cannot reach the cache endpoints, even with their API key
+	req := httptest.NewRequest(http.MethodPost, "/cache/purge", strings.NewReader(`{"prefix":""}`))
+	req.Header.Set("X-Request-ID", "admin-test")
+	req.Header.Set("x-api-key", "client-secret-key")
+	w := httptest.NewRecorder()
+	gateway.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected no cache purge on the client listener, got %d", w.Code)
+	}
+	if w := clientRequest(gateway, "/cache/stats"); w.Code != http.StatusNotFound {
+		t.Errorf("expected no cache stats on the client listener, got %d", w.Code)
+	}
+
+	var stats cache.Stats
+	json.Unmarshal(adminRequest(t, admin, http.MethodGet, "/cache/stats", "").Body.Bytes(), &stats)
+	if stats.Entries != 1 {
+		t.Errorf("expected one cached entry, got %+v", stats)
+	}
+
+	w = adminRequest(t, admin, http.MethodPost, "/cache/purge", `{"prefix":"GET users/"}`)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"purged":1}` {
+		t.Errorf("expected one entry purged, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := adminRequest(t, admin, http.MethodPost, "/cache/purge", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a key or prefix, got %d", w.Code)
+	}
+}
+
+func TestAdminMetrics(t *testing.T) {
+	gateway, admin, _ := newAdminTestGateway(t)
+
+	// Clients cannot read the metrics, even with their API key
+	if w := clientRequest(gateway, "/metrics"); w.Code != http.StatusNotFound {
+		t.Errorf("expected no metrics on the client listener, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected metrics to require the admin token, got %d", w.Code)
+	}
+
+	w = adminRequest(t, admin, http.MethodGet, "/metrics", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "gateway_admin_actions_total") {
+		t.Errorf("expected the metrics on the admin listener, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminMaintenance(t *testing.T) {
+	gateway, admin, _ := newAdminTestGateway(t)
+
+	if w := adminRequest(t, admin, http.MethodPut, "/services/users/maintenance", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected maintenance mode to be set, got %d", w.Code)
+	}
+	w := clientRequest(gateway, "/api/users/users")
+	if w.Code != http.StatusServiceUnavailable || problemCode(t, w) != problem.ServiceMaintenance.Code {
+		t.Errorf("expected 503 during maintenance, got %d", w.Code)
+	}
+	if !strings.Contains(adminRequest(t, admin, http.MethodGet, "/maintenance", "").Body.String(), `"service":"users"`) {
+		t.Error("expected the service to be listed in maintenance")
+	}
+
+	adminRequest(t, admin, http.MethodDelete, "/services/users/maintenance", "")
+	if w := clientRequest(gateway, "/api/users/users"); w.Code != http.StatusOK {
+		t.Errorf("expected the service back after maintenance, got %d", w.Code)
+	}
+
+	if w := adminRequest(t, admin, http.MethodPut, "/services/unknown/maintenance", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown service, got %d", w.Code)
+	}
+
+	if w := adminRequest(t, admin, http.MethodPut, "/maintenance", `{"duration":"10m"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the gateway to be put in maintenance, got %d: %s", w.Code, w.Body.String())
+	}
+	w = clientRequest(gateway, "/api/users/users")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After during gateway maintenance, got %d", w.Code)
+	}
+	if !strings.Contains(adminRequest(t, admin, http.MethodGet, "/maintenance", "").Body.String(), `"gateway":{"since"`) {
+		t.Error("expected the gateway to be listed in maintenance")
+	}
+	adminRequest(t, admin, http.MethodDelete, "/maintenance", "")
+	if w := clientRequest(gateway, "/api/users/users"); w.Code != http.StatusOK {
+		t.Errorf("expected the gateway back after maintenance, got %d", w.Code)
+	}
+	if w := adminRequest(t, admin, http.MethodPut, "/maintenance", `{"until":"2000-01-01T00:00:00Z"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a maintenance ending in the past to be refused, got %d", w.Code)
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	gateway, admin, backendURL := newAdminTestGateway(t)
+	gateway.Controls().SetMaintenance("users", time.Time{})
+
+	gateway.load = func() (config.AppConfig, error) {
+		return config.AppConfig{}, errors.New("failed to parse config file")
+	}
+	w := adminRequest(t, admin, http.MethodPost, "/reload", "")
+	if w.Code != http.StatusUnprocessableEntity || problemCode(t, w) != problem.ConfigRejected.Code {
+		t.Errorf("expected a rejected reload, got %d", w.Code)
+	}
+
+	reloaded := gateway.Config()
+	reloaded.KnownServices = map[string]string{"users": backendURL, "auth": backendURL}
+	gateway.load = func() (config.AppConfig, error) { return reloaded, nil }
+	if w := adminRequest(t, admin, http.MethodPost, "/reload", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the reload to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := clientRequest(gateway, "/api/auth/login"); w.Code != http.StatusOK {
+		t.Errorf("expected the reloaded service to be routed, got %d", w.Code)
+	}
+	if w := clientRequest(gateway, "/api/users/users"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected maintenance mode to survive the reload, got %d", w.Code)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+)
+
+// cachePurgeRequest selects the entries to remove. Keys have the form
+// "GET <service><path>?<query>"; a prefix of "GET <service>/" removes every
+// entry of a service and an empty prefix clears the cache.
+type cachePurgeRequest struct {
+	Key    *string `json:"key"`
+	Prefix *string `json:"prefix"`
+}
+
+// cachePurgeHandler removes entries from the response cache
+func (a *adminAPI) cachePurgeHandler(w http.ResponseWriter, r *http.Request) {
+	responseCache := a.gateway.responseCache
+	if responseCache == nil {
+		writeErrorResponse(w, r, problem.CacheDisabled, "Response cache is not enabled")
+		return
+	}
+
+	var purge cachePurgeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequestBytes)).Decode(&purge); err != nil {
+		writeErrorResponse(w, r, problem.InvalidBody, "Invalid purge request body")
+		return
+	}
+	if (purge.Key == nil) == (purge.Prefix == nil) {
+		writeErrorResponse(w, r, problem.InvalidRequest, "Exactly one of 'key' or 'prefix' is required")
+		return
+	}
+
+	purged := 0
+	if purge.Key != nil {
+		if responseCache.Delete(*purge.Key) {
+			purged = 1
+		}
+	} else {
+		purged = responseCache.DeletePrefix(*purge.Prefix)
+	}
+
+	adminActions.Inc("cache_purge", "ok")
+	log.Printf("AUDIT admin purged %d cached responses - Remote: %s", purged, r.RemoteAddr)
+	writeJSONResponse(w, r, http.StatusOK, map[string]int{"purged": purged})
+}
+
+// cacheStatsHandler reports the response cache's size and hit counters
+func (a *adminAPI) cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.gateway.responseCache == nil {
+		writeErrorResponse(w, r, problem.CacheDisabled, "Response cache is not enabled")
+		return
+	}
+	writeJSONResponse(w, r, http.StatusOK, a.gateway.responseCache.Stats())
+}
+
+func writeJSONResponse(w http.ResponseWriter, r *http.Request, statusCode int, body interface{}) {
+	jsonResp, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Failed to marshal response: %v", err)
+		writeErrorResponse(w, r, problem.InternalError, "Failed to encode response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(jsonResp); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
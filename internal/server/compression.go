@@ -0,0 +1,223 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/LucianoBarrera/api-gateway/internal/compression"
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/grpcstatus"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+)
+
+var responseCompressions = metrics.NewCounter("gateway_response_compressions_total",
+	"Responses compressed for clients, by encoding", "encoding")
+
+// compressionMiddleware compresses responses for clients that accept one of
+// the configured encodings. Upgraded connections and gRPC calls are left
+// alone, as are responses the upstream already encoded.
+func (s *Server) compressionMiddleware(next http.Handler) http.Handler {
+	cfg := s.appConfig.Compression
+	if !cfg.Enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" || grpcstatus.IsGRPCRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			cfg:            cfg,
+			streaming:      s.appConfig.Streaming,
+			coding:         compression.Negotiate(r.Header.Get("Accept-Encoding"), cfg.Offered()),
+			head:           r.Method == http.MethodHead,
+		}
+		defer cw.finish()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter decides whether to compress once the response headers are
+// known. Bodies of unknown length are held back until they reach the
+// minimum size, so small responses are still sent as they are.
+type compressWriter struct {
+	http.ResponseWriter
+	cfg       config.CompressionConfig
+	streaming config.StreamingConfig
+	coding    string
+	head      bool
+
+	status      int
+	wroteHeader bool
+	pending     bool // holding back the body until it is large enough
+	buf         []byte
+	encoder     compression.Writer
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	// Informational responses go out as they are, ahead of the final one
+	if statusCode < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = statusCode
+
+	if !cw.compressible() {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	// Caches must keep the encoded and plain variants apart, whichever one
+	// this client gets
+	addVary(cw.Header(), "Accept-Encoding")
+	if cw.coding == "" || cw.head {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	if length, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil {
+		if length < cw.cfg.MinSize() {
+			cw.ResponseWriter.WriteHeader(statusCode)
+			return
+		}
+		cw.start()
+		return
+	}
+	cw.pending = true
+}
+
+// compressible reports whether the response may be encoded at all
+func (cw *compressWriter) compressible() bool {
+	switch cw.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	return cw.cfg.IsCompressibleContentType(contentType) && !cw.streaming.IsStreamingContentType(contentType)
+}
+
+// start commits to compressing the response and sends its headers
+func (cw *compressWriter) start() {
+	cw.pending = false
+	encoder, err := compression.NewWriter(cw.coding, cw.ResponseWriter)
+	if err != nil {
+		log.Printf("Failed to create %s encoder: %v", cw.coding, err)
+		cw.ResponseWriter.WriteHeader(cw.status)
+		return
+	}
+	cw.encoder = encoder
+	responseCompressions.Inc(cw.coding)
+
+	header := cw.Header()
+	header.Set("Content-Encoding", cw.coding)
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	// The encoded bytes differ from the ones a strong ETag promised
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.pending {
+		cw.buf = append(cw.buf, data...)
+		if len(cw.buf) < cw.cfg.MinSize() {
+			return len(data), nil
+		}
+		cw.start()
+		buffered := cw.buf
+		cw.buf = nil
+		if _, err := cw.write(buffered); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	return cw.write(data)
+}
+
+func (cw *compressWriter) write(data []byte) (int, error) {
+	if cw.encoder != nil {
+		return cw.encoder.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher. A body still below the minimum size stays
+// held back: ReverseProxy flushes after every write of a response without
+// Content-Length, so flushing says nothing about the response's size.
+// Streaming content types are never held back in the first place.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.pending {
+		return
+	}
+	if cw.encoder != nil {
+		if err := cw.encoder.Flush(); err != nil {
+			log.Printf("Failed to flush %s encoder: %v", cw.coding, err)
+			return
+		}
+	}
+	if err := http.NewResponseController(cw.ResponseWriter).Flush(); err != nil {
+		log.Printf("Failed to flush response: %v", err)
+	}
+}
+
+// finish sends a held back body that stayed below the minimum size as it
+// is, or ends the compressed stream
+func (cw *compressWriter) finish() {
+	if cw.pending {
+		cw.pending = false
+		cw.Header().Set("Content-Length", strconv.Itoa(len(cw.buf)))
+		cw.ResponseWriter.WriteHeader(cw.status)
+		if _, err := cw.ResponseWriter.Write(cw.buf); err != nil {
+			log.Printf("Failed to write response: %v", err)
+		}
+		cw.buf = nil
+		return
+	}
+	if cw.encoder != nil {
+		if err := cw.encoder.Close(); err != nil {
+			log.Printf("Failed to finish %s response: %v", cw.coding, err)
+		}
+		cw.encoder = nil
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// addVary adds a header name to Vary unless it is already listed
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/LucianoBarrera/api-gateway/internal/compression"
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+func compressedHandler(cfg config.CompressionConfig, handler http.HandlerFunc) http.Handler {
+	s := &Server{appConfig: config.AppConfig{Compression: cfg}}
+	return s.compressionMiddleware(handler)
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	large := strings.Repeat(`{"name":"Ada"},`, 200)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		setLength      bool
+		header         http.Header
+		status         int
+		expectEncoding string
+		expectVary     bool
+	}{
+		{name: "gzip", acceptEncoding: "gzip", contentType: "application/json", body: large, expectEncoding: "gzip", expectVary: true},
+		{name: "brotli preferred", acceptEncoding: "gzip, br", contentType: "application/json", body: large, expectEncoding: "br", expectVary: true},
+		{name: "zstd with length", acceptEncoding: "zstd", contentType: "text/plain; charset=utf-8", body: large, setLength: true, expectEncoding: "zstd", expectVary: true},
+		{name: "suffix content type", acceptEncoding: "gzip", contentType: "application/problem+json", body: large, expectEncoding: "gzip", expectVary: true},
+		{name: "client accepts none", contentType: "application/json", body: large, expectVary: true},
+		{name: "below minimum size", acceptEncoding: "gzip", contentType: "application/json", body: `{"ok":true}`, expectVary: true},
+		{name: "below minimum declared size", acceptEncoding: "gzip", contentType: "application/json", body: `{"ok":true}`, setLength: true, expectVary: true},
+		{name: "content type not allowed", acceptEncoding: "gzip", contentType: "image/png", body: large},
+		{name: "streaming content type", acceptEncoding: "gzip", contentType: "text/event-stream", body: large},
+		{name: "already encoded", acceptEncoding: "gzip", contentType: "application/json", body: large, header: http.Header{"Content-Encoding": {"br"}}, expectEncoding: "br"},
+		{name: "no-transform", acceptEncoding: "gzip", contentType: "application/json", body: large, header: http.Header{"Cache-Control": {"no-transform"}}},
+		{name: "partial content", acceptEncoding: "gzip", contentType: "application/json", body: large, status: http.StatusPartialContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := compressedHandler(config.CompressionConfig{Enabled: true}, func(w http.ResponseWriter, r *http.Request) {
+				for name, values := range tt.header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Content-Type", tt.contentType)
+				if tt.setLength {
+					w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				// Written in two pieces, like a proxied body
+				half := len(tt.body) / 2
+				io.WriteString(w, tt.body[:half])
+				io.WriteString(w, tt.body[half:])
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if got := w.Header().Get("Content-Encoding"); got != tt.expectEncoding {
+				t.Fatalf("expected Content-Encoding %q, got %q", tt.expectEncoding, got)
+			}
+			if got := w.Header().Get("Vary") == "Accept-Encoding"; got != tt.expectVary {
+				t.Errorf("expected Vary: Accept-Encoding %v, got %q", tt.expectVary, w.Header().Get("Vary"))
+			}
+			if tt.expectEncoding == "" || tt.header != nil {
+				if w.Body.String() != tt.body {
+					t.Errorf("expected the body to be sent as is, got %q", w.Body.String())
+				}
+				return
+			}
+
+			if w.Header().Get("Content-Length") != "" {
+				t.Error("expected Content-Length to be removed from a compressed response")
+			}
+			r, err := compression.NewReader(tt.expectEncoding, w.Body)
+			if err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			decoded, err := io.ReadAll(r)
+			if err != nil || string(decoded) != tt.body {
+				t.Errorf("expected the response to decode to the original body, got %d bytes: %v", len(decoded), err)
+			}
+			if w.Body.Len() >= len(tt.body) {
+				t.Errorf("expected the response to shrink, got %d bytes", w.Body.Len())
+			}
+		})
+	}
+}
+
+func TestCompressionMiddlewareHeaders(t *testing.T) {
+	large := strings.Repeat("a", 4096)
+	handler := compressedHandler(config.CompressionConfig{Enabled: true, MinSizeBytes: 100}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Vary", "Origin")
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+		io.WriteString(w, large)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("ETag"); got != `W/"v1"` {
+		t.Errorf("expected the ETag to be weakened, got %q", got)
+	}
+	if got := w.Header().Values("Vary"); len(got) != 2 || got[1] != "Accept-Encoding" {
+		t.Errorf("expected Accept-Encoding to be added to Vary, got %q", got)
+	}
+	if w.Header().Get("Accept-Ranges") != "" {
+		t.Error("expected Accept-Ranges to be removed")
+	}
+}
+
+func TestCompressionMiddlewareFlushesEncodedData(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	first := `{"first":"` + strings.Repeat("a", config.DefaultCompressionMinSizeBytes) + `"}`
+	handler := compressedHandler(config.CompressionConfig{Enabled: true}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, first)
+		http.NewResponseController(w).Flush()
+
+		// What was flushed must already be decodable by the client
+		reader, err := compression.NewReader("gzip", strings.NewReader(recorder.Body.String()))
+		if err != nil {
+			t.Errorf("expected a gzip stream after flushing, got %v", err)
+			return
+		}
+		flushed := make([]byte, len(first))
+		if _, err := io.ReadFull(reader, flushed); err != nil || string(flushed) != first {
+			t.Errorf("expected the flushed data to decode, got %q: %v", flushed, err)
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(recorder, req)
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected a flushed response to be compressed, got %q", recorder.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestCompressionMiddlewareSmallChunkedResponse(t *testing.T) {
+	// Without Content-Length, ReverseProxy flushes after every write
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"ok":true}`)
+		http.NewResponseController(w).Flush()
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	handler := compressedHandler(config.CompressionConfig{Enabled: true}, httputil.NewSingleHostReverseProxy(backendURL).ServeHTTP)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != `{"ok":true}` {
+		t.Errorf("expected a response below the minimum size as it is, got %q %q", w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+	if w.Header().Get("Content-Length") != "11" {
+		t.Errorf("expected the held back body to get a Content-Length, got %q", w.Header().Get("Content-Length"))
+	}
+}
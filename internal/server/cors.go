@@ -0,0 +1,300 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+)
+
+var corsRejections = metrics.NewCounter("gateway_cors_rejections_total",
+	"Preflight requests rejected by a CORS policy, by service and reason", "service", "reason")
+
+// corsResponseHeaders are owned by the gateway's policy; upstream values are
+// dropped so browsers never see two of them
+var corsResponseHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Max-Age",
+}
+
+// subdomainOrigin matches origins like https://*.example.com
+type subdomainOrigin struct {
+	prefix string // scheme and "://"
+	suffix string // ".example.com", with the port if there is one
+}
+
+func (o subdomainOrigin) matches(origin string) bool {
+	if len(origin) <= len(o.prefix)+len(o.suffix) || !strings.HasPrefix(origin, o.prefix) || !strings.HasSuffix(origin, o.suffix) {
+		return false
+	}
+	subdomain := origin[len(o.prefix) : len(origin)-len(o.suffix)]
+	return !strings.ContainsAny(subdomain, "/:@")
+}
+
+// corsPolicy is a compiled config.CORSConfig
+type corsPolicy struct {
+	cfg        config.CORSConfig
+	anyOrigin  bool
+	origins    map[string]bool
+	subdomains []subdomainOrigin
+	patterns   []*regexp.Regexp
+	anyHeader  bool
+	headers    map[string]bool
+}
+
+func newCORSPolicy(cfg config.CORSConfig) (*corsPolicy, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	p := &corsPolicy{cfg: cfg, origins: map[string]bool{}, headers: map[string]bool{}}
+	for _, origin := range cfg.AllowedOrigins {
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.HasPrefix(origin, config.CORSRegexPrefix):
+			p.patterns = append(p.patterns, regexp.MustCompile(config.CORSOriginPattern(origin)))
+		case strings.Contains(origin, "*"):
+			prefix, suffix, _ := strings.Cut(strings.ToLower(origin), "*")
+			p.subdomains = append(p.subdomains, subdomainOrigin{prefix: prefix, suffix: suffix})
+		default:
+			p.origins[strings.ToLower(origin)] = true
+		}
+	}
+	for _, header := range cfg.Headers() {
+		if header == "*" {
+			p.anyHeader = true
+		}
+		p.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	return p, nil
+}
+
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if p.origins[lower] {
+		return true
+	}
+	for _, subdomain := range p.subdomains {
+		if subdomain.matches(lower) {
+			return true
+		}
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *corsPolicy) allowsMethod(method string) bool {
+	for _, allowed := range p.cfg.Methods() {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// echoesOrigin reports whether responses name the client's origin rather
+// than "*", and so vary by Origin
+func (p *corsPolicy) echoesOrigin() bool {
+	return !p.anyOrigin || p.cfg.AllowCredentials
+}
+
+// allowOrigin sets the headers that grant an allowed origin access
+func (p *corsPolicy) allowOrigin(header http.Header, origin string) {
+	if p.echoesOrigin() {
+		header.Set("Access-Control-Allow-Origin", origin)
+	} else {
+		header.Set("Access-Control-Allow-Origin", "*")
+	}
+	if p.cfg.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight answers a CORS preflight request, or rejects it when the
+// policy does not allow the origin, method or headers it asks for
+func (p *corsPolicy) preflight(w http.ResponseWriter, r *http.Request, serviceName string) {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	var requested []string
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				requested = append(requested, name)
+			}
+		}
+	}
+
+	header := w.Header()
+	addVary(header, "Origin")
+	addVary(header, "Access-Control-Request-Method")
+	addVary(header, "Access-Control-Request-Headers")
+
+	reason, message := "", ""
+	switch {
+	case !p.allowsOrigin(origin):
+		reason, message = "origin", fmt.Sprintf("Origin '%s' is not allowed", origin)
+	case !p.allowsMethod(method):
+		reason, message = "method", fmt.Sprintf("Method '%s' is not allowed for cross-origin requests", method)
+	default:
+		for _, name := range requested {
+			if !p.anyHeader && !p.headers[http.CanonicalHeaderKey(name)] {
+				reason, message = "header", fmt.Sprintf("Header '%s' is not allowed for cross-origin requests", name)
+				break
+			}
+		}
+	}
+	if reason != "" {
+		corsRejections.Inc(serviceName, reason)
+		log.Printf("[%s] CORS preflight rejected: %s", r.Header.Get("X-Request-ID"), message)
+		writeErrorResponse(w, r, problem.CORSRejected, message)
+		return
+	}
+
+	p.allowOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(p.cfg.Methods(), ", "))
+	if len(requested) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if maxAge := p.cfg.MaxAge.Duration; maxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyHeaders replaces whatever CORS headers the response carries with
+// the policy's own
+func (p *corsPolicy) applyHeaders(header http.Header, origin string) {
+	for _, name := range corsResponseHeaders {
+		header.Del(name)
+	}
+	if p.echoesOrigin() {
+		addVary(header, "Origin")
+	}
+	if origin == "" || !p.allowsOrigin(origin) {
+		return
+	}
+	p.allowOrigin(header, origin)
+	if len(p.cfg.ExposedHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(p.cfg.ExposedHeaders, ", "))
+	}
+}
+
+// corsMiddleware applies the CORS policy of the service a request is for.
+// Preflight requests are answered before authentication, since browsers
+// send them without credentials; any other OPTIONS request is routed like
+// every other request. Without a policy, CORS is left to the upstreams.
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	compile := func(name string, cfg *config.CORSConfig) *corsPolicy {
+		if cfg == nil {
+			return nil
+		}
+		policy, err := newCORSPolicy(*cfg)
+		if err != nil {
+			// Refused at config load; if it gets here anyway, no origin is
+			// allowed and upstream CORS headers are still dropped
+			log.Printf("Denying every origin for the invalid CORS policy of %s: %v", name, err)
+			return &corsPolicy{cfg: *cfg, origins: map[string]bool{}, headers: map[string]bool{}}
+		}
+		return policy
+	}
+
+	gatewayPolicy := compile("the gateway", s.appConfig.CORS)
+	servicePolicies := map[string]*corsPolicy{}
+	for serviceName, serviceConfig := range s.appConfig.Services {
+		if serviceConfig.CORS != nil {
+			servicePolicies[serviceName] = compile("service '"+serviceName+"'", serviceConfig.CORS)
+		}
+	}
+	if gatewayPolicy == nil && len(servicePolicies) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serviceName, policy := "gateway", gatewayPolicy
+		if name, ok := serviceFromPath(r.URL.Path); ok {
+			serviceName = name
+			if servicePolicy, ok := servicePolicies[name]; ok {
+				policy = servicePolicy
+			}
+		}
+		if policy == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		if r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+			policy.preflight(w, r, serviceName)
+			return
+		}
+
+		next.ServeHTTP(&corsWriter{ResponseWriter: w, apply: func(header http.Header) {
+			policy.applyHeaders(header, origin)
+		}}, r)
+	})
+}
+
+// serviceFromPath returns the service an /api/<service>/ path is for
+func serviceFromPath(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, "/api/")
+	if !ok {
+		return "", false
+	}
+	serviceName, _, _ := strings.Cut(rest, "/")
+	return serviceName, serviceName != ""
+}
+
+// corsWriter applies the CORS headers once the response headers are final,
+// so they also cover responses the gateway itself writes
+type corsWriter struct {
+	http.ResponseWriter
+	apply       func(http.Header)
+	wroteHeader bool
+}
+
+func (cw *corsWriter) WriteHeader(statusCode int) {
+	if !cw.wroteHeader && statusCode >= http.StatusOK {
+		cw.wroteHeader = true
+		cw.apply(cw.Header())
+	}
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (cw *corsWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher so streamed responses are not held back by the wrapper
+func (cw *corsWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if err := http.NewResponseController(cw.ResponseWriter).Flush(); err != nil {
+		log.Printf("Failed to flush response: %v", err)
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *corsWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
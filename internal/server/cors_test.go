@@ -0,0 +1,218 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+func newCORSGateway(t *testing.T) (http.Handler, *[]string) {
+	t.Helper()
+	var methods []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("X-Total-Count", "3")
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+
+	appConfig := config.AppConfig{
+		AllowedApiKey: "test-key",
+		KnownServices: map[string]string{"users": backend.URL, "auth": backend.URL, "legacy": backend.URL},
+		CORS: &config.CORSConfig{
+			AllowedOrigins: []string{"https://app.example.com", "https://*.example.org", "regex:^https://pr-[0-9]+\\.preview\\.example\\.net$", `regex:https://.*\.staging\.example\.io`},
+			MaxAge:         config.Duration{Duration: 10 * time.Minute},
+		},
+		Services: map[string]config.ServiceConfig{
+			"users": {CORS: &config.CORSConfig{
+				AllowedOrigins:   []string{"https://admin.example.com"},
+				AllowedMethods:   []string{"GET", "POST"},
+				ExposedHeaders:   []string{"X-Total-Count"},
+				AllowCredentials: true,
+			}},
+		},
+	}
+	s := &Server{appConfig: appConfig, apiGatewayService: usecase.NewApiGatewayService(appConfig, nil)}
+	return s.RegisterRoutes(), &methods
+}
+
+func preflightRequest(path, origin, method, headers string) *http.Request {
+	req := httptest.NewRequest(http.MethodOptions, path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return req
+}
+
+func TestCORSPreflight(t *testing.T) {
+	gateway, methods := newCORSGateway(t)
+
+	tests := []struct {
+		name     string
+		path     string
+		origin   string
+		method   string
+		headers  string
+		expected int
+	}{
+		{name: "exact origin", path: "/api/auth/login", origin: "https://app.example.com", method: "PUT", headers: "x-api-key, content-type, x-request-id", expected: http.StatusNoContent},
+		{name: "wildcard subdomain", path: "/api/auth/login", origin: "https://eu.shop.example.org", method: "GET", expected: http.StatusNoContent},
+		{name: "wildcard needs a subdomain", path: "/api/auth/login", origin: "https://example.org", method: "GET", expected: http.StatusForbidden},
+		{name: "wildcard scheme must match", path: "/api/auth/login", origin: "http://shop.example.org", method: "GET", expected: http.StatusForbidden},
+		{name: "regex origin", path: "/api/auth/login", origin: "https://pr-42.preview.example.net", method: "GET", expected: http.StatusNoContent},
+		{name: "regex origin mismatch", path: "/api/auth/login", origin: "https://pr-x.preview.example.net", method: "GET", expected: http.StatusForbidden},
+		{name: "unanchored regex origin", path: "/api/auth/login", origin: "https://web.staging.example.io", method: "GET", expected: http.StatusNoContent},
+		{name: "unanchored regex must match the whole origin", path: "/api/auth/login", origin: "https://web.staging.example.io.attacker.net", method: "GET", expected: http.StatusForbidden},
+		{name: "unanchored regex must match from the start", path: "/api/auth/login", origin: "http://attacker.net/https://web.staging.example.io", method: "GET", expected: http.StatusForbidden},
+		{name: "unknown origin", path: "/api/auth/login", origin: "https://evil.example.com", method: "GET", expected: http.StatusForbidden},
+		{name: "header not allowed", path: "/api/auth/login", origin: "https://app.example.com", method: "GET", headers: "X-Debug", expected: http.StatusForbidden},
+		{name: "service policy replaces the gateway's", path: "/api/users/users", origin: "https://app.example.com", method: "GET", expected: http.StatusForbidden},
+		{name: "service origin", path: "/api/users/users", origin: "https://admin.example.com", method: "POST", expected: http.StatusNoContent},
+		{name: "service method not allowed", path: "/api/users/users", origin: "https://admin.example.com", method: "DELETE", expected: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			gateway.ServeHTTP(w, preflightRequest(tt.path, tt.origin, tt.method, tt.headers))
+
+			if w.Code != tt.expected {
+				t.Fatalf("expected %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+			allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if tt.expected != http.StatusNoContent {
+				if allowOrigin != "" {
+					t.Errorf("expected no Access-Control-Allow-Origin on a rejection, got %q", allowOrigin)
+				}
+				return
+			}
+			if allowOrigin != tt.origin {
+				t.Errorf("expected the origin to be allowed, got %q", allowOrigin)
+			}
+			if tt.headers != "" && w.Header().Get("Access-Control-Allow-Headers") != tt.headers {
+				t.Errorf("expected the requested headers to be allowed, got %q", w.Header().Get("Access-Control-Allow-Headers"))
+			}
+		})
+	}
+
+	if len(*methods) != 0 {
+		t.Errorf("expected preflight requests to be answered by the gateway, upstream got %v", *methods)
+	}
+
+	w := httptest.NewRecorder()
+	gateway.ServeHTTP(w, preflightRequest("/api/auth/login", "https://app.example.com", "GET", ""))
+	if got := w.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("expected Access-Control-Max-Age 600, got %q", got)
+	}
+}
+
+func TestCORSActualRequests(t *testing.T) {
+	gateway, methods := newCORSGateway(t)
+
+	send := func(method, path, origin, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Request-ID", "cors-test")
+		if apiKey != "" {
+			req.Header.Set("x-api-key", apiKey)
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		gateway.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(http.MethodGet, "/api/users/users", "https://admin.example.com", "test-key")
+	if got := w.Header().Values("Access-Control-Allow-Origin"); len(got) != 1 || got[0] != "https://admin.example.com" {
+		t.Errorf("expected the upstream's Access-Control-Allow-Origin to be replaced, got %q", got)
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" || w.Header().Get("Access-Control-Expose-Headers") != "X-Total-Count" {
+		t.Errorf("expected credentials and exposed headers, got %v", w.Header())
+	}
+	if w.Header().Get("Vary") != "Origin" {
+		t.Errorf("expected Vary: Origin, got %q", w.Header().Get("Vary"))
+	}
+
+	w = send(http.MethodGet, "/api/users/users", "https://evil.example.com", "test-key")
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected no CORS headers for a disallowed origin, got %q", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	// Browsers can only read the gateway's own errors with CORS headers
+	w = send(http.MethodGet, "/api/auth/login", "https://app.example.com", "")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("expected a 401 readable by the origin, got %d %v", w.Code, w.Header())
+	}
+
+	// OPTIONS without a preflight goes through authentication to the upstream
+	*methods = nil
+	if w = send(http.MethodOptions, "/api/auth/login", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a plain OPTIONS request to be authenticated, got %d", w.Code)
+	}
+	if w = send(http.MethodOptions, "/api/auth/login", "", "test-key"); w.Code != http.StatusOK || len(*methods) != 1 || (*methods)[0] != http.MethodOptions {
+		t.Errorf("expected a plain OPTIONS request to be proxied, got %d and upstream calls %v", w.Code, *methods)
+	}
+}
+
+func TestCORSWithoutPolicies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "https://upstream.example.com")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer backend.Close()
+
+	appConfig := config.AppConfig{AllowedApiKey: "test-key", KnownServices: map[string]string{"users": backend.URL}}
+	s := &Server{appConfig: appConfig, apiGatewayService: usecase.NewApiGatewayService(appConfig, nil)}
+
+	req := preflightRequest("/api/users/users", "https://app.example.com", "GET", "")
+	req.Header.Set("X-Request-ID", "cors-test")
+	req.Header.Set("x-api-key", "test-key")
+	w := httptest.NewRecorder()
+	s.RegisterRoutes().ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://upstream.example.com" {
+		t.Errorf("expected CORS to be left to the upstream, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCORSInvalidPolicyFailsClosed(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	// Validation would refuse this config; the middleware must not fall
+	// back to leaving CORS to the upstream
+	appConfig := config.AppConfig{
+		AllowedApiKey: "test-key",
+		KnownServices: map[string]string{"users": backend.URL},
+		CORS:          &config.CORSConfig{AllowedOrigins: []string{"regex:("}},
+	}
+	s := &Server{appConfig: appConfig, apiGatewayService: usecase.NewApiGatewayService(appConfig, nil)}
+	gateway := s.RegisterRoutes()
+
+	w := httptest.NewRecorder()
+	gateway.ServeHTTP(w, preflightRequest("/api/users/users", "https://app.example.com", "GET", ""))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected the preflight to be rejected, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("X-Request-ID", "cors-test")
+	req.Header.Set("x-api-key", "test-key")
+	w = httptest.NewRecorder()
+	gateway.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected the upstream's CORS headers to be dropped, got %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+// Drain runs the shutdown sequence of the client listener:
+//
+//  1. readiness starts failing and keep-alives are turned off, so load
+//     balancers and clients move elsewhere during the pre-stop delay while
+//     the listener still accepts connections
+//  2. the listener closes, idle connections are closed and HTTP/2 clients
+//     are sent GOAWAY
+//  3. requests in flight get up to the drain timeout to finish
+//  4. the requests left are cut off, then upgraded connections such as
+//     WebSockets are closed
+//
+// It returns the number of requests cut off and of upgraded connections
+// closed.
+func Drain(srv *http.Server, controls *Controls, cfg config.ShutdownConfig) (cutOff, upgraded int) {
+	controls.StartDrain()
+	srv.SetKeepAlivesEnabled(false)
+	if delay := cfg.PreStopDelay.Duration; delay > 0 {
+		log.Printf("Readiness is failing; closing the listener in %s", delay)
+		time.Sleep(delay)
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout())
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		// Upgraded connections are hijacked, so Shutdown does not wait for
+		// them, but their requests are still being served
+		requests, open := controls.InFlight()
+		cutOff = max(0, requests-open)
+		if err := srv.Close(); err != nil {
+			log.Printf("Failed to close the listener: %v", err)
+		}
+	case err != nil:
+		log.Printf("Server forced to shutdown with error: %v", err)
+	}
+
+	upgraded = controls.closeUpgraded("shutdown")
+	if cutOff > 0 {
+		log.Printf("Drain timeout of %s reached: cut off %d requests in flight and closed %d upgraded connections", cfg.Timeout(), cutOff, upgraded)
+	} else {
+		log.Printf("Drained requests in flight in %s; closed %d upgraded connections", time.Since(start).Round(time.Millisecond), upgraded)
+	}
+	return cutOff, upgraded
+}
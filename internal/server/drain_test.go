@@ -0,0 +1,117 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/upstream"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+// serve starts a listener for handler and returns it with its base URL
+func serve(t *testing.T, handler http.Handler) (*http.Server, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+	return srv, "http://" + listener.Addr().String()
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	gateway, _, _ := newAdminTestGateway(t)
+	controls := gateway.Controls()
+	srv, baseURL := serve(t, gateway)
+
+	slow := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, baseURL+"/api/users/slow", nil)
+		req.Header.Set("X-Request-ID", "drain-test")
+		req.Header.Set("x-api-key", "client-secret-key")
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		slow <- err
+	}()
+	waitFor(t, "the slow request", func() bool { requests, _ := controls.InFlight(); return requests == 1 })
+
+	type result struct{ cutOff, upgraded int }
+	drained := make(chan result, 1)
+	go func() {
+		cutOff, upgraded := Drain(srv, controls, config.ShutdownConfig{
+			PreStopDelay: config.Duration{Duration: 200 * time.Millisecond},
+			DrainTimeout: config.Duration{Duration: 200 * time.Millisecond},
+		})
+		drained <- result{cutOff, upgraded}
+	}()
+	waitFor(t, "the drain to start", controls.Draining)
+
+	// The listener keeps accepting connections during the pre-stop delay
+	resp, err := http.Get(baseURL + "/readiness")
+	if err != nil {
+		t.Fatalf("expected the listener to accept connections during the pre-stop delay: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || !resp.Close {
+		t.Errorf("expected readiness to fail and keep-alives to be off, got %d (close %v)", resp.StatusCode, resp.Close)
+	}
+
+	if got := <-drained; got.cutOff != 1 || got.upgraded != 0 {
+		t.Errorf("expected the slow request to be cut off, got %+v", got)
+	}
+	if err := <-slow; err == nil {
+		t.Error("expected the slow request to fail")
+	}
+	if _, err := http.Get(baseURL + "/liveness"); err == nil {
+		t.Error("expected the listener to be closed")
+	}
+}
+
+func TestDrainClosesUpgradedConnections(t *testing.T) {
+	backend := newWebSocketEchoBackend(t)
+	defer backend.Close()
+	appConfig := config.AppConfig{
+		AllowedApiKey: "test-key",
+		KnownServices: map[string]string{"notifications": backend.URL},
+	}
+	controls := NewControls(upstream.NewRegistry())
+	s := &Server{appConfig: appConfig, apiGatewayService: usecase.NewApiGatewayService(appConfig, nil), controls: controls}
+	srv, baseURL := serve(t, s.RegisterRoutes())
+
+	conn, reader := dialWebSocket(t, baseURL, "/api/notifications/ws")
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := readTestFrame(reader); err != nil {
+		t.Fatalf("expected the backend's greeting: %v", err)
+	}
+	waitFor(t, "the upgraded connection", func() bool { _, upgraded := controls.InFlight(); return upgraded == 1 })
+
+	if cutOff, upgraded := Drain(srv, controls, config.ShutdownConfig{DrainTimeout: config.Duration{Duration: time.Second}}); cutOff != 0 || upgraded != 1 {
+		t.Errorf("expected the upgraded connection to be closed without cutting off requests, got %d and %d", cutOff, upgraded)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := readTestFrame(reader); err == nil {
+		t.Error("expected the upgraded connection to be closed")
+	}
+	if _, upgraded := controls.InFlight(); upgraded != 0 {
+		t.Errorf("expected no upgraded connections left, got %d", upgraded)
+	}
+}
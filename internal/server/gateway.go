@@ -0,0 +1,404 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/cache"
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/configstore"
+	"github.com/LucianoBarrera/api-gateway/internal/upstream"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+// Controls are the runtime switches operators flip through the admin API,
+// plus the process state readiness reports on. They are not part of the
+// config, so they survive reloads.
+type Controls struct {
+	upstreams *upstream.Registry
+	startedAt time.Time
+	draining  atomic.Bool
+	requests  atomic.Int64 // being served, including upgraded ones
+
+	mu          sync.RWMutex
+	maintenance map[string]runtimeMaintenance // by service, "" for the whole gateway
+	upgraded    map[*upgradedConn]struct{}    // open, so shutdown can close them
+}
+
+// runtimeMaintenance is a maintenance started through the admin API
+type runtimeMaintenance struct {
+	Service string    `json:"service,omitempty"`
+	Since   time.Time `json:"since"`
+	// Until is when the maintenance ends by itself; zero lasts until it is
+	// cleared
+	Until time.Time `json:"until,omitzero"`
+}
+
+// activeAt reports whether the maintenance is still on at now
+func (m runtimeMaintenance) activeAt(now time.Time) bool {
+	return m.Until.IsZero() || now.Before(m.Until)
+}
+
+// NewControls creates controls with every upstream active and no service
+// in maintenance
+func NewControls(upstreams *upstream.Registry) *Controls {
+	return &Controls{
+		upstreams:   upstreams,
+		startedAt:   time.Now(),
+		maintenance: map[string]runtimeMaintenance{},
+		upgraded:    map[*upgradedConn]struct{}{},
+	}
+}
+
+// StartedAt returns when the controls were created, at process start, or
+// the zero time for nil controls
+func (c *Controls) StartedAt() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+	return c.startedAt
+}
+
+// StartDrain marks the gateway as shutting down, so it stops reporting
+// ready while requests in flight finish
+func (c *Controls) StartDrain() {
+	if c == nil {
+		return
+	}
+	c.draining.Store(true)
+}
+
+// Draining reports whether the gateway is shutting down
+func (c *Controls) Draining() bool {
+	return c != nil && c.draining.Load()
+}
+
+// Upstreams returns the upstream registry, or nil for nil controls
+func (c *Controls) Upstreams() *upstream.Registry {
+	if c == nil {
+		return nil
+	}
+	return c.upstreams
+}
+
+// beginRequest counts a request being served until the returned function
+// is called
+func (c *Controls) beginRequest() func() {
+	if c == nil {
+		return func() {}
+	}
+	c.requests.Add(1)
+	return func() { c.requests.Add(-1) }
+}
+
+// InFlight returns the number of requests being served and, of those, the
+// ones whose connection was upgraded, e.g. to a WebSocket
+func (c *Controls) InFlight() (requests, upgraded int) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return int(c.requests.Load()), len(c.upgraded)
+}
+
+func (c *Controls) addUpgraded(conn *upgradedConn) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.upgraded[conn] = struct{}{}
+}
+
+func (c *Controls) removeUpgraded(conn *upgradedConn) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.upgraded, conn)
+}
+
+// closeUpgraded closes every upgraded connection still open and returns
+// how many there were
+func (c *Controls) closeUpgraded(reason string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.RLock()
+	open := make([]*upgradedConn, 0, len(c.upgraded))
+	for conn := range c.upgraded {
+		open = append(open, conn)
+	}
+	c.mu.RUnlock()
+	for _, conn := range open {
+		conn.closeWithReason(reason)
+	}
+	return len(open)
+}
+
+// SetMaintenance puts a service, or the whole gateway for an empty name,
+// in maintenance mode until the given time, or until it is cleared for a
+// zero time
+func (c *Controls) SetMaintenance(serviceName string, until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	current, ok := c.maintenance[serviceName]
+	if !ok || !current.activeAt(now) {
+		current = runtimeMaintenance{Service: serviceName, Since: now}
+	}
+	current.Until = until
+	c.maintenance[serviceName] = current
+}
+
+// ClearMaintenance takes a service, or the whole gateway for an empty
+// name, out of maintenance mode
+func (c *Controls) ClearMaintenance(serviceName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.maintenance, serviceName)
+}
+
+// maintenanceOf returns the maintenance of a service, or of the whole
+// gateway for an empty name, if it is on at now
+func (c *Controls) maintenanceOf(serviceName string, now time.Time) (runtimeMaintenance, bool) {
+	if c == nil {
+		return runtimeMaintenance{}, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m, ok := c.maintenance[serviceName]
+	return m, ok && m.activeAt(now)
+}
+
+// Maintenance lists the maintenances on at now, the whole gateway's first
+// and then by service name
+func (c *Controls) Maintenance(now time.Time) []runtimeMaintenance {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	active := make([]runtimeMaintenance, 0, len(c.maintenance))
+	for _, m := range c.maintenance {
+		if m.activeAt(now) {
+			active = append(active, m)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Service < active[j].Service })
+	return active
+}
+
+// ForwarderBuilder builds the request forwarder for a config
+type ForwarderBuilder func(appConfig config.AppConfig) (usecase.RequestForwarder, error)
+
+// ErrConfigRejected wraps the reason a config was refused
+var ErrConfigRejected = errors.New("config rejected")
+
+// ErrRevisionConflict is returned for changes based on a revision that is
+// no longer the current one
+var ErrRevisionConflict = errors.New("the config has changed since the expected revision")
+
+// Gateway serves the routes built from the current config. Applying a new
+// config builds a complete new set of routes and forwarders and swaps them
+// in at once: requests already being served finish on the ones they
+// started with. Every config applied is stored as a new revision.
+type Gateway struct {
+	build         ForwarderBuilder
+	load          func() (config.AppConfig, error)
+	validate      func(config.AppConfig) error
+	responseCache *cache.Store
+	controls      *Controls
+	revisions     *configstore.Store
+
+	mu      sync.Mutex // serialises changes to the config
+	current atomic.Pointer[generation]
+}
+
+// generation is the config in use and the routes built from it
+type generation struct {
+	appConfig config.AppConfig
+	handler   http.Handler
+	revision  int
+	appliedAt time.Time
+}
+
+// NewGateway builds the routes for the latest stored revision. The admin
+// settings always come from fileConfig, since they say where the revisions
+// are stored. When nothing is stored yet, fileConfig becomes revision 1.
+func NewGateway(fileConfig config.AppConfig, build ForwarderBuilder, responseCache *cache.Store, controls *Controls, revisions *configstore.Store) (*Gateway, error) {
+	g := &Gateway{
+		build:         build,
+		load:          config.ReadAppConfig,
+		validate:      func(appConfig config.AppConfig) error { return appConfig.Validate(config.GetEnvironment()) },
+		responseCache: responseCache,
+		controls:      controls,
+		revisions:     revisions,
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	latest, ok := revisions.Latest()
+	if !ok {
+		if _, err := g.commit(fileConfig, "initial config from files", ""); err != nil {
+			return nil, err
+		}
+		return g, nil
+	}
+	appConfig := latest.Config
+	appConfig.Admin = fileConfig.Admin
+	if err := g.validate(appConfig); err != nil {
+		return nil, fmt.Errorf("stored revision %d: %w", latest.Number, err)
+	}
+	handler, err := g.routes(appConfig)
+	if err != nil {
+		return nil, fmt.Errorf("stored revision %d: %w", latest.Number, err)
+	}
+	log.Printf("Using config revision %d (%s, %s)", latest.Number, latest.Change, latest.CreatedAt.Format(time.RFC3339))
+	g.swap(appConfig, handler, latest.Number)
+	return g, nil
+}
+
+// ServeHTTP implements http.Handler with the current routes
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	done := g.controls.beginRequest()
+	defer done()
+	g.current.Load().handler.ServeHTTP(w, r)
+}
+
+// Config returns the config in use
+func (g *Gateway) Config() config.AppConfig {
+	return g.current.Load().appConfig
+}
+
+// Revision returns the number of the config revision in use
+func (g *Gateway) Revision() int {
+	return g.current.Load().revision
+}
+
+// AppliedAt returns when the config in use was applied
+func (g *Gateway) AppliedAt() time.Time {
+	return g.current.Load().appliedAt
+}
+
+// Controls returns the gateway's runtime switches
+func (g *Gateway) Controls() *Controls {
+	return g.controls
+}
+
+// Revisions returns the store of config revisions
+func (g *Gateway) Revisions() *configstore.Store {
+	return g.revisions
+}
+
+// Update applies a change to a copy of the current config. With a non-zero
+// expected revision, the change is refused with ErrRevisionConflict unless
+// that revision is still current. Errors returned by edit are passed on
+// as they are.
+func (g *Gateway) Update(expected int, change, remoteAddr string, edit func(*config.AppConfig) error) (configstore.Revision, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	current := g.current.Load()
+	if expected != 0 && expected != current.revision {
+		return configstore.Revision{}, ErrRevisionConflict
+	}
+	appConfig, err := current.appConfig.Clone()
+	if err != nil {
+		return configstore.Revision{}, err
+	}
+	if err := edit(&appConfig); err != nil {
+		return configstore.Revision{}, err
+	}
+	return g.commit(appConfig, change, remoteAddr)
+}
+
+// Rollback applies the config of an earlier revision as a new revision.
+// The admin settings in use are kept.
+func (g *Gateway) Rollback(number, expected int, remoteAddr string) (configstore.Revision, error) {
+	previous, err := g.revisions.Get(number)
+	if err != nil {
+		return configstore.Revision{}, err
+	}
+	return g.Update(expected, fmt.Sprintf("rollback to revision %d", number), remoteAddr, func(appConfig *config.AppConfig) error {
+		restored, err := previous.Config.Clone()
+		if err != nil {
+			return err
+		}
+		restored.Admin = appConfig.Admin
+		*appConfig = restored
+		return nil
+	})
+}
+
+// Reload reads the config files again and applies them as a new revision,
+// replacing any changes made through the admin API
+func (g *Gateway) Reload(remoteAddr string) (configstore.Revision, error) {
+	appConfig, err := g.load()
+	if err != nil {
+		return configstore.Revision{}, fmt.Errorf("%w: %w", ErrConfigRejected, err)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.commit(appConfig, "reload from files", remoteAddr)
+}
+
+// commit validates appConfig, builds its routes, stores it as a new
+// revision and swaps it in. Anything refused leaves the current config in
+// place. g.mu must be held.
+func (g *Gateway) commit(appConfig config.AppConfig, change, remoteAddr string) (configstore.Revision, error) {
+	if err := g.validate(appConfig); err != nil {
+		return configstore.Revision{}, fmt.Errorf("%w: %w", ErrConfigRejected, err)
+	}
+	handler, err := g.routes(appConfig)
+	if err != nil {
+		return configstore.Revision{}, fmt.Errorf("%w: %w", ErrConfigRejected, err)
+	}
+	revision, err := g.revisions.Append(appConfig, change, remoteAddr)
+	if err != nil {
+		return configstore.Revision{}, err
+	}
+	g.swap(appConfig, handler, revision.Number)
+	return revision, nil
+}
+
+// routes builds the forwarder and routes for appConfig
+func (g *Gateway) routes(appConfig config.AppConfig) (http.Handler, error) {
+	forwarder, err := g.build(appConfig)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		appConfig:         appConfig,
+		apiGatewayService: forwarder,
+		controls:          g.controls,
+	}
+	return s.RegisterRoutes(), nil
+}
+
+// swap makes appConfig the config in use. g.mu must be held.
+func (g *Gateway) swap(appConfig config.AppConfig, handler http.Handler, revision int) {
+	if previous := g.current.Load(); previous != nil && listenerChanged(previous.appConfig, appConfig) {
+		log.Printf("Listener, admin and cache size settings changed; they take effect on restart")
+	}
+	g.controls.Upstreams().Sync(appConfig.UpstreamTargets())
+	g.current.Store(&generation{appConfig: appConfig, handler: handler, revision: revision, appliedAt: time.Now()})
+}
+
+// listenerChanged reports whether settings that are only read at startup
+// differ between two configs
+func listenerChanged(previous, next config.AppConfig) bool {
+	before, after := previous.Server, next.Server
+	return before.H2C != after.H2C ||
+		before.TLSCertFile != after.TLSCertFile ||
+		before.TLSKeyFile != after.TLSKeyFile ||
+		before.Limits.HeaderBytes() != after.Limits.HeaderBytes() ||
+		before.Limits.HeaderTimeout() != after.Limits.HeaderTimeout() ||
+		previous.Admin != next.Admin ||
+		previous.Cache.Limit() != next.Cache.Limit()
+}
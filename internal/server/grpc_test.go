@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+// grpcFrame wraps a message in the 5-byte gRPC length-prefixed framing
+func grpcFrame(message string) []byte {
+	frame := []byte{0, 0, 0, 0, byte(len(message))}
+	return append(frame, message...)
+}
+
+func newH2CClient() *http.Client {
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: transport}
+}
+
+// newGRPCBackend starts an h2c upstream that answers UserService calls with
+// a single frame and grpc-status trailers
+func newGRPCBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("expected HTTP/2 to the upstream, got %s", r.Proto)
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/users.v1.UserService/GetUser":
+			if !bytes.Equal(body, grpcFrame("req")) {
+				t.Errorf("unexpected upstream body %q", body)
+			}
+		case "/users.v1.UserService/ImportUsers":
+			// Client stream: answers with the number of frames received
+			if len(body)%len(grpcFrame("req")) != 0 {
+				t.Errorf("unexpected upstream body %q", body)
+			}
+			body = grpcFrame(strconv.Itoa(len(body) / len(grpcFrame("req"))))
+		default:
+			t.Errorf("unexpected upstream path %s", r.URL.Path)
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+		if r.URL.Path == "/users.v1.UserService/ImportUsers" {
+			w.Write(body)
+		} else {
+			w.Write(grpcFrame("resp"))
+		}
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "done")
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	return backend
+}
+
+func newGRPCGateway(t *testing.T, backendURL string) *httptest.Server {
+	t.Helper()
+	appConfig := config.AppConfig{
+		AllowedApiKey: "test-key",
+		KnownServices: map[string]string{"users": backendURL},
+		Server:        config.ServerConfig{H2C: true},
+		Services: map[string]config.ServiceConfig{
+			"users": {Protocol: config.ProtocolGRPC, GRPCServices: []string{"users.v1"}},
+		},
+	}
+	s := &Server{
+		appConfig:         appConfig,
+		apiGatewayService: usecase.NewApiGatewayService(appConfig, nil),
+	}
+	gateway := httptest.NewUnstartedServer(s.RegisterRoutes())
+	gateway.Config.Protocols = serverProtocols(appConfig.Server)
+	// Short stand-ins for the listener's timeouts, which gRPC streams must
+	// be able to outlive
+	gateway.Config.ReadTimeout = 300 * time.Millisecond
+	gateway.Config.WriteTimeout = 300 * time.Millisecond
+	gateway.Start()
+	return gateway
+}
+
+func newGRPCRequest(url, apiKey string) *http.Request {
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(grpcFrame("req")))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("X-Request-ID", "grpc-test")
+	if apiKey != "" {
+		req.Header.Set("x-api-key", apiKey)
+	}
+	return req
+}
+
+func TestGRPCProxyingOverH2C(t *testing.T) {
+	backend := newGRPCBackend(t)
+	defer backend.Close()
+
+	gateway := newGRPCGateway(t, backend.URL)
+	defer gateway.Close()
+
+	resp, err := newH2CClient().Do(newGRPCRequest(gateway.URL+"/users.v1.UserService/GetUser", "test-key"))
+	if err != nil {
+		t.Fatalf("gRPC call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2 from the gateway, got %s", resp.Proto)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(body, grpcFrame("resp")) {
+		t.Errorf("unexpected response body %q", body)
+	}
+
+	// Trailers are only available once the body has been fully read
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("expected grpc-status trailer 0, got %q", got)
+	}
+	if got := resp.Trailer.Get("Grpc-Message"); got != "done" {
+		t.Errorf("expected grpc-message trailer 'done', got %q", got)
+	}
+}
+
+func TestGRPCErrorsUseGRPCStatus(t *testing.T) {
+	backend := newGRPCBackend(t)
+	defer backend.Close()
+	gateway := newGRPCGateway(t, backend.URL)
+	defer gateway.Close()
+
+	deadBackend := httptest.NewServer(http.NotFoundHandler())
+	deadBackend.Close()
+	deadGateway := newGRPCGateway(t, deadBackend.URL)
+	defer deadGateway.Close()
+
+	tests := []struct {
+		name       string
+		url        string
+		apiKey     string
+		wantStatus string
+	}{
+		{
+			name:       "missing api key is UNAUTHENTICATED",
+			url:        gateway.URL + "/users.v1.UserService/GetUser",
+			wantStatus: "16",
+		},
+		{
+			name:       "unknown gRPC service is UNIMPLEMENTED",
+			url:        gateway.URL + "/orders.v1.OrderService/GetOrder",
+			apiKey:     "test-key",
+			wantStatus: "12",
+		},
+		{
+			name:       "malformed gRPC path is INVALID_ARGUMENT",
+			url:        gateway.URL + "/not-a-service/GetUser",
+			apiKey:     "test-key",
+			wantStatus: "3",
+		},
+		{
+			name:       "unreachable upstream is UNAVAILABLE",
+			url:        deadGateway.URL + "/users.v1.UserService/GetUser",
+			apiKey:     "test-key",
+			wantStatus: "14",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := newH2CClient().Do(newGRPCRequest(tt.url, tt.apiKey))
+			if err != nil {
+				t.Fatalf("gRPC call failed: %v", err)
+			}
+			defer resp.Body.Close()
+			io.Copy(io.Discard, resp.Body)
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("expected HTTP 200 for gRPC errors, got %d", resp.StatusCode)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "application/grpc" {
+				t.Errorf("expected application/grpc content type, got %q", ct)
+			}
+			if got := resp.Header.Get("Grpc-Status"); got != tt.wantStatus {
+				t.Errorf("expected grpc-status %s, got %q", tt.wantStatus, got)
+			}
+		})
+	}
+}
+
+func TestGRPCClientStreamOutlivesServerTimeouts(t *testing.T) {
+	backend := newGRPCBackend(t)
+	defer backend.Close()
+	gateway := newGRPCGateway(t, backend.URL)
+	defer gateway.Close()
+
+	// The client keeps its stream open well past the read and write timeouts
+	body, stream := io.Pipe()
+	go func() {
+		for i := 0; i < 8; i++ {
+			stream.Write(grpcFrame("req"))
+			time.Sleep(100 * time.Millisecond)
+		}
+		stream.Close()
+	}()
+	req := newGRPCRequest(gateway.URL+"/users.v1.UserService/ImportUsers", "test-key")
+	req.Body = body
+	req.ContentLength = -1
+
+	resp, err := newH2CClient().Do(req)
+	if err != nil {
+		t.Fatalf("gRPC call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(respBody, grpcFrame("8")) {
+		t.Errorf("expected the upstream to receive 8 frames, got %q", respBody)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("expected grpc-status trailer 0, got %q", got)
+	}
+}
+
+func TestGRPCWebIsNotRoutedAsGRPC(t *testing.T) {
+	s := &Server{appConfig: config.AppConfig{AllowedApiKey: "test-key"}}
+	handler := s.RegisterRoutes()
+
+	// gRPC-Web is framed for HTTP/1.1 and cannot be sent to a gRPC upstream
+	req := httptest.NewRequest(http.MethodPost, "/users.v1.UserService/GetUser", bytes.NewReader(grpcFrame("req")))
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set("X-Request-ID", "grpc-web-test")
+	req.Header.Set("x-api-key", "test-key")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != problem.ContentType {
+		t.Errorf("expected a 404 problem, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestNonGRPCRequestsToUnknownPathsAre404(t *testing.T) {
+	s := &Server{appConfig: config.AppConfig{AllowedApiKey: "test-key"}}
+	handler := s.RegisterRoutes()
+
+	req := httptest.NewRequest(http.MethodGet, "/users.v1.UserService/GetUser", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
@@ -1,28 +1,13 @@
 package server
 
 import (
-	"encoding/json"
-	"log"
 	"net/http"
+
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
 )
 
 // writeErrorResponse is a helper method to write consistent error responses
-func writeErrorResponse(w http.ResponseWriter, statusCode int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-
-	errorResponse := map[string]string{
-		"error": message,
-	}
-
-	jsonResp, err := json.Marshal(errorResponse)
-	if err != nil {
-		log.Printf("Failed to marshal error response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	if _, err := w.Write(jsonResp); err != nil {
-		log.Printf("Failed to write error response: %v", err)
-	}
+// as problem details. gRPC clients get the equivalent grpc-status instead.
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, problemType problem.Type, detail string) {
+	problem.Write(w, r, problem.New(problemType, detail))
 }
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/grpcstatus"
+	"github.com/LucianoBarrera/api-gateway/internal/ipfilter"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+)
+
+var ipFilterRejections = metrics.NewCounter("gateway_ip_filter_rejections_total",
+	"Requests blocked by an IP allow or deny list, by scope and list", "scope", "list")
+
+// clientIPResolver finds the address of the client behind any trusted
+// proxies
+type clientIPResolver struct {
+	trusted *ipfilter.Set
+	header  string
+}
+
+func (s *Server) clientIPResolver() clientIPResolver {
+	trusted, err := s.appConfig.Server.TrustedProxySet()
+	if err != nil {
+		// Refused at config load; trust nobody if it gets here anyway
+		log.Printf("Ignoring invalid trusted proxies: %v", err)
+	}
+	return clientIPResolver{trusted: trusted, header: s.appConfig.Server.ClientHeader()}
+}
+
+// resolve returns the client address of a request. Addresses in the
+// forwarding header are only believed when a trusted proxy sent the
+// request; it is read from the right, and the first address that is not a
+// trusted proxy is the client.
+func (c clientIPResolver) resolve(r *http.Request) string {
+	remote, err := netip.ParseAddr(remoteIP(r.RemoteAddr))
+	if err != nil || !c.trusted.Contains(remote) {
+		return remoteIP(r.RemoteAddr)
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values(c.header) {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !c.trusted.Contains(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// parseHop parses one address of a forwarding header, which some proxies
+// write with a port
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+	if addr, err := netip.ParseAddr(hop); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// ipFilter is a compiled config.IPFilterConfig
+type ipFilter struct {
+	allow *ipfilter.Set // nil allows everyone
+	deny  *ipfilter.Set
+}
+
+func newIPFilter(name string, cfg *config.IPFilterConfig) *ipFilter {
+	if cfg == nil {
+		return nil
+	}
+	allow, deny, err := cfg.Lists()
+	if err != nil {
+		// Refused at config load; fail closed if it gets here anyway
+		log.Printf("Blocking every client of %s, its IP filter is invalid: %v", name, err)
+		return &ipFilter{allow: &ipfilter.Set{}}
+	}
+	allowed := "everyone"
+	if allow != nil {
+		allowed = fmt.Sprintf("%d prefixes", allow.Len())
+	}
+	log.Printf("IP filter for %s: allowing %s, denying %d prefixes", name, allowed, deny.Len())
+	return &ipFilter{allow: allow, deny: deny}
+}
+
+// blocks returns the list that blocks a client, if any. Deny entries win
+// over allow entries.
+func (f *ipFilter) blocks(client netip.Addr) (string, bool) {
+	if f.deny.Contains(client) {
+		return "deny", true
+	}
+	if f.allow != nil && !f.allow.Contains(client) {
+		return "allow", true
+	}
+	return "", false
+}
+
+// ipFilters are the gateway's and the services' compiled IP lists
+type ipFilters struct {
+	gateway  *ipFilter
+	services map[string]*ipFilter
+}
+
+func (s *Server) newIPFilters() *ipFilters {
+	filters := &ipFilters{
+		gateway:  newIPFilter("the gateway", s.appConfig.IPFilter),
+		services: map[string]*ipFilter{},
+	}
+	for serviceName, serviceConfig := range s.appConfig.Services {
+		if serviceConfig.IPFilter != nil {
+			filters.services[serviceName] = newIPFilter("service '"+serviceName+"'", serviceConfig.IPFilter)
+		}
+	}
+	return filters
+}
+
+// ipFilterMiddleware blocks clients by the gateway's and their service's
+// IP lists. It runs after logging, which resolves the client address.
+func (s *Server) ipFilterMiddleware(next http.Handler) http.Handler {
+	if s.ipFilters.gateway == nil && len(s.ipFilters.services) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.ipFilters.blocks(w, r, "gateway", s.ipFilters.gateway) {
+			return
+		}
+		if serviceName := s.requestService(r); s.ipFilters.blocksService(w, r, serviceName) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// blocksService answers the request and returns true if serviceName's IP
+// lists block its client. Aggregates check each of their calls with it,
+// since they reach the services they call without going through the
+// middleware.
+func (f *ipFilters) blocksService(w http.ResponseWriter, r *http.Request, serviceName string) bool {
+	if f == nil {
+		return false
+	}
+	return f.blocks(w, r, serviceName, f.services[serviceName])
+}
+
+// blocks answers the request and returns true if filter blocks its client
+func (f *ipFilters) blocks(w http.ResponseWriter, r *http.Request, scope string, filter *ipFilter) bool {
+	if filter == nil {
+		return false
+	}
+	info := requestctx.From(r.Context())
+	// Unparseable addresses match no list, so allow lists block them
+	client, _ := netip.ParseAddr(info.ClientIP())
+	list, blocked := filter.blocks(client)
+	if !blocked {
+		return false
+	}
+
+	ipFilterRejections.Inc(scope, list)
+	log.Printf("[%s] AUDIT access denied - Client: %s - Remote: %s - Scope: %s - List: %s - %s %s",
+		r.Header.Get("X-Request-ID"), info.ClientIP(), r.RemoteAddr, scope, list, r.Method, r.URL.Path)
+	writeErrorResponse(w, r, problem.ClientAddressBlocked, "Access denied for this client address")
+	return true
+}
+
+// requestService returns the service an API or gRPC request is for, or ""
+func (s *Server) requestService(r *http.Request) string {
+	if serviceName, ok := serviceFromPath(r.URL.Path); ok {
+		return serviceName
+	}
+	if grpcstatus.IsGRPCRequest(r) {
+		if grpcService, _, ok := parseGRPCPath(r.URL.Path); ok {
+			serviceName, _ := s.appConfig.ServiceForGRPC(grpcService)
+			return serviceName
+		}
+	}
+	return ""
+}
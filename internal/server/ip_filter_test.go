@@ -0,0 +1,182 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	s := &Server{appConfig: config.AppConfig{Server: config.ServerConfig{
+		TrustedProxies: []string{"10.0.0.0/8", "2001:db8::/32"},
+	}}}
+	resolver := s.clientIPResolver()
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expectedIP   string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.9:4000", expectedIP: "203.0.113.9"},
+		{name: "untrusted peer cannot spoof", remoteAddr: "203.0.113.9:4000", forwardedFor: []string{"198.51.100.1"}, expectedIP: "203.0.113.9"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.5:4000", forwardedFor: []string{"198.51.100.1"}, expectedIP: "198.51.100.1"},
+		{name: "spoofed entries left of the client", remoteAddr: "10.0.0.5:4000", forwardedFor: []string{"1.2.3.4, 198.51.100.1, 10.0.0.7"}, expectedIP: "198.51.100.1"},
+		{name: "several headers", remoteAddr: "10.0.0.5:4000", forwardedFor: []string{"198.51.100.1", "10.1.1.1"}, expectedIP: "198.51.100.1"},
+		{name: "only proxies", remoteAddr: "10.0.0.5:4000", forwardedFor: []string{"10.9.9.9, 10.0.0.7"}, expectedIP: "10.9.9.9"},
+		{name: "address with port", remoteAddr: "10.0.0.5:4000", forwardedFor: []string{"198.51.100.1:5555"}, expectedIP: "198.51.100.1"},
+		{name: "ipv6", remoteAddr: "[2001:db8::1]:4000", forwardedFor: []string{"2001:db9::42"}, expectedIP: "2001:db9::42"},
+		{name: "garbage stops the walk", remoteAddr: "10.0.0.5:4000", forwardedFor: []string{"198.51.100.1, unknown"}, expectedIP: "10.0.0.5"},
+		{name: "no header", remoteAddr: "10.0.0.5:4000", expectedIP: "10.0.0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if got := resolver.resolve(req); got != tt.expectedIP {
+				t.Errorf("expected %s, got %s", tt.expectedIP, got)
+			}
+		})
+	}
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	denyFile := filepath.Join(t.TempDir(), "deny.txt")
+	os.WriteFile(denyFile, []byte("# abusive ranges\n198.51.100.0/24\n2001:db8:bad::/48\n"), 0o644)
+
+	appConfig := config.AppConfig{
+		AllowedApiKey: "test-key",
+		KnownServices: map[string]string{"users": backend.URL, "auth": backend.URL},
+		Server:        config.ServerConfig{TrustedProxies: []string{"10.0.0.1"}},
+		IPFilter:      &config.IPFilterConfig{Deny: []string{"203.0.113.66"}, DenyFile: denyFile},
+		Services: map[string]config.ServiceConfig{
+			"auth": {IPFilter: &config.IPFilterConfig{Allow: []string{"192.0.2.0/24", "2001:db8:1::/48"}, Deny: []string{"192.0.2.13"}}},
+		},
+	}
+	s := &Server{appConfig: appConfig, apiGatewayService: usecase.NewApiGatewayService(appConfig, nil)}
+	gateway := s.RegisterRoutes()
+
+	tests := []struct {
+		name         string
+		path         string
+		remoteAddr   string
+		forwardedFor string
+		expected     int
+	}{
+		{name: "open service", path: "/api/users/users", remoteAddr: "203.0.113.9:1000", expected: http.StatusOK},
+		{name: "gateway deny list", path: "/api/users/users", remoteAddr: "203.0.113.66:1000", expected: http.StatusForbidden},
+		{name: "deny file", path: "/api/users/users", remoteAddr: "198.51.100.20:1000", expected: http.StatusForbidden},
+		{name: "deny file ipv6", path: "/api/users/users", remoteAddr: "[2001:db8:bad::1]:1000", expected: http.StatusForbidden},
+		{name: "service allow list", path: "/api/auth/admin", remoteAddr: "192.0.2.10:1000", expected: http.StatusOK},
+		{name: "service allow list ipv6", path: "/api/auth/admin", remoteAddr: "[2001:db8:1::7]:1000", expected: http.StatusOK},
+		{name: "outside service allow list", path: "/api/auth/admin", remoteAddr: "203.0.113.9:1000", expected: http.StatusForbidden},
+		{name: "deny wins over allow", path: "/api/auth/admin", remoteAddr: "192.0.2.13:1000", expected: http.StatusForbidden},
+		{name: "client behind trusted proxy", path: "/api/auth/admin", remoteAddr: "10.0.0.1:1000", forwardedFor: "192.0.2.10", expected: http.StatusOK},
+		{name: "blocked client behind trusted proxy", path: "/api/users/users", remoteAddr: "10.0.0.1:1000", forwardedFor: "198.51.100.20", expected: http.StatusForbidden},
+		{name: "forwarding header from untrusted peer", path: "/api/auth/admin", remoteAddr: "203.0.113.9:1000", forwardedFor: "192.0.2.10", expected: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Request-ID", "ip-filter-test")
+			req.Header.Set("x-api-key", "test-key")
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			w := httptest.NewRecorder()
+			gateway.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("expected %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+			if tt.expected == http.StatusForbidden && w.Header().Get("Content-Type") != problem.ContentType {
+				t.Errorf("expected problem details, got %q", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+
+	if ipFilterRejections.Value("auth", "allow") == 0 || ipFilterRejections.Value("gateway", "deny") == 0 {
+		t.Error("expected rejections to be counted by scope and list")
+	}
+}
+
+func TestIPFilterAppliesToAggregateCalls(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer backend.Close()
+
+	appConfig := config.AppConfig{
+		AllowedApiKey: "test-key",
+		KnownServices: map[string]string{"users": backend.URL, "auth": backend.URL},
+		Services: map[string]config.ServiceConfig{
+			"auth": {IPFilter: &config.IPFilterConfig{Allow: []string{"192.0.2.0/24"}}},
+		},
+		Aggregates: map[string]config.AggregateConfig{
+			"home": {Calls: []config.AggregateCall{
+				{Key: "users", Service: "users", Path: "/users", Required: true},
+				{Key: "session", Service: "auth", Path: "/auth/status"},
+			}},
+			"login": {Calls: []config.AggregateCall{
+				{Key: "session", Service: "auth", Path: "/auth/status", Required: true},
+			}},
+		},
+	}
+	forwarder := usecase.NewApiGatewayService(appConfig, nil)
+	aggregates := map[string]usecase.RequestForwarder{}
+	for name := range appConfig.Aggregates {
+		aggregate, err := usecase.NewAggregateService(appConfig, name, forwarder)
+		if err != nil {
+			t.Fatalf("failed to build aggregate: %v", err)
+		}
+		aggregates[name] = aggregate
+	}
+	s := &Server{appConfig: appConfig, apiGatewayService: usecase.NewForwarderDispatcher(forwarder, aggregates)}
+	gateway := s.RegisterRoutes()
+
+	tests := []struct {
+		name       string
+		path       string
+		remoteAddr string
+		expected   int
+		contains   string
+	}{
+		{name: "allowed client", path: "/api/home/", remoteAddr: "192.0.2.10:1000", expected: http.StatusOK, contains: `"session":{"ok":true}`},
+		{name: "optional call blocked", path: "/api/home/", remoteAddr: "203.0.113.9:1000", expected: http.StatusOK, contains: `"session":"call to service 'auth' was refused with 403"`},
+		{name: "required call blocked", path: "/api/login/", remoteAddr: "203.0.113.9:1000", expected: http.StatusBadGateway, contains: `"code":"aggregate_call_failed"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Request-ID", "ip-filter-test")
+			req.Header.Set("x-api-key", "test-key")
+			w := httptest.NewRecorder()
+			gateway.ServeHTTP(w, req)
+
+			if w.Code != tt.expected || !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("expected %d with %s, got %d: %s", tt.expected, tt.contains, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+// minRateBody fails reads once a client has sent its body at a lower
+// average rate than required. The clock starts at the first read, so time
+// the gateway spends before reading the body does not count against the
+// client. Where the connection supports it, each read is given a deadline
+// so a client that stops sending altogether is cut off too.
+type minRateBody struct {
+	io.ReadCloser
+	rc    *http.ResponseController
+	info  *requestctx.Info
+	rate  int64
+	grace time.Duration
+
+	start     time.Time
+	read      int64
+	deadlines bool
+	done      bool
+	err       error
+}
+
+func newMinRateBody(w http.ResponseWriter, r *http.Request, rate int64, grace time.Duration) *minRateBody {
+	return &minRateBody{
+		ReadCloser: r.Body,
+		rc:         http.NewResponseController(w),
+		info:       requestctx.From(r.Context()),
+		rate:       rate,
+		grace:      grace,
+		deadlines:  true,
+	}
+}
+
+// deadline is when the bytes read so far are due at the minimum rate, plus
+// the grace period
+func (b *minRateBody) deadline() time.Time {
+	due := time.Duration(float64(b.read) / float64(b.rate) * float64(time.Second))
+	return b.start.Add(b.grace + due)
+}
+
+func (b *minRateBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.done {
+		return b.ReadCloser.Read(p)
+	}
+	if b.start.IsZero() {
+		b.start = time.Now()
+	}
+
+	deadline := b.deadline()
+	if time.Now().After(deadline) {
+		return 0, b.tooSlow()
+	}
+	if b.deadlines {
+		if err := b.rc.SetReadDeadline(deadline); err != nil {
+			// e.g. http.ErrNotSupported; the rate is still checked per read
+			b.deadlines = false
+		}
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, b.tooSlow()
+	}
+	if err != nil {
+		// The server clears the read deadline itself once the body is done
+		b.done = true
+	}
+	return n, err
+}
+
+func (b *minRateBody) tooSlow() error {
+	b.err = fmt.Errorf("%w: %d bytes in %v, at least %d bytes/s required",
+		usecase.ErrSlowRequestBody, b.read, time.Since(b.start).Round(time.Millisecond), b.rate)
+	b.info.SetBodyError(b.err)
+	return b.err
+}
+
+// liftGRPCDeadlines replaces the server's ReadTimeout and WriteTimeout for a
+// gRPC call with the streaming max_duration, or no deadline at all. Over
+// HTTP/2 they apply to each stream, and client-streaming and bidirectional
+// calls may stay open for as long as the call lasts.
+func (s *Server) liftGRPCDeadlines(w http.ResponseWriter, r *http.Request) {
+	var deadline time.Time
+	if maxDuration := s.appConfig.Streaming.MaxDuration.Duration; maxDuration > 0 {
+		deadline = time.Now().Add(maxDuration)
+	}
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		log.Printf("[%s] Failed to lift read deadline for gRPC call: %v", r.Header.Get("X-Request-ID"), err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		log.Printf("[%s] Failed to lift write deadline for gRPC call: %v", r.Header.Get("X-Request-ID"), err)
+	}
+}
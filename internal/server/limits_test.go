@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+func newLimitedGateway(t *testing.T, limits config.RequestLimitsConfig) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	forwarded := new(atomic.Int32)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+
+	appConfig := config.AppConfig{
+		AllowedApiKey: "test-key",
+		KnownServices: map[string]string{"users": backend.URL},
+		Server:        config.ServerConfig{Limits: limits},
+		Services: map[string]config.ServiceConfig{"users": {Routes: []config.RouteConfig{
+			{PathPrefix: "/avatars", MaxBodyBytes: 4096},
+		}}},
+	}
+	s := &Server{appConfig: appConfig, apiGatewayService: usecase.NewApiGatewayService(appConfig, nil)}
+	gateway := httptest.NewServer(s.RegisterRoutes())
+	t.Cleanup(gateway.Close)
+	return gateway, forwarded
+}
+
+func sendLimited(t *testing.T, method, url string, body io.Reader) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, body)
+	req.Header.Set("X-Request-ID", "limits-test")
+	req.Header.Set("x-api-key", "test-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestRequestLimits(t *testing.T) {
+	gateway, forwarded := newLimitedGateway(t, config.RequestLimitsConfig{
+		MaxURLLength:          256,
+		MaxQueryParams:        3,
+		MaxBodyBytes:          1024,
+		MinBodyBytesPerSecond: -1,
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     io.Reader
+		expected int
+		reaches  bool
+	}{
+		{name: "within limits", method: http.MethodPost, path: "/api/users/users?a=1&b=2", body: strings.NewReader("{}"), expected: http.StatusOK, reaches: true},
+		{name: "long url", method: http.MethodGet, path: "/api/users/" + strings.Repeat("x", 300), expected: http.StatusRequestURITooLong},
+		{name: "many query parameters", method: http.MethodGet, path: "/api/users/users?a=1&b=2&c=3&d=4", expected: http.StatusBadRequest},
+		{name: "declared body too large", method: http.MethodPost, path: "/api/users/users", body: strings.NewReader(strings.Repeat("x", 2048)), expected: http.StatusRequestEntityTooLarge},
+		{name: "route allows larger bodies", method: http.MethodPost, path: "/api/users/avatars", body: strings.NewReader(strings.Repeat("x", 2048)), expected: http.StatusOK, reaches: true},
+		// Hiding the length forces a chunked body that is only caught while
+		// it is being forwarded
+		{name: "chunked body too large", method: http.MethodPost, path: "/api/users/users", body: io.MultiReader(strings.NewReader(strings.Repeat("x", 2048))), expected: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := forwarded.Load()
+			resp := sendLimited(t, tt.method, gateway.URL+tt.path, tt.body)
+			if resp.StatusCode != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, resp.StatusCode)
+			}
+			if tt.reaches && forwarded.Load() != before+1 {
+				t.Error("expected the request to reach the backend")
+			}
+			if !tt.reaches && tt.expected != http.StatusRequestEntityTooLarge && forwarded.Load() != before {
+				t.Error("expected the request to be rejected before the backend")
+			}
+		})
+	}
+}
+
+func TestSlowRequestBodyIsRejected(t *testing.T) {
+	gateway, _ := newLimitedGateway(t, config.RequestLimitsConfig{
+		MinBodyBytesPerSecond: 100,
+		MinBodyRateGrace:      config.Duration{Duration: 200 * time.Millisecond},
+	})
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(gateway.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "POST /api/users/users HTTP/1.1\r\nHost: gateway\r\nX-Request-ID: slow\r\nx-api-key: test-key\r\nContent-Length: 1000\r\n\r\n")
+	// A byte every 100ms is about 10 bytes/s, well below the minimum
+	go func() {
+		for range 50 {
+			if _, err := conn.Write([]byte("x")); err != nil {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("expected a response, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("expected 408 for a slow body, got %d", resp.StatusCode)
+	}
+}
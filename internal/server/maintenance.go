@@ -0,0 +1,196 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/grpcstatus"
+	"github.com/LucianoBarrera/api-gateway/internal/ipfilter"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+)
+
+var maintenanceRequests = metrics.NewCounter("gateway_maintenance_requests_total",
+	"Requests to services in maintenance mode, by service and whether they were turned away or let through", "service", "result")
+
+// maintenancePolicy is a compiled config.MaintenanceConfig: how requests
+// are answered during a maintenance and who still gets through
+type maintenancePolicy struct {
+	config         config.MaintenanceConfig
+	body           []byte
+	allowIPs       *ipfilter.Set
+	allowConsumers map[string]bool
+}
+
+func newMaintenancePolicy(name string, cfg *config.MaintenanceConfig) *maintenancePolicy {
+	if cfg == nil {
+		return nil
+	}
+	policy := &maintenancePolicy{config: *cfg, allowConsumers: map[string]bool{}}
+	body, err := cfg.ResponseBody()
+	if err != nil {
+		// Refused at config load; fall back to problem details if it gets here anyway
+		log.Printf("Ignoring the maintenance body of %s: %v", name, err)
+	} else if body != nil {
+		policy.body = body
+	}
+	if policy.allowIPs, err = ipfilter.Parse(cfg.AllowIPs); err != nil {
+		log.Printf("Ignoring the maintenance allow_ips of %s: %v", name, err)
+	}
+	for _, consumer := range cfg.AllowConsumers {
+		policy.allowConsumers[consumer] = true
+	}
+	return policy
+}
+
+// allows reports whether a request is let through during the maintenance
+func (p *maintenancePolicy) allows(info *requestctx.Info) bool {
+	if p == nil {
+		return false
+	}
+	if consumer := info.Consumer(); consumer != "" && p.allowConsumers[consumer] {
+		return true
+	}
+	client, err := netip.ParseAddr(info.ClientIP())
+	return err == nil && p.allowIPs.Contains(client)
+}
+
+// write answers a request turned away by the maintenance. Retry-After
+// points at the end of the maintenance when it is known.
+func (p *maintenancePolicy) write(w http.ResponseWriter, r *http.Request, detail string, until time.Time) {
+	var cfg config.MaintenanceConfig
+	var body []byte
+	if p != nil {
+		cfg, body = p.config, p.body
+	}
+	retryAfter := cfg.RetryAfterDuration()
+	if !until.IsZero() {
+		retryAfter = time.Until(until)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+
+	if body != nil && !grpcstatus.IsGRPCRequest(r) {
+		w.Header().Set("Content-Type", cfg.ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if _, err := w.Write(body); err != nil {
+			log.Printf("Failed to write response: %v", err)
+		}
+		return
+	}
+	details := problem.New(problem.ServiceMaintenance, detail)
+	if !until.IsZero() {
+		details = details.With("until", until.UTC().Format(time.RFC3339))
+	}
+	problem.Write(w, r, details)
+}
+
+// maintenanceGate turns away requests to services in maintenance mode,
+// whether set in the config or through the admin API. The whole gateway's
+// maintenance applies to every service; a service's own policy replaces
+// the gateway's.
+type maintenanceGate struct {
+	gateway  *maintenancePolicy
+	services map[string]*maintenancePolicy
+	controls *Controls
+}
+
+func (s *Server) newMaintenanceGate() *maintenanceGate {
+	gate := &maintenanceGate{
+		gateway:  newMaintenancePolicy("the gateway", s.appConfig.Maintenance),
+		services: map[string]*maintenancePolicy{},
+		controls: s.controls,
+	}
+	for serviceName, serviceConfig := range s.appConfig.Services {
+		if serviceConfig.Maintenance != nil {
+			gate.services[serviceName] = newMaintenancePolicy("service '"+serviceName+"'", serviceConfig.Maintenance)
+		}
+	}
+	return gate
+}
+
+// maintenanceStatus is whether a service is in maintenance mode and why
+type maintenanceStatus struct {
+	active bool
+	// service is set when the service itself is in maintenance, rather
+	// than the whole gateway
+	service bool
+	// until is when the maintenance ends, zero when it is not known
+	until time.Time
+}
+
+// status reports whether serviceName is in maintenance mode at now
+func (g *maintenanceGate) status(serviceName string, now time.Time) maintenanceStatus {
+	var status maintenanceStatus
+	openEnded := false
+	add := func(active bool, until time.Time, service bool) {
+		if !active {
+			return
+		}
+		status.active = true
+		status.service = status.service || service
+		if until.IsZero() {
+			openEnded = true
+		} else if until.After(status.until) {
+			status.until = until
+		}
+	}
+
+	if m, ok := g.controls.maintenanceOf(serviceName, now); ok {
+		add(true, m.Until, true)
+	}
+	if m, ok := g.controls.maintenanceOf("", now); ok {
+		add(true, m.Until, false)
+	}
+	if policy := g.services[serviceName]; policy != nil {
+		active, until := policy.config.ActiveAt(now)
+		add(active, until, true)
+	}
+	if g.gateway != nil {
+		active, until := g.gateway.config.ActiveAt(now)
+		add(active, until, false)
+	}
+	if openEnded {
+		status.until = time.Time{}
+	}
+	return status
+}
+
+// policy returns the maintenance policy that applies to a service
+func (g *maintenanceGate) policy(serviceName string) *maintenancePolicy {
+	if policy := g.services[serviceName]; policy != nil {
+		return policy
+	}
+	return g.gateway
+}
+
+// turnsAway answers the request and returns true if its service is in
+// maintenance mode and the client is not on the allowlist
+func (g *maintenanceGate) turnsAway(w http.ResponseWriter, r *http.Request, serviceName string) bool {
+	if g == nil {
+		return false
+	}
+	status := g.status(serviceName, time.Now())
+	if !status.active {
+		return false
+	}
+	policy := g.policy(serviceName)
+	if policy.allows(requestctx.From(r.Context())) {
+		maintenanceRequests.Inc(serviceName, "allowed")
+		return false
+	}
+	maintenanceRequests.Inc(serviceName, "rejected")
+	detail := "The gateway is under maintenance"
+	if status.service {
+		detail = fmt.Sprintf("Service '%s' is under maintenance", serviceName)
+	}
+	policy.write(w, r, detail, status.until)
+	return true
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/upstream"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+// newMaintenanceTestRoutes builds the routes for the users and auth
+// services, both served by one backend
+func newMaintenanceTestRoutes(t *testing.T, appConfig config.AppConfig, controls *Controls) http.Handler {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+
+	appConfig.AllowedApiKey = "test-key"
+	appConfig.Consumers = []config.ConsumerConfig{{ID: "qa", APIKey: "qa-key"}}
+	appConfig.KnownServices = map[string]string{"users": backend.URL, "auth": backend.URL}
+	s := &Server{appConfig: appConfig, apiGatewayService: usecase.NewApiGatewayService(appConfig, nil), controls: controls}
+	return s.RegisterRoutes()
+}
+
+func sendMaintenanceRequest(handler http.Handler, path, apiKey, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-Request-ID", "maintenance-test")
+	req.Header.Set("x-api-key", apiKey)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func retryAfter(t *testing.T, w *httptest.ResponseRecorder) int {
+	t.Helper()
+	seconds, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil {
+		t.Fatalf("expected a Retry-After in seconds, got %q", w.Header().Get("Retry-After"))
+	}
+	return seconds
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	now := time.Now()
+	handler := newMaintenanceTestRoutes(t, config.AppConfig{
+		Maintenance: &config.MaintenanceConfig{Windows: []config.MaintenanceWindow{
+			{Start: now.Add(-time.Hour), End: now.Add(time.Hour)},
+		}},
+		Services: map[string]config.ServiceConfig{"auth": {Maintenance: &config.MaintenanceConfig{
+			Windows: []config.MaintenanceWindow{{Start: now.Add(-time.Hour), End: now.Add(2 * time.Hour)}},
+		}}},
+	}, nil)
+
+	w := sendMaintenanceRequest(handler, "/api/users/users", "test-key", "192.0.2.1:1234")
+	if w.Code != http.StatusServiceUnavailable || problemCode(t, w) != problem.ServiceMaintenance.Code {
+		t.Fatalf("expected the gateway's window to apply to every service, got %d", w.Code)
+	}
+	if seconds := retryAfter(t, w); seconds < 3500 || seconds > 3600 {
+		t.Errorf("expected Retry-After to point at the end of the window, got %d", seconds)
+	}
+	if !strings.Contains(w.Body.String(), `"until"`) || !strings.Contains(w.Body.String(), "The gateway is under maintenance") {
+		t.Errorf("unexpected body %s", w.Body.String())
+	}
+
+	// The latest end of the maintenances that apply wins
+	w = sendMaintenanceRequest(handler, "/api/auth/login", "test-key", "192.0.2.1:1234")
+	if seconds := retryAfter(t, w); seconds < 7100 || !strings.Contains(w.Body.String(), "Service 'auth'") {
+		t.Errorf("expected the service's own window, got Retry-After %d: %s", seconds, w.Body.String())
+	}
+
+	future := newMaintenanceTestRoutes(t, config.AppConfig{
+		Maintenance: &config.MaintenanceConfig{Windows: []config.MaintenanceWindow{
+			{Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)},
+		}},
+	}, nil)
+	if w := sendMaintenanceRequest(future, "/api/users/users", "test-key", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected a future window not to apply yet, got %d", w.Code)
+	}
+}
+
+func TestMaintenancePolicy(t *testing.T) {
+	handler := newMaintenanceTestRoutes(t, config.AppConfig{
+		Services: map[string]config.ServiceConfig{"users": {Maintenance: &config.MaintenanceConfig{
+			Enabled:        true,
+			RetryAfter:     config.Duration{Duration: 2 * time.Minute},
+			ContentType:    "text/html; charset=utf-8",
+			Body:           "<h1>Back soon</h1>",
+			AllowConsumers: []string{"qa"},
+			AllowIPs:       []string{"10.0.0.0/8"},
+		}}},
+	}, nil)
+
+	w := sendMaintenanceRequest(handler, "/api/users/users", "test-key", "192.0.2.1:1234")
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "<h1>Back soon</h1>" || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("expected the configured page, got %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	if seconds := retryAfter(t, w); seconds != 120 {
+		t.Errorf("expected the configured Retry-After, got %d", seconds)
+	}
+
+	if w := sendMaintenanceRequest(handler, "/api/users/users", "qa-key", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected an allowed consumer to get through, got %d", w.Code)
+	}
+	if w := sendMaintenanceRequest(handler, "/api/users/users", "test-key", "10.1.2.3:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected an allowed address to get through, got %d", w.Code)
+	}
+	if w := sendMaintenanceRequest(handler, "/api/auth/login", "test-key", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected other services to stay up, got %d", w.Code)
+	}
+}
+
+func TestRuntimeMaintenance(t *testing.T) {
+	controls := NewControls(upstream.NewRegistry())
+	handler := newMaintenanceTestRoutes(t, config.AppConfig{
+		Maintenance: &config.MaintenanceConfig{AllowConsumers: []string{"qa"}},
+	}, controls)
+
+	controls.SetMaintenance("", time.Now().Add(10*time.Minute))
+	w := sendMaintenanceRequest(handler, "/api/auth/login", "test-key", "192.0.2.1:1234")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the whole gateway to be in maintenance, got %d", w.Code)
+	}
+	if seconds := retryAfter(t, w); seconds < 590 || seconds > 600 {
+		t.Errorf("expected Retry-After to point at the end, got %d", seconds)
+	}
+	if w := sendMaintenanceRequest(handler, "/api/auth/login", "qa-key", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected the configured allowlist to apply, got %d", w.Code)
+	}
+
+	// An open-ended maintenance has no known end
+	controls.SetMaintenance("auth", time.Time{})
+	w = sendMaintenanceRequest(handler, "/api/auth/login", "test-key", "192.0.2.1:1234")
+	if seconds := retryAfter(t, w); seconds != int(config.DefaultMaintenanceRetryAfter.Seconds()) {
+		t.Errorf("expected the default Retry-After, got %d", seconds)
+	}
+
+	controls.ClearMaintenance("")
+	controls.ClearMaintenance("auth")
+	if w := sendMaintenanceRequest(handler, "/api/auth/login", "test-key", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected the maintenance to be over, got %d", w.Code)
+	}
+
+	controls.SetMaintenance("users", time.Now().Add(-time.Second))
+	if w := sendMaintenanceRequest(handler, "/api/users/users", "test-key", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected an expired maintenance to be over, got %d", w.Code)
+	}
+}
+
+func TestMaintenanceAppliesToAggregateCalls(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer users.Close()
+	var authCalls atomic.Int32
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authCalls.Add(1)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer auth.Close()
+
+	appConfig := config.AppConfig{
+		AllowedApiKey: "test-key",
+		Consumers:     []config.ConsumerConfig{{ID: "qa", APIKey: "qa-key"}},
+		KnownServices: map[string]string{"users": users.URL, "auth": auth.URL},
+		Maintenance:   &config.MaintenanceConfig{AllowConsumers: []string{"qa"}},
+		Aggregates: map[string]config.AggregateConfig{
+			"home": {Calls: []config.AggregateCall{
+				{Key: "users", Service: "users", Path: "/users", Required: true},
+				{Key: "session", Service: "auth", Path: "/auth/status"},
+			}},
+			"login": {Calls: []config.AggregateCall{
+				{Key: "session", Service: "auth", Path: "/auth/status", Required: true},
+			}},
+		},
+	}
+	forwarder := usecase.NewApiGatewayService(appConfig, nil)
+	aggregates := map[string]usecase.RequestForwarder{}
+	for name := range appConfig.Aggregates {
+		aggregate, err := usecase.NewAggregateService(appConfig, name, forwarder)
+		if err != nil {
+			t.Fatalf("failed to build aggregate: %v", err)
+		}
+		aggregates[name] = aggregate
+	}
+	controls := NewControls(upstream.NewRegistry())
+	s := &Server{appConfig: appConfig, apiGatewayService: usecase.NewForwarderDispatcher(forwarder, aggregates), controls: controls}
+	handler := s.RegisterRoutes()
+
+	controls.SetMaintenance("auth", time.Now().Add(10*time.Minute))
+
+	w := sendMaintenanceRequest(handler, "/api/home/", "test-key", "192.0.2.1:1234")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"session":"call to service 'auth' was refused with 503"`) {
+		t.Errorf("expected the optional call to fail, got %d: %s", w.Code, w.Body.String())
+	}
+	w = sendMaintenanceRequest(handler, "/api/login/", "test-key", "192.0.2.1:1234")
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), `"code":"aggregate_call_failed"`) {
+		t.Errorf("expected the required call to fail the aggregate, got %d: %s", w.Code, w.Body.String())
+	}
+	if calls := authCalls.Load(); calls != 0 {
+		t.Errorf("expected no calls to reach the service in maintenance, got %d", calls)
+	}
+
+	// The allowlist applies to aggregate calls as it does to client requests
+	if w := sendMaintenanceRequest(handler, "/api/login/", "qa-key", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected an allowed consumer to get through, got %d: %s", w.Code, w.Body.String())
+	}
+}
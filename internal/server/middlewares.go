@@ -1,16 +1,43 @@
 package server
 
 import (
+	"bufio"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/grpcstatus"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
 )
 
-// responseWriter wraps http.ResponseWriter to capture status code
+var requestsTotal = metrics.NewCounter("gateway_requests_total",
+	"Requests routed to a service, by service, version and status class", "service", "version", "status")
+
+// statusClass groups status codes for metrics, e.g. 404 becomes "4xx"
+func statusClass(statusCode int) string {
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// maxLoggedBodyBytes bounds how much of a response body is kept in memory for
+// error logging, so streamed and large responses are never buffered
+const maxLoggedBodyBytes = 500
+
+// responseWriter wraps http.ResponseWriter to capture status code, response
+// size and a bounded prefix of the body
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
 	body       []byte
+	size       int64
+	upgradeCfg config.WebSocketConfig
+	controls   *Controls
 }
 
 func (rw *responseWriter) WriteHeader(statusCode int) {
@@ -19,11 +46,43 @@ func (rw *responseWriter) WriteHeader(statusCode int) {
 }
 
 func (rw *responseWriter) Write(data []byte) (int, error) {
-	rw.body = append(rw.body, data...)
-	return rw.ResponseWriter.Write(data)
+	if remaining := maxLoggedBodyBytes - len(rw.body); remaining > 0 {
+		rw.body = append(rw.body, data[:min(remaining, len(data))]...)
+	}
+	n, err := rw.ResponseWriter.Write(data)
+	rw.size += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher so streamed responses are not held back by the wrapper
+func (rw *responseWriter) Flush() {
+	if err := http.NewResponseController(rw.ResponseWriter).Flush(); err != nil {
+		log.Printf("Failed to flush response: %v", err)
+	}
+}
+
+// Hijack implements http.Hijacker so the reverse proxy can switch protocols
+// (e.g. WebSockets). The returned connection enforces the upgrade timeouts.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The proxy writes the 101 response straight to the hijacked connection
+	rw.statusCode = http.StatusSwitchingProtocols
+
+	return newUpgradedConn(conn, rw.upgradeCfg, rw.controls), brw, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
 }
 
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
+	clientIPs := s.clientIPResolver()
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
@@ -37,10 +96,17 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 		log.Printf("[%s] %s %s - User-Agent: %s - Remote: %s",
 			requestID, r.Method, r.URL.String(), r.UserAgent(), r.RemoteAddr)
 
+		// Collect what later handlers learn about the request for reporting
+		ctx, info := requestctx.New(r.Context(), requestID)
+		info.SetClientIP(clientIPs.resolve(r))
+		r = r.WithContext(ctx)
+
 		// Wrap response writer to capture status and body
 		rw := &responseWriter{
 			ResponseWriter: w,
 			statusCode:     http.StatusOK, // Default status code
+			upgradeCfg:     s.appConfig.WebSocket,
+			controls:       s.controls,
 		}
 
 		// Process the request
@@ -50,26 +116,108 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 		duration := time.Since(start)
 
 		// Log response details with structured format
-		log.Printf("[%s] %s %s - Status: %d (%s) - Duration: %v - Size: %d bytes",
-			requestID, r.Method, r.URL.Path, rw.statusCode, http.StatusText(rw.statusCode), duration, len(rw.body))
+		routing := ""
+		if service := info.Service(); service != "" {
+			routing = " - Service: " + service
+			if version := info.Version(); version != "" {
+				routing += " - Version: " + version
+			}
+			requestsTotal.Inc(service, info.Version(), statusClass(rw.statusCode))
+		}
+		if consumer := info.Consumer(); consumer != "" {
+			routing += " - Consumer: " + consumer
+		}
+		log.Printf("[%s] %s %s - Status: %d (%s) - Duration: %v - Size: %d bytes%s",
+			requestID, r.Method, r.URL.Path, rw.statusCode, http.StatusText(rw.statusCode), duration, rw.size, routing)
 
 		// Log response body (truncated if too long) only for errors
 		if rw.statusCode >= 400 && len(rw.body) > 0 {
 			bodyPreview := string(rw.body)
-			if len(bodyPreview) > 500 {
-				bodyPreview = bodyPreview[:500] + "... (truncated)"
+			if rw.size > int64(len(rw.body)) {
+				bodyPreview += "... (truncated)"
 			}
 			log.Printf("[%s] Error response body: %s", requestID, bodyPreview)
 		}
 	})
 }
 
+// remoteIP strips the port from a request's RemoteAddr
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+var limitRejections = metrics.NewCounter("gateway_request_limit_rejections_total",
+	"Requests rejected for breaking the request limits, by limit", "limit")
+
+// requestLimitsMiddleware rejects requests whose URL, query or declared body
+// is too large, and bounds the size and transfer rate of request bodies.
+// gRPC streams are exempt from the body limits and the server's timeouts,
+// since they may stay open and idle for as long as the call lasts.
+func (s *Server) requestLimitsMiddleware(next http.Handler) http.Handler {
+	limits := s.appConfig.Server.Limits
+	rate, grace := limits.MinBodyRate()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.RequestURI) > limits.URLLength() {
+			limitRejections.Inc("url_length")
+			writeErrorResponse(w, r, problem.URLTooLong, fmt.Sprintf("Request URL exceeds %d bytes", limits.URLLength()))
+			return
+		}
+		if count := countQueryParams(r.URL.RawQuery); count > limits.QueryParams() {
+			limitRejections.Inc("query_params")
+			writeErrorResponse(w, r, problem.TooManyQueryParams, fmt.Sprintf("Request has %d query parameters, at most %d are allowed", count, limits.QueryParams()))
+			return
+		}
+
+		if grpcstatus.IsGRPCRequest(r) {
+			s.liftGRPCDeadlines(w, r)
+		} else if r.Body != nil && r.Body != http.NoBody {
+			limit := s.bodyLimit(r)
+			if r.ContentLength > limit {
+				limitRejections.Inc("body_size")
+				writeErrorResponse(w, r, problem.BodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", limit))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			if rate > 0 {
+				r.Body = newMinRateBody(w, r, rate, grace)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyLimit returns the body limit of the route a request is for
+func (s *Server) bodyLimit(r *http.Request) int64 {
+	if serviceName, ok := serviceFromPath(r.URL.Path); ok {
+		return s.appConfig.BodyLimit(serviceName, r.Method, strings.TrimPrefix(r.URL.Path, "/api/"+serviceName))
+	}
+	return s.appConfig.Server.Limits.BodyBytes()
+}
+
+// countQueryParams counts the parameters of a raw query string without
+// decoding it
+func countQueryParams(rawQuery string) int {
+	count := 0
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param != "" {
+			count++
+		}
+	}
+	return count
+}
+
 func (s *Server) requestValidationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check if X-Request-ID header is present
 		requestID := r.Header.Get("X-Request-ID")
 		if requestID == "" {
-			writeErrorResponse(w, http.StatusBadRequest, "X-Request-ID header is missing")
+			writeErrorResponse(w, r, problem.MissingRequestID, "X-Request-ID header is missing")
 			return
 		}
 
@@ -83,13 +231,15 @@ func (s *Server) basicAuthMiddleware(next http.Handler) http.Handler {
 		// Check if x-api-key header is present
 		apiKey := r.Header.Get("x-api-key")
 		if apiKey == "" {
-			writeErrorResponse(w, http.StatusUnauthorized, "x-api-key header is missing")
+			writeErrorResponse(w, r, problem.MissingAPIKey, "x-api-key header is missing")
 			return
 		}
 
-		// Validate the API key
-		if apiKey != s.appConfig.AllowedApiKey {
-			writeErrorResponse(w, http.StatusUnauthorized, "Invalid API key")
+		// Validate the API key, which is either the shared key or a consumer's own
+		if consumer, ok := s.appConfig.ConsumerForKey(apiKey); ok {
+			requestctx.From(r.Context()).SetConsumer(consumer.ID)
+		} else if apiKey != s.appConfig.AllowedApiKey {
+			writeErrorResponse(w, r, problem.InvalidAPIKey, "Invalid API key")
 			return
 		}
 
@@ -97,22 +247,3 @@ func (s *Server) basicAuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-
-func (s *Server) corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*") // Replace "*" with specific origins if needed
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token, X-Request-ID")
-		w.Header().Set("Access-Control-Allow-Credentials", "false") // Set to "true" if credentials are required
-
-		// Handle preflight OPTIONS requests
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-
-		// Proceed with the next handler
-		next.ServeHTTP(w, r)
-	})
-}
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
 	"github.com/LucianoBarrera/api-gateway/internal/usecase"
 )
 
@@ -48,13 +49,13 @@ func TestBasicAuthMiddleware(t *testing.T) {
 			name:           "Missing API Key",
 			apiKey:         "",
 			expectedStatus: http.StatusUnauthorized,
-			expectedBody:   `{"error":"x-api-key header is missing"}`,
+			expectedBody:   `{"type":"urn:api-gateway:problem:missing_api_key","title":"Missing API key","status":401,"detail":"x-api-key header is missing","instance":"/test","code":"missing_api_key"}`,
 		},
 		{
 			name:           "Invalid API Key",
 			apiKey:         "wrong-api-key",
 			expectedStatus: http.StatusUnauthorized,
-			expectedBody:   `{"error":"Invalid API key"}`,
+			expectedBody:   `{"type":"urn:api-gateway:problem:invalid_api_key","title":"Invalid API key","status":401,"detail":"Invalid API key","instance":"/test","code":"invalid_api_key"}`,
 		},
 	}
 
@@ -80,6 +81,31 @@ func TestBasicAuthMiddleware(t *testing.T) {
 	}
 }
 
+func TestBasicAuthMiddlewareIdentifiesConsumers(t *testing.T) {
+	appConfig := config.AppConfig{
+		AllowedApiKey: "test-api-key",
+		Consumers:     []config.ConsumerConfig{{ID: "mobile-app", APIKey: "mobile-key"}},
+	}
+	server := &Server{appConfig: appConfig}
+
+	var consumer string
+	handler := server.loggingMiddleware(server.basicAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		consumer = requestctx.From(r.Context()).Consumer()
+	})))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("x-api-key", "mobile-key")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if consumer != "mobile-app" {
+		t.Errorf("Expected consumer 'mobile-app', got '%s'", consumer)
+	}
+}
+
 func TestLoggingMiddleware(t *testing.T) {
 	// Create a test server with logging middleware
 	appConfig := config.AppConfig{
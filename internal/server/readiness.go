@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/upstream"
+)
+
+// readinessReport is the outcome of the readiness checks. The admin API
+// shows it whole; GET /readiness, which anyone can call, only the outcome
+// of each check.
+type readinessReport struct {
+	Ready  bool             `json:"ready"`
+	Checks []readinessCheck `json:"checks"`
+}
+
+// readinessCheck is one of the conditions the gateway needs to be ready
+type readinessCheck struct {
+	Name    string            `json:"name"`
+	Ready   bool              `json:"ready"`
+	Detail  string            `json:"detail,omitempty"`
+	Targets []targetReadiness `json:"targets,omitempty"`
+}
+
+// targetReadiness is whether one target of a critical service can take
+// requests
+type targetReadiness struct {
+	URL       string         `json:"url"`
+	State     upstream.State `json:"state"`
+	Healthy   bool           `json:"healthy"`
+	Reachable bool           `json:"reachable"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// probeResult is the outcome of a connection attempt to a target
+type probeResult struct {
+	at  time.Time
+	err error
+}
+
+// readinessChecker decides whether the gateway should be sent traffic:
+// not while it warms up after starting, not once it is draining for
+// shutdown, and not while a critical service has no target that is
+// active, passively healthy and accepting connections. Connection attempts
+// are reused for the probe interval so frequent polling does not turn into
+// a connection storm against the upstreams.
+type readinessChecker struct {
+	appConfig config.AppConfig
+	controls  *Controls
+	dial      func(ctx context.Context, network, address string) (net.Conn, error)
+
+	mu     sync.Mutex
+	probes map[string]probeResult // by target URL
+}
+
+func (s *Server) newReadinessChecker() *readinessChecker {
+	var dialer net.Dialer
+	return &readinessChecker{
+		appConfig: s.appConfig,
+		controls:  s.controls,
+		dial:      dialer.DialContext,
+		probes:    map[string]probeResult{},
+	}
+}
+
+// check runs every readiness check at now
+func (c *readinessChecker) check(ctx context.Context, now time.Time) readinessReport {
+	report := readinessReport{Ready: true}
+	add := func(check readinessCheck) {
+		report.Ready = report.Ready && check.Ready
+		report.Checks = append(report.Checks, check)
+	}
+
+	warmUp := readinessCheck{Name: "warm_up", Ready: true}
+	if startedAt := c.controls.StartedAt(); !startedAt.IsZero() {
+		if remaining := startedAt.Add(c.appConfig.Readiness.WarmUp.Duration).Sub(now); remaining > 0 {
+			warmUp.Ready = false
+			warmUp.Detail = fmt.Sprintf("Warming up for another %s", remaining.Round(time.Second))
+		}
+	}
+	add(warmUp)
+
+	shutdown := readinessCheck{Name: "shutdown", Ready: !c.controls.Draining()}
+	if !shutdown.Ready {
+		shutdown.Detail = "Draining requests in flight before shutting down"
+	}
+	add(shutdown)
+
+	for _, serviceName := range c.appConfig.Readiness.CriticalServices {
+		add(c.checkService(ctx, serviceName, now))
+	}
+	return report
+}
+
+// checkService reports whether a critical service has a target that can
+// take requests
+func (c *readinessChecker) checkService(ctx context.Context, serviceName string, now time.Time) readinessCheck {
+	check := readinessCheck{Name: "service:" + serviceName}
+	for url, users := range c.appConfig.UpstreamTargets() {
+		for _, user := range users {
+			if user == serviceName || strings.HasPrefix(user, serviceName+"/") {
+				check.Targets = append(check.Targets, c.targetStatus(url))
+				break
+			}
+		}
+	}
+	sort.Slice(check.Targets, func(i, j int) bool { return check.Targets[i].URL < check.Targets[j].URL })
+
+	// Only targets that would be sent requests are worth a connection
+	var wg sync.WaitGroup
+	for i := range check.Targets {
+		target := &check.Targets[i]
+		if target.State != upstream.Active || !target.Healthy {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.probe(ctx, target.URL, now); err != nil {
+				target.Error = err.Error()
+				return
+			}
+			target.Reachable = true
+		}()
+	}
+	wg.Wait()
+
+	available := 0
+	for _, target := range check.Targets {
+		if target.Reachable {
+			available++
+		}
+	}
+	check.Ready = available > 0
+	check.Detail = fmt.Sprintf("%d of %d targets available", available, len(check.Targets))
+	return check
+}
+
+// targetStatus returns what the upstream registry knows about a target.
+// Targets the registry does not track are taken to be active and healthy.
+func (c *readinessChecker) targetStatus(url string) targetReadiness {
+	status, ok := c.controls.Upstreams().Status(url)
+	if !ok {
+		return targetReadiness{URL: url, State: upstream.Active, Healthy: true}
+	}
+	return targetReadiness{URL: url, State: status.State, Healthy: status.Healthy}
+}
+
+// probe opens a TCP connection to a target, reusing the last result while
+// it is younger than the probe interval
+func (c *readinessChecker) probe(ctx context.Context, raw string, now time.Time) error {
+	c.mu.Lock()
+	last, ok := c.probes[raw]
+	c.mu.Unlock()
+	if ok && now.Sub(last.at) < c.appConfig.Readiness.Interval() {
+		return last.err
+	}
+
+	err := c.connect(ctx, raw)
+	if ctx.Err() != nil {
+		// The caller went away; that says nothing about the target
+		return err
+	}
+	c.mu.Lock()
+	c.probes[raw] = probeResult{at: now, err: err}
+	c.mu.Unlock()
+	return err
+}
+
+func (c *readinessChecker) connect(ctx context.Context, raw string) error {
+	target, err := config.ParseUpstreamURL(raw)
+	if err != nil {
+		return err
+	}
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.appConfig.Readiness.Timeout())
+	defer cancel()
+	conn, err := c.dial(ctx, "tcp", net.JoinHostPort(target.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// summary leaves out the details and targets of each check, which name
+// upstream URLs and connection errors
+func (r readinessReport) summary() readinessReport {
+	summary := readinessReport{Ready: r.Ready, Checks: make([]readinessCheck, len(r.Checks))}
+	for i, check := range r.Checks {
+		summary.Checks[i] = readinessCheck{Name: check.Name, Ready: check.Ready}
+	}
+	return summary
+}
+
+// ReadinessHandler reports whether the gateway should be sent traffic,
+// with whether each check passed. It answers 503 when it should not.
+func (s *Server) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := s.readiness.check(r.Context(), time.Now())
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSONResponse(w, r, status, report.summary())
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/upstream"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+func newReadinessTestRoutes(appConfig config.AppConfig, controls *Controls) http.Handler {
+	controls.Upstreams().Sync(appConfig.UpstreamTargets())
+	s := &Server{appConfig: appConfig, apiGatewayService: usecase.NewApiGatewayService(appConfig, nil), controls: controls}
+	return s.RegisterRoutes()
+}
+
+func readiness(t *testing.T, handler http.Handler) (int, readinessReport) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+	var report readinessReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("expected a JSON report, got %s", w.Body.String())
+	}
+	if report.Ready != (w.Code == http.StatusOK) {
+		t.Errorf("expected the status to match the report, got %d for ready %v", w.Code, report.Ready)
+	}
+	return w.Code, report
+}
+
+// adminReadiness returns the readiness report of the admin API
+func adminReadiness(t *testing.T, appConfig config.AppConfig, controls *Controls) readinessReport {
+	t.Helper()
+	gateway := &Gateway{controls: controls}
+	gateway.current.Store(&generation{appConfig: appConfig})
+	w := httptest.NewRecorder()
+	(&adminAPI{gateway: gateway}).readinessHandler(w, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+	var report readinessReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected a JSON report, got %d %s", w.Code, w.Body.String())
+	}
+	return report
+}
+
+func findCheck(report readinessReport, name string) readinessCheck {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	return readinessCheck{}
+}
+
+func TestReadinessWarmUpAndDrain(t *testing.T) {
+	controls := NewControls(upstream.NewRegistry())
+	warming := newReadinessTestRoutes(config.AppConfig{
+		Readiness: config.ReadinessConfig{WarmUp: config.Duration{Duration: time.Hour}},
+	}, controls)
+	if code, report := readiness(t, warming); code != http.StatusServiceUnavailable || findCheck(report, "warm_up").Ready {
+		t.Errorf("expected the gateway not to be ready while warming up, got %d: %+v", code, report)
+	}
+
+	handler := newReadinessTestRoutes(config.AppConfig{}, controls)
+	if code, _ := readiness(t, handler); code != http.StatusOK {
+		t.Fatalf("expected the gateway to be ready, got %d", code)
+	}
+	controls.StartDrain()
+	if code, report := readiness(t, handler); code != http.StatusServiceUnavailable || findCheck(report, "shutdown").Ready {
+		t.Errorf("expected the gateway not to be ready while draining, got %d: %+v", code, report)
+	}
+	if w := sendMaintenanceRequest(handler, "/liveness", "", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected liveness to be unaffected, got %d", w.Code)
+	}
+}
+
+func TestReadinessCriticalServices(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closed := "http://" + listener.Addr().String()
+	listener.Close()
+
+	appConfig := config.AppConfig{
+		KnownServices: map[string]string{"users": backend.URL, "auth": closed},
+		Services: map[string]config.ServiceConfig{"users": {Versions: []config.VersionConfig{
+			{Name: "stable", Weight: 1, Upstreams: []string{backend.URL, closed}},
+		}}},
+		Readiness: config.ReadinessConfig{CriticalServices: []string{"users"}, ProbeInterval: config.Duration{Duration: time.Nanosecond}},
+	}
+	controls := NewControls(upstream.NewRegistry())
+	handler := newReadinessTestRoutes(appConfig, controls)
+
+	code, report := readiness(t, handler)
+	users := findCheck(report, "service:users")
+	if code != http.StatusOK || !users.Ready {
+		t.Fatalf("expected one reachable target to be enough, got %d: %+v", code, report)
+	}
+	if users.Detail != "" || len(users.Targets) != 0 {
+		t.Errorf("expected no upstream details on the client listener, got %+v", users)
+	}
+
+	users = findCheck(adminReadiness(t, appConfig, controls), "service:users")
+	if !users.Ready || len(users.Targets) != 2 {
+		t.Fatalf("expected the admin API to show both targets, got %+v", users)
+	}
+	for _, target := range users.Targets {
+		if target.Reachable != (target.URL == backend.URL) || (target.URL == closed && target.Error == "") {
+			t.Errorf("unexpected target %+v", target)
+		}
+	}
+	if findCheck(report, "service:auth").Name != "" {
+		t.Error("expected services that are not critical not to be checked")
+	}
+
+	controls.Upstreams().SetState(backend.URL, upstream.Disabled)
+	code, report = readiness(t, handler)
+	if users := findCheck(report, "service:users"); code != http.StatusServiceUnavailable || users.Ready {
+		t.Errorf("expected a critical service without targets to fail readiness, got %d: %+v", code, report)
+	}
+	if users := findCheck(adminReadiness(t, appConfig, controls), "service:users"); users.Ready || users.Detail != "0 of 2 targets available" {
+		t.Errorf("expected the admin API to explain the failure, got %+v", users)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+)
+
+var panicsTotal = metrics.NewCounter("gateway_panics_total",
+	"Handler panics recovered by the gateway, by service", "service")
+
+// recoveryMiddleware turns a panicking handler into a 500 problem response
+// instead of a dropped connection. It runs inside logging, so the response
+// is logged and counted like any other. A panic with http.ErrAbortHandler
+// is a deliberate abort and is passed on, as is any panic once the
+// response has started, since the client can only be told by closing the
+// connection.
+func (s *Server) recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoveryWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if err, ok := recovered.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(recovered)
+			}
+
+			requestID := r.Header.Get("X-Request-ID")
+			if requestID == "" {
+				requestID = "unknown"
+			}
+			panicsTotal.Inc(requestctx.From(r.Context()).Service())
+			log.Printf("[%s] Panic serving %s %s: %v\n%s", requestID, r.Method, r.URL.Path, recovered, debug.Stack())
+
+			if rw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			// Drop whatever headers the handler set before it failed
+			header := w.Header()
+			for name := range header {
+				delete(header, name)
+			}
+			writeErrorResponse(w, r, problem.InternalError, "The gateway failed to handle the request")
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// recoveryWriter records whether the response has started
+type recoveryWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (rw *recoveryWriter) WriteHeader(statusCode int) {
+	if statusCode >= http.StatusOK || statusCode == http.StatusSwitchingProtocols {
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recoveryWriter) Write(data []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher so streamed responses are not held back by the wrapper
+func (rw *recoveryWriter) Flush() {
+	rw.wroteHeader = true
+	if err := http.NewResponseController(rw.ResponseWriter).Flush(); err != nil {
+		log.Printf("Failed to flush response: %v", err)
+	}
+}
+
+// Hijack implements http.Hijacker; a hijacked connection belongs to the
+// handler, so a later panic cannot be answered
+func (rw *recoveryWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw.wroteHeader = true
+	return http.NewResponseController(rw.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *recoveryWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	s := &Server{}
+	handler := s.loggingMiddleware(s.recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestctx.From(r.Context()).SetService("users")
+		w.Header().Set("ETag", `"stale"`)
+		var routes map[string]string
+		routes["users"] = "boom"
+	})))
+
+	before := panicsTotal.Value("users")
+	req := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	req.Header.Set("X-Request-ID", "panic-test")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != problem.ContentType {
+		t.Errorf("expected content type %q, got %q", problem.ContentType, ct)
+	}
+	if w.Header().Get("ETag") != "" {
+		t.Error("expected the handler's headers to be dropped")
+	}
+	var details problem.Details
+	if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+		t.Fatalf("invalid problem details %q: %v", w.Body.String(), err)
+	}
+	if details.Code != problem.InternalError.Code || details.RequestID != "panic-test" {
+		t.Errorf("unexpected details %+v", details)
+	}
+	if panicsTotal.Value("users") != before+1 {
+		t.Error("expected the panic to be counted")
+	}
+}
+
+func TestRecoveryMiddlewareAborts(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{name: "deliberate abort", handler: func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}},
+		{name: "response already started", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("partial"))
+			panic("boom")
+		}},
+	}
+
+	s := &Server{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recovered := recover(); recovered != http.ErrAbortHandler {
+					t.Errorf("expected http.ErrAbortHandler to reach the server, got %v", recovered)
+				}
+			}()
+			req := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+			s.recoveryMiddleware(tt.handler).ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+}
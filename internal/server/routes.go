@@ -4,27 +4,45 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strings"
+
+	"github.com/LucianoBarrera/api-gateway/internal/grpcstatus"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
 )
 
 func (s *Server) RegisterRoutes() http.Handler {
 	mux := http.NewServeMux()
+	s.ipFilters = s.newIPFilters()
+	s.maintenance = s.newMaintenanceGate()
+	s.readiness = s.newReadinessChecker()
 
 	mux.HandleFunc("GET /liveness", s.LivenessHandler)
+	mux.HandleFunc("GET /readiness", s.ReadinessHandler)
 
 	// API Gateway route - handles /api/<service>/<path>
 	// Apply middleware in correct order: auth -> validation -> handler
 	apiHandler := s.basicAuthMiddleware(s.requestValidationMiddleware(http.HandlerFunc(s.APIGatewayHandler)))
 	mux.Handle("/api/{server}/", apiHandler)
 
-	// Wrap the mux with middleware in correct order: CORS -> logging
-	return s.corsMiddleware(s.loggingMiddleware(mux))
+	// gRPC route - handles /<package.Service>/<Method> for services that
+	// declare grpc_services. Anything else falls through to a plain 404.
+	grpcHandler := s.basicAuthMiddleware(s.requestValidationMiddleware(http.HandlerFunc(s.GRPCGatewayHandler)))
+	mux.Handle("/", s.grpcOnly(grpcHandler))
+
+	// Wrap the mux with middleware in correct order: CORS -> compression ->
+	// logging -> recovery -> IP filter -> limits. Logging sees responses
+	// before they are encoded, including those written after a panic, and
+	// resolves the client address the IP filter checks.
+	return s.corsMiddleware(s.compressionMiddleware(s.loggingMiddleware(s.recoveryMiddleware(s.ipFilterMiddleware(s.requestLimitsMiddleware(mux))))))
 }
 
 func (s *Server) LivenessHandler(w http.ResponseWriter, r *http.Request) {
 	resp := map[string]string{"message": "server is live"}
 	jsonResp, err := json.Marshal(resp)
 	if err != nil {
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		writeErrorResponse(w, r, problem.InternalError, "Failed to marshal response")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -40,15 +58,69 @@ func (s *Server) APIGatewayHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Check if service name is empty
 	if serviceName == "" {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid path - server name is required")
+		writeErrorResponse(w, r, problem.InvalidPath, "Invalid path - server name is required")
+		return
+	}
+
+	// Check if the service exists in the known services or aggregates
+	if !s.appConfig.HasService(serviceName) {
+		writeErrorResponse(w, r, problem.ServiceNotFound, "Service not found")
 		return
 	}
 
-	// Check if the service exists in the known services map
-	if _, exists := s.appConfig.KnownServices[serviceName]; !exists {
-		writeErrorResponse(w, http.StatusNotFound, "Service not found")
+	requestctx.From(r.Context()).SetService(serviceName)
+	if s.maintenance.turnsAway(w, r, serviceName) {
 		return
 	}
+	s.apiGatewayService.ForwardRequest(w, r.WithContext(usecase.WithCallGate(r.Context(), s.gateAggregateCall)), serviceName)
+}
 
+// gateAggregateCall applies the checks a client request for serviceName
+// goes through to a call an aggregate makes to it: the service's IP lists
+// and its maintenance mode
+func (s *Server) gateAggregateCall(w http.ResponseWriter, r *http.Request, serviceName string) bool {
+	return s.ipFilters.blocksService(w, r, serviceName) || s.maintenance.turnsAway(w, r, serviceName)
+}
+
+// grpcOnly sends gRPC calls to next and answers every other request with the
+// mux's usual 404
+func (s *Server) grpcOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !grpcstatus.IsGRPCRequest(r) {
+			writeErrorResponse(w, r, problem.RouteNotFound, "No route for "+r.URL.Path)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GRPCGatewayHandler handles gRPC calls to /<package.Service>/<Method>
+func (s *Server) GRPCGatewayHandler(w http.ResponseWriter, r *http.Request) {
+	grpcService, method, ok := parseGRPCPath(r.URL.Path)
+	if !ok || r.Method != http.MethodPost {
+		writeErrorResponse(w, r, problem.InvalidPath, "Invalid gRPC path - expected POST /package.Service/Method")
+		return
+	}
+
+	serviceName, found := s.appConfig.ServiceForGRPC(grpcService)
+	if !found {
+		writeErrorResponse(w, r, problem.ServiceNotFound, "Unknown gRPC service "+grpcService)
+		return
+	}
+
+	log.Printf("[%s] gRPC call %s/%s routed to service '%s'", r.Header.Get("X-Request-ID"), grpcService, method, serviceName)
+	requestctx.From(r.Context()).SetService(serviceName)
+	if s.maintenance.turnsAway(w, r, serviceName) {
+		return
+	}
 	s.apiGatewayService.ForwardRequest(w, r, serviceName)
 }
+
+// parseGRPCPath splits /package.Service/Method into its service and method
+func parseGRPCPath(path string) (string, string, bool) {
+	grpcService, method, found := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !found || method == "" || strings.Contains(method, "/") || !strings.Contains(grpcService, ".") {
+		return "", "", false
+	}
+	return grpcService, method, true
+}
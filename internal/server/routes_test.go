@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
 	"github.com/LucianoBarrera/api-gateway/internal/usecase"
 )
 
@@ -128,14 +129,14 @@ func TestAPIGatewayHandler_GET(t *testing.T) {
 			}
 
 			if tt.expectedStatus == http.StatusNotFound {
-				var response map[string]string
+				var response problem.Details
 				err := json.Unmarshal(w.Body.Bytes(), &response)
 				if err != nil {
 					t.Fatalf("failed to unmarshal error response: %v", err)
 				}
 
-				if response["error"] != tt.expectedError {
-					t.Errorf("expected error %s, got %s", tt.expectedError, response["error"])
+				if response.Detail != tt.expectedError {
+					t.Errorf("expected error %s, got %s", tt.expectedError, response.Detail)
 				}
 			}
 		})
@@ -15,26 +15,49 @@ import (
 
 type Server struct {
 	appConfig         config.AppConfig
-	port              int
 	apiGatewayService usecase.RequestForwarder
+	// controls are the operators' runtime switches; nil leaves every
+	// service and upstream in service
+	controls *Controls
+	// ipFilters block clients by address; they are built by RegisterRoutes
+	ipFilters *ipFilters
+	// maintenance turns away requests to services in maintenance mode; it
+	// is built by RegisterRoutes
+	maintenance *maintenanceGate
+	// readiness answers GET /readiness; it is built by RegisterRoutes
+	readiness *readinessChecker
 }
 
-func NewServer(appConfig config.AppConfig, apiGatewayService usecase.RequestForwarder) *http.Server {
+// NewServer creates the client-facing listener for a gateway. Its own
+// settings are read from the config the gateway started with.
+func NewServer(gateway *Gateway) *http.Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
-	NewServer := &Server{
-		port:              port,
-		appConfig:         appConfig,
-		apiGatewayService: apiGatewayService,
-	}
+	appConfig := gateway.Config()
 
 	// Declare Server config
+	// Request bodies are read under the minimum transfer rate, which
+	// replaces ReadTimeout once the handler starts reading them
+	limits := appConfig.Server.Limits
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", NewServer.port),
-		Handler:      NewServer.RegisterRoutes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           gateway,
+		IdleTimeout:       time.Minute,
+		ReadHeaderTimeout: limits.HeaderTimeout(),
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		MaxHeaderBytes:    limits.HeaderBytes(),
+		Protocols:         serverProtocols(appConfig.Server),
 	}
 
 	return server
 }
+
+// serverProtocols returns the protocols the listener accepts. HTTP/2 over TLS
+// is negotiated when TLS is configured; h2c must be enabled explicitly.
+func serverProtocols(cfg config.ServerConfig) *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(cfg.H2C)
+	return protocols
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/cache"
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+// newStreamingGateway serves the full gateway handler with the given write timeout
+func newStreamingGateway(t *testing.T, backendURL string, writeTimeout time.Duration) *httptest.Server {
+	t.Helper()
+	appConfig := config.AppConfig{
+		AllowedApiKey: "test-key",
+		KnownServices: map[string]string{"events": backendURL},
+	}
+	return serveStreamingGateway(t, appConfig, usecase.NewApiGatewayService(appConfig, nil), writeTimeout)
+}
+
+// serveStreamingGateway serves the full gateway handler in front of forwarder
+func serveStreamingGateway(t *testing.T, appConfig config.AppConfig, forwarder usecase.RequestForwarder, writeTimeout time.Duration) *httptest.Server {
+	t.Helper()
+	s := &Server{
+		appConfig:         appConfig,
+		apiGatewayService: forwarder,
+	}
+	gateway := httptest.NewUnstartedServer(s.RegisterRoutes())
+	gateway.Config.WriteTimeout = writeTimeout
+	gateway.Start()
+	return gateway
+}
+
+func newStreamingRequest(t *testing.T, url string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("X-Request-ID", "stream-test")
+	req.Header.Set("x-api-key", "test-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	return resp
+}
+
+func TestServerSentEventsAreNotBuffered(t *testing.T) {
+	received := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		// Only send the second event once the client has seen the first,
+		// which can only happen if the gateway is not buffering
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+		}
+		fmt.Fprint(w, "data: second\n\n")
+	}))
+	defer backend.Close()
+
+	gateway := newStreamingGateway(t, backend.URL, 30*time.Second)
+	defer gateway.Close()
+
+	resp := newStreamingRequest(t, gateway.URL+"/api/events/stream")
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	firstEvent := make(chan string, 1)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		firstEvent <- line
+	}()
+
+	select {
+	case line := <-firstEvent:
+		if line != "data: first\n" {
+			t.Errorf("expected first event, got %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("first event was not flushed to the client")
+	}
+	close(received)
+}
+
+func TestStreamingResponseExemptFromWriteTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(w, "{\"event\":%d}\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	// The stream lasts well past the server's write timeout
+	gateway := newStreamingGateway(t, backend.URL, 200*time.Millisecond)
+	defer gateway.Close()
+
+	resp := newStreamingRequest(t, gateway.URL+"/api/events/feed")
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+	}
+	if lines != 5 {
+		t.Errorf("expected 5 streamed lines, got %d (err: %v)", lines, scanner.Err())
+	}
+}
+
+func TestCachedStreamingResponseExemptFromWriteTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(w, "data: %d\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name    string
+		service config.ServiceConfig
+		wrap    func(appConfig config.AppConfig, next usecase.RequestForwarder) usecase.RequestForwarder
+	}{
+		{
+			name:    "cached service",
+			service: config.ServiceConfig{Cache: &config.ServiceCacheConfig{DefaultTTL: config.Duration{Duration: time.Minute}}},
+			wrap: func(appConfig config.AppConfig, next usecase.RequestForwarder) usecase.RequestForwarder {
+				return usecase.NewCachingService(appConfig, cache.NewStore(1<<20), next)
+			},
+		},
+		{
+			name:    "coalesced route",
+			service: config.ServiceConfig{Routes: []config.RouteConfig{{Name: "feed", PathPrefix: "/feed", Coalesce: &config.CoalesceConfig{}}}},
+			wrap:    usecase.NewCoalescingService,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appConfig := config.AppConfig{
+				AllowedApiKey: "test-key",
+				KnownServices: map[string]string{"events": backend.URL},
+				Services:      map[string]config.ServiceConfig{"events": tt.service},
+			}
+			forwarder := tt.wrap(appConfig, usecase.NewApiGatewayService(appConfig, nil))
+
+			// The stream lasts well past the server's write timeout
+			gateway := serveStreamingGateway(t, appConfig, forwarder, 200*time.Millisecond)
+			defer gateway.Close()
+
+			resp := newStreamingRequest(t, gateway.URL+"/api/events/feed")
+			defer resp.Body.Close()
+
+			scanner := bufio.NewScanner(resp.Body)
+			lines := 0
+			for scanner.Scan() {
+				lines++
+			}
+			if lines != 5 {
+				t.Errorf("expected 5 streamed lines, got %d (err: %v)", lines, scanner.Err())
+			}
+		})
+	}
+}
+
+func TestResponseWriterCapturesBoundedBody(t *testing.T) {
+	rr := httptest.NewRecorder()
+	rw := &responseWriter{ResponseWriter: rr, statusCode: http.StatusOK}
+
+	chunk := []byte(strings.Repeat("x", 1024))
+	for i := 0; i < 100; i++ {
+		if _, err := rw.Write(chunk); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	rw.Flush()
+
+	if len(rw.body) != maxLoggedBodyBytes {
+		t.Errorf("expected captured body of %d bytes, got %d", maxLoggedBodyBytes, len(rw.body))
+	}
+	if rw.size != 100*1024 {
+		t.Errorf("expected size %d, got %d", 100*1024, rw.size)
+	}
+	if rr.Body.Len() != 100*1024 {
+		t.Errorf("expected full body to reach the client, got %d bytes", rr.Body.Len())
+	}
+	if !rr.Flushed {
+		t.Error("expected flush to reach the underlying writer")
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+)
+
+var (
+	upgradedConnectionsOpen = metrics.NewGauge("gateway_upgraded_connections_open",
+		"Number of client connections currently upgraded (e.g. WebSockets) through the gateway")
+	upgradedConnectionsTotal = metrics.NewCounter("gateway_upgraded_connections_total",
+		"Number of client connections upgraded through the gateway, by close reason", "reason")
+)
+
+// upgradedConn wraps a hijacked client connection and enforces the idle and
+// max-lifetime limits from the websocket config. Both limits close the
+// connection, which makes the reverse proxy tear down the upstream side too.
+// Open connections are tracked in the controls so shutdown can close them.
+type upgradedConn struct {
+	net.Conn
+	controls *Controls
+
+	idleTimeout  time.Duration
+	lastActivity atomic.Int64
+
+	// mu guards the timers, which are armed after their callbacks are created
+	mu            sync.Mutex
+	idleTimer     *time.Timer
+	lifetimeTimer *time.Timer
+
+	closeOnce sync.Once
+}
+
+func newUpgradedConn(conn net.Conn, cfg config.WebSocketConfig, controls *Controls) *upgradedConn {
+	// The server's read/write deadlines were set for the original HTTP
+	// request and would otherwise cut the upgraded stream short
+	_ = conn.SetDeadline(time.Time{})
+
+	uc := &upgradedConn{
+		Conn:        conn,
+		controls:    controls,
+		idleTimeout: cfg.IdleTimeout.Duration,
+	}
+	uc.touch()
+	upgradedConnectionsOpen.Inc()
+	controls.addUpgraded(uc)
+
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	if uc.idleTimeout > 0 {
+		uc.idleTimer = time.AfterFunc(uc.idleTimeout, uc.checkIdle)
+	}
+	if cfg.MaxLifetime.Duration > 0 {
+		uc.lifetimeTimer = time.AfterFunc(cfg.MaxLifetime.Duration, func() {
+			uc.closeWithReason("max_lifetime")
+		})
+	}
+
+	return uc
+}
+
+func (c *upgradedConn) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+// checkIdle closes the connection if it has been idle long enough, otherwise
+// it re-arms the timer for the remaining idle window
+func (c *upgradedConn) checkIdle() {
+	idleFor := time.Since(time.Unix(0, c.lastActivity.Load()))
+	if idleFor >= c.idleTimeout {
+		c.closeWithReason("idle_timeout")
+		return
+	}
+	c.mu.Lock()
+	c.idleTimer.Reset(c.idleTimeout - idleFor)
+	c.mu.Unlock()
+}
+
+func (c *upgradedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Close() error {
+	return c.closeWithReason("closed")
+}
+
+func (c *upgradedConn) closeWithReason(reason string) error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+		}
+		if c.lifetimeTimer != nil {
+			c.lifetimeTimer.Stop()
+		}
+		c.mu.Unlock()
+		// Counted before closing, so the metrics agree with what the client
+		// sees by the time the connection ends
+		c.controls.removeUpgraded(c)
+		upgradedConnectionsOpen.Dec()
+		upgradedConnectionsTotal.Inc(reason)
+		err = c.Conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/usecase"
+)
+
+const testWebSocketKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// newWebSocketEchoBackend stands in for the /ws endpoint of mock-server, which
+// lives in its own module and is tested there: it completes the handshake,
+// greets the client and echoes every frame back until the client closes
+func newWebSocketEchoBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws" || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			http.Error(w, "Expected WebSocket upgrade on /ws", http.StatusBadRequest)
+			return
+		}
+
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("backend failed to hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			base64.StdEncoding.EncodeToString(sum[:]))
+		brw.Flush()
+
+		writeTestFrame(brw.Writer, 0x1, []byte("connected to notifications"), false)
+		brw.Flush()
+
+		for {
+			opcode, payload, err := readTestFrame(brw.Reader)
+			if err != nil || opcode == 0x8 {
+				return
+			}
+			writeTestFrame(brw.Writer, opcode, payload, false)
+			brw.Flush()
+		}
+	}))
+}
+
+// newWebSocketGateway starts the full gateway handler in front of backendURL
+func newWebSocketGateway(t *testing.T, backendURL string, wsConfig config.WebSocketConfig) *httptest.Server {
+	t.Helper()
+	appConfig := config.AppConfig{
+		AllowedApiKey: "test-key",
+		KnownServices: map[string]string{"notifications": backendURL},
+		WebSocket:     wsConfig,
+	}
+	s := &Server{
+		appConfig:         appConfig,
+		apiGatewayService: usecase.NewApiGatewayService(appConfig, nil),
+	}
+	return httptest.NewServer(s.RegisterRoutes())
+}
+
+// dialWebSocket opens a raw connection to the gateway and performs the client handshake
+func dialWebSocket(t *testing.T, gatewayURL, path string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(gatewayURL, "http://"))
+	if err != nil {
+		t.Fatalf("failed to dial gateway: %v", err)
+	}
+
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: gateway\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\nX-Request-ID: ws-test\r\nx-api-key: test-key\r\n\r\n",
+		path, testWebSocketKey)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		t.Fatalf("failed to read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		t.Fatalf("expected status 101, got %d", resp.StatusCode)
+	}
+
+	return conn, reader
+}
+
+func readTestFrame(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	masked := header[1]&0x80 != 0
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return 0, nil, err
+		}
+		length = int(binary.BigEndian.Uint16(ext))
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return header[0] & 0x0F, payload, nil
+}
+
+func writeTestFrame(w io.Writer, opcode byte, payload []byte, masked bool) error {
+	header := []byte{0x80 | opcode, byte(len(payload))}
+	if !masked {
+		_, err := w.Write(append(header, payload...))
+		return err
+	}
+	header[1] |= 0x80
+	mask := []byte{1, 2, 3, 4}
+	maskedPayload := make([]byte, len(payload))
+	for i := range payload {
+		maskedPayload[i] = payload[i] ^ mask[i%4]
+	}
+	_, err := w.Write(append(append(header, mask...), maskedPayload...))
+	return err
+}
+
+func TestWebSocketProxying(t *testing.T) {
+	backend := newWebSocketEchoBackend(t)
+	defer backend.Close()
+
+	gateway := newWebSocketGateway(t, backend.URL, config.WebSocketConfig{})
+	defer gateway.Close()
+
+	openBefore := upgradedConnectionsOpen.Value()
+
+	conn, reader := dialWebSocket(t, gateway.URL, "/api/notifications/ws")
+
+	if got := upgradedConnectionsOpen.Value(); got != openBefore+1 {
+		t.Errorf("expected %d open upgraded connections, got %d", openBefore+1, got)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if opcode, payload, err := readTestFrame(reader); err != nil || opcode != 0x1 || string(payload) != "connected to notifications" {
+		t.Fatalf("expected greeting frame, got opcode %d payload %q err %v", opcode, payload, err)
+	}
+
+	for _, message := range []string{"hello", "second message"} {
+		if err := writeTestFrame(conn, 0x1, []byte(message), true); err != nil {
+			t.Fatalf("failed to write frame: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		opcode, payload, err := readTestFrame(reader)
+		if err != nil {
+			t.Fatalf("failed to read echoed frame: %v", err)
+		}
+		if opcode != 0x1 || string(payload) != message {
+			t.Errorf("expected text frame %q, got opcode %d payload %q", message, opcode, payload)
+		}
+	}
+
+	writeTestFrame(conn, 0x8, nil, true)
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for upgradedConnectionsOpen.Value() != openBefore && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := upgradedConnectionsOpen.Value(); got != openBefore {
+		t.Errorf("expected open upgraded connections to return to %d, got %d", openBefore, got)
+	}
+}
+
+func TestWebSocketTimeouts(t *testing.T) {
+	backend := newWebSocketEchoBackend(t)
+	defer backend.Close()
+
+	tests := []struct {
+		name     string
+		config   config.WebSocketConfig
+		activity bool
+		reason   string
+	}{
+		{
+			name:   "idle timeout closes quiet connection",
+			config: config.WebSocketConfig{IdleTimeout: config.Duration{Duration: 100 * time.Millisecond}},
+			reason: "idle_timeout",
+		},
+		{
+			name:     "max lifetime closes active connection",
+			config:   config.WebSocketConfig{IdleTimeout: config.Duration{Duration: time.Second}, MaxLifetime: config.Duration{Duration: 300 * time.Millisecond}},
+			activity: true,
+			reason:   "max_lifetime",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newWebSocketGateway(t, backend.URL, tt.config)
+			defer gateway.Close()
+
+			closedBefore := upgradedConnectionsTotal.Value(tt.reason)
+
+			conn, reader := dialWebSocket(t, gateway.URL, "/api/notifications/ws")
+			defer conn.Close()
+
+			if tt.activity {
+				// Keep the connection busy so only the lifetime limit can close it
+				go func() {
+					for i := 0; i < 20; i++ {
+						if writeTestFrame(conn, 0x1, []byte("ping"), true) != nil {
+							return
+						}
+						time.Sleep(50 * time.Millisecond)
+					}
+				}()
+			}
+
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			for {
+				if _, _, err := readTestFrame(reader); err != nil {
+					if ne, ok := err.(net.Error); ok && ne.Timeout() {
+						t.Fatal("connection was not closed by the gateway")
+					}
+					break
+				}
+			}
+
+			if got := upgradedConnectionsTotal.Value(tt.reason); got != closedBefore+1 {
+				t.Errorf("expected %s close count %d, got %d", tt.reason, closedBefore+1, got)
+			}
+		})
+	}
+}
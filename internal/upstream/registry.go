@@ -0,0 +1,244 @@
+// Package upstream tracks the targets the gateway proxies to: their passive
+// health, as seen from the requests sent to them, and whether operators
+// have drained or disabled them.
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is whether a target takes new requests
+type State string
+
+const (
+	// Active targets take requests
+	Active State = "active"
+	// Draining targets take no new requests; requests in flight finish
+	Draining State = "draining"
+	// Disabled targets take no new requests, and the ones in flight are
+	// cancelled
+	Disabled State = "disabled"
+)
+
+// UnhealthyAfter is the number of consecutive failures that mark a target
+// unhealthy. Health is only reported; it does not take targets out of
+// rotation.
+const UnhealthyAfter = 3
+
+// ErrUnknownTarget is returned when changing the state of a target that is
+// not configured
+var ErrUnknownTarget = errors.New("unknown upstream target")
+
+// ErrTargetDisabled cancels the requests in flight to a disabled target
+var ErrTargetDisabled = errors.New("upstream target disabled")
+
+// Registry holds the state of every target. A nil Registry tracks nothing
+// and reports every target as active.
+type Registry struct {
+	mu      sync.Mutex
+	targets map[string]*target
+}
+
+type target struct {
+	url      string
+	services []string
+
+	state               State
+	inFlight            map[uint64]context.CancelCauseFunc
+	nextID              uint64
+	requests            int64
+	failures            int64
+	consecutiveFailures int
+	lastError           string
+	lastErrorAt         time.Time
+	lastSuccessAt       time.Time
+}
+
+// Status is a snapshot of one target
+type Status struct {
+	URL string `json:"url"`
+	// Services lists the services and versions that use the target
+	Services            []string   `json:"services"`
+	State               State      `json:"state"`
+	Healthy             bool       `json:"healthy"`
+	InFlight            int        `json:"in_flight"`
+	Requests            int64      `json:"requests"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{targets: map[string]*target{}}
+}
+
+// Sync registers the configured targets, keyed by URL with the services
+// that use them, and forgets targets that are no longer configured. Known
+// targets keep their state and counters, so a drained target stays drained
+// across config reloads.
+func (r *Registry) Sync(targets map[string][]string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for url, t := range r.targets {
+		if _, ok := targets[url]; !ok {
+			delete(r.targets, url)
+			t.services = nil
+		}
+	}
+	for url, services := range targets {
+		t := r.lookup(url)
+		t.services = slices.Clone(services)
+	}
+}
+
+// lookup returns the target for url, creating it if needed. r.mu must be
+// held.
+func (r *Registry) lookup(url string) *target {
+	t, ok := r.targets[url]
+	if !ok {
+		t = &target{url: url, state: Active, inFlight: map[uint64]context.CancelCauseFunc{}}
+		r.targets[url] = t
+	}
+	return t
+}
+
+// Available reports whether url takes new requests
+func (r *Registry) Available(url string) bool {
+	if r == nil {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.targets[url]
+	return !ok || t.state == Active
+}
+
+// Begin records a request to url. The returned context is cancelled if the
+// target is disabled while the request is in flight; done must be called
+// with the outcome once the request is over.
+func (r *Registry) Begin(ctx context.Context, url string) (context.Context, func(failure error)) {
+	if r == nil {
+		return ctx, func(error) {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	r.mu.Lock()
+	t := r.lookup(url)
+	id := t.nextID
+	t.nextID++
+	t.inFlight[id] = cancel
+	t.requests++
+	r.mu.Unlock()
+
+	return ctx, func(failure error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(t.inFlight, id)
+		cancel(nil)
+		if errors.Is(failure, ErrTargetDisabled) {
+			// Cut off by an operator, not a sign of the target's health
+			return
+		}
+		now := time.Now()
+		if failure != nil {
+			t.failures++
+			t.consecutiveFailures++
+			t.lastError = failure.Error()
+			t.lastErrorAt = now
+			return
+		}
+		t.consecutiveFailures = 0
+		t.lastSuccessAt = now
+	}
+}
+
+// SetState changes whether a configured target takes requests. Disabling a
+// target cancels the requests in flight to it.
+func (r *Registry) SetState(url string, state State) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.targets[url]
+	if !ok || len(t.services) == 0 {
+		return ErrUnknownTarget
+	}
+	t.state = state
+	if state == Disabled {
+		for _, cancel := range t.inFlight {
+			cancel(ErrTargetDisabled)
+		}
+	}
+	return nil
+}
+
+// Status returns a snapshot of a target
+func (r *Registry) Status(url string) (Status, bool) {
+	if r == nil {
+		return Status{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.targets[url]
+	if !ok {
+		return Status{}, false
+	}
+	return t.status(), true
+}
+
+// Statuses returns a snapshot of every target, ordered by URL
+func (r *Registry) Statuses() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]Status, 0, len(r.targets))
+	for _, t := range r.targets {
+		statuses = append(statuses, t.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].URL < statuses[j].URL })
+	return statuses
+}
+
+func (t *target) status() Status {
+	status := Status{
+		URL:                 t.url,
+		Services:            slices.Clone(t.services),
+		State:               t.state,
+		Healthy:             t.consecutiveFailures < UnhealthyAfter,
+		InFlight:            len(t.inFlight),
+		Requests:            t.requests,
+		Failures:            t.failures,
+		ConsecutiveFailures: t.consecutiveFailures,
+		LastError:           t.lastError,
+	}
+	if !t.lastErrorAt.IsZero() {
+		lastErrorAt := t.lastErrorAt
+		status.LastErrorAt = &lastErrorAt
+	}
+	if !t.lastSuccessAt.IsZero() {
+		lastSuccessAt := t.lastSuccessAt
+		status.LastSuccessAt = &lastSuccessAt
+	}
+	return status
+}
+
+// IsFailureStatus reports whether an upstream response counts against the
+// target's health: the statuses a sick or overloaded upstream, or a proxy
+// in front of it, answers with
+func IsFailureStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRegistryHealth(t *testing.T) {
+	r := NewRegistry()
+	r.Sync(map[string][]string{"http://users": {"users"}})
+
+	for range UnhealthyAfter {
+		_, done := r.Begin(context.Background(), "http://users")
+		done(errors.New("connection refused"))
+	}
+	status, _ := r.Status("http://users")
+	if status.Healthy || status.Failures != UnhealthyAfter || status.LastError != "connection refused" || status.LastErrorAt == nil {
+		t.Errorf("expected an unhealthy target, got %+v", status)
+	}
+
+	_, done := r.Begin(context.Background(), "http://users")
+	done(nil)
+	status, _ = r.Status("http://users")
+	if !status.Healthy || status.ConsecutiveFailures != 0 || status.Requests != UnhealthyAfter+1 || status.LastSuccessAt == nil {
+		t.Errorf("expected a success to restore health, got %+v", status)
+	}
+}
+
+func TestRegistryStates(t *testing.T) {
+	r := NewRegistry()
+	r.Sync(map[string][]string{"http://users-a": {"users/stable"}, "http://users-b": {"users/stable"}})
+
+	ctx, done := r.Begin(context.Background(), "http://users-a")
+	if err := r.SetState("http://users-a", Draining); err != nil {
+		t.Fatalf("failed to drain: %v", err)
+	}
+	if r.Available("http://users-a") || !r.Available("http://users-b") {
+		t.Error("expected only the drained target to stop taking requests")
+	}
+	if ctx.Err() != nil {
+		t.Error("expected draining to let requests in flight finish")
+	}
+	if status, _ := r.Status("http://users-a"); status.InFlight != 1 || status.State != Draining {
+		t.Errorf("expected one request in flight while draining, got %+v", status)
+	}
+
+	r.SetState("http://users-a", Disabled)
+	if !errors.Is(context.Cause(ctx), ErrTargetDisabled) {
+		t.Errorf("expected disabling to cancel the request in flight, got %v", context.Cause(ctx))
+	}
+	done(context.Cause(ctx))
+	if status, _ := r.Status("http://users-a"); status.InFlight != 0 || status.Failures != 0 {
+		t.Errorf("expected a cancelled request not to count as a failure, got %+v", status)
+	}
+
+	if err := r.SetState("http://unknown", Draining); !errors.Is(err, ErrUnknownTarget) {
+		t.Errorf("expected ErrUnknownTarget, got %v", err)
+	}
+}
+
+func TestRegistrySyncKeepsState(t *testing.T) {
+	r := NewRegistry()
+	r.Sync(map[string][]string{"http://users": {"users"}, "http://auth": {"auth"}})
+	r.SetState("http://users", Disabled)
+
+	r.Sync(map[string][]string{"http://users": {"users", "users/canary"}})
+
+	statuses := r.Statuses()
+	if len(statuses) != 1 || statuses[0].URL != "http://users" || statuses[0].State != Disabled || len(statuses[0].Services) != 2 {
+		t.Errorf("expected the remaining target to stay disabled, got %+v", statuses)
+	}
+}
+
+func TestNilRegistry(t *testing.T) {
+	var r *Registry
+	ctx, done := r.Begin(context.Background(), "http://users")
+	done(errors.New("ignored"))
+	if !r.Available("http://users") || ctx.Err() != nil {
+		t.Error("expected a nil registry to track nothing")
+	}
+}
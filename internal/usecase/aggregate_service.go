@@ -0,0 +1,353 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+)
+
+// maxAggregatedResponseBytes bounds each upstream response held in memory
+// while an aggregate is being assembled
+const maxAggregatedResponseBytes = 1 << 20
+
+// aggregateErrorsKey holds the errors of optional calls in the merged document
+const aggregateErrorsKey = "_errors"
+
+// placeholderPattern matches ${key.field.path} references in call paths
+var placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_-]+)((?:\.[A-Za-z0-9_-]+)*)\}`)
+
+// AggregateService implements RequestForwarder for composite routes: it calls
+// several services through the regular forwarder and merges their JSON
+type AggregateService struct {
+	name      string
+	calls     []config.AggregateCall
+	deps      map[string][]string
+	forwarder RequestForwarder
+}
+
+// CallGate applies the checks the gateway makes on client requests for
+// serviceName, such as IP filters and maintenance mode, to a call an aggregate is about to make.
+// When the call may not reach the service, it answers the call itself and
+// returns true.
+type CallGate func(w http.ResponseWriter, req *http.Request, serviceName string) bool
+
+type callGateKey struct{}
+
+// WithCallGate attaches the gate the aggregates serving a request check
+// their calls against
+func WithCallGate(ctx context.Context, gate CallGate) context.Context {
+	return context.WithValue(ctx, callGateKey{}, gate)
+}
+
+// callResult is the outcome of a single aggregate call
+type callResult struct {
+	value interface{}
+	err   error
+}
+
+// NewAggregateService validates the aggregate's call graph and returns a
+// forwarder that serves it using forwarder for every upstream call
+func NewAggregateService(appConfig config.AppConfig, name string, forwarder RequestForwarder) (RequestForwarder, error) {
+	aggregate, ok := appConfig.Aggregates[name]
+	if !ok {
+		return nil, fmt.Errorf("aggregate '%s' is not configured", name)
+	}
+	if _, clash := appConfig.KnownServices[name]; clash {
+		return nil, fmt.Errorf("aggregate '%s' has the same name as a known service", name)
+	}
+	if len(aggregate.Calls) == 0 {
+		return nil, fmt.Errorf("aggregate '%s' has no calls", name)
+	}
+
+	keys := map[string]bool{}
+	for _, call := range aggregate.Calls {
+		if call.Key == "" || call.Key == aggregateErrorsKey {
+			return nil, fmt.Errorf("aggregate '%s' has a call with invalid key %q", name, call.Key)
+		}
+		if keys[call.Key] {
+			return nil, fmt.Errorf("aggregate '%s' has duplicate key '%s'", name, call.Key)
+		}
+		keys[call.Key] = true
+		if _, known := appConfig.KnownServices[call.Service]; !known {
+			return nil, fmt.Errorf("aggregate '%s' call '%s' targets unknown service '%s'", name, call.Key, call.Service)
+		}
+	}
+
+	deps := map[string][]string{}
+	for _, call := range aggregate.Calls {
+		seen := map[string]bool{}
+		for _, dep := range append(append([]string(nil), call.DependsOn...), referencedKeys(call.Path)...) {
+			if !keys[dep] {
+				return nil, fmt.Errorf("aggregate '%s' call '%s' depends on unknown call '%s'", name, call.Key, dep)
+			}
+			if dep == call.Key {
+				return nil, fmt.Errorf("aggregate '%s' call '%s' depends on itself", name, call.Key)
+			}
+			if !seen[dep] {
+				seen[dep] = true
+				deps[call.Key] = append(deps[call.Key], dep)
+			}
+		}
+	}
+	if err := checkAcyclic(aggregate.Calls, deps); err != nil {
+		return nil, fmt.Errorf("aggregate '%s': %w", name, err)
+	}
+
+	return &AggregateService{
+		name:      name,
+		calls:     aggregate.Calls,
+		deps:      deps,
+		forwarder: forwarder,
+	}, nil
+}
+
+func referencedKeys(path string) []string {
+	var keys []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(path, -1) {
+		keys = append(keys, match[1])
+	}
+	return keys
+}
+
+func checkAcyclic(calls []config.AggregateCall, deps map[string][]string) error {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var visit func(key string) error
+	visit = func(key string) error {
+		switch state[key] {
+		case visiting:
+			return fmt.Errorf("dependency cycle through call '%s'", key)
+		case done:
+			return nil
+		}
+		state[key] = visiting
+		for _, dep := range deps[key] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[key] = done
+		return nil
+	}
+	for _, call := range calls {
+		if err := visit(call.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForwardRequest implements RequestForwarder for AggregateService
+func (a *AggregateService) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
+	requestID := req.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = "unknown"
+	}
+
+	if req.Method != http.MethodGet {
+		writeError(w, req, problem.MethodNotAllowed, "Aggregate routes only support GET")
+		return
+	}
+
+	results := a.run(req, requestID)
+
+	merged := map[string]interface{}{}
+	optionalErrors := map[string]string{}
+	for _, call := range a.calls {
+		result := results[call.Key]
+		if result.err == nil {
+			merged[call.Key] = result.value
+			continue
+		}
+		if call.Required {
+			log.Printf("[%s] Aggregate '%s' failed: required call '%s' failed: %v", requestID, a.name, call.Key, result.err)
+			problem.Write(w, req, problem.New(problem.AggregateCallFailed, result.err.Error()).With("failed", call.Key))
+			return
+		}
+		merged[call.Key] = nil
+		optionalErrors[call.Key] = result.err.Error()
+	}
+	if len(optionalErrors) > 0 {
+		merged[aggregateErrorsKey] = optionalErrors
+	}
+
+	writeJSON(w, req, http.StatusOK, merged)
+}
+
+// run executes the calls in waves: each wave runs, in parallel, every call
+// whose dependencies finished in an earlier wave
+func (a *AggregateService) run(req *http.Request, requestID string) map[string]callResult {
+	results := make(map[string]callResult, len(a.calls))
+	var mu sync.Mutex
+
+	pending := append([]config.AggregateCall(nil), a.calls...)
+	for len(pending) > 0 {
+		var ready, waiting []config.AggregateCall
+		mu.Lock()
+		for _, call := range pending {
+			if a.depsDone(call.Key, results) {
+				ready = append(ready, call)
+			} else {
+				waiting = append(waiting, call)
+			}
+		}
+		mu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, call := range ready {
+			mu.Lock()
+			path, err := a.prepare(call, results)
+			if err != nil {
+				results[call.Key] = callResult{err: err}
+			}
+			mu.Unlock()
+			if err != nil {
+				continue
+			}
+
+			wg.Add(1)
+			go func(call config.AggregateCall, path string) {
+				defer wg.Done()
+				value, err := a.call(req, call, path, requestID)
+				mu.Lock()
+				results[call.Key] = callResult{value: value, err: err}
+				mu.Unlock()
+			}(call, path)
+		}
+		wg.Wait()
+		pending = waiting
+	}
+
+	return results
+}
+
+func (a *AggregateService) depsDone(key string, results map[string]callResult) bool {
+	for _, dep := range a.deps[key] {
+		if _, done := results[dep]; !done {
+			return false
+		}
+	}
+	return true
+}
+
+// prepare checks that every dependency succeeded and resolves the call's path
+func (a *AggregateService) prepare(call config.AggregateCall, results map[string]callResult) (string, error) {
+	for _, dep := range a.deps[call.Key] {
+		if results[dep].err != nil {
+			return "", fmt.Errorf("dependency '%s' failed", dep)
+		}
+	}
+	return resolvePlaceholders(call.Path, results)
+}
+
+// call sends one upstream request through the regular forwarder and decodes
+// its JSON response
+func (a *AggregateService) call(req *http.Request, call config.AggregateCall, path, requestID string) (interface{}, error) {
+	ctx := req.Context()
+	if call.Timeout.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, call.Timeout.Duration)
+		defer cancel()
+	}
+
+	method := call.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	callReq, err := http.NewRequestWithContext(ctx, method, "/api/"+call.Service+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q: %w", path, err)
+	}
+	callReq.Header = req.Header.Clone()
+	callReq.Header.Del("Accept-Encoding")
+	callReq.Host = req.Host
+	callReq.RemoteAddr = req.RemoteAddr
+
+	log.Printf("[%s] Aggregate '%s' calling %s %s on service '%s'", requestID, a.name, method, path, call.Service)
+
+	recorder := newBufferedResponse(maxAggregatedResponseBytes)
+	if gate, ok := ctx.Value(callGateKey{}).(CallGate); ok && gate(recorder, callReq, call.Service) {
+		return nil, fmt.Errorf("call to service '%s' was refused with %d", call.Service, recorder.status())
+	}
+	if forwardCatchingAbort(a.forwarder, recorder, callReq, call.Service) {
+		return nil, fmt.Errorf("response of service '%s' broke off", call.Service)
+	}
+
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("call '%s' timed out", call.Key)
+	}
+	if status := recorder.status(); status < 200 || status > 299 {
+		return nil, fmt.Errorf("service '%s' answered %d", call.Service, status)
+	}
+	if recorder.overflowed {
+		return nil, fmt.Errorf("service '%s' answered with more than %d bytes", call.Service, maxAggregatedResponseBytes)
+	}
+
+	body := recorder.body.Bytes()
+	if len(body) == 0 {
+		return nil, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		// Non-JSON payloads are passed through as strings
+		return string(body), nil
+	}
+	return value, nil
+}
+
+// resolvePlaceholders replaces ${key.field} references with values taken
+// from earlier results, path-escaped
+func resolvePlaceholders(path string, results map[string]callResult) (string, error) {
+	var resolveErr error
+	resolved := placeholderPattern.ReplaceAllStringFunc(path, func(placeholder string) string {
+		match := placeholderPattern.FindStringSubmatch(placeholder)
+		value := results[match[1]].value
+		for _, field := range strings.Split(strings.TrimPrefix(match[2], "."), ".") {
+			if field == "" {
+				continue
+			}
+			switch v := value.(type) {
+			case map[string]interface{}:
+				value = v[field]
+			case []interface{}:
+				index, err := strconv.Atoi(field)
+				if err != nil || index < 0 || index >= len(v) {
+					value = nil
+				} else {
+					value = v[index]
+				}
+			default:
+				value = nil
+			}
+		}
+
+		var text string
+		switch v := value.(type) {
+		case string:
+			text = v
+		case float64:
+			text = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			text = strconv.FormatBool(v)
+		default:
+			resolveErr = fmt.Errorf("placeholder %s did not resolve to a scalar value", placeholder)
+			return ""
+		}
+		return url.PathEscape(text)
+	})
+	return resolved, resolveErr
+}
@@ -0,0 +1,265 @@
+package usecase
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+// forwarderFunc adapts a function to RequestForwarder
+type forwarderFunc func(w http.ResponseWriter, r *http.Request, serviceName string)
+
+func (f forwarderFunc) ForwardRequest(w http.ResponseWriter, r *http.Request, serviceName string) {
+	f(w, r, serviceName)
+}
+
+// fakeServices answers like the mock users and auth services, with a delay
+// so parallel execution can be observed
+func fakeServices(delay time.Duration, inFlight, maxInFlight *atomic.Int32) RequestForwarder {
+	return forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxInFlight.Load()
+			if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/auth/auth/status":
+			json.NewEncoder(w).Encode(map[string]interface{}{"status": "authenticated", "user_id": 456})
+		case "/api/users/users":
+			json.NewEncoder(w).Encode(map[string]interface{}{"count": 3})
+		case "/api/users/users/456":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": "456", "name": "Mock User"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "not found"})
+		}
+	})
+}
+
+func newTestAggregateConfig(calls ...config.AggregateCall) config.AppConfig {
+	return config.AppConfig{
+		KnownServices: map[string]string{"users": "http://users", "auth": "http://auth"},
+		Aggregates:    map[string]config.AggregateConfig{"home": {Calls: calls}},
+	}
+}
+
+func serveAggregate(t *testing.T, aggregate RequestForwarder) map[string]interface{} {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/home/", nil)
+	req.Header.Set("X-Request-ID", "aggregate-test")
+	w := httptest.NewRecorder()
+	aggregate.ForwardRequest(w, req, "home")
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", w.Body.String(), err)
+	}
+	body["_status"] = float64(w.Code)
+	return body
+}
+
+func TestAggregateServiceMergesParallelAndDependentCalls(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	appConfig := newTestAggregateConfig(
+		config.AggregateCall{Key: "users", Service: "users", Path: "/users", Required: true},
+		config.AggregateCall{Key: "session", Service: "auth", Path: "/auth/status", Required: true},
+		config.AggregateCall{Key: "profile", Service: "users", Path: "/users/${session.user_id}"},
+	)
+
+	aggregate, err := NewAggregateService(appConfig, "home", fakeServices(50*time.Millisecond, &inFlight, &maxInFlight))
+	if err != nil {
+		t.Fatalf("failed to create aggregate: %v", err)
+	}
+
+	body := serveAggregate(t, aggregate)
+
+	if body["_status"] != float64(http.StatusOK) {
+		t.Fatalf("expected status 200, got %v (%v)", body["_status"], body)
+	}
+	if maxInFlight.Load() != 2 {
+		t.Errorf("expected the two independent calls to run in parallel, max in flight was %d", maxInFlight.Load())
+	}
+	if users, _ := body["users"].(map[string]interface{}); users["count"] != float64(3) {
+		t.Errorf("unexpected users result %v", body["users"])
+	}
+	if profile, _ := body["profile"].(map[string]interface{}); profile["id"] != "456" {
+		t.Errorf("expected profile resolved from session.user_id, got %v", body["profile"])
+	}
+	if _, hasErrors := body[aggregateErrorsKey]; hasErrors {
+		t.Errorf("did not expect errors, got %v", body[aggregateErrorsKey])
+	}
+}
+
+func TestAggregateServiceFailures(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	services := fakeServices(50*time.Millisecond, &inFlight, &maxInFlight)
+
+	t.Run("optional call failure is reported and merged as null", func(t *testing.T) {
+		aggregate, err := NewAggregateService(newTestAggregateConfig(
+			config.AggregateCall{Key: "session", Service: "auth", Path: "/auth/status", Required: true},
+			config.AggregateCall{Key: "extra", Service: "users", Path: "/missing"},
+		), "home", services)
+		if err != nil {
+			t.Fatalf("failed to create aggregate: %v", err)
+		}
+
+		body := serveAggregate(t, aggregate)
+		if body["_status"] != float64(http.StatusOK) {
+			t.Fatalf("expected status 200, got %v", body["_status"])
+		}
+		if value, present := body["extra"]; !present || value != nil {
+			t.Errorf("expected extra to be null, got %v", value)
+		}
+		errors, _ := body[aggregateErrorsKey].(map[string]interface{})
+		if errors["extra"] == nil {
+			t.Errorf("expected an error entry for extra, got %v", body[aggregateErrorsKey])
+		}
+	})
+
+	t.Run("required call timeout fails the aggregate", func(t *testing.T) {
+		aggregate, err := NewAggregateService(newTestAggregateConfig(
+			config.AggregateCall{Key: "session", Service: "auth", Path: "/auth/status", Required: true,
+				Timeout: config.Duration{Duration: 10 * time.Millisecond}},
+			config.AggregateCall{Key: "profile", Service: "users", Path: "/users/${session.user_id}"},
+		), "home", services)
+		if err != nil {
+			t.Fatalf("failed to create aggregate: %v", err)
+		}
+
+		body := serveAggregate(t, aggregate)
+		if body["_status"] != float64(http.StatusBadGateway) {
+			t.Fatalf("expected status 502, got %v", body["_status"])
+		}
+		if body["failed"] != "session" {
+			t.Errorf("expected failed call 'session', got %v", body["failed"])
+		}
+	})
+}
+
+// TestAggregateServiceUpstreamBodyFailures proxies calls through a real
+// server, where ReverseProxy panics when copying a response fails
+func TestAggregateServiceUpstreamBodyFailures(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/auth/status":
+			json.NewEncoder(w).Encode(map[string]interface{}{"status": "authenticated"})
+		case "/users":
+			// A JSON string just over the buffer limit
+			w.Write([]byte(`"` + strings.Repeat("a", maxAggregatedResponseBytes) + `"`))
+		case "/users/truncated":
+			w.Header().Set("Content-Length", "1000")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"id":`))
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	proxy := httputil.NewSingleHostReverseProxy(backendURL)
+	proxy.ErrorLog = log.New(io.Discard, "", 0)
+	services := forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/api/"+serviceName)
+		proxy.ServeHTTP(w, r)
+	})
+
+	for _, path := range []string{"/users", "/users/truncated"} {
+		t.Run(path, func(t *testing.T) {
+			aggregate, err := NewAggregateService(newTestAggregateConfig(
+				config.AggregateCall{Key: "session", Service: "auth", Path: "/auth/status", Required: true},
+				config.AggregateCall{Key: "users", Service: "users", Path: path},
+			), "home", services)
+			if err != nil {
+				t.Fatalf("failed to create aggregate: %v", err)
+			}
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				aggregate.ForwardRequest(w, r, "home")
+			}))
+			defer gateway.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/api/home/", nil)
+			req.Header.Set("X-Request-ID", "aggregate-test")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			var body map[string]interface{}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("invalid JSON response: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status 200, got %d (%v)", resp.StatusCode, body)
+			}
+			if body["users"] != nil {
+				t.Error("expected the failed call to be merged as null")
+			}
+			errors, _ := body[aggregateErrorsKey].(map[string]interface{})
+			if errors["users"] == nil {
+				t.Errorf("expected an error entry for users, got %v", body[aggregateErrorsKey])
+			}
+		})
+	}
+}
+
+func TestNewAggregateServiceValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		calls []config.AggregateCall
+	}{
+		{
+			name:  "unknown service",
+			calls: []config.AggregateCall{{Key: "a", Service: "orders", Path: "/"}},
+		},
+		{
+			name: "duplicate key",
+			calls: []config.AggregateCall{
+				{Key: "a", Service: "users", Path: "/"},
+				{Key: "a", Service: "auth", Path: "/"},
+			},
+		},
+		{
+			name:  "reference to unknown call",
+			calls: []config.AggregateCall{{Key: "a", Service: "users", Path: "/users/${b.id}"}},
+		},
+		{
+			name: "dependency cycle",
+			calls: []config.AggregateCall{
+				{Key: "a", Service: "users", Path: "/users/${b.id}"},
+				{Key: "b", Service: "auth", Path: "/", DependsOn: []string{"a"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAggregateService(newTestAggregateConfig(tt.calls...), "home", nil); err == nil {
+				t.Error("expected a validation error")
+			}
+		})
+	}
+}
@@ -1,46 +1,130 @@
 package usecase
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"sync/atomic"
 
 	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/grpcstatus"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+	"github.com/LucianoBarrera/api-gateway/internal/upstream"
 )
 
 // ApiGatewayService implements RequestForwarder for actual HTTP proxying
 type ApiGatewayService struct {
 	appConfig config.AppConfig
+	// nextUpstream holds the round-robin position of every service version,
+	// keyed by "<service>/<version>"
+	nextUpstream map[string]*atomic.Uint64
+	// targets tracks the health of the upstreams and which of them
+	// operators have taken out of rotation; nil tracks nothing
+	targets *upstream.Registry
 }
 
 // NewApiGatewayService creates a real API gateway service for production
-func NewApiGatewayService(appConfig config.AppConfig) RequestForwarder {
-	return &ApiGatewayService{appConfig: appConfig}
+func NewApiGatewayService(appConfig config.AppConfig, targets *upstream.Registry) RequestForwarder {
+	nextUpstream := map[string]*atomic.Uint64{}
+	for serviceName, serviceConfig := range appConfig.Services {
+		for _, version := range serviceConfig.Versions {
+			nextUpstream[serviceName+"/"+version.Name] = new(atomic.Uint64)
+		}
+	}
+	return &ApiGatewayService{appConfig: appConfig, nextUpstream: nextUpstream, targets: targets}
+}
+
+// upstreamFor returns the base URL to proxy to: the next upstream of the
+// version picked by the traffic splitter, or the known_services URL.
+// Drained and disabled upstreams are skipped; it reports false when no
+// upstream is left.
+func (r *ApiGatewayService) upstreamFor(req *http.Request, serviceName string) (string, bool) {
+	versionName := versionFromContext(req.Context(), serviceName)
+	version, ok := r.appConfig.Service(serviceName).Version(versionName)
+	counter := r.nextUpstream[serviceName+"/"+versionName]
+	if !ok || counter == nil || len(version.Upstreams) == 0 {
+		target := r.appConfig.KnownServices[serviceName]
+		return target, r.targets.Available(target)
+	}
+	for range version.Upstreams {
+		target := version.Upstreams[(counter.Add(1)-1)%uint64(len(version.Upstreams))]
+		if r.targets.Available(target) {
+			return target, true
+		}
+	}
+	return "", false
 }
 
 // ForwardRequest implements RequestForwarder for ApiGatewayService
 func (r *ApiGatewayService) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
-	targetService, _ := r.appConfig.KnownServices[serviceName]
-
-	// Remove the /api/<serviceName> prefix from the path
-	originalPath := req.URL.Path
-	trimmedPath := strings.TrimPrefix(originalPath, "/api/"+serviceName)
-
 	requestID := req.Header.Get("X-Request-ID")
 	if requestID == "" {
 		requestID = "unknown"
 	}
+
+	targetService, available := r.upstreamFor(req, serviceName)
+	if !available {
+		log.Printf("[%s] No upstream of service '%s' is taking requests", requestID, serviceName)
+		writeError(w, req, problem.UpstreamUnavailable, "Every upstream of the service is drained or disabled")
+		return
+	}
+
+	// Remove the /api/<serviceName> prefix from the path
+	originalPath := req.URL.Path
+	trimmedPath := strings.TrimPrefix(originalPath, "/api/"+serviceName)
 	log.Printf("[%s] API Gateway: Forwarding %s request to backend service '%s' with path '%s'",
 		requestID, req.Method, serviceName, targetService)
 
+	// Upstream URLs are validated at config load; refuse the request if an
+	// invalid one gets here anyway
 	targetURL, err := url.Parse(targetService)
 	if err != nil {
-		panic(err)
+		log.Printf("[%s] Invalid upstream URL for service '%s': %v", requestID, serviceName, err)
+		writeError(w, req, problem.InternalError, "Service is misconfigured")
+		return
 	}
 
+	// The outcome feeds the target's passive health
+	ctx, done := r.targets.Begin(req.Context(), targetService)
+	var failure error
+	defer func() { done(failure) }()
+	req = req.WithContext(ctx)
+
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	proxy.FlushInterval = r.appConfig.Streaming.FlushInterval.Duration
+	proxy.ErrorHandler = func(w http.ResponseWriter, _ *http.Request, err error) {
+		failure = targetFailure(ctx, err)
+		proxyErrorHandler(w, req, serviceName, err)
+	}
+	if r.appConfig.Service(serviceName).IsGRPC() {
+		proxy.Transport = grpcTransport
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			failure = targetFailure(ctx, err)
+			grpcErrorHandler(w, r, err)
+		}
+	}
+
+	// Streamed responses (e.g. Server-Sent Events) are flushed per write and
+	// exempt from the server's WriteTimeout
+	sw := &streamingResponseWriter{ResponseWriter: w}
+	proxy.ModifyResponse = func(res *http.Response) error {
+		if upstream.IsFailureStatus(res.StatusCode) {
+			failure = fmt.Errorf("upstream answered %s", res.Status)
+		}
+		contentType := res.Header.Get("Content-Type")
+		if r.appConfig.Streaming.IsStreamingContentType(contentType) || grpcstatus.IsGRPCContentType(contentType) {
+			log.Printf("[%s] Streaming %s response from '%s'", requestID, contentType, serviceName)
+			sw.startStreaming(r.appConfig.Streaming, requestID)
+			return nil
+		}
+		return transformResponse(res, serviceName)
+	}
 
 	// Custom director to modify the request URL
 	originalDirector := proxy.Director
@@ -52,5 +136,19 @@ func (r *ApiGatewayService) ForwardRequest(w http.ResponseWriter, req *http.Requ
 	}
 
 	log.Printf("[%s] Proxying request to: %s", requestID, targetURL)
-	proxy.ServeHTTP(w, req)
+	proxy.ServeHTTP(sw, req)
+}
+
+// targetFailure returns what a failed proxy request says about its target.
+// Requests cut off by a disabled target report that instead of the
+// transport's cancellation error, and failures caused by the client say
+// nothing about the target at all.
+func targetFailure(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(cause, upstream.ErrTargetDisabled) {
+		return cause
+	}
+	if _, _, ok := requestBodyError(err); ok || errors.Is(err, context.Canceled) || requestctx.From(ctx).BodyError() != nil {
+		return nil
+	}
+	return err
 }
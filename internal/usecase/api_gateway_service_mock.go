@@ -9,6 +9,9 @@ import (
 	"github.com/LucianoBarrera/api-gateway/internal/config"
 )
 
+// maxMockBodyBytes bounds the request body echoed by the mock
+const maxMockBodyBytes = 1 << 20
+
 // MockApiGatewayService implements RequestForwarder for testing
 type MockApiGatewayService struct {
 	appConfig config.AppConfig
@@ -41,9 +44,9 @@ func (m *MockApiGatewayService) ForwardRequest(w http.ResponseWriter, r *http.Re
 	}
 	response["headers"] = headers
 
-	// Add body for POST requests
+	// Add body for POST requests, up to maxMockBodyBytes
 	if r.Method == http.MethodPost {
-		body, err := io.ReadAll(r.Body)
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxMockBodyBytes))
 		if err == nil {
 			response["body"] = string(body)
 		}
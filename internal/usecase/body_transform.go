@@ -0,0 +1,225 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/jsontransform"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+)
+
+var bodyTransforms = metrics.NewCounter("gateway_body_transforms_total",
+	"JSON bodies transformed by route rules, by service, direction and result", "service", "direction", "result")
+
+// errBodyTooLarge is returned when a body that has to be buffered to be
+// transformed exceeds the route's limit
+var errBodyTooLarge = errors.New("body exceeds the transform size limit")
+
+// BodyTransformer implements RequestForwarder by rewriting the JSON body of
+// requests before they are forwarded, and by handing the route's response
+// transform to the reverse proxy, which applies it in its ModifyResponse hook
+type BodyTransformer struct {
+	appConfig config.AppConfig
+	next      RequestForwarder
+	// compiled holds the transform of every configured config.BodyTransform.
+	// Route lookups return copies of the route, but the pointers they hold
+	// are shared with appConfig, so they identify the transform.
+	compiled map[*config.BodyTransform]*jsontransform.Transform
+}
+
+// NewBodyTransformer compiles the body transforms of every route and wraps
+// next. Without any transforms configured it returns next unchanged.
+func NewBodyTransformer(appConfig config.AppConfig, next RequestForwarder) (RequestForwarder, error) {
+	compiled := map[*config.BodyTransform]*jsontransform.Transform{}
+	for serviceName, serviceConfig := range appConfig.Services {
+		for _, route := range serviceConfig.Routes {
+			if route.Transform == nil {
+				continue
+			}
+			for _, spec := range []*config.BodyTransform{route.Transform.Request, route.Transform.Response} {
+				if spec == nil {
+					continue
+				}
+				transform, err := jsontransform.Compile(*spec)
+				if err != nil {
+					return nil, fmt.Errorf("service '%s', route '%s': transform: %w", serviceName, route.DisplayName(), err)
+				}
+				compiled[spec] = transform
+			}
+		}
+	}
+	if len(compiled) == 0 {
+		return next, nil
+	}
+	return &BodyTransformer{appConfig: appConfig, next: next, compiled: compiled}, nil
+}
+
+// ForwardRequest implements RequestForwarder for BodyTransformer
+func (b *BodyTransformer) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
+	route, ok := b.appConfig.Service(serviceName).Route(req.Method, strings.TrimPrefix(req.URL.Path, "/api/"+serviceName))
+	if !ok || route.Transform == nil {
+		b.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+	limit := route.Transform.BodyLimit()
+
+	if spec := route.Transform.Request; spec != nil && hasBody(req) && isJSONContentType(req.Header.Get("Content-Type")) {
+		transformed, err := b.transformRequest(req, b.compiled[spec], limit)
+		if problemType, message, ok := requestBodyError(err); ok {
+			bodyTransforms.Inc(serviceName, "request", "rejected")
+			writeError(w, req, problemType, message)
+			return
+		}
+		switch {
+		case errors.Is(err, errBodyTooLarge):
+			bodyTransforms.Inc(serviceName, "request", "too_large")
+			writeError(w, req, problem.BodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", limit))
+			return
+		case err != nil:
+			bodyTransforms.Inc(serviceName, "request", "error")
+			writeError(w, req, problem.InvalidBody, "Invalid JSON request body")
+			return
+		}
+		defer transformed.Body.Close()
+		bodyTransforms.Inc(serviceName, "request", "ok")
+		req = transformed
+	}
+
+	if spec := route.Transform.Response; spec != nil {
+		ctx := context.WithValue(req.Context(), responseTransformKey{}, &responseTransform{
+			transform: b.compiled[spec],
+			limit:     limit,
+		})
+		req = req.Clone(ctx)
+		// The body has to arrive uncompressed to be transformed; without
+		// Accept-Encoding the transport negotiates and decodes gzip itself
+		req.Header.Del("Accept-Encoding")
+	}
+
+	b.next.ForwardRequest(w, req, serviceName)
+}
+
+// transformRequest returns a copy of req carrying the transformed body.
+// Streamable transforms are applied while the body is sent upstream.
+func (b *BodyTransformer) transformRequest(req *http.Request, transform *jsontransform.Transform, limit int64) (*http.Request, error) {
+	transformed := req.Clone(req.Context())
+
+	if transform.Streamable() {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(transform.Stream(pw, req.Body))
+		}()
+		transformed.Body = pr
+		transformed.ContentLength = -1
+		transformed.Header.Del("Content-Length")
+		return transformed, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errBodyTooLarge
+	}
+	if data, err = transform.Rewrite(data); err != nil {
+		return nil, err
+	}
+	transformed.Body = io.NopCloser(bytes.NewReader(data))
+	transformed.ContentLength = int64(len(data))
+	transformed.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return transformed, nil
+}
+
+type responseTransformKey struct{}
+
+// responseTransform is the response transform of the route a request
+// matched, passed to the reverse proxy through the request context
+type responseTransform struct {
+	transform *jsontransform.Transform
+	limit     int64
+}
+
+// transformResponse applies the request's response transform, if any, to a
+// successful JSON response. It is called from the reverse proxy's
+// ModifyResponse hook; an error makes the proxy answer 502 Bad Gateway
+// rather than leak an untransformed body.
+func transformResponse(res *http.Response, serviceName string) error {
+	rt, ok := res.Request.Context().Value(responseTransformKey{}).(*responseTransform)
+	if !ok || res.StatusCode < 200 || res.StatusCode >= 300 || !isJSONContentType(res.Header.Get("Content-Type")) {
+		return nil
+	}
+	if encoding := res.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		bodyTransforms.Inc(serviceName, "response", "error")
+		return fmt.Errorf("cannot transform %s-encoded response", encoding)
+	}
+
+	// The transformed body is a different representation of the resource
+	if etag := res.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		res.Header.Set("ETag", "W/"+etag)
+	}
+
+	if rt.transform.Streamable() {
+		upstream := res.Body
+		pr, pw := io.Pipe()
+		go func() {
+			err := rt.transform.Stream(pw, upstream)
+			upstream.Close()
+			if err != nil {
+				log.Printf("Failed to transform streamed response from '%s': %v", serviceName, err)
+				bodyTransforms.Inc(serviceName, "response", "error")
+			} else {
+				bodyTransforms.Inc(serviceName, "response", "ok")
+			}
+			pw.CloseWithError(err)
+		}()
+		res.Body = pr
+		res.ContentLength = -1
+		res.Header.Del("Content-Length")
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, rt.limit+1))
+	res.Body.Close()
+	if err != nil {
+		bodyTransforms.Inc(serviceName, "response", "error")
+		return err
+	}
+	if int64(len(data)) > rt.limit {
+		bodyTransforms.Inc(serviceName, "response", "too_large")
+		return fmt.Errorf("response: %w (%d bytes)", errBodyTooLarge, rt.limit)
+	}
+	if data, err = rt.transform.Rewrite(data); err != nil {
+		bodyTransforms.Inc(serviceName, "response", "error")
+		return fmt.Errorf("response: %w", err)
+	}
+	bodyTransforms.Inc(serviceName, "response", "ok")
+	res.Body = io.NopCloser(bytes.NewReader(data))
+	res.ContentLength = int64(len(data))
+	res.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return nil
+}
+
+func hasBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0
+}
+
+// isJSONContentType reports whether a Content-Type is application/json or
+// one of its +json variants
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,150 @@
+package usecase
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+func newTestBodyTransformer(t *testing.T, upstreamURL string, transforms config.BodyTransforms) RequestForwarder {
+	t.Helper()
+	appConfig := config.AppConfig{
+		KnownServices: map[string]string{"users": upstreamURL},
+		Services: map[string]config.ServiceConfig{"users": {Routes: []config.RouteConfig{
+			{PathPrefix: "/users", Transform: &transforms},
+		}}},
+	}
+	transformer, err := NewBodyTransformer(appConfig, NewApiGatewayService(appConfig, nil))
+	if err != nil {
+		t.Fatalf("failed to create body transformer: %v", err)
+	}
+	return transformer
+}
+
+func TestBodyTransformerRewritesRequestAndResponse(t *testing.T) {
+	var received string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		if r.Header.Get("Accept-Encoding") == "br" {
+			t.Error("expected the client's Accept-Encoding not to reach the upstream")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"status":"OK","result":{"usr_nm":"ada","password_hash":"x"}}`))
+	}))
+	defer upstream.Close()
+
+	transformer := newTestBodyTransformer(t, upstream.URL, config.BodyTransforms{
+		Request: &config.BodyTransform{Rename: map[string]string{"$.username": "usr_nm"}},
+		Response: &config.BodyTransform{
+			Unwrap: "$.result",
+			Allow:  []string{"$.usr_nm"},
+			Rename: map[string]string{"$.usr_nm": "username"},
+			Wrap:   "data",
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users/users", strings.NewReader(`{"username":"ada"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Encoding", "br")
+	w := httptest.NewRecorder()
+	transformer.ForwardRequest(w, req, "users")
+
+	if received != `{"usr_nm":"ada"}` {
+		t.Errorf("expected the transformed request body upstream, got %s", received)
+	}
+	if w.Body.String() != `{"data":{"username":"ada"}}` {
+		t.Errorf("expected the transformed response body, got %s", w.Body.String())
+	}
+	if w.Header().Get("Content-Length") != "27" {
+		t.Errorf("expected Content-Length to match the new body, got %q", w.Header().Get("Content-Length"))
+	}
+	if w.Header().Get("ETag") != `W/"v1"` {
+		t.Errorf("expected the ETag to become weak, got %q", w.Header().Get("ETag"))
+	}
+}
+
+func TestBodyTransformerStreamsArrays(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("["))
+		for i := range 500 {
+			if i > 0 {
+				w.Write([]byte(","))
+			}
+			w.Write([]byte(`{"id":1,"email":"a@example.com","secret":"` + strings.Repeat("x", 100) + `"}`))
+		}
+		w.Write([]byte("]"))
+	}))
+	defer upstream.Close()
+
+	// The body is well over the limit, which only applies to buffered bodies
+	transformer := newTestBodyTransformer(t, upstream.URL, config.BodyTransforms{
+		Response:     &config.BodyTransform{Allow: []string{"$[*].id", "$[*].email"}},
+		MaxBodyBytes: 1024,
+	})
+	w := httptest.NewRecorder()
+	transformer.ForwardRequest(w, httptest.NewRequest(http.MethodGet, "/api/users/users", nil), "users")
+
+	var users []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if len(users) != 500 || len(users[0]) != 2 || users[0]["secret"] != nil {
+		t.Errorf("expected 500 projected users, got %d (first: %v)", len(users), users[0])
+	}
+}
+
+func TestBodyTransformerLimitsAndPassThrough(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/error" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":"` + strings.Repeat("x", 2048) + `"}`))
+	}))
+	defer upstream.Close()
+
+	transformer := newTestBodyTransformer(t, upstream.URL, config.BodyTransforms{
+		Request:      &config.BodyTransform{Remove: []string{"$.debug"}},
+		Response:     &config.BodyTransform{Unwrap: "$.data"},
+		MaxBodyBytes: 1024,
+	})
+
+	w := httptest.NewRecorder()
+	transformer.ForwardRequest(w, httptest.NewRequest(http.MethodGet, "/api/users/users", nil), "users")
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 for a response over the limit, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	transformer.ForwardRequest(w, httptest.NewRequest(http.MethodGet, "/api/users/users/error", nil), "users")
+	if w.Code != http.StatusNotFound || w.Body.String() != `{"error":"not found"}` {
+		t.Errorf("expected error responses to pass through, got %d %s", w.Code, w.Body.String())
+	}
+
+	large := httptest.NewRequest(http.MethodPost, "/api/users/users", strings.NewReader(`{"name":"`+strings.Repeat("x", 2048)+`"}`))
+	large.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	transformer.ForwardRequest(w, large, "users")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a request over the limit, got %d", w.Code)
+	}
+
+	invalid := httptest.NewRequest(http.MethodPost, "/api/users/users", strings.NewReader(`{"name":`))
+	invalid.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	transformer.ForwardRequest(w, invalid, "users")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid JSON body, got %d", w.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package usecase
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// bufferedResponse is an in-memory http.ResponseWriter used when the gateway
+// calls a service on its own behalf and needs the whole response
+type bufferedResponse struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+	limit      int
+	overflowed bool // the body went over limit and was cut short
+}
+
+func newBufferedResponse(limit int) *bufferedResponse {
+	return &bufferedResponse{header: http.Header{}, limit: limit}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(statusCode int) {
+	if b.statusCode == 0 {
+		b.statusCode = statusCode
+	}
+}
+
+// Write keeps at most limit bytes. The rest is dropped rather than failed:
+// ReverseProxy aborts the handler with a panic when a write fails, which
+// nothing recovers outside the request's own goroutine.
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	if b.statusCode == 0 {
+		b.statusCode = http.StatusOK
+	}
+	if b.limit > 0 && b.body.Len()+len(data) > b.limit {
+		b.body.Write(data[:b.limit-b.body.Len()])
+		b.overflowed = true
+		return len(data), nil
+	}
+	return b.body.Write(data)
+}
+
+// Flush implements http.Flusher; there is nothing to flush in memory
+func (b *bufferedResponse) Flush() {}
+
+// status returns the recorded status, defaulting to 200 like net/http
+func (b *bufferedResponse) status() int {
+	if b.statusCode == 0 {
+		return http.StatusOK
+	}
+	return b.statusCode
+}
+
+// forwardCatchingAbort forwards a request the gateway sends on its own
+// behalf and reports whether the upstream response broke off. ReverseProxy
+// panics with http.ErrAbortHandler when copying a response fails, which
+// net/http only recovers in the goroutine serving the client's request.
+func forwardCatchingAbort(next RequestForwarder, w http.ResponseWriter, req *http.Request, serviceName string) (aborted bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r != http.ErrAbortHandler {
+				panic(r)
+			}
+			aborted = true
+		}
+	}()
+	next.ForwardRequest(w, req, serviceName)
+	return false
+}
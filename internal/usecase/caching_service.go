@@ -0,0 +1,411 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/cache"
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+)
+
+// defaultMaxCacheEntryBytes is used when a service does not set max_entry_bytes
+const defaultMaxCacheEntryBytes = 1 << 20
+
+// backgroundRevalidationTimeout bounds stale-while-revalidate refreshes,
+// which outlive the client request that triggered them
+const backgroundRevalidationTimeout = 30 * time.Second
+
+// Values of the X-Cache response header
+const (
+	cacheHit         = "HIT"
+	cacheMiss        = "MISS"
+	cacheStale       = "STALE"
+	cacheRevalidated = "REVALIDATED"
+	cacheBypass      = "BYPASS"
+)
+
+var cacheRequests = metrics.NewCounter("gateway_cache_requests_total",
+	"Requests to cached services, by service and cache result", "service", "result")
+
+// CachingService implements RequestForwarder by answering cacheable requests
+// for services with a cache section from the shared response cache, and
+// forwarding everything else to next
+type CachingService struct {
+	appConfig config.AppConfig
+	store     *cache.Store
+	next      RequestForwarder
+
+	revalidating sync.Map
+	now          func() time.Time
+}
+
+// NewCachingService wraps next with the response cache backed by store
+func NewCachingService(appConfig config.AppConfig, store *cache.Store, next RequestForwarder) RequestForwarder {
+	return &CachingService{
+		appConfig: appConfig,
+		store:     store,
+		next:      next,
+		now:       time.Now,
+	}
+}
+
+// CacheKey builds the key a request is cached under: method, service,
+// rewritten path, normalised query, the service version picked by the
+// traffic splitter, the consumer and the given vary headers. HEAD requests
+// share the GET entry. Purging by the prefix "GET <service>/" removes every
+// entry of a service.
+func CacheKey(req *http.Request, serviceName string, varyHeaders []string) string {
+	var key strings.Builder
+	key.WriteString(http.MethodGet)
+	key.WriteString(" ")
+	key.WriteString(serviceName)
+	key.WriteString(strings.TrimPrefix(req.URL.Path, "/api/"+serviceName))
+	if query := req.URL.Query(); len(query) > 0 {
+		key.WriteString("?")
+		key.WriteString(query.Encode())
+	}
+	if version := versionFromContext(req.Context(), serviceName); version != "" {
+		key.WriteString("\x00version=")
+		key.WriteString(version)
+	}
+	// The gateway's own API key is removed before forwarding, so the
+	// consumer stands in for it
+	if consumer := requestctx.From(req.Context()).Consumer(); consumer != "" {
+		key.WriteString("\x00consumer=")
+		key.WriteString(consumer)
+	}
+
+	names := append([]string(nil), varyHeaders...)
+	sort.Strings(names)
+	for _, name := range names {
+		key.WriteString("\x00")
+		key.WriteString(http.CanonicalHeaderKey(name))
+		key.WriteString("=")
+		key.WriteString(strings.Join(req.Header.Values(name), ", "))
+	}
+	return key.String()
+}
+
+// keyHeaders are the headers a request to a service is cached or coalesced
+// by: the configured vary headers and the headers header rules fill in
+// with the caller's identity
+func keyHeaders(appConfig config.AppConfig, req *http.Request, serviceName string, varyHeaders []string) []string {
+	return append(slices.Clone(varyHeaders), identityHeaders(appConfig, req, serviceName)...)
+}
+
+// ForwardRequest implements RequestForwarder for CachingService
+func (c *CachingService) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
+	cacheConfig := c.appConfig.Service(serviceName).Cache
+	if cacheConfig == nil {
+		c.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+	if !cache.IsRequestCacheable(req) {
+		cacheRequests.Inc(serviceName, cacheBypass)
+		c.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+
+	requestID := req.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = "unknown"
+	}
+
+	key := CacheKey(req, serviceName, keyHeaders(c.appConfig, req, serviceName, cacheConfig.VaryHeaders))
+	now := c.now()
+
+	entry, found := c.store.Get(key)
+	if found && !entry.MatchesVary(req) {
+		found = false
+	}
+
+	if found && !cache.RequiresRevalidation(req) {
+		if entry.IsFresh(now) {
+			c.serveEntry(w, req, entry, cacheHit, serviceName)
+			return
+		}
+		if entry.WithinStaleWhileRevalidate(now) {
+			c.serveEntry(w, req, entry, cacheStale, serviceName)
+			c.revalidateInBackground(req, serviceName, key, entry, cacheConfig, requestID)
+			return
+		}
+	}
+
+	// HEAD responses carry no body, so they can only be served from the cache
+	if req.Method == http.MethodHead {
+		cacheRequests.Inc(serviceName, cacheMiss)
+		c.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+
+	if found {
+		c.revalidate(w, req, serviceName, key, entry, cacheConfig, requestID)
+		return
+	}
+
+	c.fill(w, req, serviceName, key, cacheConfig, requestID)
+}
+
+// fill forwards a cache miss, streaming the response to the client while
+// keeping a copy to store if the response turns out to be cacheable
+func (c *CachingService) fill(w http.ResponseWriter, req *http.Request, serviceName, key string, cfg *config.ServiceCacheConfig, requestID string) {
+	cacheRequests.Inc(serviceName, cacheMiss)
+
+	capture := newCaptureWriter(w, maxCacheEntryBytes(cfg))
+	capture.onHeader = func(statusCode int, header http.Header) bool {
+		header.Set("X-Cache", cacheMiss)
+		return true
+	}
+	c.next.ForwardRequest(capture, req, serviceName)
+
+	c.storeResponse(req, serviceName, key, capture, c.now(), cfg, requestID)
+}
+
+// revalidate asks the upstream whether a stale entry is still valid. A 304
+// refreshes the entry; upstream errors fall back to the stale entry when
+// stale-if-error allows it; anything else replaces the entry.
+func (c *CachingService) revalidate(w http.ResponseWriter, req *http.Request, serviceName, key string, entry *cache.Entry, cfg *config.ServiceCacheConfig, requestID string) {
+	conditional := conditionalRequest(req.Context(), req, entry)
+
+	var outcome string
+	capture := newCaptureWriter(w, maxCacheEntryBytes(cfg))
+	capture.onHeader = func(statusCode int, header http.Header) bool {
+		switch {
+		case statusCode == http.StatusNotModified:
+			outcome = cacheRevalidated
+			return false
+		case statusCode >= 500 && entry.WithinStaleIfError(c.now()):
+			outcome = cacheStale
+			return false
+		}
+		outcome = cacheMiss
+		header.Set("X-Cache", cacheMiss)
+		return true
+	}
+	c.next.ForwardRequest(capture, conditional, serviceName)
+
+	now := c.now()
+	switch outcome {
+	case cacheRevalidated:
+		refreshed, ok := entry.Refresh(req, capture.Header(), now, cachePolicy(cfg))
+		if ok {
+			c.store.Set(key, refreshed)
+			entry = refreshed
+		}
+		c.serveEntry(w, req, entry, cacheRevalidated, serviceName)
+	case cacheStale:
+		log.Printf("[%s] Upstream for '%s' answered %d, serving stale cached response", requestID, serviceName, capture.statusCode)
+		c.serveEntry(w, req, entry, cacheStale, serviceName)
+	default:
+		cacheRequests.Inc(serviceName, cacheMiss)
+		c.storeResponse(req, serviceName, key, capture, now, cfg, requestID)
+	}
+}
+
+// revalidateInBackground refreshes an entry served under
+// stale-while-revalidate, at most once at a time per key
+func (c *CachingService) revalidateInBackground(req *http.Request, serviceName, key string, entry *cache.Entry, cfg *config.ServiceCacheConfig, requestID string) {
+	if _, running := c.revalidating.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), backgroundRevalidationTimeout)
+	conditional := conditionalRequest(ctx, req, entry)
+
+	go func() {
+		defer cancel()
+		defer c.revalidating.Delete(key)
+
+		capture := newCaptureWriter(nil, maxCacheEntryBytes(cfg))
+		if forwardCatchingAbort(c.next, capture, conditional, serviceName) {
+			log.Printf("[%s] Background revalidation for '%s' failed: the response broke off", requestID, serviceName)
+			return
+		}
+
+		now := c.now()
+		switch {
+		case capture.statusCode == http.StatusNotModified:
+			if refreshed, ok := entry.Refresh(req, capture.Header(), now, cachePolicy(cfg)); ok {
+				c.store.Set(key, refreshed)
+			}
+		case capture.statusCode >= 500:
+			log.Printf("[%s] Background revalidation for '%s' failed with status %d", requestID, serviceName, capture.statusCode)
+		default:
+			c.storeResponse(req, serviceName, key, capture, now, cfg, requestID)
+		}
+	}()
+}
+
+// storeResponse caches a captured upstream response when RFC 9111 allows it
+func (c *CachingService) storeResponse(req *http.Request, serviceName, key string, capture *captureWriter, now time.Time, cfg *config.ServiceCacheConfig, requestID string) {
+	if !capture.storable() {
+		return
+	}
+
+	header := capture.Header().Clone()
+	header.Del("X-Cache")
+	entry, ok := cache.NewEntry(req, capture.statusCode, header, capture.body.Bytes(), now, cachePolicy(cfg))
+	if !ok {
+		return
+	}
+	if !c.store.Set(key, entry) {
+		log.Printf("[%s] Response for '%s' is larger than the whole cache, not storing it", requestID, serviceName)
+	}
+}
+
+// conditionalRequest clones req into a GET that carries the entry's validators
+func conditionalRequest(ctx context.Context, req *http.Request, entry *cache.Entry) *http.Request {
+	conditional := req.Clone(ctx)
+	conditional.Method = http.MethodGet
+	conditional.Body = http.NoBody
+	conditional.ContentLength = 0
+	conditional.Header.Del("If-None-Match")
+	conditional.Header.Del("If-Modified-Since")
+	conditional.Header.Del("Cache-Control")
+	conditional.Header.Del("Pragma")
+
+	etag, lastModified := entry.Validators()
+	if etag != "" {
+		conditional.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		conditional.Header.Set("If-Modified-Since", lastModified)
+	}
+	return conditional
+}
+
+// serveEntry writes a cached response, answering 304 when the client's own
+// validators match
+func (c *CachingService) serveEntry(w http.ResponseWriter, req *http.Request, entry *cache.Entry, result, serviceName string) {
+	cacheRequests.Inc(serviceName, result)
+
+	header := w.Header()
+	for name, values := range entry.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("Age", strconv.FormatInt(entry.Age(c.now()), 10))
+	header.Set("X-Cache", result)
+
+	if entry.NotModifiedFor(req) {
+		header.Del("Content-Length")
+		header.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	w.WriteHeader(entry.StatusCode)
+	if req.Method != http.MethodHead {
+		if _, err := w.Write(entry.Body); err != nil {
+			log.Printf("Failed to write cached response: %v", err)
+		}
+	}
+}
+
+func cachePolicy(cfg *config.ServiceCacheConfig) cache.Policy {
+	return cache.Policy{
+		DefaultTTL:           cfg.DefaultTTL.Duration,
+		StaleWhileRevalidate: cfg.StaleWhileRevalidate.Duration,
+		StaleIfError:         cfg.StaleIfError.Duration,
+	}
+}
+
+func maxCacheEntryBytes(cfg *config.ServiceCacheConfig) int {
+	if cfg.MaxEntryBytes > 0 {
+		return int(cfg.MaxEntryBytes)
+	}
+	return defaultMaxCacheEntryBytes
+}
+
+// captureWriter records an upstream response up to a size limit. When dst
+// is set, onHeader decides once the status is known whether the response is
+// also passed through to the client or held back (e.g. a 304 from a
+// revalidation that the cache answers itself).
+type captureWriter struct {
+	dst      http.ResponseWriter
+	header   http.Header
+	onHeader func(statusCode int, header http.Header) bool
+
+	statusCode  int
+	wroteHeader bool
+	passthrough bool
+
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func newCaptureWriter(dst http.ResponseWriter, limit int) *captureWriter {
+	return &captureWriter{dst: dst, header: http.Header{}, limit: limit}
+}
+
+func (cw *captureWriter) Header() http.Header {
+	return cw.header
+}
+
+func (cw *captureWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.statusCode = statusCode
+
+	if cw.dst == nil {
+		return
+	}
+	cw.passthrough = cw.onHeader == nil || cw.onHeader(statusCode, cw.header)
+	if cw.passthrough {
+		for name, values := range cw.header {
+			cw.dst.Header()[name] = values
+		}
+		cw.dst.WriteHeader(statusCode)
+	}
+}
+
+func (cw *captureWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.overflow {
+		if cw.body.Len()+len(data) > cw.limit {
+			cw.overflow = true
+			cw.body.Reset()
+		} else {
+			cw.body.Write(data)
+		}
+	}
+
+	if cw.passthrough {
+		return cw.dst.Write(data)
+	}
+	return len(data), nil
+}
+
+// Flush implements http.Flusher for passed-through responses
+func (cw *captureWriter) Flush() {
+	if cw.passthrough {
+		http.NewResponseController(cw.dst).Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the client's writer, so streamed
+// responses can lift the server's write deadline
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.dst
+}
+
+// storable reports whether the captured response is complete enough to cache
+func (cw *captureWriter) storable() bool {
+	return cw.wroteHeader && !cw.overflow
+}
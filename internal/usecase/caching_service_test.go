@@ -0,0 +1,231 @@
+package usecase
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/cache"
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+// cachedUpstream answers with a versioned, revalidatable JSON document and
+// counts how often it is reached
+type cachedUpstream struct {
+	calls        atomic.Int32
+	conditionals atomic.Int32
+	version      atomic.Int32
+	failing      atomic.Bool
+	cacheControl string
+}
+
+func (u *cachedUpstream) ForwardRequest(w http.ResponseWriter, r *http.Request, serviceName string) {
+	u.calls.Add(1)
+	if u.failing.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	etag := fmt.Sprintf(`"v%d"`, u.version.Load())
+	w.Header().Set("Cache-Control", u.cacheControl)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") != "" {
+		u.conditionals.Add(1)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"version":%d}`, u.version.Load())
+}
+
+func newTestCachingService(upstream RequestForwarder, serviceCache config.ServiceCacheConfig) (*CachingService, *cache.Store, *time.Time) {
+	appConfig := config.AppConfig{
+		KnownServices: map[string]string{"users": "http://users"},
+		Services:      map[string]config.ServiceConfig{"users": {Cache: &serviceCache}},
+	}
+	store := cache.NewStore(1 << 20)
+	caching := NewCachingService(appConfig, store, upstream).(*CachingService)
+
+	now := time.Now()
+	caching.now = func() time.Time { return now }
+	return caching, store, &now
+}
+
+func getCached(caching RequestForwarder, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/users/users?b=2&a=1", nil)
+	req.Header.Set("X-Request-ID", "cache-test")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	caching.ForwardRequest(w, req, "users")
+	return w
+}
+
+func TestCachingServiceHitsAndConditionals(t *testing.T) {
+	upstream := &cachedUpstream{cacheControl: "max-age=60"}
+	caching, _, now := newTestCachingService(upstream, config.ServiceCacheConfig{})
+
+	if w := getCached(caching, nil); w.Header().Get("X-Cache") != cacheMiss || w.Body.String() != `{"version":0}` {
+		t.Fatalf("expected a cache miss with the upstream body, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+
+	*now = now.Add(10 * time.Second)
+	w := getCached(caching, nil)
+	if w.Header().Get("X-Cache") != cacheHit || w.Body.String() != `{"version":0}` {
+		t.Fatalf("expected a cache hit, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if w.Header().Get("Age") != "10" {
+		t.Errorf("expected Age 10, got %q", w.Header().Get("Age"))
+	}
+
+	w = getCached(caching, http.Header{"If-None-Match": {`"v0"`}})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected 304 without body for a matching If-None-Match, got %d %q", w.Code, w.Body.String())
+	}
+
+	if calls := upstream.calls.Load(); calls != 1 {
+		t.Errorf("expected the upstream to be called once, got %d", calls)
+	}
+
+	// A client asking for revalidation goes to the upstream with the cached validators
+	getCached(caching, http.Header{"Cache-Control": {"no-cache"}})
+	if upstream.conditionals.Load() != 1 {
+		t.Errorf("expected a conditional revalidation request, got %d", upstream.conditionals.Load())
+	}
+}
+
+func TestCachingServiceRevalidation(t *testing.T) {
+	upstream := &cachedUpstream{cacheControl: "max-age=60"}
+	caching, store, now := newTestCachingService(upstream, config.ServiceCacheConfig{
+		StaleIfError: config.Duration{Duration: time.Hour},
+	})
+
+	getCached(caching, nil)
+
+	t.Run("stale entry revalidated with 304", func(t *testing.T) {
+		*now = now.Add(2 * time.Minute)
+		w := getCached(caching, nil)
+		if w.Header().Get("X-Cache") != cacheRevalidated || w.Body.String() != `{"version":0}` {
+			t.Fatalf("expected a revalidated cached response, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+		}
+		if w.Header().Get("Age") != "0" {
+			t.Errorf("expected the revalidated entry to be fresh again, got Age %q", w.Header().Get("Age"))
+		}
+	})
+
+	t.Run("stale entry replaced when the upstream changed", func(t *testing.T) {
+		*now = now.Add(2 * time.Minute)
+		upstream.version.Store(1)
+		if w := getCached(caching, nil); w.Body.String() != `{"version":1}` {
+			t.Fatalf("expected the new version, got %q", w.Body.String())
+		}
+		if w := getCached(caching, nil); w.Header().Get("X-Cache") != cacheHit || w.Body.String() != `{"version":1}` {
+			t.Errorf("expected the new version to be cached, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+		}
+	})
+
+	t.Run("stale entry served when the upstream fails", func(t *testing.T) {
+		*now = now.Add(2 * time.Minute)
+		upstream.failing.Store(true)
+		w := getCached(caching, nil)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != cacheStale || w.Body.String() != `{"version":1}` {
+			t.Errorf("expected the stale response, got %d %q %q", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+		}
+	})
+
+	t.Run("purged entries are fetched again", func(t *testing.T) {
+		upstream.failing.Store(false)
+		if removed := store.DeletePrefix("GET users/"); removed != 1 {
+			t.Fatalf("expected one entry purged, got %d", removed)
+		}
+		if w := getCached(caching, nil); w.Header().Get("X-Cache") != cacheMiss {
+			t.Errorf("expected a miss after purging, got %q", w.Header().Get("X-Cache"))
+		}
+	})
+}
+
+func TestCachingServiceBackgroundRevalidationBreaksOff(t *testing.T) {
+	upstream := &cachedUpstream{cacheControl: "max-age=60, stale-while-revalidate=60"}
+	var truncating atomic.Bool
+	forwarder := forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+		if !truncating.Load() {
+			upstream.ForwardRequest(w, r, serviceName)
+			return
+		}
+		// What ReverseProxy does when the upstream body breaks off
+		w.Header().Set("Cache-Control", upstream.cacheControl)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"vers`))
+		panic(http.ErrAbortHandler)
+	})
+	caching, _, now := newTestCachingService(forwarder, config.ServiceCacheConfig{})
+
+	getCached(caching, nil)
+	*now = now.Add(90 * time.Second)
+	truncating.Store(true)
+	if w := getCached(caching, nil); w.Header().Get("X-Cache") != cacheStale {
+		t.Fatalf("expected the stale response, got %q", w.Header().Get("X-Cache"))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		revalidating := false
+		caching.revalidating.Range(func(key, value any) bool {
+			revalidating = true
+			return false
+		})
+		if !revalidating {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background revalidation did not finish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if w := getCached(caching, nil); w.Body.String() != `{"version":0}` {
+		t.Errorf("expected the broken-off response not to replace the entry, got %q", w.Body.String())
+	}
+}
+
+func TestCachingServiceBypass(t *testing.T) {
+	upstream := &cachedUpstream{cacheControl: "no-store"}
+	caching, _, _ := newTestCachingService(upstream, config.ServiceCacheConfig{})
+
+	getCached(caching, nil)
+	getCached(caching, nil)
+	if calls := upstream.calls.Load(); calls != 2 {
+		t.Errorf("expected no-store responses to reach the upstream every time, got %d calls", calls)
+	}
+
+	upstream.cacheControl = "max-age=60"
+	req := httptest.NewRequest(http.MethodPost, "/api/users/users", nil)
+	caching.ForwardRequest(httptest.NewRecorder(), req, "users")
+	caching.ForwardRequest(httptest.NewRecorder(), req, "users")
+	if calls := upstream.calls.Load(); calls != 4 {
+		t.Errorf("expected POST requests to bypass the cache, got %d calls", calls)
+	}
+}
+
+func TestCacheKeyNormalisesQueryAndVaryHeaders(t *testing.T) {
+	first := httptest.NewRequest(http.MethodGet, "/api/users/users?b=2&a=1", nil)
+	first.Header.Set("Accept-Language", "en")
+	second := httptest.NewRequest(http.MethodHead, "/api/users/users?a=1&b=2", nil)
+	second.Header.Set("Accept-Language", "en")
+
+	vary := []string{"accept-language"}
+	if CacheKey(first, "users", vary) != CacheKey(second, "users", vary) {
+		t.Error("expected query order and HEAD to map to the same key")
+	}
+
+	second.Header.Set("Accept-Language", "es")
+	if CacheKey(first, "users", vary) == CacheKey(second, "users", vary) {
+		t.Error("expected configured vary headers to be part of the key")
+	}
+}
@@ -0,0 +1,217 @@
+package usecase
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/LucianoBarrera/api-gateway/internal/cache"
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+)
+
+// Outcomes recorded for requests that joined another request's upstream call
+const (
+	coalesceShared     = "shared"
+	coalesceUnshared   = "unshareable"
+	coalesceMaxWaiters = "max_waiters"
+)
+
+var coalescedRequests = metrics.NewCounter("gateway_coalesced_requests_total",
+	"Requests that waited on an identical in-flight request, by service, route and outcome", "service", "route", "outcome")
+
+// flight is one upstream call shared by identical concurrent requests
+type flight struct {
+	done    chan struct{}
+	release sync.Once
+	waiters int
+
+	// Set before done is closed
+	shared     bool
+	statusCode int
+	header     http.Header
+	body       []byte
+	varyValues map[string]string
+}
+
+// finish publishes the leader's response, if it can be shared, and wakes
+// the waiters. Only the first call has an effect.
+func (f *flight) finish(req *http.Request, shared bool, capture *captureWriter) {
+	f.release.Do(func() {
+		if shared {
+			f.varyValues, f.shared = varyValues(req, capture.Header())
+			f.statusCode = capture.statusCode
+			f.header = capture.Header().Clone()
+			f.body = capture.body.Bytes()
+		}
+		close(f.done)
+	})
+}
+
+// matchesVary reports whether a waiter selects the same variant as the leader
+func (f *flight) matchesVary(req *http.Request) bool {
+	for name, value := range f.varyValues {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// CoalescingService implements RequestForwarder by collapsing identical
+// concurrent GET requests on routes with coalescing enabled into a single
+// upstream call whose response is fanned out to every waiting request
+type CoalescingService struct {
+	appConfig config.AppConfig
+	next      RequestForwarder
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// NewCoalescingService wraps next with per-route request coalescing
+func NewCoalescingService(appConfig config.AppConfig, next RequestForwarder) RequestForwarder {
+	return &CoalescingService{
+		appConfig: appConfig,
+		next:      next,
+		flights:   map[string]*flight{},
+	}
+}
+
+// ForwardRequest implements RequestForwarder for CoalescingService
+func (c *CoalescingService) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
+	path := strings.TrimPrefix(req.URL.Path, "/api/"+serviceName)
+	route, found := c.appConfig.Service(serviceName).Route(req.Method, path)
+	if !found || route.Coalesce == nil || req.Method != http.MethodGet || !cache.IsRequestCacheable(req) {
+		c.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+	cfg := route.Coalesce
+
+	key := c.coalesceKey(req, serviceName, cfg)
+
+	c.mu.Lock()
+	current, inFlight := c.flights[key]
+	if !inFlight {
+		current = &flight{done: make(chan struct{})}
+		c.flights[key] = current
+		c.mu.Unlock()
+		c.lead(w, req, serviceName, key, current, cfg)
+		return
+	}
+	if current.waiters >= coalesceMaxWaitersFor(cfg) {
+		c.mu.Unlock()
+		coalescedRequests.Inc(serviceName, route.DisplayName(), coalesceMaxWaiters)
+		c.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+	current.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-current.done:
+	case <-req.Context().Done():
+		return
+	}
+
+	if !current.shared || !current.matchesVary(req) {
+		coalescedRequests.Inc(serviceName, route.DisplayName(), coalesceUnshared)
+		c.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+
+	coalescedRequests.Inc(serviceName, route.DisplayName(), coalesceShared)
+	for name, values := range current.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.WriteHeader(current.statusCode)
+	if _, err := w.Write(current.body); err != nil {
+		log.Printf("Failed to write coalesced response: %v", err)
+	}
+}
+
+// lead performs the shared upstream call, streaming the response to its own
+// client while keeping a copy for the waiters
+func (c *CoalescingService) lead(w http.ResponseWriter, req *http.Request, serviceName, key string, current *flight, cfg *config.CoalesceConfig) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.flights, key)
+		c.mu.Unlock()
+	}()
+
+	capture := newCaptureWriter(w, coalesceMaxResponseBytesFor(cfg))
+	capture.onHeader = func(statusCode int, header http.Header) bool {
+		// Streams never complete in time to be shared; release the waiters
+		// so they open their own
+		if c.appConfig.Streaming.IsStreamingContentType(header.Get("Content-Type")) {
+			current.finish(req, false, nil)
+		}
+		return true
+	}
+
+	// Waiters must never be left behind, even if the upstream call panics
+	defer current.finish(req, false, nil)
+
+	c.next.ForwardRequest(capture, req, serviceName)
+
+	current.finish(req, isShareable(req, capture), capture)
+}
+
+// isShareable reports whether the leader's response may be handed to other
+// clients: it must be complete, not the result of the leader giving up, and
+// not start a session of its own
+func isShareable(req *http.Request, capture *captureWriter) bool {
+	if !capture.storable() || req.Context().Err() != nil {
+		return false
+	}
+	return capture.Header().Get("Set-Cookie") == ""
+}
+
+// varyValues returns the leader's values for the headers named by the
+// response's Vary header, or false when the response varies on everything
+func varyValues(req *http.Request, header http.Header) (map[string]string, bool) {
+	values := map[string]string{}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				values[name] = req.Header.Get(name)
+			}
+		}
+	}
+	return values, true
+}
+
+// coalesceKey extends the cache key with the caller's credentials, so
+// responses that depend on Authorization or cookies are only shared between
+// requests carrying the same ones
+func (c *CoalescingService) coalesceKey(req *http.Request, serviceName string, cfg *config.CoalesceConfig) string {
+	key := CacheKey(req, serviceName, keyHeaders(c.appConfig, req, serviceName, cfg.VaryHeaders))
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if value := req.Header.Get(name); value != "" {
+			sum := sha256.Sum256([]byte(value))
+			key += "\x00" + name + "=" + hex.EncodeToString(sum[:])
+		}
+	}
+	return key
+}
+
+func coalesceMaxWaitersFor(cfg *config.CoalesceConfig) int {
+	if cfg.MaxWaiters > 0 {
+		return cfg.MaxWaiters
+	}
+	return config.DefaultCoalesceMaxWaiters
+}
+
+func coalesceMaxResponseBytesFor(cfg *config.CoalesceConfig) int {
+	if cfg.MaxResponseBytes > 0 {
+		return int(cfg.MaxResponseBytes)
+	}
+	return defaultMaxCacheEntryBytes
+}
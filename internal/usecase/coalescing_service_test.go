@@ -0,0 +1,165 @@
+package usecase
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+)
+
+// slowUpstream holds every call until release is closed and counts them
+type slowUpstream struct {
+	calls   atomic.Int32
+	release chan struct{}
+	header  http.Header
+}
+
+func (u *slowUpstream) ForwardRequest(w http.ResponseWriter, r *http.Request, serviceName string) {
+	call := u.calls.Add(1)
+	<-u.release
+	for name, values := range u.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"call":%d}`, call)
+}
+
+func newTestCoalescingService(upstream RequestForwarder, coalesce config.CoalesceConfig) RequestForwarder {
+	return NewCoalescingService(config.AppConfig{
+		KnownServices: map[string]string{"users": "http://users"},
+		Services: map[string]config.ServiceConfig{"users": {Routes: []config.RouteConfig{
+			{Name: "users-list", PathPrefix: "/users", Methods: []string{"GET"}, Coalesce: &coalesce},
+		}}},
+	}, upstream)
+}
+
+// sendConcurrently issues n identical requests and waits until the upstream
+// has seen the expected number of calls before letting them complete
+func sendConcurrently(t *testing.T, coalescing RequestForwarder, upstream *slowUpstream, header http.Header, n int, expectedCalls int32) []*httptest.ResponseRecorder {
+	t.Helper()
+
+	recorders := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+			for name, values := range header {
+				req.Header[name] = values
+			}
+			coalescing.ForwardRequest(w, req, "users")
+		}(recorders[i])
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for upstream.calls.Load() < expectedCalls && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// Give the remaining requests time to join the flight
+	time.Sleep(20 * time.Millisecond)
+	close(upstream.release)
+	wg.Wait()
+	return recorders
+}
+
+func TestCoalescingServiceSharesOneUpstreamCall(t *testing.T) {
+	upstream := &slowUpstream{release: make(chan struct{})}
+	coalescing := newTestCoalescingService(upstream, config.CoalesceConfig{})
+
+	recorders := sendConcurrently(t, coalescing, upstream, nil, 20, 1)
+
+	if calls := upstream.calls.Load(); calls != 1 {
+		t.Errorf("expected a single upstream call, got %d", calls)
+	}
+	for i, w := range recorders {
+		if w.Code != http.StatusOK || w.Body.String() != `{"call":1}` {
+			t.Errorf("request %d: expected the shared response, got %d %q", i, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCoalescingServiceSafeguards(t *testing.T) {
+	t.Run("max waiters", func(t *testing.T) {
+		upstream := &slowUpstream{release: make(chan struct{})}
+		coalescing := newTestCoalescingService(upstream, config.CoalesceConfig{MaxWaiters: 2})
+
+		sendConcurrently(t, coalescing, upstream, nil, 6, 4)
+		if calls := upstream.calls.Load(); calls != 4 {
+			t.Errorf("expected one leader, two waiters and three own calls, got %d upstream calls", calls)
+		}
+	})
+
+	t.Run("Set-Cookie responses are not shared", func(t *testing.T) {
+		upstream := &slowUpstream{release: make(chan struct{}), header: http.Header{"Set-Cookie": {"session=1"}}}
+		coalescing := newTestCoalescingService(upstream, config.CoalesceConfig{})
+
+		recorders := sendConcurrently(t, coalescing, upstream, nil, 3, 1)
+		if calls := upstream.calls.Load(); calls != 3 {
+			t.Errorf("expected every request to reach the upstream, got %d calls", calls)
+		}
+		for i, w := range recorders {
+			if w.Header().Get("Set-Cookie") != "session=1" {
+				t.Errorf("request %d: expected its own cookie", i)
+			}
+		}
+	})
+
+	// Each caller sends its request as a different client
+	callers := map[string]func(req *http.Request, i int) *http.Request{
+		"credentials": func(req *http.Request, i int) *http.Request {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %d", i))
+			return req
+		},
+		"consumers": func(req *http.Request, i int) *http.Request {
+			ctx, info := requestctx.New(req.Context(), "coalesce-test")
+			info.SetConsumer(fmt.Sprintf("consumer-%d", i))
+			return req.WithContext(ctx)
+		},
+	}
+	for name, caller := range callers {
+		t.Run("different "+name+" are not coalesced", func(t *testing.T) {
+			upstream := &slowUpstream{release: make(chan struct{})}
+			coalescing := newTestCoalescingService(upstream, config.CoalesceConfig{})
+
+			var wg sync.WaitGroup
+			for i := range 2 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					req := caller(httptest.NewRequest(http.MethodGet, "/api/users/users", nil), i)
+					coalescing.ForwardRequest(httptest.NewRecorder(), req, "users")
+				}()
+			}
+			deadline := time.Now().Add(time.Second)
+			for upstream.calls.Load() < 2 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			close(upstream.release)
+			wg.Wait()
+
+			if calls := upstream.calls.Load(); calls != 2 {
+				t.Errorf("expected one upstream call per client, got %d", calls)
+			}
+		})
+	}
+
+	t.Run("routes without coalescing pass through", func(t *testing.T) {
+		upstream := &slowUpstream{release: make(chan struct{})}
+		close(upstream.release)
+		coalescing := newTestCoalescingService(upstream, config.CoalesceConfig{})
+
+		req := httptest.NewRequest(http.MethodGet, "/api/users/profile", nil)
+		coalescing.ForwardRequest(httptest.NewRecorder(), req, "users")
+		if upstream.calls.Load() != 1 {
+			t.Error("expected the request to be forwarded")
+		}
+	})
+}
@@ -0,0 +1,212 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+)
+
+// throttleChunkBytes is the largest write made at once to a throttled client
+const throttleChunkBytes = 1024
+
+var injectedFaults = metrics.NewCounter("gateway_injected_faults_total",
+	"Faults injected for chaos testing, by service, route and fault", "service", "route", "fault")
+
+// FaultInjector implements RequestForwarder by delaying, aborting, resetting
+// or throttling requests that match a route's fault rules, and forwarding
+// everything else to next unchanged
+type FaultInjector struct {
+	appConfig config.AppConfig
+	next      RequestForwarder
+}
+
+// NewFaultInjector validates the fault rules of every route and wraps next.
+// When fault injection is disabled, next is returned as is.
+func NewFaultInjector(appConfig config.AppConfig, next RequestForwarder) (RequestForwarder, error) {
+	for serviceName, serviceConfig := range appConfig.Services {
+		for _, route := range serviceConfig.Routes {
+			for i, rule := range route.Faults {
+				if err := validateFaultRule(rule); err != nil {
+					return nil, fmt.Errorf("service '%s', route '%s', fault %d: %w", serviceName, route.DisplayName(), i, err)
+				}
+			}
+		}
+	}
+
+	if !appConfig.FaultInjection.Enabled {
+		return next, nil
+	}
+	if appConfig.HasFaults() {
+		log.Printf("WARNING: fault injection is enabled")
+	}
+	return &FaultInjector{appConfig: appConfig, next: next}, nil
+}
+
+func validateFaultRule(rule config.FaultRule) error {
+	if rule.Percentage < 0 || rule.Percentage > 100 {
+		return fmt.Errorf("percentage must be between 0 and 100")
+	}
+	if rule.Header == "" && rule.Percentage == 0 {
+		return fmt.Errorf("either a percentage or a header is required")
+	}
+	if rule.AbortStatus != 0 && (rule.AbortStatus < 200 || rule.AbortStatus > 599) {
+		return fmt.Errorf("invalid abort status %d", rule.AbortStatus)
+	}
+	if rule.AbortStatus != 0 && rule.ResetConnection {
+		return fmt.Errorf("abort_status and reset_connection are mutually exclusive")
+	}
+	if rule.BandwidthBytesPerSecond < 0 {
+		return fmt.Errorf("bandwidth must be positive")
+	}
+	if rule.Delay != nil {
+		switch rule.Delay.Distribution {
+		case "", config.DelayFixed, config.DelayUniform, config.DelayNormal:
+		default:
+			return fmt.Errorf("unknown delay distribution '%s'", rule.Delay.Distribution)
+		}
+	}
+	if rule.Delay == nil && rule.AbortStatus == 0 && !rule.ResetConnection && rule.BandwidthBytesPerSecond == 0 {
+		return fmt.Errorf("the rule injects no fault")
+	}
+	return nil
+}
+
+// ForwardRequest implements RequestForwarder for FaultInjector
+func (f *FaultInjector) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
+	path := strings.TrimPrefix(req.URL.Path, "/api/"+serviceName)
+	route, found := f.appConfig.Service(serviceName).Route(req.Method, path)
+	if !found {
+		f.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+
+	for _, rule := range route.Faults {
+		if !faultApplies(rule, req) {
+			continue
+		}
+		f.inject(w, req, serviceName, route.DisplayName(), rule)
+		return
+	}
+	f.next.ForwardRequest(w, req, serviceName)
+}
+
+// faultApplies checks a rule's header trigger and rolls its percentage
+func faultApplies(rule config.FaultRule, req *http.Request) bool {
+	percentage := rule.Percentage
+	if rule.Header != "" {
+		values, present := req.Header[http.CanonicalHeaderKey(rule.Header)]
+		if !present {
+			return false
+		}
+		if rule.HeaderValue != "" && !strings.EqualFold(strings.Join(values, ","), rule.HeaderValue) {
+			return false
+		}
+		if percentage == 0 {
+			percentage = 100
+		}
+	}
+	return rand.Float64()*100 < percentage
+}
+
+// inject applies a rule's faults in order: delay, then abort, reset or a
+// throttled forward
+func (f *FaultInjector) inject(w http.ResponseWriter, req *http.Request, serviceName, routeName string, rule config.FaultRule) {
+	requestID := req.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = "unknown"
+	}
+
+	if rule.Delay != nil {
+		delay := faultDelay(*rule.Delay)
+		injectedFaults.Inc(serviceName, routeName, "delay")
+		log.Printf("[%s] Fault injection: delaying request to '%s' by %v", requestID, serviceName, delay)
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return
+		}
+	}
+
+	switch {
+	case rule.AbortStatus != 0:
+		injectedFaults.Inc(serviceName, routeName, "abort")
+		log.Printf("[%s] Fault injection: aborting request to '%s' with status %d", requestID, serviceName, rule.AbortStatus)
+		writeError(w, req, problem.FaultInjected.WithStatus(rule.AbortStatus), "Fault injected")
+	case rule.ResetConnection:
+		injectedFaults.Inc(serviceName, routeName, "reset")
+		log.Printf("[%s] Fault injection: resetting connection for request to '%s'", requestID, serviceName)
+		// The server closes the connection (or resets the HTTP/2 stream)
+		// without sending a response
+		panic(http.ErrAbortHandler)
+	case rule.BandwidthBytesPerSecond > 0:
+		injectedFaults.Inc(serviceName, routeName, "throttle")
+		log.Printf("[%s] Fault injection: throttling response from '%s' to %d bytes/s", requestID, serviceName, rule.BandwidthBytesPerSecond)
+		f.next.ForwardRequest(&throttledWriter{ResponseWriter: w, ctx: req.Context(), bytesPerSecond: rule.BandwidthBytesPerSecond}, req, serviceName)
+	default:
+		f.next.ForwardRequest(w, req, serviceName)
+	}
+}
+
+// faultDelay draws a delay from the configured distribution
+func faultDelay(cfg config.DelayFault) time.Duration {
+	base, jitter := cfg.Duration.Duration, cfg.Jitter.Duration
+	var delay time.Duration
+	switch cfg.Distribution {
+	case config.DelayUniform:
+		delay = base - jitter + time.Duration(rand.Int64N(int64(2*jitter)+1))
+	case config.DelayNormal:
+		delay = base + time.Duration(rand.NormFloat64()*float64(jitter))
+	default:
+		delay = base
+	}
+	return max(delay, 0)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledWriter sends the response body at a bounded rate, flushing each
+// chunk so the client really receives it slowly
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx            context.Context
+	bytesPerSecond int64
+}
+
+func (tw *throttledWriter) Write(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		chunk := data[written:min(len(data), written+throttleChunkBytes)]
+		n, err := tw.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		http.NewResponseController(tw.ResponseWriter).Flush()
+
+		pause := time.Duration(int64(n) * int64(time.Second) / tw.bytesPerSecond)
+		if err := sleepContext(tw.ctx, pause); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (tw *throttledWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
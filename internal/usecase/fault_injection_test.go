@@ -0,0 +1,148 @@
+package usecase
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+var okUpstream = forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+	w.Write([]byte(strings.Repeat("x", 2048)))
+})
+
+func newTestFaultInjector(t *testing.T, enabled bool, rules ...config.FaultRule) RequestForwarder {
+	t.Helper()
+	injector, err := NewFaultInjector(config.AppConfig{
+		KnownServices:  map[string]string{"users": "http://users"},
+		FaultInjection: config.FaultInjectionConfig{Enabled: enabled},
+		Services: map[string]config.ServiceConfig{"users": {Routes: []config.RouteConfig{
+			{PathPrefix: "/users", Faults: rules},
+		}}},
+	}, okUpstream)
+	if err != nil {
+		t.Fatalf("failed to create fault injector: %v", err)
+	}
+	return injector
+}
+
+func TestFaultInjectorAbortAndDelay(t *testing.T) {
+	injector := newTestFaultInjector(t, true,
+		config.FaultRule{Header: "X-Chaos", HeaderValue: "abort", AbortStatus: http.StatusServiceUnavailable},
+		config.FaultRule{Header: "X-Chaos", HeaderValue: "delay", Delay: &config.DelayFault{Duration: config.Duration{Duration: 50 * time.Millisecond}}},
+	)
+
+	abort := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	abort.Header.Set("X-Chaos", "abort")
+	w := httptest.NewRecorder()
+	injector.ForwardRequest(w, abort, "users")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the injected 503, got %d", w.Code)
+	}
+
+	delay := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	delay.Header.Set("X-Chaos", "delay")
+	w = httptest.NewRecorder()
+	start := time.Now()
+	injector.ForwardRequest(w, delay, "users")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the request to be delayed, took %v", elapsed)
+	}
+	if w.Code != http.StatusOK || w.Body.Len() != 2048 {
+		t.Errorf("expected the delayed request to be forwarded, got %d", w.Code)
+	}
+
+	untouched := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	w = httptest.NewRecorder()
+	injector.ForwardRequest(w, untouched, "users")
+	if w.Code != http.StatusOK {
+		t.Errorf("expected requests without the header to pass, got %d", w.Code)
+	}
+}
+
+func TestFaultInjectorResetAndThrottle(t *testing.T) {
+	injector := newTestFaultInjector(t, true,
+		config.FaultRule{Header: "X-Chaos", HeaderValue: "reset", ResetConnection: true},
+		config.FaultRule{Header: "X-Chaos", HeaderValue: "slow", BandwidthBytesPerSecond: 10 * 1024},
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		injector.ForwardRequest(w, r, "users")
+	}))
+	defer server.Close()
+
+	send := func(chaos string) (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/users/users", nil)
+		req.Header.Set("X-Chaos", chaos)
+		return http.DefaultClient.Do(req)
+	}
+
+	if resp, err := send("reset"); err == nil {
+		resp.Body.Close()
+		t.Errorf("expected the connection to be reset, got status %d", resp.StatusCode)
+	}
+
+	start := time.Now()
+	resp, err := send("slow")
+	if err != nil {
+		t.Fatalf("throttled request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(body) != 2048 {
+		t.Errorf("expected the full body, got %d bytes", len(body))
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected 2KiB at 10KiB/s to take about 200ms, took %v", elapsed)
+	}
+}
+
+func TestFaultInjectorDisabledAndValidation(t *testing.T) {
+	injector := newTestFaultInjector(t, false, config.FaultRule{Percentage: 100, AbortStatus: http.StatusInternalServerError})
+	w := httptest.NewRecorder()
+	injector.ForwardRequest(w, httptest.NewRequest(http.MethodGet, "/api/users/users", nil), "users")
+	if w.Code != http.StatusOK {
+		t.Errorf("expected no faults while fault injection is disabled, got %d", w.Code)
+	}
+
+	invalid := []config.FaultRule{
+		{AbortStatus: http.StatusInternalServerError},
+		{Percentage: 150, AbortStatus: http.StatusInternalServerError},
+		{Percentage: 10},
+		{Percentage: 10, AbortStatus: http.StatusBadGateway, ResetConnection: true},
+		{Percentage: 10, Delay: &config.DelayFault{Distribution: "exponential"}},
+	}
+	for _, rule := range invalid {
+		_, err := NewFaultInjector(config.AppConfig{
+			Services: map[string]config.ServiceConfig{"users": {Routes: []config.RouteConfig{{Faults: []config.FaultRule{rule}}}}},
+		}, okUpstream)
+		if err == nil {
+			t.Errorf("expected rule %+v to be rejected", rule)
+		}
+	}
+}
+
+func TestFaultDelayDistributions(t *testing.T) {
+	for range 100 {
+		uniform := faultDelay(config.DelayFault{
+			Distribution: config.DelayUniform,
+			Duration:     config.Duration{Duration: 100 * time.Millisecond},
+			Jitter:       config.Duration{Duration: 20 * time.Millisecond},
+		})
+		if uniform < 80*time.Millisecond || uniform > 120*time.Millisecond {
+			t.Fatalf("uniform delay %v outside 100ms ± 20ms", uniform)
+		}
+
+		normal := faultDelay(config.DelayFault{
+			Distribution: config.DelayNormal,
+			Duration:     config.Duration{Duration: 10 * time.Millisecond},
+			Jitter:       config.Duration{Duration: 50 * time.Millisecond},
+		})
+		if normal < 0 {
+			t.Fatalf("normal delay %v is negative", normal)
+		}
+	}
+}
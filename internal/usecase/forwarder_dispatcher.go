@@ -0,0 +1,27 @@
+package usecase
+
+import (
+	"net/http"
+)
+
+// ForwarderDispatcher implements RequestForwarder by handing each service to
+// its own forwarder, falling back to a default one (usually the reverse proxy)
+type ForwarderDispatcher struct {
+	fallback   RequestForwarder
+	forwarders map[string]RequestForwarder
+}
+
+// NewForwarderDispatcher creates a dispatcher that uses forwarders[serviceName]
+// when present and fallback otherwise
+func NewForwarderDispatcher(fallback RequestForwarder, forwarders map[string]RequestForwarder) RequestForwarder {
+	return &ForwarderDispatcher{fallback: fallback, forwarders: forwarders}
+}
+
+// ForwardRequest implements RequestForwarder for ForwarderDispatcher
+func (d *ForwarderDispatcher) ForwardRequest(w http.ResponseWriter, r *http.Request, serviceName string) {
+	if forwarder, ok := d.forwarders[serviceName]; ok {
+		forwarder.ForwardRequest(w, r, serviceName)
+		return
+	}
+	d.fallback.ForwardRequest(w, r, serviceName)
+}
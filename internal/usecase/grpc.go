@@ -0,0 +1,27 @@
+package usecase
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/LucianoBarrera/api-gateway/internal/grpcstatus"
+)
+
+// grpcTransport speaks HTTP/2 only: h2c with prior knowledge for http://
+// upstreams and ALPN-negotiated HTTP/2 for https:// upstreams
+var grpcTransport = newGRPCTransport()
+
+func newGRPCTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetHTTP2(true)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return transport
+}
+
+// grpcErrorHandler reports upstream failures as grpc-status UNAVAILABLE so
+// gRPC clients see a proper status instead of an HTTP 502
+func grpcErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("[%s] gRPC upstream error for %s: %v", r.Header.Get("X-Request-ID"), r.URL.Path, err)
+	grpcstatus.WriteError(w, http.StatusBadGateway, "upstream unavailable")
+}
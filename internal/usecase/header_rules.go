@@ -0,0 +1,235 @@
+package usecase
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+)
+
+// headerTemplatePattern matches ${variable} references in header values
+var headerTemplatePattern = regexp.MustCompile(`\$\{([a-z_]+)\}`)
+
+// headerTemplateVariables are the values header rules can reference
+var headerTemplateVariables = map[string]bool{
+	"request_id":   true,
+	"client_ip":    true,
+	"principal_id": true,
+	"service":      true,
+	"route":        true,
+	"version":      true,
+}
+
+// identityVariables are the template variables that identify the caller.
+// Responses to requests carrying headers set from them may be specific to
+// that caller.
+var identityVariables = map[string]bool{
+	"client_ip":    true,
+	"principal_id": true,
+}
+
+// identityHeaders returns the request headers the service and route header
+// rules set or add from identityVariables
+func identityHeaders(appConfig config.AppConfig, req *http.Request, serviceName string) []string {
+	serviceConfig := appConfig.Service(serviceName)
+	rules := []*config.HeaderRules{serviceConfig.Headers}
+	if route, ok := serviceConfig.Route(req.Method, strings.TrimPrefix(req.URL.Path, "/api/"+serviceName)); ok {
+		rules = append(rules, route.Headers)
+	}
+
+	var names []string
+	for _, r := range rules {
+		if r == nil {
+			continue
+		}
+		for _, values := range []map[string]string{r.Request.Set, r.Request.Add} {
+			for name, value := range values {
+				for _, match := range headerTemplatePattern.FindAllStringSubmatch(value, -1) {
+					if identityVariables[match[1]] {
+						names = append(names, name)
+						break
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// HeaderTransformer implements RequestForwarder by applying the service and
+// route header rules to the request sent upstream and to the response sent
+// back, and by keeping the client's x-api-key from reaching upstreams
+type HeaderTransformer struct {
+	appConfig config.AppConfig
+	next      RequestForwarder
+}
+
+// NewHeaderTransformer validates the header rules of every service and route
+// and wraps next
+func NewHeaderTransformer(appConfig config.AppConfig, next RequestForwarder) (RequestForwarder, error) {
+	for serviceName, serviceConfig := range appConfig.Services {
+		if err := validateHeaderRules(serviceConfig.Headers); err != nil {
+			return nil, fmt.Errorf("service '%s': %w", serviceName, err)
+		}
+		for _, route := range serviceConfig.Routes {
+			if err := validateHeaderRules(route.Headers); err != nil {
+				return nil, fmt.Errorf("service '%s', route '%s': %w", serviceName, route.DisplayName(), err)
+			}
+		}
+	}
+	return &HeaderTransformer{appConfig: appConfig, next: next}, nil
+}
+
+func validateHeaderRules(rules *config.HeaderRules) error {
+	if rules == nil {
+		return nil
+	}
+	for _, ops := range []config.HeaderOperations{rules.Request, rules.Response} {
+		for _, name := range ops.Remove {
+			if !httpguts.ValidHeaderFieldName(strings.TrimSuffix(name, "*")) {
+				return fmt.Errorf("invalid header name '%s'", name)
+			}
+		}
+		for from, to := range ops.Rename {
+			if !httpguts.ValidHeaderFieldName(from) || !httpguts.ValidHeaderFieldName(to) {
+				return fmt.Errorf("invalid rename '%s' to '%s'", from, to)
+			}
+		}
+		for _, values := range []map[string]string{ops.Set, ops.Add} {
+			for name, value := range values {
+				if !httpguts.ValidHeaderFieldName(name) {
+					return fmt.Errorf("invalid header name '%s'", name)
+				}
+				for _, match := range headerTemplatePattern.FindAllStringSubmatch(value, -1) {
+					if !headerTemplateVariables[match[1]] {
+						return fmt.Errorf("header '%s' references unknown variable '%s'", name, match[1])
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ForwardRequest implements RequestForwarder for HeaderTransformer
+func (h *HeaderTransformer) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
+	serviceConfig := h.appConfig.Service(serviceName)
+	route, hasRoute := serviceConfig.Route(req.Method, strings.TrimPrefix(req.URL.Path, "/api/"+serviceName))
+
+	var rules []*config.HeaderRules
+	if serviceConfig.Headers != nil {
+		rules = append(rules, serviceConfig.Headers)
+	}
+	if hasRoute && route.Headers != nil {
+		rules = append(rules, route.Headers)
+	}
+
+	if serviceConfig.ForwardAPIKey && len(rules) == 0 {
+		h.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+
+	info := requestctx.From(req.Context())
+	routeName := ""
+	if hasRoute {
+		routeName = route.DisplayName()
+	}
+	requestID := info.RequestID()
+	if requestID == "" {
+		requestID = req.Header.Get("X-Request-ID")
+	}
+	variables := map[string]string{
+		"request_id":   requestID,
+		"client_ip":    info.ClientIP(),
+		"principal_id": info.Consumer(),
+		"service":      serviceName,
+		"route":        routeName,
+		"version":      versionFromContext(req.Context(), serviceName),
+	}
+
+	upstreamReq := req.Clone(req.Context())
+	if !serviceConfig.ForwardAPIKey {
+		upstreamReq.Header.Del("X-Api-Key")
+	}
+	for _, r := range rules {
+		applyHeaderOperations(upstreamReq.Header, r.Request, variables)
+	}
+
+	if len(rules) > 0 {
+		w = &headerRewriter{ResponseWriter: w, apply: func(header http.Header) {
+			for _, r := range rules {
+				applyHeaderOperations(header, r.Response, variables)
+			}
+		}}
+	}
+	h.next.ForwardRequest(w, upstreamReq, serviceName)
+}
+
+// applyHeaderOperations transforms header in the order remove, rename, set, add
+func applyHeaderOperations(header http.Header, ops config.HeaderOperations, variables map[string]string) {
+	for _, name := range ops.Remove {
+		if prefix, wildcard := strings.CutSuffix(name, "*"); wildcard {
+			prefix = http.CanonicalHeaderKey(prefix)
+			for existing := range header {
+				if strings.HasPrefix(existing, prefix) {
+					header.Del(existing)
+				}
+			}
+			continue
+		}
+		header.Del(name)
+	}
+	for from, to := range ops.Rename {
+		if values := header.Values(from); len(values) > 0 {
+			values = append([]string(nil), values...)
+			header.Del(from)
+			header[http.CanonicalHeaderKey(to)] = values
+		}
+	}
+	for name, value := range ops.Set {
+		header.Set(name, expandHeaderTemplate(value, variables))
+	}
+	for name, value := range ops.Add {
+		header.Add(name, expandHeaderTemplate(value, variables))
+	}
+}
+
+func expandHeaderTemplate(value string, variables map[string]string) string {
+	return headerTemplatePattern.ReplaceAllStringFunc(value, func(match string) string {
+		return variables[match[2:len(match)-1]]
+	})
+}
+
+// headerRewriter applies the response rules just before the final status
+// line is written
+type headerRewriter struct {
+	http.ResponseWriter
+	apply   func(http.Header)
+	applied bool
+}
+
+func (hr *headerRewriter) WriteHeader(statusCode int) {
+	// Informational responses (e.g. 103 Early Hints) are sent as they are
+	if !hr.applied && statusCode >= http.StatusOK {
+		hr.applied = true
+		hr.apply(hr.ResponseWriter.Header())
+	}
+	hr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (hr *headerRewriter) Write(data []byte) (int, error) {
+	if !hr.applied {
+		hr.WriteHeader(http.StatusOK)
+	}
+	return hr.ResponseWriter.Write(data)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (hr *headerRewriter) Unwrap() http.ResponseWriter {
+	return hr.ResponseWriter
+}
@@ -0,0 +1,198 @@
+package usecase
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/LucianoBarrera/api-gateway/internal/cache"
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+)
+
+// headerEcho records the headers it receives and answers with internal headers
+type headerEcho struct {
+	received http.Header
+}
+
+func (h *headerEcho) ForwardRequest(w http.ResponseWriter, r *http.Request, serviceName string) {
+	h.received = r.Header.Clone()
+	w.Header().Set("X-Internal-Node", "users-7")
+	w.Header().Set("X-Internal-Build", "1234")
+	w.Header().Set("Server", "users/1.0")
+	w.Header().Set("X-Upstream-Timing", "12ms")
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestHeaderTransformer(t *testing.T) {
+	upstream := &headerEcho{}
+	transformer, err := NewHeaderTransformer(config.AppConfig{
+		KnownServices: map[string]string{"users": "http://users"},
+		Services: map[string]config.ServiceConfig{"users": {
+			Headers: &config.HeaderRules{
+				Request: config.HeaderOperations{
+					Remove: []string{"Cookie"},
+					Rename: map[string]string{"X-Client-Trace": "X-Trace"},
+					Set: map[string]string{
+						"X-Principal-ID":    "${principal_id}",
+						"X-Forwarded-Route": "${service}:${route}",
+					},
+					Add: map[string]string{"X-Client-IP": "${client_ip}"},
+				},
+				Response: config.HeaderOperations{
+					Remove: []string{"X-Internal-*", "Server"},
+					Rename: map[string]string{"X-Upstream-Timing": "Server-Timing"},
+					Set:    map[string]string{"X-Request-ID": "${request_id}"},
+				},
+			},
+			Routes: []config.RouteConfig{{
+				Name:       "users-list",
+				PathPrefix: "/users",
+				Headers: &config.HeaderRules{
+					Request: config.HeaderOperations{Set: map[string]string{"X-Forwarded-Route": "list"}},
+				},
+			}},
+		}},
+	}, upstream)
+	if err != nil {
+		t.Fatalf("failed to create header transformer: %v", err)
+	}
+
+	ctx, info := requestctx.New(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "req-1")
+	info.SetClientIP("203.0.113.9")
+	info.SetConsumer("mobile-app")
+
+	send := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx)
+		req.Header.Set("X-Api-Key", "secret")
+		req.Header.Set("Cookie", "session=1")
+		req.Header.Set("X-Client-Trace", "abc")
+		w := httptest.NewRecorder()
+		transformer.ForwardRequest(w, req, "users")
+		return w
+	}
+
+	w := send("/api/users/profile")
+	expectedRequest := map[string]string{
+		"X-Api-Key":         "",
+		"Cookie":            "",
+		"X-Client-Trace":    "",
+		"X-Trace":           "abc",
+		"X-Principal-Id":    "mobile-app",
+		"X-Forwarded-Route": "users:",
+		"X-Client-Ip":       "203.0.113.9",
+	}
+	for name, expected := range expectedRequest {
+		if got := upstream.received.Get(name); got != expected {
+			t.Errorf("upstream header %s: expected %q, got %q", name, expected, got)
+		}
+	}
+
+	expectedResponse := map[string]string{
+		"X-Internal-Node":   "",
+		"X-Internal-Build":  "",
+		"Server":            "",
+		"X-Upstream-Timing": "",
+		"Server-Timing":     "12ms",
+		"X-Request-Id":      "req-1",
+	}
+	for name, expected := range expectedResponse {
+		if got := w.Header().Get(name); got != expected {
+			t.Errorf("response header %s: expected %q, got %q", name, expected, got)
+		}
+	}
+
+	send("/api/users/users")
+	if got := upstream.received.Get("X-Forwarded-Route"); got != "list" {
+		t.Errorf("expected the route rule to override the service rule, got %q", got)
+	}
+}
+
+func TestHeaderTransformerAPIKeyForwarding(t *testing.T) {
+	upstream := &headerEcho{}
+	for _, forward := range []bool{false, true} {
+		transformer, err := NewHeaderTransformer(config.AppConfig{
+			Services: map[string]config.ServiceConfig{"auth": {ForwardAPIKey: forward}},
+		}, upstream)
+		if err != nil {
+			t.Fatalf("failed to create header transformer: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/status", nil)
+		req.Header.Set("x-api-key", "secret")
+		transformer.ForwardRequest(httptest.NewRecorder(), req, "auth")
+
+		if got := upstream.received.Get("X-Api-Key") != ""; got != forward {
+			t.Errorf("forward_api_key=%v: expected key forwarded %v, got %v", forward, forward, got)
+		}
+		if req.Header.Get("x-api-key") != "secret" {
+			t.Error("expected the client request to be left untouched")
+		}
+	}
+}
+
+func TestNewHeaderTransformerValidation(t *testing.T) {
+	invalid := []config.HeaderOperations{
+		{Set: map[string]string{"X-User": "${user_email}"}},
+		{Set: map[string]string{"Bad Header": "x"}},
+		{Rename: map[string]string{"X-A": ""}},
+	}
+	for _, ops := range invalid {
+		_, err := NewHeaderTransformer(config.AppConfig{
+			Services: map[string]config.ServiceConfig{"users": {Headers: &config.HeaderRules{Request: ops}}},
+		}, nil)
+		if err == nil {
+			t.Errorf("expected %+v to be rejected", ops)
+		}
+	}
+}
+
+// TestIdentityHeadersAreNotShared sends two consumers, and two shared-key
+// clients, through header rules that tell the upstream who is calling, with
+// caching and coalescing below them
+func TestIdentityHeadersAreNotShared(t *testing.T) {
+	appConfig := config.AppConfig{
+		KnownServices: map[string]string{"users": "http://users"},
+		Services: map[string]config.ServiceConfig{"users": {
+			Headers: &config.HeaderRules{Request: config.HeaderOperations{
+				Set: map[string]string{"X-Principal-ID": "${principal_id}"},
+				Add: map[string]string{"X-Client-IP": "${client_ip}"},
+			}},
+			Cache: &config.ServiceCacheConfig{},
+			Routes: []config.RouteConfig{{
+				Name: "users-list", PathPrefix: "/users", Coalesce: &config.CoalesceConfig{},
+			}},
+		}},
+	}
+	upstream := forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.Header.Get("X-Principal-ID") + "@" + r.Header.Get("X-Client-IP")))
+	})
+	forwarder, err := NewHeaderTransformer(appConfig,
+		NewCachingService(appConfig, cache.NewStore(1<<20), NewCoalescingService(appConfig, upstream)))
+	if err != nil {
+		t.Fatalf("failed to create header transformer: %v", err)
+	}
+
+	get := func(consumer, clientIP string) string {
+		req := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+		ctx, info := requestctx.New(req.Context(), "identity-test")
+		info.SetConsumer(consumer)
+		info.SetClientIP(clientIP)
+		w := httptest.NewRecorder()
+		forwarder.ForwardRequest(w, req.WithContext(ctx), "users")
+		return w.Body.String()
+	}
+
+	for _, client := range []struct{ consumer, clientIP string }{
+		{"mobile", "10.0.0.1"},
+		{"web", "10.0.0.1"},
+		{"", "10.0.0.2"},
+		{"", "10.0.0.3"},
+		{"mobile", "10.0.0.1"},
+	} {
+		if body, want := get(client.consumer, client.clientIP), client.consumer+"@"+client.clientIP; body != want {
+			t.Errorf("expected the response for %s, got %s", want, body)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+)
+
+// defaultMirrorTimeout bounds mirrored requests when no timeout is configured
+const defaultMirrorTimeout = 10 * time.Second
+
+// maxReportedDifferences bounds how many differing JSON paths are logged
+const maxReportedDifferences = 10
+
+// mirrorHeader marks requests sent to a shadow upstream
+const mirrorHeader = "X-Gateway-Mirror"
+
+// Results recorded for mirrored requests
+const (
+	mirrorDropped    = "dropped"
+	mirrorTooLarge   = "body_too_large"
+	mirrorBodyFailed = "body_read_error"
+	mirrorBodyUnread = "body_not_read"
+	mirrorFailed     = "error"
+	mirrorMatch      = "match"
+	mirrorMismatch   = "mismatch"
+	mirrorCompleted  = "completed"
+)
+
+var mirrorRequests = metrics.NewCounter("gateway_mirror_requests_total",
+	"Requests copied to shadow upstreams, by service and result", "service", "result")
+
+// serviceMirror is the runtime state of one service's mirror
+type serviceMirror struct {
+	cfg      config.MirrorConfig
+	upstream *url.URL
+	slots    chan struct{}
+}
+
+// MirroringService implements RequestForwarder by forwarding requests to next
+// and, for services with a mirror, sending a copy of a share of them to a
+// shadow upstream in the background. Mirror responses are discarded after
+// being compared with the primary response.
+type MirroringService struct {
+	next    RequestForwarder
+	mirrors map[string]*serviceMirror
+	client  *http.Client
+}
+
+// NewMirroringService validates the mirror settings of every service and wraps next
+func NewMirroringService(appConfig config.AppConfig, next RequestForwarder) (RequestForwarder, error) {
+	mirrors := map[string]*serviceMirror{}
+	for serviceName, serviceConfig := range appConfig.Services {
+		cfg := serviceConfig.Mirror
+		if cfg == nil {
+			continue
+		}
+
+		upstream, err := config.ParseUpstreamURL(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("service '%s': mirror: %w", serviceName, err)
+		}
+		if cfg.Percentage < 0 || cfg.Percentage > 100 {
+			return nil, fmt.Errorf("service '%s': mirror percentage must be between 0 and 100", serviceName)
+		}
+
+		mirror := &serviceMirror{cfg: *cfg, upstream: upstream}
+		if mirror.cfg.MaxBodyBytes <= 0 {
+			mirror.cfg.MaxBodyBytes = config.DefaultMirrorMaxBodyBytes
+		}
+		if mirror.cfg.MaxConcurrent <= 0 {
+			mirror.cfg.MaxConcurrent = config.DefaultMirrorMaxConcurrent
+		}
+		if mirror.cfg.Timeout.Duration <= 0 {
+			mirror.cfg.Timeout.Duration = defaultMirrorTimeout
+		}
+		mirror.slots = make(chan struct{}, mirror.cfg.MaxConcurrent)
+		mirrors[serviceName] = mirror
+	}
+
+	return &MirroringService{
+		next:    next,
+		mirrors: mirrors,
+		client: &http.Client{
+			// Redirects are reported as they are, like the primary does
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}, nil
+}
+
+// ForwardRequest implements RequestForwarder for MirroringService
+func (m *MirroringService) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
+	mirror := m.mirrors[serviceName]
+	if mirror == nil || req.Header.Get("Upgrade") != "" || rand.Float64()*100 >= mirror.cfg.Percentage {
+		m.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+
+	// Never queue behind slow mirrors: skip the copy when all slots are busy
+	select {
+	case mirror.slots <- struct{}{}:
+	default:
+		mirrorRequests.Inc(serviceName, mirrorDropped)
+		m.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+
+	requestID := req.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = "unknown"
+	}
+
+	// The body is copied while the primary streams it, so the primary is
+	// never held back reading it first
+	var body *mirrorBody
+	if req.Body != nil && req.Body != http.NoBody {
+		body = newMirrorBody(req.Body, mirror.cfg.MaxBodyBytes)
+		req.Body = body
+	}
+
+	mirrorReq := m.newMirrorRequest(req, serviceName, mirror)
+	primary := &responseRecorder{ResponseWriter: w, limit: int(mirror.cfg.MaxBodyBytes), keepBody: mirror.cfg.CompareBodies}
+	primaryDone := make(chan struct{})
+
+	go func() {
+		if body != nil {
+			copied, skipped := body.wait(primaryDone)
+			if skipped != "" {
+				<-mirror.slots
+				mirrorRequests.Inc(serviceName, skipped)
+				return
+			}
+			mirrorReq.Body = io.NopCloser(bytes.NewReader(copied))
+			mirrorReq.ContentLength = int64(len(copied))
+		}
+		defer func() { <-mirror.slots }()
+		m.mirror(mirrorReq, serviceName, requestID, mirror, primary, primaryDone)
+	}()
+
+	start := time.Now()
+	defer func() {
+		primary.latency = time.Since(start)
+		close(primaryDone)
+	}()
+	m.next.ForwardRequest(primary, req, serviceName)
+}
+
+// mirrorBody passes a request body through to the primary and keeps a copy
+// of up to limit bytes for the mirror
+type mirrorBody struct {
+	io.ReadCloser
+	limit int64
+	copy  bytes.Buffer
+
+	once sync.Once
+	done chan struct{} // closed once the copy is complete or given up
+	// skipped is why the body cannot be mirrored; it is set before done
+	// is closed
+	skipped string
+}
+
+func newMirrorBody(body io.ReadCloser, limit int64) *mirrorBody {
+	return &mirrorBody{ReadCloser: body, limit: limit, done: make(chan struct{})}
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	select {
+	case <-b.done:
+		return n, err
+	default:
+	}
+	if int64(b.copy.Len()+n) > b.limit {
+		b.copy.Reset()
+		b.finish(mirrorTooLarge)
+		return n, err
+	}
+	b.copy.Write(p[:n])
+	switch {
+	case err == io.EOF:
+		b.finish("")
+	case err != nil:
+		b.finish(mirrorBodyFailed)
+	}
+	return n, err
+}
+
+// Close gives up the copy when the primary stops before the end of the body
+func (b *mirrorBody) Close() error {
+	b.finish(mirrorBodyUnread)
+	return b.ReadCloser.Close()
+}
+
+func (b *mirrorBody) finish(skipped string) {
+	b.once.Do(func() {
+		b.skipped = skipped
+		close(b.done)
+	})
+}
+
+// wait returns the copied body once the primary has read all of it, or why
+// it cannot be mirrored
+func (b *mirrorBody) wait(primaryDone <-chan struct{}) ([]byte, string) {
+	select {
+	case <-b.done:
+	case <-primaryDone:
+		select {
+		case <-b.done:
+		default:
+			// The primary finished without reading the whole body
+			return nil, mirrorBodyUnread
+		}
+	}
+	if b.skipped != "" {
+		return nil, b.skipped
+	}
+	return b.copy.Bytes(), ""
+}
+
+// newMirrorRequest copies req, without its body, for the shadow upstream.
+// It is detached from the client's cancellation so the mirror never
+// shortens or lengthens the primary request.
+func (m *MirroringService) newMirrorRequest(req *http.Request, serviceName string, mirror *serviceMirror) *http.Request {
+	target := *mirror.upstream
+	target.Path = strings.TrimSuffix(target.Path, "/") + strings.TrimPrefix(req.URL.Path, "/api/"+serviceName)
+	target.RawQuery = req.URL.RawQuery
+
+	mirrorReq := req.Clone(context.WithoutCancel(req.Context()))
+	mirrorReq.URL = &target
+	mirrorReq.Host = ""
+	mirrorReq.RequestURI = ""
+	mirrorReq.Body = http.NoBody
+	mirrorReq.ContentLength = 0
+	mirrorReq.Header.Set(mirrorHeader, "true")
+	return mirrorReq
+}
+
+// mirror sends the copy, waits for the primary to finish and logs how the
+// two responses compare
+func (m *MirroringService) mirror(mirrorReq *http.Request, serviceName, requestID string, mirror *serviceMirror, primary *responseRecorder, primaryDone <-chan struct{}) {
+	ctx, cancel := context.WithTimeout(mirrorReq.Context(), mirror.cfg.Timeout.Duration)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := m.client.Do(mirrorReq.WithContext(ctx))
+	if err != nil {
+		mirrorRequests.Inc(serviceName, mirrorFailed)
+		log.Printf("[%s] Mirror for '%s' failed after %v: %v", requestID, serviceName, time.Since(start), err)
+		return
+	}
+	mirrorBody, readErr := io.ReadAll(io.LimitReader(resp.Body, mirror.cfg.MaxBodyBytes+1))
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	<-primaryDone
+
+	summary := fmt.Sprintf("[%s] Mirror for '%s' answered %d in %v (primary %d in %v)",
+		requestID, serviceName, resp.StatusCode, latency, primary.status(), primary.latency)
+
+	if !mirror.cfg.CompareBodies {
+		mirrorRequests.Inc(serviceName, mirrorCompleted)
+		log.Print(summary)
+		return
+	}
+
+	var differences []string
+	switch {
+	case readErr != nil:
+		differences = []string{"mirror body could not be read: " + readErr.Error()}
+	case primary.overflow || int64(len(mirrorBody)) > mirror.cfg.MaxBodyBytes:
+		differences = []string{"bodies too large to compare"}
+	default:
+		differences = compareBodies(primary.body.Bytes(), mirrorBody)
+	}
+	if resp.StatusCode != primary.status() {
+		differences = append([]string{"status differs"}, differences...)
+	}
+
+	if len(differences) == 0 {
+		mirrorRequests.Inc(serviceName, mirrorMatch)
+		log.Printf("%s - responses match", summary)
+		return
+	}
+	mirrorRequests.Inc(serviceName, mirrorMismatch)
+	log.Printf("%s - responses differ: %s", summary, strings.Join(differences, "; "))
+}
+
+// compareBodies lists the differences between two response bodies: the
+// differing paths for JSON documents, or a byte-level summary otherwise
+func compareBodies(primary, mirror []byte) []string {
+	if bytes.Equal(primary, mirror) {
+		return nil
+	}
+
+	var primaryJSON, mirrorJSON interface{}
+	if json.Unmarshal(primary, &primaryJSON) != nil || json.Unmarshal(mirror, &mirrorJSON) != nil {
+		return []string{fmt.Sprintf("bodies differ (%d bytes vs %d bytes)", len(primary), len(mirror))}
+	}
+
+	var differences []string
+	diffJSON("$", primaryJSON, mirrorJSON, &differences)
+	if len(differences) > maxReportedDifferences {
+		more := len(differences) - maxReportedDifferences
+		differences = append(differences[:maxReportedDifferences], fmt.Sprintf("and %d more", more))
+	}
+	return differences
+}
+
+// diffJSON walks two decoded JSON values and records the paths where they differ
+func diffJSON(path string, primary, mirror interface{}, differences *[]string) {
+	switch p := primary.(type) {
+	case map[string]interface{}:
+		m, ok := mirror.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(p)+len(m))
+		for key := range p {
+			keys = append(keys, key)
+		}
+		for key := range m {
+			if _, shared := p[key]; !shared {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			primaryValue, inPrimary := p[key]
+			mirrorValue, inMirror := m[key]
+			switch {
+			case !inMirror:
+				*differences = append(*differences, path+"."+key+" missing in mirror")
+			case !inPrimary:
+				*differences = append(*differences, path+"."+key+" only in mirror")
+			default:
+				diffJSON(path+"."+key, primaryValue, mirrorValue, differences)
+			}
+		}
+		return
+	case []interface{}:
+		m, ok := mirror.([]interface{})
+		if !ok {
+			break
+		}
+		if len(p) != len(m) {
+			*differences = append(*differences, fmt.Sprintf("%s has %d items vs %d", path, len(p), len(m)))
+			return
+		}
+		for i := range p {
+			diffJSON(fmt.Sprintf("%s[%d]", path, i), p[i], m[i], differences)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(primary, mirror) {
+		*differences = append(*differences, fmt.Sprintf("%s: %v vs %v", path, primary, mirror))
+	}
+}
+
+// responseRecorder passes a response through untouched while noting its
+// status, latency and, when keepBody is set, a bounded copy of its body
+type responseRecorder struct {
+	http.ResponseWriter
+	latency    time.Duration
+	statusCode int
+	keepBody   bool
+	limit      int
+	body       bytes.Buffer
+	overflow   bool
+}
+
+func (pr *responseRecorder) WriteHeader(statusCode int) {
+	if pr.statusCode == 0 {
+		pr.statusCode = statusCode
+	}
+	pr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (pr *responseRecorder) Write(data []byte) (int, error) {
+	if pr.statusCode == 0 {
+		pr.statusCode = http.StatusOK
+	}
+	if pr.keepBody && !pr.overflow {
+		if pr.body.Len()+len(data) > pr.limit {
+			pr.overflow = true
+			pr.body.Reset()
+		} else {
+			pr.body.Write(data)
+		}
+	}
+	return pr.ResponseWriter.Write(data)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (pr *responseRecorder) Unwrap() http.ResponseWriter {
+	return pr.ResponseWriter
+}
+
+func (pr *responseRecorder) status() int {
+	if pr.statusCode == 0 {
+		return http.StatusOK
+	}
+	return pr.statusCode
+}
@@ -0,0 +1,254 @@
+package usecase
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+// echoPrimary answers every request with its own body
+var echoPrimary = forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+})
+
+func newTestMirroringService(t *testing.T, mirrorURL string, mirror config.MirrorConfig) RequestForwarder {
+	t.Helper()
+	mirror.URL = mirrorURL
+	mirroring, err := NewMirroringService(config.AppConfig{
+		KnownServices: map[string]string{"users": "http://users"},
+		Services:      map[string]config.ServiceConfig{"users": {Mirror: &mirror}},
+	}, echoPrimary)
+	if err != nil {
+		t.Fatalf("failed to create mirroring service: %v", err)
+	}
+	return mirroring
+}
+
+func TestMirroringServiceCopiesRequestsWithoutDelayingClients(t *testing.T) {
+	type mirrored struct {
+		path, body, header string
+	}
+	received := make(chan mirrored, 1)
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- mirrored{path: r.URL.RequestURI(), body: string(body), header: r.Header.Get(mirrorHeader)}
+		<-release
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	mirroring := newTestMirroringService(t, shadow.URL, config.MirrorConfig{Percentage: 100, CompareBodies: true})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users/users?page=2", strings.NewReader(`{"name":"Ada"}`))
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	mirroring.ForwardRequest(w, req, "users")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the client response not to wait for the mirror, took %v", elapsed)
+	}
+	if w.Body.String() != `{"name":"Ada"}` {
+		t.Errorf("expected the primary to receive the full body, got %q", w.Body.String())
+	}
+
+	select {
+	case got := <-received:
+		if got.path != "/users?page=2" || got.body != `{"name":"Ada"}` || got.header != "true" {
+			t.Errorf("unexpected mirrored request %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to be mirrored")
+	}
+}
+
+func TestMirroringServiceLimits(t *testing.T) {
+	var mirroredCount atomic.Int32
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirroredCount.Add(1)
+		<-release
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	mirroring := newTestMirroringService(t, shadow.URL, config.MirrorConfig{Percentage: 100, MaxConcurrent: 1, MaxBodyBytes: 8})
+
+	t.Run("oversized bodies are not mirrored but still reach the primary", func(t *testing.T) {
+		before := mirrorRequests.Value("users", mirrorTooLarge)
+		w := httptest.NewRecorder()
+		mirroring.ForwardRequest(w, httptest.NewRequest(http.MethodPost, "/api/users/users", strings.NewReader(`{"name":"Grace"}`)), "users")
+		if w.Body.String() != `{"name":"Grace"}` {
+			t.Errorf("expected the primary to receive the full body, got %q", w.Body.String())
+		}
+		waitForMirrorResult(t, mirrorTooLarge, before)
+	})
+
+	t.Run("mirrors beyond the concurrency limit are dropped", func(t *testing.T) {
+		for range 5 {
+			mirroring.ForwardRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/users/users", nil), "users")
+		}
+		time.Sleep(100 * time.Millisecond)
+		if got := mirroredCount.Load(); got != 1 {
+			t.Errorf("expected a single mirrored request in flight, got %d", got)
+		}
+	})
+}
+
+// waitForMirrorResult waits for the count of mirrors of users with result
+// to go past before
+func waitForMirrorResult(t *testing.T, result string, before int64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for mirrorRequests.Value("users", result) == before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a mirror counted as %q", result)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMirroringServiceStreamsBodiesToThePrimary(t *testing.T) {
+	received := make(chan string, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer shadow.Close()
+
+	firstChunk := make(chan struct{})
+	primary := forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+		chunk := make([]byte, len(`{"name":`))
+		io.ReadFull(r.Body, chunk)
+		close(firstChunk)
+		rest, _ := io.ReadAll(r.Body)
+		w.Write(append(chunk, rest...))
+	})
+	mirroring, err := NewMirroringService(config.AppConfig{
+		KnownServices: map[string]string{"users": "http://users"},
+		Services:      map[string]config.ServiceConfig{"users": {Mirror: &config.MirrorConfig{URL: shadow.URL, Percentage: 100}}},
+	}, primary)
+	if err != nil {
+		t.Fatalf("failed to create mirroring service: %v", err)
+	}
+
+	// The rest of the body is only sent once the primary has the start of
+	// it, which it never gets if the body is read in full before forwarding
+	body, client := io.Pipe()
+	go func() {
+		client.Write([]byte(`{"name":`))
+		select {
+		case <-firstChunk:
+			client.Write([]byte(`"Ada"}`))
+			client.Close()
+		case <-time.After(time.Second):
+			client.CloseWithError(errors.New("the primary did not receive the start of the body"))
+		}
+	}()
+
+	w := httptest.NewRecorder()
+	mirroring.ForwardRequest(w, httptest.NewRequest(http.MethodPost, "/api/users/users", body), "users")
+	if w.Body.String() != `{"name":"Ada"}` {
+		t.Fatalf("expected the primary to stream the full body, got %q", w.Body.String())
+	}
+
+	select {
+	case got := <-received:
+		if got != `{"name":"Ada"}` {
+			t.Errorf("expected the mirror to receive the full body, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to be mirrored")
+	}
+}
+
+func TestMirroringServiceSkipsBodiesItCannotCopy(t *testing.T) {
+	var mirroredCount atomic.Int32
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirroredCount.Add(1)
+	}))
+	defer shadow.Close()
+
+	tests := []struct {
+		name    string
+		primary RequestForwarder
+		body    io.Reader
+		result  string
+	}{
+		{
+			name:    "read error",
+			primary: echoPrimary,
+			body:    io.MultiReader(strings.NewReader(`{"name":`), iotest.ErrReader(errors.New("connection reset"))),
+			result:  mirrorBodyFailed,
+		},
+		{
+			name: "body the primary does not read",
+			primary: forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+				w.WriteHeader(http.StatusUnauthorized)
+			}),
+			body:   strings.NewReader(`{"name":"Ada"}`),
+			result: mirrorBodyUnread,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mirroring, err := NewMirroringService(config.AppConfig{
+				KnownServices: map[string]string{"users": "http://users"},
+				Services:      map[string]config.ServiceConfig{"users": {Mirror: &config.MirrorConfig{URL: shadow.URL, Percentage: 100}}},
+			}, tt.primary)
+			if err != nil {
+				t.Fatalf("failed to create mirroring service: %v", err)
+			}
+
+			before := mirrorRequests.Value("users", tt.result)
+			tooLarge := mirrorRequests.Value("users", mirrorTooLarge)
+			mirroring.ForwardRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/users/users", tt.body), "users")
+			waitForMirrorResult(t, tt.result, before)
+			if got := mirrorRequests.Value("users", mirrorTooLarge); got != tooLarge {
+				t.Errorf("expected nothing counted as too large, went from %v to %v", tooLarge, got)
+			}
+			if got := mirroredCount.Load(); got != 0 {
+				t.Errorf("expected nothing mirrored, got %d", got)
+			}
+		})
+	}
+}
+
+func TestCompareBodies(t *testing.T) {
+	tests := []struct {
+		name     string
+		primary  string
+		mirror   string
+		expected []string
+	}{
+		{name: "identical", primary: `{"a":1}`, mirror: `{"a":1}`},
+		{name: "same JSON, different formatting", primary: `{"a":1,"b":[1,2]}`, mirror: `{ "b": [1, 2], "a": 1 }`},
+		{
+			name:     "JSON differences",
+			primary:  `{"a":1,"b":{"c":"x"},"d":[1]}`,
+			mirror:   `{"a":2,"b":{"c":"x","e":true},"d":[1,2]}`,
+			expected: []string{"$.a: 1 vs 2", "$.b.e only in mirror", "$.d has 1 items vs 2"},
+		},
+		{name: "plain text", primary: "ok", mirror: "not ok", expected: []string{"bodies differ (2 bytes vs 6 bytes)"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareBodies([]byte(tt.primary), []byte(tt.mirror))
+			if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
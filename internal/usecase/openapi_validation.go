@@ -0,0 +1,275 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+)
+
+var openAPIValidations = metrics.NewCounter("gateway_openapi_validations_total",
+	"Requests and responses checked against OpenAPI documents, by service, direction and result", "service", "direction", "result")
+
+// maxValidatedResponseBytes bounds the response bodies kept for validation;
+// larger responses are not validated
+const maxValidatedResponseBytes = 1 << 20
+
+// ValidationError is one violation of a service's OpenAPI document
+type ValidationError struct {
+	// In is where the violation was found: "path", "query", "header",
+	// "cookie", "body" or "route"
+	In string `json:"in"`
+	// Name is the parameter name, or for bodies the JSON pointer of the
+	// offending value
+	Name    string `json:"name,omitempty"`
+	Message string `json:"message"`
+}
+
+// serviceSpec is a loaded OpenAPI document and its router
+type serviceSpec struct {
+	cfg    config.OpenAPIConfig
+	router routers.Router
+}
+
+// OpenAPIValidator implements RequestForwarder by checking requests against
+// the OpenAPI document of their service before forwarding them, and
+// optionally logging responses that do not match it
+type OpenAPIValidator struct {
+	specs map[string]*serviceSpec
+	next  RequestForwarder
+}
+
+// NewOpenAPIValidator loads the OpenAPI document of every service that
+// configures one and wraps next. Without any documents it returns next
+// unchanged.
+func NewOpenAPIValidator(appConfig config.AppConfig, next RequestForwarder) (RequestForwarder, error) {
+	specs := map[string]*serviceSpec{}
+	for serviceName, serviceConfig := range appConfig.Services {
+		if serviceConfig.OpenAPI == nil {
+			continue
+		}
+		spec, err := loadServiceSpec(serviceName, *serviceConfig.OpenAPI)
+		if err != nil {
+			return nil, fmt.Errorf("service '%s': %w", serviceName, err)
+		}
+		specs[serviceName] = spec
+	}
+	if len(specs) == 0 {
+		return next, nil
+	}
+	return &OpenAPIValidator{specs: specs, next: next}, nil
+}
+
+func loadServiceSpec(serviceName string, cfg config.OpenAPIConfig) (*serviceSpec, error) {
+	if cfg.Spec == "" {
+		return nil, errors.New("openapi spec path is required")
+	}
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromFile(cfg.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OpenAPI document '%s': %w", cfg.Spec, err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document '%s': %w", cfg.Spec, err)
+	}
+
+	// The document's servers describe the upstreams; clients reach the same
+	// paths through the gateway's prefix
+	doc.Servers = openapi3.Servers{{URL: "/api/" + serviceName}}
+	for _, pathItem := range doc.Paths.Map() {
+		pathItem.Servers = nil
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build routes from '%s': %w", cfg.Spec, err)
+	}
+	log.Printf("Validating requests of service '%s' against %s (%d paths)", serviceName, cfg.Spec, doc.Paths.Len())
+	return &serviceSpec{cfg: cfg, router: router}, nil
+}
+
+// validationOptions leave authentication to the gateway's own middlewares
+// and never rewrite the client's request with schema defaults
+func validationOptions() *openapi3filter.Options {
+	return &openapi3filter.Options{
+		MultiError:          true,
+		AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+		SkipSettingDefaults: true,
+	}
+}
+
+// ForwardRequest implements RequestForwarder for OpenAPIValidator
+func (v *OpenAPIValidator) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
+	spec, ok := v.specs[serviceName]
+	if !ok {
+		v.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+
+	route, pathParams, err := spec.router.FindRoute(req)
+	if err != nil {
+		if !spec.cfg.Strict {
+			openAPIValidations.Inc(serviceName, "request", "undeclared")
+			v.next.ForwardRequest(w, req, serviceName)
+			return
+		}
+		openAPIValidations.Inc(serviceName, "request", "rejected")
+		problemType, message := problem.RouteNotFound, "Route is not declared by the service's OpenAPI document"
+		if errors.Is(err, routers.ErrMethodNotAllowed) {
+			problemType, message = problem.MethodNotAllowed, "Method is not declared by the service's OpenAPI document"
+		}
+		writeError(w, req, problemType, message)
+		return
+	}
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: pathParams,
+		Route:      route,
+		Options:    validationOptions(),
+	}
+	if err := openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+		openAPIValidations.Inc(serviceName, "request", "rejected")
+		if problemType, message, ok := requestBodyError(err); ok {
+			writeError(w, req, problemType, message)
+			return
+		}
+		problem.Write(w, req, problem.New(problem.ValidationFailed, "Request does not match the service's OpenAPI document").
+			With("errors", validationErrors(err)))
+		return
+	}
+	openAPIValidations.Inc(serviceName, "request", "valid")
+
+	if !spec.cfg.LogResponseViolations {
+		v.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+
+	recorder := &responseRecorder{ResponseWriter: w, keepBody: true, limit: maxValidatedResponseBytes}
+	v.next.ForwardRequest(recorder, req, serviceName)
+	v.logResponseViolations(req.Context(), input, recorder, serviceName)
+}
+
+// logResponseViolations checks a response that has already been sent to the
+// client; violations are only logged
+func (v *OpenAPIValidator) logResponseViolations(ctx context.Context, input *openapi3filter.RequestValidationInput, recorder *responseRecorder, serviceName string) {
+	if recorder.overflow {
+		openAPIValidations.Inc(serviceName, "response", "skipped")
+		return
+	}
+	err := openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 recorder.status(),
+		Header:                 recorder.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(recorder.body.Bytes())),
+		Options:                validationOptions(),
+	})
+	if err == nil {
+		openAPIValidations.Inc(serviceName, "response", "valid")
+		return
+	}
+
+	openAPIValidations.Inc(serviceName, "response", "invalid")
+	var messages []string
+	for _, violation := range validationErrors(err) {
+		messages = append(messages, violation.String())
+	}
+	log.Printf("[%s] Response from '%s' for %s %s does not match its OpenAPI document: %s",
+		input.Request.Header.Get("X-Request-ID"), serviceName, input.Request.Method, input.Route.Path, strings.Join(messages, "; "))
+}
+
+func (e ValidationError) String() string {
+	if e.Name == "" {
+		return e.In + ": " + e.Message
+	}
+	return e.In + " " + e.Name + ": " + e.Message
+}
+
+// validationErrors flattens the errors returned by openapi3filter into one
+// entry per violation
+func validationErrors(err error) []ValidationError {
+	// MultiErrors also appear inside RequestErrors, so only unpack the one
+	// at the top rather than searching the chain
+	if multi, ok := err.(openapi3.MultiError); ok {
+		var flattened []ValidationError
+		for _, e := range multi {
+			flattened = append(flattened, validationErrors(e)...)
+		}
+		return flattened
+	}
+
+	var requestErr *openapi3filter.RequestError
+	if errors.As(err, &requestErr) {
+		switch {
+		case requestErr.Parameter != nil:
+			return []ValidationError{{
+				In:      requestErr.Parameter.In,
+				Name:    requestErr.Parameter.Name,
+				Message: parameterMessage(requestErr),
+			}}
+		case requestErr.Err != nil:
+			return bodyErrors(requestErr.Err, requestErr.Reason)
+		default:
+			return []ValidationError{{In: "body", Message: requestErr.Reason}}
+		}
+	}
+
+	var responseErr *openapi3filter.ResponseError
+	if errors.As(err, &responseErr) {
+		if responseErr.Err != nil {
+			return bodyErrors(responseErr.Err, responseErr.Reason)
+		}
+		return []ValidationError{{In: "body", Message: responseErr.Reason}}
+	}
+
+	return []ValidationError{{In: "request", Message: err.Error()}}
+}
+
+func parameterMessage(requestErr *openapi3filter.RequestError) string {
+	var schemaErr *openapi3.SchemaError
+	if errors.As(requestErr.Err, &schemaErr) {
+		return schemaErr.Reason
+	}
+	if requestErr.Err != nil {
+		return requestErr.Err.Error()
+	}
+	return requestErr.Reason
+}
+
+// bodyErrors reports schema violations by JSON pointer, and anything else
+// (e.g. an unexpected Content-Type) with the reason openapi3filter gave
+func bodyErrors(err error, reason string) []ValidationError {
+	if multi, ok := err.(openapi3.MultiError); ok {
+		var flattened []ValidationError
+		for _, e := range multi {
+			flattened = append(flattened, bodyErrors(e, reason)...)
+		}
+		return flattened
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		return []ValidationError{{
+			In:      "body",
+			Name:    "/" + strings.Join(schemaErr.JSONPointer(), "/"),
+			Message: schemaErr.Reason,
+		}}
+	}
+	if reason == "" {
+		reason = err.Error()
+	}
+	return []ValidationError{{In: "body", Message: reason}}
+}
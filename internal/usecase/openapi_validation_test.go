@@ -0,0 +1,153 @@
+package usecase
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+const testUsersSpec = "../../config-files/openapi/users.yaml"
+
+func newTestOpenAPIValidator(t *testing.T, cfg config.OpenAPIConfig, upstream RequestForwarder) RequestForwarder {
+	t.Helper()
+	validator, err := NewOpenAPIValidator(config.AppConfig{
+		Services: map[string]config.ServiceConfig{"users": {OpenAPI: &cfg}},
+	}, upstream)
+	if err != nil {
+		t.Fatalf("failed to create OpenAPI validator: %v", err)
+	}
+	return validator
+}
+
+func TestOpenAPIValidatorRejectsInvalidRequests(t *testing.T) {
+	forwarded := 0
+	validator := newTestOpenAPIValidator(t, config.OpenAPIConfig{Spec: testUsersSpec},
+		forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+			forwarded++
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		expected    []ValidationError
+	}{
+		{name: "valid list", method: http.MethodGet, path: "/api/users/users?limit=10"},
+		{name: "valid create", method: http.MethodPost, path: "/api/users/users", contentType: "application/json", body: `{"name":"Ada","email":"ada@example.com"}`},
+		{name: "undeclared route is forwarded", method: http.MethodGet, path: "/api/users/ws"},
+		{
+			name: "query parameter", method: http.MethodGet, path: "/api/users/users?limit=500",
+			expected: []ValidationError{{In: "query", Name: "limit"}},
+		},
+		{
+			name: "path parameter", method: http.MethodGet, path: "/api/users/users/abc",
+			expected: []ValidationError{{In: "path", Name: "id"}},
+		},
+		{
+			name: "content type", method: http.MethodPost, path: "/api/users/users", contentType: "text/plain", body: `name=Ada`,
+			expected: []ValidationError{{In: "body"}},
+		},
+		{
+			name: "body schema", method: http.MethodPost, path: "/api/users/users", contentType: "application/json", body: `{"name":"","email":"nope","admin":true}`,
+			expected: []ValidationError{{In: "body", Name: "/name"}, {In: "body", Name: "/email"}, {In: "body", Name: "/"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := forwarded
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			validator.ForwardRequest(w, req, "users")
+
+			if tt.expected == nil {
+				if w.Code != http.StatusOK || forwarded != before+1 {
+					t.Errorf("expected the request to be forwarded, got %d: %s", w.Code, w.Body.String())
+				}
+				return
+			}
+
+			if w.Code != http.StatusBadRequest || forwarded != before {
+				t.Fatalf("expected 400 without forwarding, got %d", w.Code)
+			}
+			var response struct {
+				Errors []ValidationError `json:"errors"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid error response: %v", err)
+			}
+			for _, want := range tt.expected {
+				found := false
+				for _, got := range response.Errors {
+					if got.In == want.In && (want.Name == "" || got.Name == want.Name) && got.Message != "" {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("expected an error for %s %s, got %+v", want.In, want.Name, response.Errors)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenAPIValidatorStrictMode(t *testing.T) {
+	validator := newTestOpenAPIValidator(t, config.OpenAPIConfig{Spec: testUsersSpec, Strict: true},
+		forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	expected := map[string]int{
+		http.MethodGet + " /api/users/users":    http.StatusOK,
+		http.MethodGet + " /api/users/ws":       http.StatusNotFound,
+		http.MethodDelete + " /api/users/users": http.StatusMethodNotAllowed,
+	}
+	for request, status := range expected {
+		method, path, _ := strings.Cut(request, " ")
+		w := httptest.NewRecorder()
+		validator.ForwardRequest(w, httptest.NewRequest(method, path, nil), "users")
+		if w.Code != status {
+			t.Errorf("%s: expected %d, got %d", request, status, w.Code)
+		}
+	}
+}
+
+func TestOpenAPIValidatorLogsResponseViolations(t *testing.T) {
+	validator := newTestOpenAPIValidator(t, config.OpenAPIConfig{Spec: testUsersSpec, LogResponseViolations: true},
+		forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"users":[{"id":"one","name":"Ada","email":"ada@example.com"}]}`))
+		}))
+
+	invalid := openAPIValidations.Value("users", "response", "invalid")
+	w := httptest.NewRecorder()
+	validator.ForwardRequest(w, httptest.NewRequest(http.MethodGet, "/api/users/users", nil), "users")
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"one"`) {
+		t.Errorf("expected the response to be passed through, got %d %s", w.Code, w.Body.String())
+	}
+	if openAPIValidations.Value("users", "response", "invalid") != invalid+1 {
+		t.Error("expected the response to be reported as invalid")
+	}
+}
+
+func TestNewOpenAPIValidatorLoadsShippedSpecs(t *testing.T) {
+	for _, spec := range []string{testUsersSpec, "../../config-files/openapi/auth.yaml"} {
+		if _, err := loadServiceSpec("users", config.OpenAPIConfig{Spec: spec}); err != nil {
+			t.Errorf("failed to load %s: %v", spec, err)
+		}
+	}
+	if _, err := loadServiceSpec("users", config.OpenAPIConfig{Spec: "missing.yaml"}); err == nil {
+		t.Error("expected a missing document to be rejected")
+	}
+}
@@ -0,0 +1,88 @@
+package usecase
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+	"github.com/LucianoBarrera/api-gateway/internal/upstream"
+)
+
+// ErrSlowRequestBody is returned when reading a request body whose client
+// sends it below the configured minimum transfer rate
+var ErrSlowRequestBody = errors.New("request body sent below the minimum transfer rate")
+
+// requestBodyError reports whether err comes from a request body that broke
+// the server's limits, and the problem to answer with
+func requestBodyError(err error) (problem.Type, string, bool) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return problem.BodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit), true
+	case errors.Is(err, ErrSlowRequestBody):
+		return problem.BodyTooSlow, "Request body was sent too slowly", true
+	case errors.Is(err, errUndecodableBody):
+		return problem.InvalidBody, "Request body could not be decoded", true
+	}
+	return problem.Type{}, "", false
+}
+
+// upstreamError classifies a failed upstream request
+func upstreamError(err error) (problem.Type, string) {
+	var (
+		netErr       net.Error
+		dnsErr       *net.DNSError
+		opErr        *net.OpError
+		recordErr    tls.RecordHeaderError
+		verifyErr    *tls.CertificateVerificationError
+		alertErr     tls.AlertError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return problem.UpstreamTimeout, "The upstream did not respond in time"
+	case errors.As(err, &recordErr) || errors.As(err, &verifyErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr):
+		return problem.UpstreamTLSError, "The TLS handshake with the upstream failed"
+	case errors.As(err, &dnsErr) || errors.As(err, &opErr) && opErr.Op == "dial":
+		return problem.UpstreamUnreachable, "The upstream could not be reached"
+	}
+	return problem.UpstreamError, "The upstream request failed"
+}
+
+// proxyErrorHandler answers requests whose body broke the server's limits
+// while it was being forwarded, and reports any other failure with a
+// problem that tells dial errors, timeouts and TLS failures apart. req is
+// the client's request rather than the one sent upstream.
+func proxyErrorHandler(w http.ResponseWriter, req *http.Request, serviceName string, err error) {
+	requestID := req.Header.Get("X-Request-ID")
+	// A body cut short by a read deadline cancels the request, which the
+	// transport reports instead of the body's own error
+	if bodyErr := requestctx.From(req.Context()).BodyError(); bodyErr != nil {
+		err = bodyErr
+	}
+	if problemType, message, ok := requestBodyError(err); ok {
+		log.Printf("[%s] Request body rejected while forwarding: %v", requestID, err)
+		writeError(w, req, problemType, message)
+		return
+	}
+
+	if errors.Is(context.Cause(req.Context()), upstream.ErrTargetDisabled) {
+		log.Printf("[%s] Request to '%s' cut off, its upstream was disabled", requestID, serviceName)
+		writeError(w, req, problem.UpstreamUnavailable, "The upstream was disabled while the request was in flight")
+		return
+	}
+
+	problemType, message := upstreamError(err)
+	log.Printf("[%s] Upstream request to '%s' failed (%s): %v", requestID, serviceName, problemType.Code, err)
+	writeError(w, req, problemType, message)
+}
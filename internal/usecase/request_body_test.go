@@ -0,0 +1,83 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+)
+
+func TestProxyErrorHandler(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	// The gateway does not trust the test server's self-signed certificate
+	untrusted := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer untrusted.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve a port: %v", err)
+	}
+	closedURL := "http://" + listener.Addr().String()
+	listener.Close()
+
+	service := NewApiGatewayService(config.AppConfig{KnownServices: map[string]string{
+		"closed":    closedURL,
+		"slow":      slow.URL,
+		"untrusted": untrusted.URL,
+	}}, nil)
+
+	tests := []struct {
+		service        string
+		timeout        time.Duration
+		expectedType   problem.Type
+		expectedStatus int
+	}{
+		{service: "closed", expectedType: problem.UpstreamUnreachable, expectedStatus: http.StatusBadGateway},
+		{service: "slow", timeout: 50 * time.Millisecond, expectedType: problem.UpstreamTimeout, expectedStatus: http.StatusGatewayTimeout},
+		{service: "untrusted", expectedType: problem.UpstreamTLSError, expectedStatus: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/"+tt.service+"/users", nil)
+			req.Header.Set("X-Request-ID", "upstream-error-test")
+			if tt.timeout > 0 {
+				ctx, cancel := context.WithTimeout(req.Context(), tt.timeout)
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+			w := httptest.NewRecorder()
+			service.ForwardRequest(w, req, tt.service)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != problem.ContentType {
+				t.Errorf("expected content type %q, got %q", problem.ContentType, ct)
+			}
+			var details problem.Details
+			if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+				t.Fatalf("invalid problem details %q: %v", w.Body.String(), err)
+			}
+			if details.Code != tt.expectedType.Code || details.Type != problem.TypeBase+tt.expectedType.Code {
+				t.Errorf("expected code %s, got %s (%s)", tt.expectedType.Code, details.Code, details.Type)
+			}
+			if details.Instance != "/api/"+tt.service+"/users" || details.RequestID != "upstream-error-test" {
+				t.Errorf("expected the client's path and request ID, got %q and %q", details.Instance, details.RequestID)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/LucianoBarrera/api-gateway/internal/compression"
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/metrics"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+)
+
+var requestDecompressions = metrics.NewCounter("gateway_request_decompressions_total",
+	"Compressed request bodies decoded before forwarding, by service, encoding and result", "service", "encoding", "result")
+
+// errUndecodableBody is returned while reading a compressed request body
+// that turns out to be corrupt
+var errUndecodableBody = errors.New("request body could not be decoded")
+
+// RequestDecompressor implements RequestForwarder by decoding compressed
+// request bodies for services whose upstreams only accept plain ones
+type RequestDecompressor struct {
+	appConfig config.AppConfig
+	next      RequestForwarder
+}
+
+// NewRequestDecompressor wraps next. Without any service that enables
+// decompress_requests it returns next unchanged.
+func NewRequestDecompressor(appConfig config.AppConfig, next RequestForwarder) RequestForwarder {
+	for _, serviceConfig := range appConfig.Services {
+		if serviceConfig.DecompressRequests {
+			return &RequestDecompressor{appConfig: appConfig, next: next}
+		}
+	}
+	return next
+}
+
+// ForwardRequest implements RequestForwarder for RequestDecompressor
+func (d *RequestDecompressor) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
+	coding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	if !d.appConfig.Service(serviceName).DecompressRequests || !hasBody(req) || coding == "" || coding == "identity" {
+		d.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+
+	decoded, err := compression.NewReader(coding, req.Body)
+	if errors.Is(err, compression.ErrUnsupported) {
+		requestDecompressions.Inc(serviceName, "other", "unsupported")
+		// RFC 7694: tell the client which codings it may use instead
+		w.Header().Set("Accept-Encoding", strings.Join(config.CompressionEncodings, ", "))
+		writeError(w, req, problem.UnsupportedContentEncoding, fmt.Sprintf("Unsupported request Content-Encoding '%s'", coding))
+		return
+	}
+	if err != nil {
+		requestDecompressions.Inc(serviceName, coding, "rejected")
+		if problemType, message, ok := requestBodyError(err); ok {
+			writeError(w, req, problemType, message)
+			return
+		}
+		writeError(w, req, problem.InvalidBody, fmt.Sprintf("Invalid %s request body", coding))
+		return
+	}
+	requestDecompressions.Inc(serviceName, coding, "ok")
+
+	// The server's limit applies to the bytes on the wire; the decoded body
+	// gets the same limit so a small payload cannot expand without bound
+	limit := d.appConfig.BodyLimit(serviceName, req.Method, strings.TrimPrefix(req.URL.Path, "/api/"+serviceName))
+	body := &decodedBody{
+		ReadCloser: http.MaxBytesReader(w, decoded, limit),
+		info:       requestctx.From(req.Context()),
+	}
+	defer body.Close()
+
+	decompressed := req.Clone(req.Context())
+	decompressed.Body = body
+	decompressed.ContentLength = -1
+	decompressed.Header.Del("Content-Encoding")
+	decompressed.Header.Del("Content-Length")
+	d.next.ForwardRequest(w, decompressed, serviceName)
+}
+
+// decodedBody records read errors of a decoded request body, which the
+// transport would otherwise only report as a failed upstream request
+type decodedBody struct {
+	io.ReadCloser
+	info *requestctx.Info
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		if _, _, ok := requestBodyError(err); !ok {
+			err = fmt.Errorf("%w: %v", errUndecodableBody, err)
+		}
+		b.info.SetBodyError(err)
+	}
+	return n, err
+}
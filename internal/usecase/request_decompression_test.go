@@ -0,0 +1,98 @@
+package usecase
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LucianoBarrera/api-gateway/internal/compression"
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+func encodeBody(t *testing.T, coding, body string) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	w, err := compression.NewWriter(coding, &encoded)
+	if err != nil {
+		t.Fatalf("failed to create %s writer: %v", coding, err)
+	}
+	io.WriteString(w, body)
+	w.Close()
+	return encoded.Bytes()
+}
+
+func TestRequestDecompressor(t *testing.T) {
+	appConfig := config.AppConfig{
+		Server: config.ServerConfig{Limits: config.RequestLimitsConfig{MaxBodyBytes: 1024}},
+		Services: map[string]config.ServiceConfig{
+			"legacy": {DecompressRequests: true},
+			"users":  {},
+		},
+	}
+	payload := `{"name":"Ada","email":"ada@example.com"}`
+
+	tests := []struct {
+		name            string
+		service         string
+		coding          string
+		body            []byte
+		expected        int
+		expectedBody    string
+		expectedCoding  string
+		expectForwarded bool
+	}{
+		{name: "gzip", service: "legacy", coding: "gzip", body: encodeBody(t, "gzip", payload), expected: http.StatusOK, expectedBody: payload, expectForwarded: true},
+		{name: "brotli", service: "legacy", coding: "br", body: encodeBody(t, "br", payload), expected: http.StatusOK, expectedBody: payload, expectForwarded: true},
+		{name: "zstd", service: "legacy", coding: "zstd", body: encodeBody(t, "zstd", payload), expected: http.StatusOK, expectedBody: payload, expectForwarded: true},
+		{name: "plain body", service: "legacy", body: []byte(payload), expected: http.StatusOK, expectedBody: payload, expectForwarded: true},
+		{name: "service keeps encoded bodies", service: "users", coding: "gzip", body: encodeBody(t, "gzip", payload), expected: http.StatusOK, expectedCoding: "gzip", expectForwarded: true},
+		{name: "unsupported coding", service: "legacy", coding: "deflate", body: []byte("x"), expected: http.StatusUnsupportedMediaType},
+		{name: "corrupt body", service: "legacy", coding: "gzip", body: []byte("not gzip"), expected: http.StatusBadRequest},
+		{name: "decoded body too large", service: "legacy", coding: "gzip", body: encodeBody(t, "gzip", strings.Repeat("a", 4096)), expected: http.StatusRequestEntityTooLarge, expectForwarded: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded := false
+			decompressor := NewRequestDecompressor(appConfig, forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+				forwarded = true
+				body, err := io.ReadAll(r.Body)
+				if problemType, message, ok := requestBodyError(err); ok {
+					writeError(w, r, problemType, message)
+					return
+				}
+				if got := r.Header.Get("Content-Encoding"); got != tt.expectedCoding {
+					t.Errorf("expected upstream Content-Encoding %q, got %q", tt.expectedCoding, got)
+				}
+				if tt.expectedBody != "" && string(body) != tt.expectedBody {
+					t.Errorf("expected upstream body %q, got %q", tt.expectedBody, body)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/"+tt.service+"/users", bytes.NewReader(tt.body))
+			if tt.coding != "" {
+				req.Header.Set("Content-Encoding", tt.coding)
+			}
+			w := httptest.NewRecorder()
+			decompressor.ForwardRequest(w, req, tt.service)
+
+			if w.Code != tt.expected {
+				t.Errorf("expected %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+			if forwarded != tt.expectForwarded {
+				t.Errorf("expected forwarded=%v", tt.expectForwarded)
+			}
+		})
+	}
+}
+
+func TestNewRequestDecompressorWithoutServices(t *testing.T) {
+	next := forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {})
+	if _, ok := NewRequestDecompressor(config.AppConfig{}, next).(forwarderFunc); !ok {
+		t.Error("expected next to be returned when no service decompresses requests")
+	}
+}
@@ -0,0 +1,51 @@
+package usecase
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+// streamingResponseWriter flushes after every write once the upstream
+// response has been identified as a stream, so events reach the client as
+// soon as the backend produces them
+type streamingResponseWriter struct {
+	http.ResponseWriter
+	immediate bool
+}
+
+func (sw *streamingResponseWriter) Write(data []byte) (int, error) {
+	n, err := sw.ResponseWriter.Write(data)
+	if err == nil && sw.immediate {
+		sw.Flush()
+	}
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (sw *streamingResponseWriter) Flush() {
+	if err := http.NewResponseController(sw.ResponseWriter).Flush(); err != nil {
+		log.Printf("Failed to flush streaming response: %v", err)
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sw *streamingResponseWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// startStreaming switches the writer to flush-per-write and lifts the
+// server's WriteTimeout, which would otherwise cut long-lived streams
+func (sw *streamingResponseWriter) startStreaming(cfg config.StreamingConfig, requestID string) {
+	sw.immediate = true
+
+	var deadline time.Time
+	if cfg.MaxDuration.Duration > 0 {
+		deadline = time.Now().Add(cfg.MaxDuration.Duration)
+	}
+	if err := http.NewResponseController(sw.ResponseWriter).SetWriteDeadline(deadline); err != nil {
+		log.Printf("[%s] Failed to lift write deadline for streaming response: %v", requestID, err)
+	}
+}
@@ -0,0 +1,205 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+)
+
+// defaultStickyTTL is the lifetime of sticky version cookies
+const defaultStickyTTL = 24 * time.Hour
+
+type versionContextKey struct{}
+
+// withVersion records the version picked for serviceName on the request
+// context, where the proxy and the cache key pick it up
+func withVersion(ctx context.Context, serviceName, version string) context.Context {
+	versions, _ := ctx.Value(versionContextKey{}).(map[string]string)
+	next := make(map[string]string, len(versions)+1)
+	for service, v := range versions {
+		next[service] = v
+	}
+	next[serviceName] = version
+	return context.WithValue(ctx, versionContextKey{}, next)
+}
+
+// versionFromContext returns the version picked for serviceName, if any
+func versionFromContext(ctx context.Context, serviceName string) string {
+	versions, _ := ctx.Value(versionContextKey{}).(map[string]string)
+	return versions[serviceName]
+}
+
+// TrafficSplitter implements RequestForwarder by assigning requests to
+// services with several versions to one of them, by override, sticky
+// cookie or weight, before handing them to next
+type TrafficSplitter struct {
+	appConfig config.AppConfig
+	next      RequestForwarder
+}
+
+// NewTrafficSplitter validates the versions of every service and wraps next
+func NewTrafficSplitter(appConfig config.AppConfig, next RequestForwarder) (RequestForwarder, error) {
+	for serviceName, serviceConfig := range appConfig.Services {
+		if err := validateVersions(serviceConfig); err != nil {
+			return nil, fmt.Errorf("service '%s': %w", serviceName, err)
+		}
+	}
+	return &TrafficSplitter{appConfig: appConfig, next: next}, nil
+}
+
+func validateVersions(serviceConfig config.ServiceConfig) error {
+	if len(serviceConfig.Versions) == 0 {
+		if len(serviceConfig.TrafficSplit.Overrides) > 0 {
+			return fmt.Errorf("traffic_split overrides require versions")
+		}
+		return nil
+	}
+
+	totalWeight := 0
+	seen := map[string]bool{}
+	for _, version := range serviceConfig.Versions {
+		if version.Name == "" {
+			return fmt.Errorf("versions need a name")
+		}
+		if seen[version.Name] {
+			return fmt.Errorf("duplicate version '%s'", version.Name)
+		}
+		seen[version.Name] = true
+
+		if version.Weight < 0 {
+			return fmt.Errorf("version '%s' has a negative weight", version.Name)
+		}
+		totalWeight += version.Weight
+
+		if len(version.Upstreams) == 0 {
+			return fmt.Errorf("version '%s' has no upstreams", version.Name)
+		}
+		for _, upstream := range version.Upstreams {
+			if _, err := config.ParseUpstreamURL(upstream); err != nil {
+				return fmt.Errorf("version '%s': %w", version.Name, err)
+			}
+		}
+	}
+	if totalWeight == 0 {
+		return fmt.Errorf("at least one version needs a positive weight")
+	}
+
+	for _, override := range serviceConfig.TrafficSplit.Overrides {
+		selectors := 0
+		for _, set := range []bool{override.Header != "", override.Cookie != "", len(override.Consumers) > 0} {
+			if set {
+				selectors++
+			}
+		}
+		if selectors != 1 {
+			return fmt.Errorf("each override needs exactly one of header, cookie or consumers")
+		}
+		if !seen[override.Version] {
+			return fmt.Errorf("override refers to unknown version '%s'", override.Version)
+		}
+	}
+	return nil
+}
+
+// ForwardRequest implements RequestForwarder for TrafficSplitter
+func (t *TrafficSplitter) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
+	serviceConfig := t.appConfig.Service(serviceName)
+	if len(serviceConfig.Versions) == 0 {
+		t.next.ForwardRequest(w, req, serviceName)
+		return
+	}
+
+	info := requestctx.From(req.Context())
+	version, reason := t.pickVersion(req, serviceConfig, info.Consumer())
+
+	cookie := serviceConfig.TrafficSplit.StickyCookie
+	if reason == "weight" && cookie != "" {
+		ttl := serviceConfig.TrafficSplit.StickyTTL.Duration
+		if ttl <= 0 {
+			ttl = defaultStickyTTL
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookie,
+			Value:    version,
+			Path:     "/api/" + serviceName,
+			MaxAge:   int(ttl / time.Second),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	// Aggregates call several services on behalf of one client request;
+	// only the service the client addressed is reported for the request
+	if info.Service() == serviceName {
+		info.SetVersion(version)
+	}
+	log.Printf("[%s] Routing request for '%s' to version '%s' (%s)", info.RequestID(), serviceName, version, reason)
+
+	t.next.ForwardRequest(w, req.WithContext(withVersion(req.Context(), serviceName, version)), serviceName)
+}
+
+// pickVersion applies the overrides, then the sticky cookie, then the
+// weighted split. Consumers are hashed into the split so they stay on one
+// version without a cookie.
+func (t *TrafficSplitter) pickVersion(req *http.Request, serviceConfig config.ServiceConfig, consumer string) (string, string) {
+	split := serviceConfig.TrafficSplit
+
+	for _, override := range split.Overrides {
+		switch {
+		case override.Header != "":
+			if strings.EqualFold(req.Header.Get(override.Header), override.Value) {
+				return override.Version, "header " + override.Header
+			}
+		case override.Cookie != "":
+			if c, err := req.Cookie(override.Cookie); err == nil && strings.EqualFold(c.Value, override.Value) {
+				return override.Version, "cookie " + override.Cookie
+			}
+		default:
+			for _, id := range override.Consumers {
+				if consumer != "" && id == consumer {
+					return override.Version, "consumer " + consumer
+				}
+			}
+		}
+	}
+
+	if split.StickyCookie != "" {
+		if c, err := req.Cookie(split.StickyCookie); err == nil {
+			if version, ok := serviceConfig.Version(c.Value); ok && version.Weight > 0 {
+				return version.Name, "sticky cookie"
+			}
+		}
+	}
+
+	totalWeight := 0
+	for _, version := range serviceConfig.Versions {
+		totalWeight += version.Weight
+	}
+
+	var bucket int
+	reason := "weight"
+	if consumer != "" {
+		hash := fnv.New32a()
+		hash.Write([]byte(consumer))
+		bucket = int(hash.Sum32() % uint32(totalWeight))
+		reason = "consumer hash"
+	} else {
+		bucket = rand.IntN(totalWeight)
+	}
+
+	for _, version := range serviceConfig.Versions {
+		if bucket < version.Weight {
+			return version.Name, reason
+		}
+		bucket -= version.Weight
+	}
+	return serviceConfig.Versions[len(serviceConfig.Versions)-1].Name, reason
+}
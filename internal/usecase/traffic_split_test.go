@@ -0,0 +1,179 @@
+package usecase
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/requestctx"
+)
+
+func newTestSplitConfig(split config.TrafficSplitConfig) config.AppConfig {
+	return config.AppConfig{
+		KnownServices: map[string]string{"users": "http://users"},
+		Services: map[string]config.ServiceConfig{"users": {
+			Versions: []config.VersionConfig{
+				{Name: "stable", Upstreams: []string{"http://users-v1-a", "http://users-v1-b"}, Weight: 90},
+				{Name: "canary", Upstreams: []string{"http://users-v2"}, Weight: 10},
+			},
+			TrafficSplit: split,
+		}},
+	}
+}
+
+// versionRecorder records the version each forwarded request was assigned
+func versionRecorder(versions *[]string) RequestForwarder {
+	return forwarderFunc(func(w http.ResponseWriter, r *http.Request, serviceName string) {
+		*versions = append(*versions, versionFromContext(r.Context(), serviceName))
+	})
+}
+
+func TestTrafficSplitterOverrides(t *testing.T) {
+	var versions []string
+	splitter, err := NewTrafficSplitter(newTestSplitConfig(config.TrafficSplitConfig{
+		Overrides: []config.VersionOverride{
+			{Header: "X-Canary", Value: "true", Version: "canary"},
+			{Cookie: "beta", Value: "yes", Version: "canary"},
+			{Consumers: []string{"mobile-beta"}, Version: "canary"},
+		},
+	}), versionRecorder(&versions))
+	if err != nil {
+		t.Fatalf("failed to create splitter: %v", err)
+	}
+
+	header := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	header.Header.Set("X-Canary", "TRUE")
+
+	cookie := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	cookie.AddCookie(&http.Cookie{Name: "beta", Value: "yes"})
+
+	ctx, info := requestctx.New(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "split-test")
+	info.SetConsumer("mobile-beta")
+	info.SetService("users")
+	consumer := httptest.NewRequest(http.MethodGet, "/api/users/users", nil).WithContext(ctx)
+
+	for _, req := range []*http.Request{header, cookie, consumer} {
+		splitter.ForwardRequest(httptest.NewRecorder(), req, "users")
+	}
+
+	for i, version := range versions {
+		if version != "canary" {
+			t.Errorf("request %d: expected the canary override, got %q", i, version)
+		}
+	}
+	if info.Version() != "canary" {
+		t.Errorf("expected the version to be reported on the request info, got %q", info.Version())
+	}
+}
+
+func TestTrafficSplitterWeightsAndStickiness(t *testing.T) {
+	var versions []string
+	splitter, err := NewTrafficSplitter(newTestSplitConfig(config.TrafficSplitConfig{StickyCookie: "users-version"}), versionRecorder(&versions))
+	if err != nil {
+		t.Fatalf("failed to create splitter: %v", err)
+	}
+
+	counts := map[string]int{}
+	for range 2000 {
+		w := httptest.NewRecorder()
+		splitter.ForwardRequest(w, httptest.NewRequest(http.MethodGet, "/api/users/users", nil), "users")
+		version := versions[len(versions)-1]
+		counts[version]++
+
+		if cookie := w.Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, "users-version="+version+";") {
+			t.Fatalf("expected a sticky cookie for %q, got %q", version, cookie)
+		}
+	}
+	if counts["canary"] < 100 || counts["canary"] > 300 {
+		t.Errorf("expected roughly 10%% of requests on canary, got %v", counts)
+	}
+
+	sticky := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	sticky.AddCookie(&http.Cookie{Name: "users-version", Value: "canary"})
+	for range 20 {
+		w := httptest.NewRecorder()
+		splitter.ForwardRequest(w, sticky, "users")
+		if versions[len(versions)-1] != "canary" {
+			t.Fatal("expected the sticky cookie to keep the client on canary")
+		}
+		if w.Header().Get("Set-Cookie") != "" {
+			t.Error("did not expect a new cookie for a sticky client")
+		}
+	}
+
+	// Consumers are hashed, so they stay on one version without cookies
+	ctx, info := requestctx.New(sticky.Context(), "split-test")
+	info.SetConsumer("consumer-42")
+	consumer := httptest.NewRequest(http.MethodGet, "/api/users/users", nil).WithContext(ctx)
+	splitter.ForwardRequest(httptest.NewRecorder(), consumer, "users")
+	first := versions[len(versions)-1]
+	for range 20 {
+		splitter.ForwardRequest(httptest.NewRecorder(), consumer, "users")
+		if versions[len(versions)-1] != first {
+			t.Fatal("expected a consumer to always get the same version")
+		}
+	}
+}
+
+func TestApiGatewayServiceRoundRobinsVersionUpstreams(t *testing.T) {
+	service := NewApiGatewayService(newTestSplitConfig(config.TrafficSplitConfig{}), nil).(*ApiGatewayService)
+	req := httptest.NewRequest(http.MethodGet, "/api/users/users", nil)
+	req = req.WithContext(withVersion(req.Context(), "users", "stable"))
+
+	var upstreams []string
+	for range 4 {
+		target, _ := service.upstreamFor(req, "users")
+		upstreams = append(upstreams, target)
+	}
+	expected := "http://users-v1-a http://users-v1-b http://users-v1-a http://users-v1-b"
+	if got := strings.Join(upstreams, " "); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	if unversioned, _ := service.upstreamFor(httptest.NewRequest(http.MethodGet, "/api/users/users", nil), "users"); unversioned != "http://users" {
+		t.Errorf("expected the known_services URL without a version, got %q", unversioned)
+	}
+}
+
+func TestNewTrafficSplitterValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []config.VersionConfig
+		split    config.TrafficSplitConfig
+	}{
+		{
+			name:     "no weight",
+			versions: []config.VersionConfig{{Name: "stable", Upstreams: []string{"http://a"}}},
+		},
+		{
+			name:     "invalid upstream",
+			versions: []config.VersionConfig{{Name: "stable", Upstreams: []string{"not a url"}, Weight: 1}},
+		},
+		{
+			name: "duplicate version",
+			versions: []config.VersionConfig{
+				{Name: "stable", Upstreams: []string{"http://a"}, Weight: 1},
+				{Name: "stable", Upstreams: []string{"http://b"}, Weight: 1},
+			},
+		},
+		{
+			name:     "override to unknown version",
+			versions: []config.VersionConfig{{Name: "stable", Upstreams: []string{"http://a"}, Weight: 1}},
+			split:    config.TrafficSplitConfig{Overrides: []config.VersionOverride{{Header: "X-Canary", Value: "true", Version: "canary"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appConfig := config.AppConfig{
+				KnownServices: map[string]string{"users": "http://users"},
+				Services:      map[string]config.ServiceConfig{"users": {Versions: tt.versions, TrafficSplit: tt.split}},
+			}
+			if _, err := NewTrafficSplitter(appConfig, nil); err == nil {
+				t.Error("expected a validation error")
+			}
+		})
+	}
+}
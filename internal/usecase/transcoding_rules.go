@@ -0,0 +1,435 @@
+package usecase
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+)
+
+// transcodingRule binds an HTTP method and path template to a gRPC method
+type transcodingRule struct {
+	httpMethod   string
+	template     *pathTemplate
+	method       protoreflect.MethodDescriptor
+	body         string
+	responseBody string
+}
+
+// grpcPath is the HTTP/2 path used to call the rule's method upstream
+func (rule *transcodingRule) grpcPath() string {
+	return fmt.Sprintf("/%s/%s", rule.method.Parent().FullName(), rule.method.Name())
+}
+
+// loadDescriptorSet reads a compiled FileDescriptorSet from disk
+func loadDescriptorSet(path string) (*protoregistry.Files, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set '%s': %w", path, err)
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set '%s': %w", path, err)
+	}
+
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set '%s': %w", path, err)
+	}
+	return files, nil
+}
+
+// buildTranscodingRules combines the explicit mappings from config with the
+// google.api.http annotations found in the descriptor set
+func buildTranscodingRules(files *protoregistry.Files, cfg config.TranscodingConfig) ([]*transcodingRule, error) {
+	var rules []*transcodingRule
+
+	for _, mapping := range cfg.Mappings {
+		serviceName, methodName, ok := strings.Cut(strings.TrimPrefix(mapping.GRPCMethod, "/"), "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid grpc_method %q, expected package.Service/Method", mapping.GRPCMethod)
+		}
+		desc, err := files.FindDescriptorByName(protoreflect.FullName(serviceName))
+		if err != nil {
+			return nil, fmt.Errorf("gRPC service %s not found in descriptor set: %w", serviceName, err)
+		}
+		service, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a gRPC service", serviceName)
+		}
+		method := service.Methods().ByName(protoreflect.Name(methodName))
+		if method == nil {
+			return nil, fmt.Errorf("method %s not found on gRPC service %s", methodName, serviceName)
+		}
+
+		rule, err := newTranscodingRule(mapping.Method, mapping.Path, method, mapping.Body, mapping.ResponseBody)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	var annotationErr error
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		services := file.Services()
+		for i := 0; i < services.Len(); i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				method := methods.Get(j)
+				options, ok := method.Options().(*descriptorpb.MethodOptions)
+				if !ok || options == nil || !proto.HasExtension(options, annotations.E_Http) {
+					continue
+				}
+				httpRule := proto.GetExtension(options, annotations.E_Http).(*annotations.HttpRule)
+				for _, binding := range append([]*annotations.HttpRule{httpRule}, httpRule.GetAdditionalBindings()...) {
+					httpMethod, path := httpRulePattern(binding)
+					if path == "" {
+						continue
+					}
+					rule, err := newTranscodingRule(httpMethod, path, method, binding.GetBody(), binding.GetResponseBody())
+					if err != nil {
+						annotationErr = err
+						return false
+					}
+					rules = append(rules, rule)
+				}
+			}
+		}
+		return true
+	})
+	if annotationErr != nil {
+		return nil, annotationErr
+	}
+
+	return rules, nil
+}
+
+func httpRulePattern(rule *annotations.HttpRule) (string, string) {
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return "GET", pattern.Get
+	case *annotations.HttpRule_Put:
+		return "PUT", pattern.Put
+	case *annotations.HttpRule_Post:
+		return "POST", pattern.Post
+	case *annotations.HttpRule_Delete:
+		return "DELETE", pattern.Delete
+	case *annotations.HttpRule_Patch:
+		return "PATCH", pattern.Patch
+	case *annotations.HttpRule_Custom:
+		return pattern.Custom.GetKind(), pattern.Custom.GetPath()
+	}
+	return "", ""
+}
+
+func newTranscodingRule(httpMethod, path string, method protoreflect.MethodDescriptor, body, responseBody string) (*transcodingRule, error) {
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return nil, fmt.Errorf("cannot transcode streaming method %s", method.FullName())
+	}
+
+	template, err := parsePathTemplate(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template for %s: %w", method.FullName(), err)
+	}
+
+	if body != "" && body != "*" {
+		if fd := findField(method.Input(), body); fd == nil || fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("body field %q of %s must be a singular message field", body, method.FullName())
+		}
+	}
+	if responseBody != "" && findField(method.Output(), responseBody) == nil {
+		return nil, fmt.Errorf("response_body field %q not found on %s", responseBody, method.Output().FullName())
+	}
+
+	return &transcodingRule{
+		httpMethod:   strings.ToUpper(httpMethod),
+		template:     template,
+		method:       method,
+		body:         body,
+		responseBody: responseBody,
+	}, nil
+}
+
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	segmentWildcard
+	segmentDeepWildcard
+)
+
+type templateSegment struct {
+	kind     segmentKind
+	literal  string
+	variable string
+}
+
+// pathTemplate is a parsed google.api.http path template such as
+// /v1/{name=shelves/*/books/*}:publish
+type pathTemplate struct {
+	segments []templateSegment
+	verb     string
+}
+
+func parsePathTemplate(template string) (*pathTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("template %q must start with /", template)
+	}
+	rest := template[1:]
+
+	parsed := &pathTemplate{}
+	if idx := strings.LastIndex(rest, ":"); idx >= 0 && idx > strings.LastIndex(rest, "}") && idx > strings.LastIndex(rest, "/") {
+		parsed.verb = rest[idx+1:]
+		rest = rest[:idx]
+	}
+
+	for rest != "" {
+		if rest[0] == '{' {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable in template %q", template)
+			}
+			name, pattern, hasPattern := strings.Cut(rest[1:end], "=")
+			if name == "" {
+				return nil, fmt.Errorf("empty variable name in template %q", template)
+			}
+			if !hasPattern {
+				pattern = "*"
+			}
+			for _, part := range strings.Split(pattern, "/") {
+				parsed.segments = append(parsed.segments, newTemplateSegment(part, name))
+			}
+			rest = rest[end+1:]
+		} else {
+			end := strings.IndexByte(rest, '/')
+			if end < 0 {
+				end = len(rest)
+			}
+			parsed.segments = append(parsed.segments, newTemplateSegment(rest[:end], ""))
+			rest = rest[end:]
+		}
+
+		if strings.HasPrefix(rest, "/") {
+			rest = rest[1:]
+		} else if rest != "" {
+			return nil, fmt.Errorf("unexpected %q in template %q", rest, template)
+		}
+	}
+
+	for i, segment := range parsed.segments {
+		if segment.kind == segmentDeepWildcard && i != len(parsed.segments)-1 {
+			return nil, fmt.Errorf("** must be the last segment in template %q", template)
+		}
+	}
+
+	return parsed, nil
+}
+
+func newTemplateSegment(part, variable string) templateSegment {
+	switch part {
+	case "*":
+		return templateSegment{kind: segmentWildcard, variable: variable}
+	case "**":
+		return templateSegment{kind: segmentDeepWildcard, variable: variable}
+	}
+	return templateSegment{kind: segmentLiteral, literal: part, variable: variable}
+}
+
+// match reports whether an escaped path fits the template and returns the
+// value bound to each variable. Segments are unescaped once, after the path
+// has been split, so an escaped / or % is taken literally.
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+	path = strings.TrimPrefix(path, "/")
+	if t.verb != "" {
+		if !strings.HasSuffix(path, ":"+t.verb) {
+			return nil, false
+		}
+		path = strings.TrimSuffix(path, ":"+t.verb)
+	}
+
+	parts := strings.Split(path, "/")
+	captured := map[string][]string{}
+	i := 0
+	for _, segment := range t.segments {
+		var taken []string
+		switch segment.kind {
+		case segmentDeepWildcard:
+			taken = parts[i:]
+			i = len(parts)
+		default:
+			if i >= len(parts) || parts[i] == "" {
+				return nil, false
+			}
+			if segment.kind == segmentLiteral && unescapeSegment(parts[i]) != segment.literal {
+				return nil, false
+			}
+			taken = parts[i : i+1]
+			i++
+		}
+		if segment.variable != "" {
+			captured[segment.variable] = append(captured[segment.variable], taken...)
+		}
+	}
+	if i != len(parts) {
+		return nil, false
+	}
+
+	bindings := make(map[string]string, len(captured))
+	for variable, values := range captured {
+		for j, value := range values {
+			values[j] = unescapeSegment(value)
+		}
+		bindings[variable] = strings.Join(values, "/")
+	}
+	return bindings, true
+}
+
+// unescapeSegment decodes a path segment, leaving a malformed one as it is
+func unescapeSegment(segment string) string {
+	if unescaped, err := url.PathUnescape(segment); err == nil {
+		return unescaped
+	}
+	return segment
+}
+
+// findField resolves a dotted field path (proto or JSON names) on a message
+func findField(desc protoreflect.MessageDescriptor, path string) protoreflect.FieldDescriptor {
+	var field protoreflect.FieldDescriptor
+	for _, name := range strings.Split(path, ".") {
+		if desc == nil {
+			return nil
+		}
+		field = desc.Fields().ByName(protoreflect.Name(name))
+		if field == nil {
+			field = desc.Fields().ByJSONName(name)
+		}
+		if field == nil {
+			return nil
+		}
+		desc = field.Message()
+	}
+	return field
+}
+
+// setFieldPath assigns string values taken from the path or query string to
+// a (possibly nested) scalar field of msg
+func setFieldPath(msg protoreflect.Message, path string, values []string) error {
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		field := findField(msg.Descriptor(), name)
+		if field == nil || field.Message() == nil || field.IsList() || field.IsMap() {
+			return fmt.Errorf("%q is not a message field of %s", name, msg.Descriptor().FullName())
+		}
+		msg = msg.Mutable(field).Message()
+	}
+
+	field := findField(msg.Descriptor(), names[len(names)-1])
+	if field == nil {
+		return fmt.Errorf("unknown field %q on %s", path, msg.Descriptor().FullName())
+	}
+	if field.IsMap() || field.Message() != nil {
+		return fmt.Errorf("field %q cannot be set from a string", path)
+	}
+
+	if field.IsList() {
+		list := msg.Mutable(field).List()
+		for _, raw := range values {
+			value, err := parseScalar(field, raw)
+			if err != nil {
+				return err
+			}
+			list.Append(value)
+		}
+		return nil
+	}
+
+	value, err := parseScalar(field, values[len(values)-1])
+	if err != nil {
+		return err
+	}
+	msg.Set(field, value)
+	return nil
+}
+
+func parseScalar(field protoreflect.FieldDescriptor, raw string) (protoreflect.Value, error) {
+	invalid := func(err error) (protoreflect.Value, error) {
+		return protoreflect.Value{}, fmt.Errorf("invalid value %q for field %s: %w", raw, field.Name(), err)
+	}
+
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(raw), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfBool(v), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(raw, 10, 32)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfInt32(int32(v)), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfInt64(v), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfUint32(uint32(v)), nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfUint64(v), nil
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(raw, 32)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfFloat32(float32(v)), nil
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfFloat64(v), nil
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			if v, err = base64.URLEncoding.DecodeString(raw); err != nil {
+				return invalid(err)
+			}
+		}
+		return protoreflect.ValueOfBytes(v), nil
+	case protoreflect.EnumKind:
+		if value := field.Enum().Values().ByName(protoreflect.Name(raw)); value != nil {
+			return protoreflect.ValueOfEnum(value.Number()), nil
+		}
+		v, err := strconv.ParseInt(raw, 10, 32)
+		if err != nil {
+			return invalid(fmt.Errorf("unknown enum value"))
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), nil
+	}
+	return invalid(fmt.Errorf("unsupported kind %s", field.Kind()))
+}
@@ -0,0 +1,322 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/grpcstatus"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+	"github.com/LucianoBarrera/api-gateway/internal/upstream"
+)
+
+// maxTranscodedMessageBytes matches gRPC's default maximum message size
+const maxTranscodedMessageBytes = 4 << 20
+
+// grpcMetadataPrefix marks HTTP headers that are forwarded as gRPC metadata
+const grpcMetadataPrefix = "Grpc-Metadata-"
+
+// TranscodingService implements RequestForwarder by translating JSON/HTTP
+// requests into unary gRPC calls and the protobuf responses back into JSON
+type TranscodingService struct {
+	serviceName string
+	upstream    *url.URL
+	rules       []*transcodingRule
+	transport   http.RoundTripper
+	// targets tracks the upstream's health and whether it takes requests
+	targets *upstream.Registry
+}
+
+// NewTranscodingService builds the transcoder for a service that has a
+// transcoding section in its config
+func NewTranscodingService(appConfig config.AppConfig, serviceName string, targets *upstream.Registry) (RequestForwarder, error) {
+	cfg := appConfig.Service(serviceName).Transcoding
+	if cfg == nil {
+		return nil, fmt.Errorf("service '%s' has no transcoding config", serviceName)
+	}
+
+	upstreamURL, err := config.ParseUpstreamURL(appConfig.KnownServices[serviceName])
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL for service '%s': %w", serviceName, err)
+	}
+
+	files, err := loadDescriptorSet(cfg.DescriptorSet)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := buildTranscodingRules(files, *cfg)
+	if err != nil {
+		return nil, fmt.Errorf("service '%s': %w", serviceName, err)
+	}
+	log.Printf("Transcoding %d HTTP routes to gRPC for service '%s'", len(rules), serviceName)
+
+	return &TranscodingService{
+		serviceName: serviceName,
+		upstream:    upstreamURL,
+		rules:       rules,
+		transport:   grpcTransport,
+		targets:     targets,
+	}, nil
+}
+
+// ForwardRequest implements RequestForwarder for TranscodingService
+func (t *TranscodingService) ForwardRequest(w http.ResponseWriter, req *http.Request, serviceName string) {
+	requestID := req.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = "unknown"
+	}
+
+	// Matched escaped, so an escaped / stays within its segment
+	path := strings.TrimPrefix(req.URL.EscapedPath(), "/api/"+serviceName)
+	rule, bindings := t.match(req.Method, path)
+	if rule == nil {
+		writeError(w, req, problem.RouteNotFound, "No gRPC method is mapped to "+req.Method+" "+path)
+		return
+	}
+
+	input, err := t.buildInput(req, rule, bindings)
+	if problemType, message, ok := requestBodyError(err); ok {
+		writeError(w, req, problemType, message)
+		return
+	}
+	if err != nil {
+		writeError(w, req, problem.InvalidRequest, err.Error())
+		return
+	}
+
+	log.Printf("[%s] Transcoding %s %s to gRPC %s on service '%s'", requestID, req.Method, path, rule.grpcPath(), serviceName)
+
+	target := t.upstream.String()
+	if !t.targets.Available(target) {
+		log.Printf("[%s] The upstream of service '%s' is not taking requests", requestID, serviceName)
+		writeError(w, req, problem.UpstreamUnavailable, "The service's upstream is drained or disabled")
+		return
+	}
+	ctx, done := t.targets.Begin(req.Context(), target)
+	output, code, message, err := t.invoke(req.WithContext(ctx), rule, input)
+	done(targetFailure(ctx, err))
+	if err != nil {
+		log.Printf("[%s] gRPC call %s failed: %v", requestID, rule.grpcPath(), err)
+		writeError(w, req, problem.UpstreamGRPCError, "Upstream gRPC call failed")
+		return
+	}
+	if code != grpcstatus.OK {
+		writeGRPCStatusError(w, req, code, message)
+		return
+	}
+
+	t.writeOutput(w, req, rule, output)
+}
+
+func (t *TranscodingService) match(method, path string) (*transcodingRule, map[string]string) {
+	for _, rule := range t.rules {
+		if rule.httpMethod != method {
+			continue
+		}
+		if bindings, ok := rule.template.match(path); ok {
+			return rule, bindings
+		}
+	}
+	return nil, nil
+}
+
+// buildInput fills the request message from the JSON body, the path
+// variables and the query string, in that order of precedence
+func (t *TranscodingService) buildInput(req *http.Request, rule *transcodingRule, bindings map[string]string) (*dynamicpb.Message, error) {
+	input := dynamicpb.NewMessage(rule.method.Input())
+
+	if rule.body != "" && req.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxTranscodedMessageBytes+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		if len(body) > maxTranscodedMessageBytes {
+			return nil, fmt.Errorf("request body exceeds %d bytes", maxTranscodedMessageBytes)
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			var target proto.Message = input
+			if rule.body != "*" {
+				field := findField(rule.method.Input(), rule.body)
+				target = input.Mutable(field).Message().Interface()
+			}
+			if err := protojson.Unmarshal(body, target); err != nil {
+				return nil, fmt.Errorf("invalid JSON body: %w", err)
+			}
+		}
+	}
+
+	for variable, value := range bindings {
+		if err := setFieldPath(input.ProtoReflect(), variable, []string{value}); err != nil {
+			return nil, err
+		}
+	}
+
+	// With body "*" every field comes from the body, so the query string is ignored
+	if rule.body != "*" {
+		for key, values := range req.URL.Query() {
+			if _, bound := bindings[key]; bound {
+				continue
+			}
+			if findField(rule.method.Input(), key) == nil {
+				continue
+			}
+			if err := setFieldPath(input.ProtoReflect(), key, values); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return input, nil
+}
+
+// invoke performs the unary gRPC call and returns the decoded response
+// message together with the call's gRPC status
+func (t *TranscodingService) invoke(req *http.Request, rule *transcodingRule, input proto.Message) (protoreflect.Message, grpcstatus.Code, string, error) {
+	payload, err := proto.Marshal(input)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	target := *t.upstream
+	target.Path = strings.TrimSuffix(target.Path, "/") + rule.grpcPath()
+
+	upstreamReq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, target.String(), bytes.NewReader(frame))
+	if err != nil {
+		return nil, 0, "", err
+	}
+	upstreamReq.Header.Set("Content-Type", "application/grpc+proto")
+	upstreamReq.Header.Set("Te", "trailers")
+	for _, name := range []string{"X-Request-ID", "Authorization"} {
+		if value := req.Header.Get(name); value != "" {
+			upstreamReq.Header.Set(name, value)
+		}
+	}
+	for name, values := range req.Header {
+		if strings.HasPrefix(name, grpcMetadataPrefix) {
+			upstreamReq.Header[strings.TrimPrefix(name, grpcMetadataPrefix)] = values
+		}
+	}
+
+	resp, err := t.transport.RoundTrip(upstreamReq)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, "", fmt.Errorf("upstream answered HTTP %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTranscodedMessageBytes+6))
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Trailers-only responses carry the status in the headers
+	code, message := grpcStatusFrom(resp.Header)
+	if resp.Header.Get("Grpc-Status") == "" {
+		code, message = grpcStatusFrom(resp.Trailer)
+	}
+	if code != grpcstatus.OK {
+		return nil, code, message, nil
+	}
+
+	if len(body) < 5 {
+		return nil, 0, "", fmt.Errorf("response is missing its message frame")
+	}
+	if body[0] != 0 {
+		return nil, 0, "", fmt.Errorf("compressed responses are not supported")
+	}
+	length := binary.BigEndian.Uint32(body[1:5])
+	if int(length) != len(body)-5 {
+		return nil, 0, "", fmt.Errorf("expected exactly one response message")
+	}
+
+	output := dynamicpb.NewMessage(rule.method.Output())
+	if err := proto.Unmarshal(body[5:], output); err != nil {
+		return nil, 0, "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	return output, grpcstatus.OK, "", nil
+}
+
+func grpcStatusFrom(header http.Header) (grpcstatus.Code, string) {
+	raw := header.Get("Grpc-Status")
+	if raw == "" {
+		return grpcstatus.Unknown, "missing grpc-status"
+	}
+	code, err := strconv.Atoi(raw)
+	if err != nil {
+		return grpcstatus.Unknown, "invalid grpc-status " + raw
+	}
+	message, err := url.PathUnescape(header.Get("Grpc-Message"))
+	if err != nil {
+		message = header.Get("Grpc-Message")
+	}
+	return grpcstatus.Code(code), message
+}
+
+func (t *TranscodingService) writeOutput(w http.ResponseWriter, req *http.Request, rule *transcodingRule, output protoreflect.Message) {
+	var result proto.Message = output.Interface()
+	if rule.responseBody != "" {
+		field := findField(output.Descriptor(), rule.responseBody)
+		if field.Message() != nil && !field.IsList() && !field.IsMap() {
+			result = output.Get(field).Message().Interface()
+		}
+	}
+
+	jsonResp, err := protojson.Marshal(result)
+	if err != nil {
+		log.Printf("Failed to encode gRPC response as JSON: %v", err)
+		writeError(w, req, problem.InternalError, "Failed to encode response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(jsonResp); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// writeGRPCStatusError reports a failed gRPC call to the HTTP client using
+// the HTTP status that corresponds to its code
+func writeGRPCStatusError(w http.ResponseWriter, req *http.Request, code grpcstatus.Code, message string) {
+	problemType := problem.UpstreamGRPCError.WithStatus(grpcstatus.ToHTTPStatus(code))
+	problem.Write(w, req, problem.New(problemType, message).With("grpc_code", int(code)))
+}
+
+// writeError answers req with a problem of the given type
+func writeError(w http.ResponseWriter, req *http.Request, problemType problem.Type, detail string) {
+	problem.Write(w, req, problem.New(problemType, detail))
+}
+
+func writeJSON(w http.ResponseWriter, req *http.Request, statusCode int, body interface{}) {
+	jsonResp, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Failed to marshal response: %v", err)
+		writeError(w, req, problem.InternalError, "Failed to encode response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(jsonResp); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
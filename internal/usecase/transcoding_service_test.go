@@ -0,0 +1,336 @@
+package usecase
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/LucianoBarrera/api-gateway/internal/config"
+	"github.com/LucianoBarrera/api-gateway/internal/problem"
+)
+
+// testUsersDescriptor describes users.v1.UserService. GetUser carries a
+// google.api.http annotation, CreateUser is mapped through config.
+func testUsersDescriptor() *descriptorpb.FileDescriptorProto {
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     kind.Enum(),
+			Label:    label.Enum(),
+			JsonName: proto.String(name),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING
+
+	getUserOptions := &descriptorpb.MethodOptions{}
+	proto.SetExtension(getUserOptions, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{Get: "/v1/users/{id}"},
+	})
+
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("users/v1/users.proto"),
+		Package: proto.String("users.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("User"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, str, optional, ""),
+					field("name", 2, str, optional, ""),
+					field("tags", 3, str, repeated, ""),
+				},
+			},
+			{
+				Name: proto.String("GetUserRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, str, optional, ""),
+					field("verbose", 2, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, ""),
+				},
+			},
+			{
+				Name: proto.String("CreateUserRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("org", 1, str, optional, ""),
+					field("user", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".users.v1.User"),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("UserService"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					{
+						Name:       proto.String("GetUser"),
+						InputType:  proto.String(".users.v1.GetUserRequest"),
+						OutputType: proto.String(".users.v1.User"),
+						Options:    getUserOptions,
+					},
+					{
+						Name:       proto.String("CreateUser"),
+						InputType:  proto.String(".users.v1.CreateUserRequest"),
+						OutputType: proto.String(".users.v1.User"),
+					},
+				},
+			},
+		},
+	}
+}
+
+func writeTestDescriptorSet(t *testing.T) string {
+	t.Helper()
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{testUsersDescriptor()},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal descriptor set: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "users.pb")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write descriptor set: %v", err)
+	}
+	return path
+}
+
+// newUserServiceBackend is an h2c gRPC upstream implementing UserService
+func newUserServiceBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	file, err := protodesc.NewFile(testUsersDescriptor(), nil)
+	if err != nil {
+		t.Fatalf("invalid test descriptor: %v", err)
+	}
+	messages := file.Messages()
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var input *dynamicpb.Message
+		switch r.URL.Path {
+		case "/users.v1.UserService/GetUser":
+			input = dynamicpb.NewMessage(messages.ByName("GetUserRequest"))
+		case "/users.v1.UserService/CreateUser":
+			input = dynamicpb.NewMessage(messages.ByName("CreateUserRequest"))
+		default:
+			t.Errorf("unexpected gRPC path %s", r.URL.Path)
+			return
+		}
+		if err := proto.Unmarshal(body[5:], input); err != nil {
+			t.Errorf("upstream failed to decode request: %v", err)
+			return
+		}
+
+		user := dynamicpb.NewMessage(messages.ByName("User"))
+		userFields := user.Descriptor().Fields()
+		switch r.URL.Path {
+		case "/users.v1.UserService/GetUser":
+			id := input.Get(input.Descriptor().Fields().ByName("id")).String()
+			if id == "missing" {
+				w.Header().Set("Content-Type", "application/grpc")
+				w.Header().Set("Grpc-Status", "5")
+				w.Header().Set("Grpc-Message", "user%20not%20found")
+				return
+			}
+			user.Set(userFields.ByName("id"), protoreflect.ValueOfString(id))
+			name := "User " + id
+			if input.Get(input.Descriptor().Fields().ByName("verbose")).Bool() {
+				name += " (verbose)"
+			}
+			user.Set(userFields.ByName("name"), protoreflect.ValueOfString(name))
+		case "/users.v1.UserService/CreateUser":
+			created := input.Get(input.Descriptor().Fields().ByName("user")).Message()
+			org := input.Get(input.Descriptor().Fields().ByName("org")).String()
+			user.Set(userFields.ByName("id"), protoreflect.ValueOfString(org+"-1"))
+			user.Set(userFields.ByName("name"), created.Get(userFields.ByName("name")))
+			user.Set(userFields.ByName("tags"), created.Get(userFields.ByName("tags")))
+		}
+
+		payload, _ := proto.Marshal(user)
+		frame := make([]byte, 5)
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write(append(frame, payload...))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	return backend
+}
+
+func TestTranscodingService(t *testing.T) {
+	backend := newUserServiceBackend(t)
+	defer backend.Close()
+
+	appConfig := config.AppConfig{
+		KnownServices: map[string]string{"users": backend.URL},
+		Services: map[string]config.ServiceConfig{
+			"users": {
+				Protocol: config.ProtocolGRPC,
+				Transcoding: &config.TranscodingConfig{
+					DescriptorSet: writeTestDescriptorSet(t),
+					Mappings: []config.TranscodingMapping{
+						{Method: "POST", Path: "/v1/orgs/{org}/users", GRPCMethod: "users.v1.UserService/CreateUser", Body: "user"},
+					},
+				},
+			},
+		},
+	}
+
+	transcoder, err := NewTranscodingService(appConfig, "users", nil)
+	if err != nil {
+		t.Fatalf("failed to create transcoder: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedJSON   map[string]interface{}
+	}{
+		{
+			name:           "annotated GET with path and query bindings",
+			method:         http.MethodGet,
+			path:           "/api/users/v1/users/42?verbose=true",
+			expectedStatus: http.StatusOK,
+			expectedJSON:   map[string]interface{}{"id": "42", "name": "User 42 (verbose)"},
+		},
+		{
+			name:           "escaped slash stays in its variable",
+			method:         http.MethodGet,
+			path:           "/api/users/v1/users/a%2Fb",
+			expectedStatus: http.StatusOK,
+			expectedJSON:   map[string]interface{}{"id": "a/b"},
+		},
+		{
+			name:           "escaped percent is decoded once",
+			method:         http.MethodGet,
+			path:           "/api/users/v1/users/100%2525",
+			expectedStatus: http.StatusOK,
+			expectedJSON:   map[string]interface{}{"id": "100%25"},
+		},
+		{
+			name:           "configured POST with body field",
+			method:         http.MethodPost,
+			path:           "/api/users/v1/orgs/acme/users",
+			body:           `{"name": "Ann", "tags": ["admin"]}`,
+			expectedStatus: http.StatusOK,
+			expectedJSON:   map[string]interface{}{"id": "acme-1", "name": "Ann", "tags": []interface{}{"admin"}},
+		},
+		{
+			name:           "gRPC NOT_FOUND maps to 404",
+			method:         http.MethodGet,
+			path:           "/api/users/v1/users/missing",
+			expectedStatus: http.StatusNotFound,
+			expectedJSON:   map[string]interface{}{"code": "upstream_grpc_error", "detail": "user not found", "grpc_code": float64(5)},
+		},
+		{
+			name:           "unmapped route",
+			method:         http.MethodDelete,
+			path:           "/api/users/v1/users/42",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid JSON body",
+			method:         http.MethodPost,
+			path:           "/api/users/v1/orgs/acme/users",
+			body:           `{"unknown_field": true}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			transcoder.ForwardRequest(w, req, "users")
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d (body: %s)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			expectedType := "application/json"
+			if tt.expectedStatus != http.StatusOK {
+				expectedType = problem.ContentType
+			}
+			if ct := w.Header().Get("Content-Type"); ct != expectedType {
+				t.Errorf("expected content type %q, got %q", expectedType, ct)
+			}
+			if tt.expectedJSON == nil {
+				return
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid JSON response %q: %v", w.Body.String(), err)
+			}
+			for key, want := range tt.expectedJSON {
+				gotJSON, _ := json.Marshal(got[key])
+				wantJSON, _ := json.Marshal(want)
+				if string(gotJSON) != string(wantJSON) {
+					t.Errorf("expected %s=%s, got %s", key, wantJSON, gotJSON)
+				}
+			}
+		})
+	}
+}
+
+func TestPathTemplateMatch(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		match    bool
+		bindings map[string]string
+	}{
+		{"/v1/users/{id}", "/v1/users/42", true, map[string]string{"id": "42"}},
+		{"/v1/users/{id}", "/v1/users/42/extra", false, nil},
+		{"/v1/users/{id}", "/v1/users/", false, nil},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", true, map[string]string{"name": "shelves/1/books/2"}},
+		{"/v1/files/{path=**}", "/v1/files/a/b/c.txt", true, map[string]string{"path": "a/b/c.txt"}},
+		{"/v1/users/{id}:activate", "/v1/users/7:activate", true, map[string]string{"id": "7"}},
+		{"/v1/users/{id}:activate", "/v1/users/7", false, nil},
+		{"/v1/users/{id}", "/v1/users/a%20b", true, map[string]string{"id": "a b"}},
+		{"/v1/users/{id}", "/v1/users/a%2Fb", true, map[string]string{"id": "a/b"}},
+		{"/v1/users/{id}", "/v1/users/100%2525", true, map[string]string{"id": "100%25"}},
+		{"/v1/users/{id}", "/v1/%75sers/42", true, map[string]string{"id": "42"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template+" "+tt.path, func(t *testing.T) {
+			template, err := parsePathTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("failed to parse template: %v", err)
+			}
+			bindings, ok := template.match(tt.path)
+			if ok != tt.match {
+				t.Fatalf("expected match=%v, got %v", tt.match, ok)
+			}
+			for key, want := range tt.bindings {
+				if bindings[key] != want {
+					t.Errorf("expected %s=%q, got %q", key, want, bindings[key])
+				}
+			}
+		})
+	}
+}
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// websocketGUID is the fixed GUID from RFC 6455 used to compute Sec-WebSocket-Accept
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
 type MockServer struct {
 	port int
 	name string
@@ -23,6 +32,20 @@ func NewMockServer(port int, name string) *MockServer {
 }
 
 func (s *MockServer) Start() error {
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", s.port),
+		Handler:      s.routes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	log.Printf("Mock server '%s' starting on port %d", s.name, s.port)
+	return server.ListenAndServe()
+}
+
+// routes builds the handler serving every mock endpoint
+func (s *MockServer) routes() http.Handler {
 	mux := http.NewServeMux()
 
 	// Health check endpoint
@@ -35,19 +58,13 @@ func (s *MockServer) Start() error {
 	mux.HandleFunc("GET /auth/status", s.authStatusHandler)
 	mux.HandleFunc("POST /auth/login", s.loginHandler)
 
+	// WebSocket echo endpoint used to test upgrade proxying
+	mux.HandleFunc("GET /ws", s.websocketEchoHandler)
+
 	// Catch-all handler for any other paths
 	mux.HandleFunc("/", s.catchAllHandler)
 
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", s.port),
-		Handler:      mux,
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
-	}
-
-	log.Printf("Mock server '%s' starting on port %d", s.name, s.port)
-	return server.ListenAndServe()
+	return mux
 }
 
 func (s *MockServer) healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -158,6 +175,133 @@ func (s *MockServer) catchAllHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// websocketEchoHandler performs the RFC 6455 handshake and echoes every text
+// or binary message back to the client until it sends a close frame
+func (s *MockServer) websocketEchoHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, "Expected WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		log.Printf("Failed to hijack connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// The server's timeouts apply to the HTTP request, not the socket
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	// Greet the client so it can tell which backend answered
+	if err := writeWebSocketFrame(brw.Writer, 0x1, []byte("connected to "+s.name)); err != nil {
+		return
+	}
+
+	for {
+		opcode, payload, err := readWebSocketFrame(brw.Reader)
+		if err != nil {
+			return
+		}
+
+		switch opcode {
+		case 0x8: // close
+			writeWebSocketFrame(brw.Writer, 0x8, payload)
+			return
+		case 0x9: // ping
+			if err := writeWebSocketFrame(brw.Writer, 0xA, payload); err != nil {
+				return
+			}
+		case 0x1, 0x2:
+			if err := writeWebSocketFrame(brw.Writer, opcode, payload); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readWebSocketFrame reads a single, unfragmented client frame and unmasks its payload
+func readWebSocketFrame(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return opcode, payload, nil
+}
+
+// writeWebSocketFrame writes a single unmasked server frame and flushes it
+func writeWebSocketFrame(w *bufio.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
 func main() {
 	// Get port from environment variable or use default
 	portStr := os.Getenv("PORT")
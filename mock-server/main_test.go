@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeClientFrame writes a masked frame, as RFC 6455 requires of clients
+func writeClientFrame(t *testing.T, conn net.Conn, opcode byte, payload []byte) {
+	t.Helper()
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("failed to write frame: %v", err)
+	}
+}
+
+func readServerFrame(t *testing.T, conn net.Conn, reader *bufio.Reader) (byte, string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	opcode, payload, err := readWebSocketFrame(reader)
+	if err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	return opcode, string(payload)
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	server := httptest.NewServer(NewMockServer(0, "mock-users").routes())
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("failed to dial mock server: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: mock\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status 101, got %d", resp.StatusCode)
+	}
+	// The accept value for the sample key given in RFC 6455
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("unexpected Sec-WebSocket-Accept %q", got)
+	}
+
+	if opcode, payload := readServerFrame(t, conn, reader); opcode != 0x1 || payload != "connected to mock-users" {
+		t.Errorf("expected greeting frame, got opcode %d payload %q", opcode, payload)
+	}
+
+	frames := []struct {
+		name   string
+		opcode byte
+		want   byte
+	}{
+		{name: "text is echoed", opcode: 0x1, want: 0x1},
+		{name: "binary is echoed", opcode: 0x2, want: 0x2},
+		{name: "ping is answered with pong", opcode: 0x9, want: 0xA},
+		{name: "close is echoed", opcode: 0x8, want: 0x8},
+	}
+	for _, tt := range frames {
+		writeClientFrame(t, conn, tt.opcode, []byte(tt.name))
+		opcode, payload := readServerFrame(t, conn, reader)
+		if opcode != tt.want || payload != tt.name {
+			t.Errorf("%s: expected opcode %d payload %q, got opcode %d payload %q", tt.name, tt.want, tt.name, opcode, payload)
+		}
+	}
+
+	// The server hangs up after echoing the close frame
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := readWebSocketFrame(reader); err == nil {
+		t.Error("expected the connection to be closed after the close frame")
+	}
+}
+
+func TestWebSocketEchoRejectsPlainRequests(t *testing.T) {
+	server := httptest.NewServer(NewMockServer(0, "mock-users").routes())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/ws")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", resp.StatusCode)
+	}
+}
@@ -56,8 +56,24 @@ curl -s -X POST http://localhost:8080/api/auth/auth/login \
 echo -e "\n5. Testing non-existent service (with proper headers):"
 curl -s -H "X-Request-ID: test-request-id-6" -H "x-api-key: example-api-key-local-env" http://localhost:8080/api/nonexistent/test | jq .
 
+# Test WebSocket upgrade through the API gateway
+echo -e "\n6. Testing WebSocket upgrade through API gateway:"
+echo "GET /api/users/ws (expecting 101 Switching Protocols)"
+curl -s -i -N --max-time 2 http://localhost:8080/api/users/ws \
+  -H "Connection: Upgrade" \
+  -H "Upgrade: websocket" \
+  -H "Sec-WebSocket-Version: 13" \
+  -H "Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==" \
+  -H "X-Request-ID: test-request-id-7" \
+  -H "x-api-key: example-api-key-local-env" | head -n 1
+
+# Test aggregate route combining users and auth
+echo -e "\n7. Testing aggregate route through API gateway:"
+echo "GET /api/mobile-home/"
+curl -s -H "X-Request-ID: test-request-id-8" -H "x-api-key: example-api-key-local-env" http://localhost:8080/api/mobile-home/ | jq .
+
 # Test direct access to mock servers (for comparison)
-echo -e "\n6. Testing direct access to mock servers:"
+echo -e "\n8. Testing direct access to mock servers:"
 echo "Direct access to users service:"
 curl -s http://localhost:8081/users | jq .
 